@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -28,6 +29,7 @@ import (
 	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/replacer"
 	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
 )
 
 // CmdGHActions represents the actions command
@@ -44,6 +46,9 @@ Example:
 
 This will replace all tag or branch references in all GitHub Actions workflows
 for the given directory. Supports both directories and single references.
+With --stdin, a single workflow is read from stdin and written to stdout instead:
+
+	$ cat workflow.yml | frizbee actions --stdin > pinned.yml
 
 ` + cli.TokenHelpText + "\n",
 		Aliases:      []string{"ghactions"}, // backwards compatibility
@@ -54,9 +59,28 @@ for the given directory. Supports both directories and single references.
 
 	// flags
 	cli.DeclareFrizbeeFlags(cmd, false)
+	cli.DeclareCacheFlags(cmd)
+	cli.DeclareChangedFlags(cmd)
+	cli.DeclareGitHubTokenFlags(cmd)
+	cmd.Flags().Bool("stdin", false, "read a workflow from stdin and write the result to stdout")
+	cmd.Flags().Bool("wait-on-rate-limit", false, "wait for the GitHub API rate limit to reset instead of failing")
+	cmd.Flags().Bool("fail-on-unresolved", false, "fail if any reference can't be resolved instead of leaving it untouched")
+	cmd.Flags().Bool("no-docker", false, "leave the docker:// image steps untouched")
+	cmd.Flags().Bool("resolve-version", false,
+		"record the release a moving tag such as v4 points at, e.g. v4.1.1, in the comment of the pinned action")
+	cmd.Flags().StringSlice("insecure-registries", nil,
+		"registries of docker:// steps, as host or host:port, to talk to without verifying their TLS certificates")
+	cmd.Flags().String("registry-ca-cert", "",
+		"PEM file of the certificate authorities to verify the registries of docker:// steps against")
+	cmd.Flags().String("docker-config", "",
+		"docker config.json to read the registry credentials of docker:// steps from instead of the default one")
+	cmd.Flags().Bool("expand-env", false, "expand the $VAR and ${VAR} environment variables of the action references")
 
 	// sub-commands
 	cmd.AddCommand(CmdList())
+	cmd.AddCommand(CmdCheck())
+	cmd.AddCommand(CmdDrift())
+	cmd.AddCommand(CmdResolve())
 
 	return cmd
 }
@@ -75,26 +99,140 @@ func replaceCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	waitOnRateLimit, err := cmd.Flags().GetBool("wait-on-rate-limit")
+	if err != nil {
+		return err
+	}
+	failOnUnresolved, err := cmd.Flags().GetBool("fail-on-unresolved")
+	if err != nil {
+		return err
+	}
+	expandEnv, err := cmd.Flags().GetBool("expand-env")
+	if err != nil {
+		return err
+	}
+	dockerConfig, err := cmd.Flags().GetString("docker-config")
+	if err != nil {
+		return err
+	}
+	insecureRegistries, err := cmd.Flags().GetStringSlice("insecure-registries")
+	if err != nil {
+		return err
+	}
+	registryCACert, err := cmd.Flags().GetString("registry-ca-cert")
+	if err != nil {
+		return err
+	}
+	stdin, err := cmd.Flags().GetBool("stdin")
+	if err != nil {
+		return err
+	}
+	if stdin && len(args) > 0 {
+		return errors.New("--stdin doesn't take a path or reference")
+	}
+
 	// Set up the config
 	cfg, err := config.FromCommand(cmd)
 	if err != nil {
 		return err
 	}
 
+	token, err := cli.ResolveGitHubToken(cmd)
+	if err != nil {
+		return err
+	}
+
+	retryPolicy := retry.DefaultPolicy()
+	if waitOnRateLimit {
+		// The primary rate limit resets every hour
+		retryPolicy.MaxDelay = time.Hour
+	}
+
 	// Create a new replacer
 	r := replacer.NewGitHubActionsReplacer(cfg).
 		WithUserRegex(cliFlags.Regex).
-		WithGitHubClientFromToken(os.Getenv(cli.GitHubTokenEnvKey))
+		WithMaxConcurrency(cliFlags.Jobs).
+		WithGitHubClientFromToken(token).
+		WithRetry(retryPolicy).
+		WithConfigDiscovery(cliFlags.DiscoverConfig).
+		WithLogger(cliFlags.Logger()).
+		WithProgress(cliFlags.ProgressFunc())
+	if failOnUnresolved {
+		r = r.WithFailOnUnresolved()
+	}
+	if expandEnv {
+		r = r.WithEnvExpansion(nil)
+	}
+	if dockerConfig != "" {
+		if r, err = r.WithDockerConfig(dockerConfig); err != nil {
+			return err
+		}
+	}
+	if len(insecureRegistries) > 0 {
+		r = r.WithInsecureRegistries(insecureRegistries)
+	}
+	if registryCACert != "" {
+		if r, err = r.WithRegistryCACert(registryCACert); err != nil {
+			return err
+		}
+	}
 
+	cache, saveCache, err := cliFlags.OpenCaches()
+	if err != nil {
+		return err
+	}
+	defer saveCache()
+	defer cliFlags.EndProgress()
+	if cache != nil {
+		r = r.WithCache(cache)
+	}
+	if apiURL := os.Getenv(cli.GitHubAPIURLEnvKey); apiURL != "" {
+		if r, err = r.WithGitHubBaseURL(apiURL); err != nil {
+			return err
+		}
+	}
+
+	if stdin {
+		// Replace the tags in the workflow read from stdin
+		parse := r.ParseFile
+		if cliFlags.Unpin {
+			parse = r.UnpinFile
+		}
+		return cli.ExplainRateLimit(cliFlags.ProcessStdin(parse))
+	}
 	if cli.IsPath(pathOrRef) {
 		dir := filepath.Clean(pathOrRef)
-		// Replace the tags in the given directory
-		res, err := r.ParsePath(cmd.Context(), dir)
+		changed, err := cli.ChangedFilesFromFlags(cmd, dir)
 		if err != nil {
 			return err
 		}
+		if changed != nil {
+			r = r.WithOnlyFiles(changed)
+		}
+		// Replace the tags in the given directory
+		parse := r.ParsePath
+		if cliFlags.Unpin {
+			parse = r.UnpinPath
+		}
+		res, err := parse(cmd.Context(), dir)
+		if err != nil {
+			return cli.ExplainRateLimit(err)
+		}
 		// Process the output files
-		return cliFlags.ProcessOutput(dir, res.Processed, res.Modified)
+		err = cliFlags.ProcessOutput(dir, res.Processed, res.Modified, res.Changes)
+		cliFlags.PrintSkipped(res.Skipped)
+		totals := res.Totals()
+		cliFlags.PrintSummary(cli.Summary{
+			FilesProcessed: len(res.Processed),
+			FilesModified:  len(res.Modified),
+			Pinned:         totals.Modified,
+			Skipped:        totals.Skipped,
+			Errored:        totals.Errored,
+		})
+		return err
+	}
+	if cliFlags.Unpin {
+		return errors.New("unpinning requires a path, the tag of a single reference can't be recovered")
 	}
 	// Replace the passed reference
 	res, err := r.ParseString(cmd.Context(), pathOrRef)
@@ -103,7 +241,7 @@ func replaceCmd(cmd *cobra.Command, args []string) error {
 			fmt.Fprintln(cmd.OutOrStdout(), pathOrRef) // nolint:errcheck
 			return nil
 		}
-		return err
+		return cli.ExplainRateLimit(err)
 	}
 	fmt.Fprintf(cmd.OutOrStdout(), "%s@%s\n", res.Name, res.Ref) // nolint:errcheck
 	return nil
@@ -0,0 +1,125 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// CmdDrift represents the drift sub-command
+func CmdDrift() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Reports the pinned github actions whose tag moved",
+		Long: `This utility resolves again the tag recorded in the trailing comment of each
+github action pinned by a checksum, e.g. actions/checkout@<sha> # v4, and reports the
+actions whose tag points at another checksum by now, without modifying any file.
+It exits with a non-zero exit code if any drifted reference is found.
+
+Example: 
+	frizbee action drift .github/workflows
+`,
+		RunE:         drift,
+		SilenceUsage: true,
+		Args:         cobra.MaximumNArgs(1),
+	}
+
+	cli.DeclareDriftFlags(cmd)
+	cli.DeclareGitHubTokenFlags(cmd)
+
+	return cmd
+}
+
+func drift(cmd *cobra.Command, args []string) error {
+	// Set the default directory if not provided
+	dir := ".github/workflows"
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	dir = filepath.Clean(dir)
+	if !cli.IsPath(dir) {
+		return errors.New("the provided argument is not a path")
+	}
+	// Extract the CLI flags from the cobra command
+	cliFlags, err := cli.NewDriftHelper(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Set up the config
+	cfg, err := config.FromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	token, err := cli.ResolveGitHubToken(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Create a new replacer
+	r := replacer.NewGitHubActionsReplacer(cfg).
+		WithUserRegex(cliFlags.Regex).
+		WithMaxConcurrency(cliFlags.Jobs).
+		WithGitHubClientFromToken(token)
+
+	res, err := r.DriftPath(cmd.Context(), dir)
+	if err != nil {
+		return cli.ExplainRateLimit(err)
+	}
+
+	for i := range res.Drifted {
+		res.Drifted[i].Path = filepath.Join(filepath.Dir(dir), res.Drifted[i].Path)
+	}
+	switch cliFlags.Output {
+	case "json":
+		jsonBytes, err := json.MarshalIndent(res.Drifted, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(jsonBytes)) // nolint:errcheck
+	case "text":
+		for _, d := range res.Drifted {
+			// nolint:errcheck
+			fmt.Fprintf(cmd.OutOrStdout(), "%s:%d: %s@%s is pinned to %s but now points at %s\n",
+				d.Path, d.Line, d.Name, d.Tag, d.Pinned, d.Current)
+		}
+	default:
+		return fmt.Errorf("unknown output format: %s", cliFlags.Output)
+	}
+	for _, e := range res.Errors {
+		cliFlags.Logf("%s:%d: failed to resolve %s: %v\n",
+			filepath.Join(filepath.Dir(dir), e.Path), e.Line, e.Reference, cli.ExplainRateLimit(e.Err))
+	}
+
+	if len(res.Drifted) > 0 {
+		return fmt.Errorf("found %d drifted references", len(res.Drifted))
+	}
+	if len(res.Errors) > 0 {
+		return fmt.Errorf("failed to resolve %d references", len(res.Errors))
+	}
+	return nil
+}
@@ -19,7 +19,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strconv"
 
@@ -27,6 +26,8 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/internal/sarif"
+	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/replacer"
 	"github.com/stacklok/frizbee/pkg/utils/config"
 )
@@ -48,6 +49,8 @@ Example:
 	}
 
 	cli.DeclareFrizbeeFlags(cmd, true)
+	cmd.Flags().Bool("unpinned-only", false, "only list the references using a mutable tag or branch")
+	cli.DeclareGitHubTokenFlags(cmd)
 
 	return cmd
 }
@@ -75,21 +78,50 @@ func list(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	token, err := cli.ResolveGitHubToken(cmd)
+	if err != nil {
+		return err
+	}
+
 	// Create a new replacer
 	r := replacer.NewGitHubActionsReplacer(cfg).
 		WithUserRegex(cliFlags.Regex).
-		WithGitHubClientFromToken(os.Getenv(cli.GitHubTokenEnvKey))
+		WithMaxConcurrency(cliFlags.Jobs).
+		WithGitHubClientFromToken(token)
+
+	unpinnedOnly, err := cmd.Flags().GetBool("unpinned-only")
+	if err != nil {
+		return fmt.Errorf("failed to get unpinned-only flag: %w", err)
+	}
+
+	output := cmd.Flag("output").Value.String()
+	if output == "jsonl" {
+		// Stream the references as they're found rather than buffering them
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		return r.ListPathFunc(dir, func(e interfaces.EntityRef) error {
+			if unpinnedOnly && replacer.IsPinned(e) {
+				return nil
+			}
+			return enc.Encode(cli.Listed{EntityRef: e, Pinned: replacer.IsPinned(e)})
+		})
+	}
 
 	// List the references in the directory
 	res, err := r.ListPath(dir)
 	if err != nil {
 		return err
 	}
+	if unpinnedOnly {
+		res = res.WithoutPinned()
+	}
 
-	output := cmd.Flag("output").Value.String()
 	switch output {
 	case "json":
-		jsonBytes, err := json.MarshalIndent(res.Entities, "", "  ")
+		listed := make([]cli.Listed, 0, len(res.Entities))
+		for _, e := range res.Entities {
+			listed = append(listed, cli.Listed{EntityRef: e, Pinned: replacer.IsPinned(e)})
+		}
+		jsonBytes, err := json.MarshalIndent(listed, "", "  ")
 		if err != nil {
 			return err
 		}
@@ -99,12 +131,14 @@ func list(cmd *cobra.Command, args []string) error {
 		return nil
 	case "table":
 		table := tablewriter.NewWriter(cmd.OutOrStdout())
-		table.SetHeader([]string{"No", "Type", "Name", "Ref"})
+		table.SetHeader([]string{"No", "Type", "Name", "Ref", "Pinned"})
 		for i, a := range res.Entities {
-			table.Append([]string{strconv.Itoa(i + 1), a.Type, a.Name, a.Ref})
+			table.Append([]string{strconv.Itoa(i + 1), a.Type, a.Name, a.Ref, strconv.FormatBool(replacer.IsPinned(a))})
 		}
 		table.Render()
 		return nil
+	case "sarif":
+		return sarif.FromListResult(res, filepath.Dir(dir)).Write(cmd.OutOrStdout())
 	default:
 		return fmt.Errorf("unknown output format: %s", output)
 	}
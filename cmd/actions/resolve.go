@@ -0,0 +1,89 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+)
+
+// CmdResolve represents the resolve sub-command
+func CmdResolve() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resolve",
+		Short: "Resolves the github actions listed in a file",
+		Long: `This utility resolves the github actions listed one per line in a file
+and prints their pinned form. Actions failing to resolve are reported without
+stopping the others.
+
+Example:
+	frizbee actions resolve --from-file refs.txt
+
+` + cli.TokenHelpText + "\n",
+		RunE:         resolve,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+
+	cli.DeclareResolveFlags(cmd)
+	cli.DeclareGitHubTokenFlags(cmd)
+	cmd.Flags().Bool("wait-on-rate-limit", false, "wait for the GitHub API rate limit to reset instead of failing")
+
+	return cmd
+}
+
+func resolve(cmd *cobra.Command, _ []string) error {
+	waitOnRateLimit, err := cmd.Flags().GetBool("wait-on-rate-limit")
+	if err != nil {
+		return err
+	}
+
+	// Set up the config
+	cfg, err := config.FromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	token, err := cli.ResolveGitHubToken(cmd)
+	if err != nil {
+		return err
+	}
+
+	retryPolicy := retry.DefaultPolicy()
+	if waitOnRateLimit {
+		// The primary rate limit resets every hour
+		retryPolicy.MaxDelay = time.Hour
+	}
+
+	// Create a new replacer
+	r := replacer.NewGitHubActionsReplacer(cfg).
+		WithGitHubClientFromToken(token).
+		WithRetry(retryPolicy)
+	if apiURL := os.Getenv(cli.GitHubAPIURLEnvKey); apiURL != "" {
+		if r, err = r.WithGitHubBaseURL(apiURL); err != nil {
+			return err
+		}
+	}
+
+	return cli.ResolveFromFile(cmd, r.ParseString)
+}
@@ -0,0 +1,171 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package all provides a command-line utility to pin the GitHub Actions and container images of a repository at once.
+package all
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+)
+
+// CmdAll represents the all command
+func CmdAll() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "all",
+		Short: "Replace the tags of both GitHub Actions and container images",
+		Long: `This utility replaces the tags of the GitHub Actions and the container images
+referenced in a directory with their checksum or digest, in a single pass.
+
+Example:
+
+	$ frizbee all <path-to-repository>
+
+The directory defaults to the current one. Each file is processed by the replacers it
+may hold references for: the workflows and other YAML files for both the actions and
+the images, e.g. of their job containers, and the Dockerfiles for the images only.
+
+` + cli.TokenHelpText + "\n",
+		RunE:         replaceCmd,
+		SilenceUsage: true,
+		Args:         cobra.MaximumNArgs(1),
+	}
+
+	// flags
+	cli.DeclareFrizbeeFlags(cmd, false)
+	cli.DeclareCacheFlags(cmd)
+	cli.DeclareChangedFlags(cmd)
+	cli.DeclareGitHubTokenFlags(cmd)
+	cmd.Flags().Bool("wait-on-rate-limit", false, "wait for the GitHub API rate limit to reset instead of failing")
+	cmd.Flags().Bool("fail-on-unresolved", false, "fail if any reference can't be resolved instead of leaving it untouched")
+
+	return cmd
+}
+
+func replaceCmd(cmd *cobra.Command, args []string) error {
+	dir := "."
+	if len(args) > 0 {
+		dir = filepath.Clean(args[0])
+	}
+	if !cli.IsPath(dir) {
+		return errors.New("the provided argument is not a path")
+	}
+
+	// Extract the CLI flags from the cobra command
+	cliFlags, err := cli.NewHelper(cmd)
+	if err != nil {
+		return err
+	}
+
+	waitOnRateLimit, err := cmd.Flags().GetBool("wait-on-rate-limit")
+	if err != nil {
+		return err
+	}
+	failOnUnresolved, err := cmd.Flags().GetBool("fail-on-unresolved")
+	if err != nil {
+		return err
+	}
+
+	// Set up the config
+	cfg, err := config.FromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	token, err := cli.ResolveGitHubToken(cmd)
+	if err != nil {
+		return err
+	}
+	retryPolicy := retry.DefaultPolicy()
+	if waitOnRateLimit {
+		// The primary rate limit resets every hour
+		retryPolicy.MaxDelay = time.Hour
+	}
+
+	// Create the replacers of the actions and of the images, in the order they process each file
+	actions := replacer.NewGitHubActionsReplacer(cfg).
+		WithGitHubClientFromToken(token).
+		WithRetry(retryPolicy)
+	if apiURL := os.Getenv(cli.GitHubAPIURLEnvKey); apiURL != "" {
+		if actions, err = actions.WithGitHubBaseURL(apiURL); err != nil {
+			return err
+		}
+	}
+	images := replacer.NewContainerImagesReplacer(cfg).WithRetry(retry.DefaultPolicy())
+	replacers := []*replacer.Replacer{actions, images}
+
+	cache, saveCache, err := cliFlags.OpenCaches()
+	if err != nil {
+		return err
+	}
+	defer saveCache()
+	defer cliFlags.EndProgress()
+	changed, err := cli.ChangedFilesFromFlags(cmd, dir)
+	if err != nil {
+		return err
+	}
+
+	// The files are traversed once for all the replacers, the progress is that of the first one
+	progress := cliFlags.ProgressFunc()
+	for i, r := range replacers {
+		r = r.WithUserRegex(cliFlags.Regex).
+			WithMaxConcurrency(cliFlags.Jobs).
+			WithConfigDiscovery(cliFlags.DiscoverConfig).
+			WithLogger(cliFlags.Logger()).
+			WithProgress(progress)
+		if failOnUnresolved {
+			r = r.WithFailOnUnresolved()
+		}
+		if cache != nil {
+			r = r.WithCache(cache)
+		}
+		if changed != nil {
+			r = r.WithOnlyFiles(changed)
+		}
+		replacers[i] = r
+	}
+	r := replacer.NewCombinedReplacer(replacers...)
+
+	// Replace the references in the given directory
+	parse := r.ParsePath
+	if cliFlags.Unpin {
+		parse = r.UnpinPath
+	}
+	res, err := parse(cmd.Context(), dir)
+	if err != nil {
+		return cli.ExplainRateLimit(err)
+	}
+	// Process the output files
+	err = cliFlags.ProcessOutput(dir, res.Processed, res.Modified, res.Changes)
+	cliFlags.PrintSkipped(res.Skipped)
+	totals := res.Totals()
+	cliFlags.PrintSummary(cli.Summary{
+		FilesProcessed: len(res.Processed),
+		FilesModified:  len(res.Modified),
+		Pinned:         totals.Modified,
+		Skipped:        totals.Skipped,
+		Errored:        totals.Errored,
+	})
+	return err
+}
@@ -0,0 +1,89 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package circleci
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// CmdCheck represents the check sub-command
+func CmdCheck() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Checks that all CircleCI orbs are pinned",
+		Long: `This utility checks that all the CircleCI orbs used in the configurations
+are referenced by a full version rather than a version range, without modifying any file.
+It exits with a non-zero exit code if any unpinned reference is found.
+
+Example:
+	frizbee circleci check .circleci
+`,
+		RunE:         check,
+		SilenceUsage: true,
+		Args:         cobra.MaximumNArgs(1),
+	}
+
+	cli.DeclareFrizbeeFlags(cmd, false)
+
+	return cmd
+}
+
+func check(cmd *cobra.Command, args []string) error {
+	// Set the default directory if not provided
+	dir := defaultPath
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	dir = filepath.Clean(dir)
+	if !cli.IsPath(dir) {
+		return errors.New("the provided argument is not a path")
+	}
+	// Extract the CLI flags from the cobra command
+	cliFlags, err := cli.NewHelper(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Set up the config
+	cfg, err := config.FromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	// List the references in the directory
+	res, err := newReplacer(cfg, cliFlags.Regex, cliFlags.Jobs).ListPath(dir)
+	if err != nil {
+		return err
+	}
+
+	unpinned := res.Unpinned()
+	for _, loc := range unpinned {
+		cliFlags.Logf("%s:%d: %s %s@%s is not pinned\n",
+			filepath.Join(filepath.Dir(dir), loc.Path), loc.Line, loc.Type, loc.Name, loc.Ref)
+	}
+	if len(unpinned) > 0 {
+		return fmt.Errorf("found %d unpinned references", len(unpinned))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package circleci provides command-line utilities to work with CircleCI orbs.
+package circleci
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+)
+
+// defaultPath is the directory holding the CircleCI configuration
+const defaultPath = ".circleci"
+
+// CmdCircleCI represents the circleci command
+func CmdCircleCI() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "circleci",
+		Short: "Replace orb version ranges in CircleCI configurations",
+		Long: `This utility replaces orb references by a version range, e.g. circleci/node@5
+or circleci/node@volatile, in CircleCI configurations with the full version they
+currently resolve to. Unlike ranges, full versions can't be republished.
+
+Example:
+
+	$ frizbee circleci <.circleci> or <circleci/node@5>
+
+This will replace all orb version ranges in all CircleCI configurations
+for the given directory. Supports both directories and single references.
+
+` + cli.CircleCITokenHelpText + "\n",
+		Aliases:      []string{"orbs"},
+		RunE:         replaceCmd,
+		SilenceUsage: true,
+		Args:         cobra.MaximumNArgs(1),
+	}
+
+	// flags
+	cli.DeclareFrizbeeFlags(cmd, false)
+	cli.DeclareCacheFlags(cmd)
+	cmd.Flags().Bool("fail-on-unresolved", false, "fail if any reference can't be resolved instead of leaving it untouched")
+
+	// sub-commands
+	cmd.AddCommand(CmdList())
+	cmd.AddCommand(CmdCheck())
+
+	return cmd
+}
+
+// nolint:errcheck
+func replaceCmd(cmd *cobra.Command, args []string) error {
+	// Set the default directory if not provided
+	pathOrRef := defaultPath
+	if len(args) > 0 {
+		pathOrRef = args[0]
+	}
+
+	// Extract the CLI flags from the cobra command
+	cliFlags, err := cli.NewHelper(cmd)
+	if err != nil {
+		return err
+	}
+
+	failOnUnresolved, err := cmd.Flags().GetBool("fail-on-unresolved")
+	if err != nil {
+		return err
+	}
+
+	// Set up the config
+	cfg, err := config.FromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Create a new replacer
+	r := newReplacer(cfg, cliFlags.Regex, cliFlags.Jobs).
+		WithRetry(retry.DefaultPolicy()).
+		WithConfigDiscovery(cliFlags.DiscoverConfig).
+		WithLogger(cliFlags.Logger()).
+		WithProgress(cliFlags.ProgressFunc())
+	if failOnUnresolved {
+		r = r.WithFailOnUnresolved()
+	}
+
+	cache, saveCache, err := cliFlags.OpenCaches()
+	if err != nil {
+		return err
+	}
+	defer saveCache()
+	defer cliFlags.EndProgress()
+	if cache != nil {
+		r = r.WithCache(cache)
+	}
+
+	if cli.IsPath(pathOrRef) {
+		dir := filepath.Clean(pathOrRef)
+		// Replace the version ranges in the given directory
+		parse := r.ParsePath
+		if cliFlags.Unpin {
+			parse = r.UnpinPath
+		}
+		res, err := parse(cmd.Context(), dir)
+		if err != nil {
+			return err
+		}
+		// Process the output files
+		err = cliFlags.ProcessOutput(dir, res.Processed, res.Modified, res.Changes)
+		cliFlags.PrintSkipped(res.Skipped)
+		totals := res.Totals()
+		cliFlags.PrintSummary(cli.Summary{
+			FilesProcessed: len(res.Processed),
+			FilesModified:  len(res.Modified),
+			Pinned:         totals.Modified,
+			Skipped:        totals.Skipped,
+			Errored:        totals.Errored,
+		})
+		return err
+	}
+	if cliFlags.Unpin {
+		return errors.New("unpinning requires a path, the version range of a single reference can't be recovered")
+	}
+	// Replace the passed reference
+	res, err := r.ParseString(cmd.Context(), pathOrRef)
+	if err != nil {
+		if errors.Is(err, interfaces.ErrReferenceSkipped) {
+			fmt.Fprintln(cmd.OutOrStdout(), pathOrRef) // nolint:errcheck
+			return nil
+		}
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s@%s\n", res.Name, res.Ref) // nolint:errcheck
+	return nil
+}
+
+// newReplacer creates a replacer for CircleCI orbs set up from the environment
+func newReplacer(cfg *config.Config, regex string, jobs int) *replacer.Replacer {
+	return replacer.NewCircleCIOrbsReplacer(cfg).
+		WithUserRegex(regex).
+		WithMaxConcurrency(jobs).
+		WithCircleCIToken(os.Getenv(cli.CircleCITokenEnvKey)).
+		WithCircleCIHost(os.Getenv(cli.CircleCIHostEnvKey))
+}
@@ -0,0 +1,137 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config provides command-line utilities to work with the frizbee configuration.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// CmdConfig represents the config command
+func CmdConfig() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with the frizbee configuration",
+		// The config file is read by the sub-commands themselves, e.g. to report the
+		// problems of an invalid one rather than failing to start
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			return nil
+		},
+	}
+
+	// sub-commands
+	cmd.AddCommand(CmdValidate())
+	cmd.AddCommand(CmdSchema())
+
+	return cmd
+}
+
+// CmdValidate represents the validate sub-command
+func CmdValidate() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate [file]",
+		Short: "Validate a frizbee configuration file",
+		Long: `This utility validates a frizbee configuration file, reporting the unknown keys,
+e.g. misspelled ones, and the invalid values, e.g. platforms, along with their line.
+The file defaults to the one given by the --config flag.
+
+Example:
+
+	$ frizbee config validate .frizbee.yml
+`,
+		RunE:         validate,
+		SilenceUsage: true,
+		Args:         cobra.MaximumNArgs(1),
+	}
+}
+
+// CmdSchema represents the schema sub-command
+func CmdSchema() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON schema of the frizbee configuration file",
+		Long: `This utility prints the JSON schema of the frizbee configuration file, e.g. for
+editors to complete and check it.
+
+Example:
+
+	$ frizbee config schema > frizbee.schema.json
+`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			_, err := cmd.OutOrStdout().Write(config.Schema())
+			return err
+		},
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+}
+
+func validate(cmd *cobra.Command, args []string) error {
+	file, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return fmt.Errorf("failed to get config flag: %w", err)
+	}
+	if len(args) > 0 {
+		file = args[0]
+	}
+
+	bfs, cfgPath, err := config.RootFS(file)
+	if err != nil {
+		return err
+	}
+	err = config.ValidateConfigFileFromFS(bfs, cfgPath)
+	var validationErr *config.ValidationError
+	if errors.As(err, &validationErr) {
+		for _, p := range validationErr.Problems {
+			path := file
+			if p.File != "" {
+				path = displayPath(p.File)
+			}
+			if p.Line == 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %s\n", path, p.Message) // nolint:errcheck
+				continue
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "%s:%d: %s\n    %s\n", path, p.Line, p.Message, p.Source) // nolint:errcheck
+		}
+		return fmt.Errorf("%s is invalid", file)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s is valid\n", file) // nolint:errcheck
+	return nil
+}
+
+// displayPath returns the absolute path of an extended configuration file relative to the
+// current directory, if it's under it
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(wd, path); err == nil && filepath.IsLocal(rel) {
+		return rel
+	}
+	return path
+}
@@ -20,13 +20,23 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/stacklok/frizbee/internal/cli"
 	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/replacer/image"
 	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+)
+
+const (
+	// droneAlias is the alias of the command pinning the images of a Drone CI pipeline
+	droneAlias = "drone"
+	// droneConfigPath is the pipeline pinned by the drone alias when no path is given
+	droneConfigPath = ".drone.yml"
 )
 
 // CmdContainerImage represents the containers command
@@ -42,18 +52,41 @@ Example:
 	$ frizbee image <path-to-yaml-files> or <ghcr.io/stacklok/minder/server:latest>
 
 This will replace all tag or branch references in all yaml files for the given directory.
+With --stdin, a single file is read from stdin and written to stdout instead:
+
+	$ cat docker-compose.yml | frizbee image --stdin > pinned.yml
+
+The images of the steps, services and clone step of a Drone CI pipeline, along with
+the plugin settings under an image key, are pinned with the drone alias, which defaults
+to the .drone.yml of the current directory:
+
+	$ frizbee drone
 `,
 		RunE:         replaceCmd,
 		SilenceUsage: true,
-		Aliases:      []string{"containerimage", "dockercompose", "compose"}, // backwards compatibility
-		Args:         cobra.ExactArgs(1),
+		// containerimage, dockercompose and compose are kept for backwards compatibility
+		Aliases: []string{"containerimage", "dockercompose", "compose", droneAlias},
+		Args:    cobra.MaximumNArgs(1),
 	}
 
 	// flags
 	cli.DeclareFrizbeeFlags(cmd, false)
+	cli.DeclareCacheFlags(cmd)
+	cli.DeclareChangedFlags(cmd)
+	cmd.Flags().Bool("stdin", false, "read a file from stdin and write the result to stdout")
+	cmd.Flags().Bool("fail-on-unresolved", false, "fail if any reference can't be resolved instead of leaving it untouched")
+	cmd.Flags().Bool("expand-env", false, "expand the $VAR and ${VAR} environment variables of the image references")
+	cmd.Flags().StringSlice("insecure-registries", nil,
+		"registries, as host or host:port, to talk to without verifying their TLS certificates, or over plain HTTP")
+	cmd.Flags().String("registry-ca-cert", "", "PEM file of the certificate authorities to verify registries against")
+	cmd.Flags().String("docker-config", "", "docker config.json to read the registry credentials from instead of the default one")
+	cmd.Flags().StringSlice("platforms", nil,
+		"require pinned images to be available for all the given platforms, e.g. linux/amd64,linux/arm64")
 
 	// sub-commands
 	cmd.AddCommand(CmdList())
+	cmd.AddCommand(CmdCheck())
+	cmd.AddCommand(CmdResolve())
 
 	return cmd
 }
@@ -65,6 +98,41 @@ func replaceCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	failOnUnresolved, err := cmd.Flags().GetBool("fail-on-unresolved")
+	if err != nil {
+		return err
+	}
+	platforms, err := cmd.Flags().GetStringSlice("platforms")
+	if err != nil {
+		return err
+	}
+	expandEnv, err := cmd.Flags().GetBool("expand-env")
+	if err != nil {
+		return err
+	}
+	dockerConfig, err := cmd.Flags().GetString("docker-config")
+	if err != nil {
+		return err
+	}
+	insecureRegistries, err := cmd.Flags().GetStringSlice("insecure-registries")
+	if err != nil {
+		return err
+	}
+	registryCACert, err := cmd.Flags().GetString("registry-ca-cert")
+	if err != nil {
+		return err
+	}
+	stdin, err := cmd.Flags().GetBool("stdin")
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 && !stdin && cmd.CalledAs() == droneAlias {
+		args = []string{droneConfigPath}
+	}
+	if stdin != (len(args) == 0) {
+		return errors.New("either a path or reference, or --stdin, is required")
+	}
+
 	// Set up the config
 	cfg, err := config.FromCommand(cmd)
 	if err != nil {
@@ -73,17 +141,88 @@ func replaceCmd(cmd *cobra.Command, args []string) error {
 
 	// Create a new replacer
 	r := replacer.NewContainerImagesReplacer(cfg).
-		WithUserRegex(cliFlags.Regex)
+		WithUserRegex(cliFlags.Regex).
+		WithMaxConcurrency(cliFlags.Jobs).
+		WithRetry(retry.DefaultPolicy()).
+		WithConfigDiscovery(cliFlags.DiscoverConfig).
+		WithLogger(cliFlags.Logger()).
+		WithProgress(cliFlags.ProgressFunc())
+	if failOnUnresolved {
+		r = r.WithFailOnUnresolved()
+	}
+	if expandEnv {
+		r = r.WithEnvExpansion(nil)
+	}
+	if len(platforms) > 0 {
+		if r, err = r.WithPlatforms(platforms); err != nil {
+			return err
+		}
+	}
+	if dockerConfig != "" {
+		if r, err = r.WithDockerConfig(dockerConfig); err != nil {
+			return err
+		}
+	}
+	if len(insecureRegistries) > 0 {
+		r = r.WithInsecureRegistries(insecureRegistries)
+	}
+	if registryCACert != "" {
+		if r, err = r.WithRegistryCACert(registryCACert); err != nil {
+			return err
+		}
+	}
+
+	cache, saveCache, err := cliFlags.OpenCaches()
+	if err != nil {
+		return err
+	}
+	defer saveCache()
+	defer cliFlags.EndProgress()
+	if cache != nil {
+		r = r.WithCache(cache)
+	}
 
+	if stdin {
+		// Replace the tags in the file read from stdin
+		parse := r.ParseFile
+		if cliFlags.Unpin {
+			parse = r.UnpinFile
+		}
+		return cliFlags.ProcessStdin(parse)
+	}
 	if cli.IsPath(args[0]) {
 		dir := filepath.Clean(args[0])
+		changed, err := cli.ChangedFilesFromFlags(cmd, dir)
+		if err != nil {
+			return err
+		}
+		if changed != nil {
+			r = r.WithOnlyFiles(changed)
+		}
 		// Replace the tags in the directory
-		res, err := r.ParsePath(cmd.Context(), dir)
+		parse := r.ParsePath
+		if cliFlags.Unpin {
+			parse = r.UnpinPath
+		}
+		res, err := parse(cmd.Context(), dir)
 		if err != nil {
 			return err
 		}
 		// Process the output files
-		return cliFlags.ProcessOutput(dir, res.Processed, res.Modified)
+		err = cliFlags.ProcessOutput(dir, res.Processed, res.Modified, res.Changes)
+		cliFlags.PrintSkipped(res.Skipped)
+		totals := res.Totals()
+		cliFlags.PrintSummary(cli.Summary{
+			FilesProcessed: len(res.Processed),
+			FilesModified:  len(res.Modified),
+			Pinned:         totals.Modified,
+			Skipped:        totals.Skipped,
+			Errored:        totals.Errored,
+		})
+		return err
+	}
+	if cliFlags.Unpin {
+		return errors.New("unpinning requires a path, the tag of a single reference can't be recovered")
 	}
 	// Replace the passed reference
 	res, err := r.ParseString(cmd.Context(), args[0])
@@ -94,7 +233,21 @@ func replaceCmd(cmd *cobra.Command, args []string) error {
 		}
 		return err
 	}
-	fmt.Fprintf(cmd.OutOrStdout(), "%s@%s\n", res.Name, res.Ref) // nolint:errcheck
-	return nil
+	// The prefix keeps the transport of the reference, if any, e.g. docker://
+	fmt.Fprintf(cmd.OutOrStdout(), "%s%s@%s\n", res.Prefix, res.Name, res.Ref) // nolint:errcheck
 
+	// Tell whether the digest covers several platforms, it's only informative so errors are ignored
+	available, isIndex, err := image.GetPlatforms(cmd.Context(), res.Name+"@"+res.Ref, cfg)
+	if err == nil {
+		names := make([]string, 0, len(available))
+		for _, p := range available {
+			names = append(names, p.String())
+		}
+		kind := "single-platform image"
+		if isIndex {
+			kind = "multi-platform index"
+		}
+		cliFlags.Logf("%s is a %s for %s\n", res.Ref, kind, strings.Join(names, ", "))
+	}
+	return nil
 }
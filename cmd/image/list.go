@@ -26,6 +26,8 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/internal/sarif"
+	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/replacer"
 	"github.com/stacklok/frizbee/pkg/utils/config"
 )
@@ -47,6 +49,9 @@ Example:
 	}
 
 	cli.DeclareFrizbeeFlags(cmd, true)
+	cmd.Flags().Bool("unpinned-only", false, "only list the references using a mutable tag or branch")
+	cmd.Flags().Bool("media-types", false,
+		"ask the registries for the media type of the manifest each image refers to, shown by the json and jsonl outputs")
 
 	return cmd
 }
@@ -70,18 +75,57 @@ func list(cmd *cobra.Command, args []string) error {
 
 	// Create a new replacer
 	r := replacer.NewContainerImagesReplacer(cfg).
-		WithUserRegex(cliFlags.Regex)
+		WithUserRegex(cliFlags.Regex).
+		WithMaxConcurrency(cliFlags.Jobs)
+
+	unpinnedOnly, err := cmd.Flags().GetBool("unpinned-only")
+	if err != nil {
+		return fmt.Errorf("failed to get unpinned-only flag: %w", err)
+	}
+	mediaTypes, err := cmd.Flags().GetBool("media-types")
+	if err != nil {
+		return fmt.Errorf("failed to get media-types flag: %w", err)
+	}
+	// The listed references aren't resolved, so their media type is only known if asked for
+	listed := func(e interfaces.EntityRef) cli.Listed {
+		if mediaTypes {
+			mediaType, err := r.ResolveMediaType(cmd.Context(), e)
+			if err != nil {
+				cliFlags.Logf("Failed to get the media type of %s: %v\n", e.Name, err)
+			}
+			e.MediaType = mediaType
+		}
+		return cli.Listed{EntityRef: e, Pinned: replacer.IsPinned(e)}
+	}
+
+	output := cmd.Flag("output").Value.String()
+	if output == "jsonl" {
+		// Stream the references as they're found rather than buffering them
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		return r.ListPathFunc(dir, func(e interfaces.EntityRef) error {
+			if unpinnedOnly && replacer.IsPinned(e) {
+				return nil
+			}
+			return enc.Encode(listed(e))
+		})
+	}
 
 	// List the references in the directory
 	res, err := r.ListPath(dir)
 	if err != nil {
 		return err
 	}
+	if unpinnedOnly {
+		res = res.WithoutPinned()
+	}
 
-	output := cmd.Flag("output").Value.String()
 	switch output {
 	case "json":
-		jsonBytes, err := json.MarshalIndent(res.Entities, "", "  ")
+		entities := make([]cli.Listed, 0, len(res.Entities))
+		for _, e := range res.Entities {
+			entities = append(entities, listed(e))
+		}
+		jsonBytes, err := json.MarshalIndent(entities, "", "  ")
 		if err != nil {
 			return err
 		}
@@ -89,13 +133,23 @@ func list(cmd *cobra.Command, args []string) error {
 		fmt.Fprintln(cmd.OutOrStdout(), jsonString) // nolint:errcheck
 		return nil
 	case "table":
+		reasons := res.SkipReasons()
 		table := tablewriter.NewWriter(cmd.OutOrStdout())
-		table.SetHeader([]string{"No", "Type", "Name", "Ref"})
+		table.SetHeader([]string{"No", "Type", "Name", "Ref", "Pinned", "Skipped"})
 		for i, a := range res.Entities {
-			table.Append([]string{strconv.Itoa(i + 1), a.Type, a.Name, a.Ref})
+			table.Append([]string{
+				strconv.Itoa(i + 1),
+				a.Type,
+				a.Name,
+				a.Ref,
+				strconv.FormatBool(replacer.IsPinned(a)),
+				string(reasons[a]),
+			})
 		}
 		table.Render()
 		return nil
+	case "sarif":
+		return sarif.FromListResult(res, filepath.Dir(dir)).Write(cmd.OutOrStdout())
 	default:
 		return fmt.Errorf("unknown output format: %s", output)
 	}
@@ -0,0 +1,62 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+)
+
+// CmdResolve represents the resolve sub-command
+func CmdResolve() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resolve",
+		Short: "Resolves the container images listed in a file",
+		Long: `This utility resolves the container images listed one per line in a file
+and prints their pinned form. Images failing to resolve are reported without
+stopping the others.
+
+Example:
+	frizbee image resolve --from-file refs.txt
+`,
+		RunE:         resolve,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+	}
+
+	cli.DeclareResolveFlags(cmd)
+	cmd.Flags().StringP("platform", "p", "", "platform to match artifact references, e.g. linux/amd64 or linux/arm/v7")
+
+	return cmd
+}
+
+func resolve(cmd *cobra.Command, _ []string) error {
+	// Set up the config
+	cfg, err := config.FromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Create a new replacer
+	r := replacer.NewContainerImagesReplacer(cfg).
+		WithRetry(retry.DefaultPolicy())
+
+	return cli.ResolveFromFile(cmd, r.ParseString)
+}
@@ -0,0 +1,174 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pin provides a command-line utility to pin the references matched by a custom regex.
+package pin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+)
+
+const (
+	// typeAction resolves the matched references as GitHub Actions
+	typeAction = "action"
+	// typeImage resolves the matched references as container images
+	typeImage = "image"
+)
+
+// CmdPin represents the pin command
+func CmdPin() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pin",
+		Short: "Replace the references matched by a custom regex in files of any format",
+		Long: `This utility replaces the references matched by the given regex with their
+checksum or digest, resolving them as GitHub Actions or container images depending
+on the given type. It's meant for files of a format Frizbee doesn't know about.
+
+Example:
+
+	$ frizbee pin --type action --regex '[\w.-]+/[\w.-]+@v[\w.]+' <path/to/file>
+
+Each match is resolved as a whole, so the regex should only match the reference,
+e.g. actions/checkout@v4 or ghcr.io/stacklok/minder/server:v1. A file given as the
+path is processed whatever its name, while a directory is traversed like by the
+actions and image commands.
+
+` + cli.TokenHelpText + "\n",
+		RunE:         pinCmd,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+	}
+
+	// flags
+	cli.DeclareFrizbeeFlags(cmd, false)
+	cli.DeclareCacheFlags(cmd)
+	cli.DeclareGitHubTokenFlags(cmd)
+	cmd.Flags().StringP("type", "t", "", "type of the matched references, i.e. 'action' or 'image'")
+	cmd.Flags().Bool("wait-on-rate-limit", false, "wait for the GitHub API rate limit to reset instead of failing")
+	cmd.Flags().Bool("fail-on-unresolved", false, "fail if any reference can't be resolved instead of leaving it untouched")
+	_ = cmd.MarkFlagRequired("type")
+	_ = cmd.MarkFlagRequired("regex")
+
+	return cmd
+}
+
+func pinCmd(cmd *cobra.Command, args []string) error {
+	path := filepath.Clean(args[0])
+	if !cli.IsPath(path) {
+		return errors.New("the provided argument is not a path")
+	}
+
+	// Extract the CLI flags from the cobra command
+	cliFlags, err := cli.NewHelper(cmd)
+	if err != nil {
+		return err
+	}
+
+	refType, err := cmd.Flags().GetString("type")
+	if err != nil {
+		return err
+	}
+	waitOnRateLimit, err := cmd.Flags().GetBool("wait-on-rate-limit")
+	if err != nil {
+		return err
+	}
+	failOnUnresolved, err := cmd.Flags().GetBool("fail-on-unresolved")
+	if err != nil {
+		return err
+	}
+
+	// Set up the config
+	cfg, err := config.FromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Create a new replacer resolving the references of the given type
+	var r *replacer.Replacer
+	switch refType {
+	case typeAction:
+		token, err := cli.ResolveGitHubToken(cmd)
+		if err != nil {
+			return err
+		}
+		retryPolicy := retry.DefaultPolicy()
+		if waitOnRateLimit {
+			// The primary rate limit resets every hour
+			retryPolicy.MaxDelay = time.Hour
+		}
+		r = replacer.NewGitHubActionsReplacer(cfg).WithGitHubClientFromToken(token).WithRetry(retryPolicy)
+		if apiURL := os.Getenv(cli.GitHubAPIURLEnvKey); apiURL != "" {
+			if r, err = r.WithGitHubBaseURL(apiURL); err != nil {
+				return err
+			}
+		}
+	case typeImage:
+		r = replacer.NewContainerImagesReplacer(cfg).WithRetry(retry.DefaultPolicy())
+	default:
+		return fmt.Errorf("unknown reference type %q, it can be '%s' or '%s'", refType, typeAction, typeImage)
+	}
+	// The files given explicitly are of a custom format whatever their name
+	r = r.WithUserRegex(cliFlags.Regex).
+		WithAnyFileName().
+		WithMaxConcurrency(cliFlags.Jobs).
+		WithConfigDiscovery(cliFlags.DiscoverConfig).
+		WithLogger(cliFlags.Logger()).
+		WithProgress(cliFlags.ProgressFunc())
+	if failOnUnresolved {
+		r = r.WithFailOnUnresolved()
+	}
+
+	cache, saveCache, err := cliFlags.OpenCaches()
+	if err != nil {
+		return err
+	}
+	defer saveCache()
+	defer cliFlags.EndProgress()
+	if cache != nil {
+		r = r.WithCache(cache)
+	}
+
+	// Replace the references in the given file or directory
+	parse := r.ParsePath
+	if cliFlags.Unpin {
+		parse = r.UnpinPath
+	}
+	res, err := parse(cmd.Context(), path)
+	if err != nil {
+		return cli.ExplainRateLimit(err)
+	}
+	// Process the output files
+	err = cliFlags.ProcessOutput(path, res.Processed, res.Modified, res.Changes)
+	totals := res.Totals()
+	cliFlags.PrintSummary(cli.Summary{
+		FilesProcessed: len(res.Processed),
+		FilesModified:  len(res.Modified),
+		Pinned:         totals.Modified,
+		Skipped:        totals.Skipped,
+		Errored:        totals.Errored,
+	})
+	return err
+}
@@ -0,0 +1,141 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package precommit provides command-line utilities to work with pre-commit hook repositories.
+package precommit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+)
+
+// defaultPath is the directory holding the pre-commit configuration
+const defaultPath = "."
+
+// CmdPreCommit represents the pre-commit command
+func CmdPreCommit() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pre-commit",
+		Short: "Replace tags in pre-commit hook repositories",
+		Long: `This utility replaces the rev of the hook repositories hosted on GitHub, i.e.
+a tag or branch, in pre-commit configurations with the commit hash it points at.
+
+Example:
+
+	$ frizbee pre-commit <path/to/repo>
+
+This will replace the revs in all .pre-commit-config.yaml files for the given
+directory, keeping the original rev in a trailing comment.
+
+` + cli.TokenHelpText + "\n",
+		RunE:         replaceCmd,
+		SilenceUsage: true,
+		Args:         cobra.MaximumNArgs(1),
+	}
+
+	// flags
+	cli.DeclareFrizbeeFlags(cmd, false)
+	cli.DeclareGitHubTokenFlags(cmd)
+	cli.DeclareCacheFlags(cmd)
+	cmd.Flags().Bool("wait-on-rate-limit", false, "wait for the GitHub API rate limit to reset instead of failing")
+
+	return cmd
+}
+
+func replaceCmd(cmd *cobra.Command, args []string) error {
+	// Set the default directory if not provided
+	dir := defaultPath
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	// The rev of a hook repository can't be resolved on its own
+	dir = filepath.Clean(dir)
+	if !cli.IsPath(dir) {
+		return errors.New("the provided argument is not a path")
+	}
+
+	// Extract the CLI flags from the cobra command
+	cliFlags, err := cli.NewHelper(cmd)
+	if err != nil {
+		return err
+	}
+
+	waitOnRateLimit, err := cmd.Flags().GetBool("wait-on-rate-limit")
+	if err != nil {
+		return err
+	}
+
+	// Set up the config
+	cfg, err := config.FromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	token, err := cli.ResolveGitHubToken(cmd)
+	if err != nil {
+		return err
+	}
+
+	retryPolicy := retry.DefaultPolicy()
+	if waitOnRateLimit {
+		// The primary rate limit resets every hour
+		retryPolicy.MaxDelay = time.Hour
+	}
+
+	// Create a new replacer
+	r := replacer.NewPreCommitHooksReplacer(cfg).
+		WithUserRegex(cliFlags.Regex).
+		WithMaxConcurrency(cliFlags.Jobs).
+		WithGitHubClientFromToken(token)
+	if apiURL := os.Getenv(cli.GitHubAPIURLEnvKey); apiURL != "" {
+		if r, err = r.WithGitHubBaseURL(apiURL); err != nil {
+			return err
+		}
+	}
+	r = r.WithRetry(retryPolicy).WithConfigDiscovery(cliFlags.DiscoverConfig).WithLogger(cliFlags.Logger()).
+		WithProgress(cliFlags.ProgressFunc())
+
+	cache, saveCache, err := cliFlags.OpenCaches()
+	if err != nil {
+		return err
+	}
+	defer saveCache()
+	defer cliFlags.EndProgress()
+	if cache != nil {
+		r = r.WithCache(cache)
+	}
+
+	// Replace the revs in the given directory
+	parse := r.ParsePath
+	if cliFlags.Unpin {
+		parse = r.UnpinPath
+	}
+	res, err := parse(cmd.Context(), dir)
+	if err != nil {
+		return cli.ExplainRateLimit(err)
+	}
+	// Process the output files
+	return cliFlags.ProcessOutput(dir, res.Processed, res.Modified, res.Changes)
+}
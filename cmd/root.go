@@ -24,7 +24,13 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/stacklok/frizbee/cmd/actions"
+	"github.com/stacklok/frizbee/cmd/all"
+	"github.com/stacklok/frizbee/cmd/circleci"
+	configcmd "github.com/stacklok/frizbee/cmd/config"
 	"github.com/stacklok/frizbee/cmd/image"
+	"github.com/stacklok/frizbee/cmd/pin"
+	"github.com/stacklok/frizbee/cmd/precommit"
+	"github.com/stacklok/frizbee/cmd/terraform"
 	"github.com/stacklok/frizbee/cmd/version"
 	"github.com/stacklok/frizbee/pkg/utils/config"
 )
@@ -41,6 +47,12 @@ func Execute() {
 
 	rootCmd.AddCommand(actions.CmdGHActions())
 	rootCmd.AddCommand(image.CmdContainerImage())
+	rootCmd.AddCommand(circleci.CmdCircleCI())
+	rootCmd.AddCommand(terraform.CmdTerraform())
+	rootCmd.AddCommand(precommit.CmdPreCommit())
+	rootCmd.AddCommand(pin.CmdPin())
+	rootCmd.AddCommand(all.CmdAll())
+	rootCmd.AddCommand(configcmd.CmdConfig())
 	rootCmd.AddCommand(version.CmdVersion())
 
 	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
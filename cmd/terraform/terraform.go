@@ -0,0 +1,188 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package terraform provides command-line utilities to work with Terraform and OpenTofu module sources.
+package terraform
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/replacer/terraform"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+)
+
+// defaultPath is the directory holding the Terraform configuration
+const defaultPath = "."
+
+// CmdTerraform represents the terraform command
+func CmdTerraform() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "terraform",
+		Short: "Replace tags in Terraform and OpenTofu module sources",
+		Long: `This utility replaces tag or branch references of Terraform and OpenTofu modules
+fetched from GitHub, i.e. the ref of their git source, with the commit hash of the
+referenced tag or branch.
+
+Example:
+
+	$ frizbee terraform <path/to/terraform> or <github.com/org/mod?ref=v1.2.0>
+
+This will replace all tag or branch references in all .tf and .tofu files
+for the given directory. Supports both directories and single references.
+
+` + cli.TokenHelpText + "\n",
+		Aliases:      []string{"tofu"},
+		RunE:         replaceCmd,
+		SilenceUsage: true,
+		Args:         cobra.MaximumNArgs(1),
+	}
+
+	// flags
+	cli.DeclareFrizbeeFlags(cmd, false)
+	cli.DeclareGitHubTokenFlags(cmd)
+	cli.DeclareCacheFlags(cmd)
+	cmd.Flags().Bool("wait-on-rate-limit", false, "wait for the GitHub API rate limit to reset instead of failing")
+	cmd.Flags().Bool("fail-on-unresolved", false, "fail if any reference can't be resolved instead of leaving it untouched")
+
+	// sub-commands
+	cmd.AddCommand(CmdList())
+	cmd.AddCommand(CmdCheck())
+
+	return cmd
+}
+
+// nolint:errcheck
+func replaceCmd(cmd *cobra.Command, args []string) error {
+	// Set the default directory if not provided
+	pathOrRef := defaultPath
+	if len(args) > 0 {
+		pathOrRef = args[0]
+	}
+
+	// Extract the CLI flags from the cobra command
+	cliFlags, err := cli.NewHelper(cmd)
+	if err != nil {
+		return err
+	}
+
+	waitOnRateLimit, err := cmd.Flags().GetBool("wait-on-rate-limit")
+	if err != nil {
+		return err
+	}
+	failOnUnresolved, err := cmd.Flags().GetBool("fail-on-unresolved")
+	if err != nil {
+		return err
+	}
+
+	// Set up the config
+	cfg, err := config.FromCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	retryPolicy := retry.DefaultPolicy()
+	if waitOnRateLimit {
+		// The primary rate limit resets every hour
+		retryPolicy.MaxDelay = time.Hour
+	}
+
+	// Create a new replacer
+	r, err := newReplacer(cmd, cfg, cliFlags.Regex, cliFlags.Jobs)
+	if err != nil {
+		return err
+	}
+	r = r.WithRetry(retryPolicy).WithConfigDiscovery(cliFlags.DiscoverConfig).WithLogger(cliFlags.Logger()).
+		WithProgress(cliFlags.ProgressFunc())
+	if failOnUnresolved {
+		r = r.WithFailOnUnresolved()
+	}
+
+	cache, saveCache, err := cliFlags.OpenCaches()
+	if err != nil {
+		return err
+	}
+	defer saveCache()
+	defer cliFlags.EndProgress()
+	if cache != nil {
+		r = r.WithCache(cache)
+	}
+
+	if cli.IsPath(pathOrRef) {
+		dir := filepath.Clean(pathOrRef)
+		// Replace the refs in the given directory
+		parse := r.ParsePath
+		if cliFlags.Unpin {
+			parse = r.UnpinPath
+		}
+		res, err := parse(cmd.Context(), dir)
+		if err != nil {
+			return cli.ExplainRateLimit(err)
+		}
+		// Process the output files
+		err = cliFlags.ProcessOutput(dir, res.Processed, res.Modified, res.Changes)
+		cliFlags.PrintSkipped(res.Skipped)
+		totals := res.Totals()
+		cliFlags.PrintSummary(cli.Summary{
+			FilesProcessed: len(res.Processed),
+			FilesModified:  len(res.Modified),
+			Pinned:         totals.Modified,
+			Skipped:        totals.Skipped,
+			Errored:        totals.Errored,
+		})
+		return err
+	}
+	if cliFlags.Unpin {
+		return errors.New("unpinning requires a path, the tag of a single reference can't be recovered")
+	}
+	// Replace the passed reference
+	res, err := r.ParseString(cmd.Context(), pathOrRef)
+	if err != nil {
+		if errors.Is(err, interfaces.ErrReferenceSkipped) {
+			fmt.Fprintln(cmd.OutOrStdout(), pathOrRef) // nolint:errcheck
+			return nil
+		}
+		return cli.ExplainRateLimit(err)
+	}
+	// Keep the other parameters of the source, e.g. depth
+	fmt.Fprintln(cmd.OutOrStdout(), terraform.New().FormatReference(pathOrRef, res.Ref)) // nolint:errcheck
+	return nil
+}
+
+// newReplacer creates a replacer for module sources set up from the environment
+// and the GitHub token flags
+func newReplacer(cmd *cobra.Command, cfg *config.Config, regex string, jobs int) (*replacer.Replacer, error) {
+	token, err := cli.ResolveGitHubToken(cmd)
+	if err != nil {
+		return nil, err
+	}
+	r := replacer.NewTerraformModulesReplacer(cfg).
+		WithUserRegex(regex).
+		WithMaxConcurrency(jobs).
+		WithGitHubClientFromToken(token)
+	if apiURL := os.Getenv(cli.GitHubAPIURLEnvKey); apiURL != "" {
+		return r.WithGitHubBaseURL(apiURL)
+	}
+	return r, nil
+}
@@ -0,0 +1,103 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// DefaultBaseRef is the git reference the changed files are compared against by default
+const DefaultBaseRef = "origin/main"
+
+// DeclareChangedFlags declares the flags restricting the processed files to the ones changed
+// in a git repository.
+func DeclareChangedFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("changed-only", false, "only process the files changed since the base ref, according to git")
+	cmd.Flags().String("base-ref", DefaultBaseRef, "git reference the files changed by --changed-only are compared against")
+}
+
+// ChangedFilesFromFlags returns the files of the given directory changed since the base ref
+// if the changed-only flag is set, or nil if every file is to be processed.
+func ChangedFilesFromFlags(cmd *cobra.Command, dir string) ([]string, error) {
+	changedOnly, err := cmd.Flags().GetBool("changed-only")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed-only flag: %w", err)
+	}
+	if !changedOnly {
+		return nil, nil
+	}
+	baseRef, err := cmd.Flags().GetString("base-ref")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get base-ref flag: %w", err)
+	}
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	files, err := ChangedFiles(ctx, dir, baseRef)
+	if err != nil {
+		return nil, err
+	}
+	// Process none rather than all of them if nothing changed
+	if files == nil {
+		files = []string{}
+	}
+	return files, nil
+}
+
+// ChangedFiles returns the files of the given directory of a git repository, relative to it,
+// which changed since their merge base with the given ref, i.e. the files changed by a
+// pull request against it, uncommitted changes included, along with the untracked files.
+// Deleted files aren't returned.
+func ChangedFiles(ctx context.Context, dir, baseRef string) ([]string, error) {
+	changed, err := git(ctx, dir, "diff", "--name-only", "--relative", "--diff-filter=d", "--merge-base", baseRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files changed since %s: %w", baseRef, err)
+	}
+	untracked, err := git(ctx, dir, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the untracked files: %w", err)
+	}
+	return append(changed, untracked...), nil
+}
+
+// git runs the git command with the given arguments in the given directory and returns
+// the lines it printed. Paths are printed as they are rather than quoted.
+func git(ctx context.Context, dir string, args ...string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "core.quotePath=false", "-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
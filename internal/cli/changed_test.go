@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+// newGitRepo creates a git repository with a commit holding the given files on its main branch
+func newGitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	run("init", "--quiet", "--initial-branch", "main")
+	writeFiles(t, dir, files)
+	run("add", ".")
+	run("-c", "user.name=frizbee", "-c", "user.email=frizbee@example.com", "commit", "--quiet", "-m", "initial")
+	return dir
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := newGitRepo(t, map[string]string{
+		".github/workflows/ci.yml":      "      - uses: actions/checkout@v4\n",
+		".github/workflows/release.yml": "      - uses: actions/setup-go@v5\n",
+		"Dockerfile":                    "FROM alpine:3.20\n",
+	})
+	writeFiles(t, dir, map[string]string{
+		".github/workflows/ci.yml":  "      - uses: actions/checkout@v3\n",
+		".github/workflows/new.yml": "      - uses: actions/cache@v4\n",
+		"Dockerfile":                "FROM alpine:3.21\n",
+	})
+
+	// Only the files of the given directory are listed, relative to it
+	files, err := ChangedFiles(context.Background(), filepath.Join(dir, ".github", "workflows"), "main")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"ci.yml", "new.yml"}, files)
+
+	files, err = ChangedFiles(context.Background(), dir, "main")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{".github/workflows/ci.yml", ".github/workflows/new.yml", "Dockerfile"}, files)
+
+	_, err = ChangedFiles(context.Background(), dir, "does-not-exist")
+	require.Error(t, err)
+}
+
+func TestChangedFilesFromFlags(t *testing.T) {
+	t.Parallel()
+
+	dir := newGitRepo(t, map[string]string{"ci.yml": "      - uses: actions/checkout@v4\n"})
+
+	testCases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{name: "AllFiles", args: nil, expected: nil},
+		// An empty list processes no file rather than all of them
+		{name: "NothingChanged", args: []string{"--changed-only", "--base-ref", "main"}, expected: []string{}},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := &cobra.Command{}
+			DeclareChangedFlags(cmd)
+			require.NoError(t, cmd.ParseFlags(tt.args))
+			files, err := ChangedFilesFromFlags(cmd, dir)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, files)
+		})
+	}
+}
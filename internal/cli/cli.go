@@ -17,16 +17,29 @@
 package cli
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"runtime/debug"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/internal/traverse"
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/ghrest"
+	"github.com/stacklok/frizbee/pkg/utils/store"
 )
 
 const (
@@ -37,10 +50,27 @@ const (
 	// GitHubTokenEnvKey is the environment variable key for the GitHub token
 	//nolint:gosec // This is not a hardcoded credential
 	GitHubTokenEnvKey = "GITHUB_TOKEN"
+	// GitHubAPIURLEnvKey is the environment variable key for the GitHub API URL,
+	// e.g. of a GitHub Enterprise Server. It's set by GitHub Actions runners as well.
+	GitHubAPIURLEnvKey = "GITHUB_API_URL"
 
 	// TokenHelpText is the help text for the GitHub token
 	TokenHelpText = "NOTE: It's recommended to set the " + GitHubTokenEnvKey +
-		" environment variable given that GitHub has tighter rate limits on anonymous calls."
+		" environment variable given that GitHub has tighter rate limits on anonymous calls.\n" +
+		"To resolve actions hosted on a GitHub Enterprise Server, set the " + GitHubAPIURLEnvKey +
+		" environment variable to its API URL."
+	// CircleCITokenEnvKey is the environment variable key for the CircleCI personal API token
+	//nolint:gosec // This is not a hardcoded credential
+	CircleCITokenEnvKey = "CIRCLECI_TOKEN"
+	// CircleCIHostEnvKey is the environment variable key for the CircleCI host, e.g. of a CircleCI server installation
+	CircleCIHostEnvKey = "CIRCLECI_HOST"
+	// CircleCITokenHelpText is the help text for the CircleCI token
+	CircleCITokenHelpText = "NOTE: To resolve private orbs, set the " + CircleCITokenEnvKey +
+		" environment variable to a CircleCI personal API token.\n" +
+		"To resolve orbs published on a CircleCI server installation, set the " + CircleCIHostEnvKey +
+		" environment variable to its URL."
+	// cacheFileName is the name of the file holding the resolved references within the cache directory
+	cacheFileName   = "refs.json"
 	verboseTemplate = `Version: {{ .Version }}
 Go Version: {{.GoVersion}}
 Git Commit: {{.Commit}}
@@ -50,14 +80,54 @@ Dirty: {{.Modified}}
 `
 )
 
+// ErrModified is returned when references were pinned or unpinned and the command
+// should exit with an error code in that case
+var ErrModified = errors.New("references were modified")
+
 // Helper is a common struct for implementing a CLI command that replaces
 // files.
 type Helper struct {
 	DryRun        bool
 	Quiet         bool
 	ErrOnModified bool
+	Unpin         bool
 	Regex         string
-	Cmd           *cobra.Command
+	Jobs          int
+	Summary       bool
+	ReportSkipped bool
+	// Verbose logs the resolution of each reference to stderr, see Logger
+	Verbose bool
+	// Output is the format the changes are written in, i.e. text or json
+	Output string
+	// DiscoverConfig is the name of the configuration files discovered next to the
+	// processed files, empty unless asked for
+	DiscoverConfig string
+	// OutDir is the directory the modified files are written to, under their path relative
+	// to the parent of the processed one, instead of overwriting them. Empty by default.
+	OutDir string
+	// Progress prints the number of files processed so far to stderr, if it's a terminal,
+	// see ProgressFunc
+	Progress bool
+	Cmd      *cobra.Command
+
+	progress *progressPrinter
+}
+
+// Summary holds the totals of a run printed by PrintSummary
+type Summary struct {
+	FilesProcessed int
+	FilesModified  int
+	// Pinned is the number of references pinned, or unpinned
+	Pinned  int
+	Skipped int
+	Errored int
+}
+
+// Listed is a reference found by the list commands, along with whether it's already
+// pinned by its checksum or digest
+type Listed struct {
+	interfaces.EntityRef
+	Pinned bool `json:"pinned"`
 }
 
 type versionInfo struct {
@@ -131,17 +201,72 @@ func NewHelper(cmd *cobra.Command) (*Helper, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get quiet flag: %w", err)
 	}
+	unpin, err := cmd.Flags().GetBool("unpin")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unpin flag: %w", err)
+	}
 	regex, err := cmd.Flags().GetString("regex")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get regex flag: %w", err)
 	}
+	jobs, err := cmd.Flags().GetInt("jobs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs flag: %w", err)
+	}
+	summary, err := cmd.Flags().GetBool("summary")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get summary flag: %w", err)
+	}
+	reportSkipped, err := cmd.Flags().GetBool("report-skipped")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report-skipped flag: %w", err)
+	}
+	verbose, err := cmd.Flags().GetBool("verbose")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get verbose flag: %w", err)
+	}
+	discover, err := cmd.Flags().GetBool("discover-config")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get discover-config flag: %w", err)
+	}
+	// The list commands don't write any file
+	var outDir string
+	if f := cmd.Flags().Lookup("out-dir"); f != nil {
+		outDir = f.Value.String()
+	}
+	var progress bool
+	if f := cmd.Flags().Lookup("progress"); f != nil {
+		progress = f.Value.String() == "true"
+	}
+	// The list commands have an output flag of their own, unrelated to the changes
+	var output string
+	if f := cmd.Flags().Lookup("output"); f != nil {
+		output = f.Value.String()
+	}
+	var discoverConfig string
+	if discover {
+		// The discovered files are named like the one given by the root config flag
+		discoverConfig = ".frizbee.yml"
+		if f := cmd.Flag("config"); f != nil && f.Value.String() != "" {
+			discoverConfig = filepath.Base(f.Value.String())
+		}
+	}
 
 	return &Helper{
-		Cmd:           cmd,
-		DryRun:        dryRun,
-		ErrOnModified: errOnModified,
-		Quiet:         quiet,
-		Regex:         regex,
+		Cmd:            cmd,
+		DryRun:         dryRun,
+		ErrOnModified:  errOnModified,
+		Quiet:          quiet,
+		Unpin:          unpin,
+		Regex:          regex,
+		Jobs:           jobs,
+		Summary:        summary,
+		ReportSkipped:  reportSkipped,
+		Verbose:        verbose,
+		Output:         output,
+		DiscoverConfig: discoverConfig,
+		OutDir:         outDir,
+		Progress:       progress,
 	}, nil
 }
 
@@ -150,10 +275,244 @@ func DeclareFrizbeeFlags(cmd *cobra.Command, enableOutput bool) {
 	cmd.Flags().BoolP("dry-run", "n", false, "don't modify files")
 	cmd.Flags().BoolP("quiet", "q", false, "don't print anything")
 	cmd.Flags().BoolP("error", "e", false, "exit with error code if any file is modified")
+	cmd.Flags().BoolP("unpin", "u", false, "revert references pinned by digest back to their tags")
 	cmd.Flags().StringP("regex", "r", "", "regex to match artifact references")
-	cmd.Flags().StringP("platform", "p", "", "platform to match artifact references, e.g. linux/amd64")
+	cmd.Flags().StringP("platform", "p", "", "platform to match artifact references, e.g. linux/amd64 or linux/arm/v7")
+	cmd.Flags().Bool("rewrite-registry", false, "replace the registry host of pinned images with the configured mirror")
+	DeclareTraversalFlags(cmd)
+	declareJobsFlag(cmd)
+	cmd.Flags().Bool("summary", false, "print a summary of the processed files and references at the end")
+	cmd.Flags().Bool("discover-config", false,
+		"use the nearest config file, named like the --config one, found above each processed file")
+	cmd.Flags().Bool("report-skipped", false,
+		"print the references skipped on purpose along with the reason, e.g. excluded-tag for latest")
+	cmd.Flags().BoolP("verbose", "v", false,
+		"print how each reference is resolved, i.e. from the cache or the API, and the resolved checksum or digest")
 	if enableOutput {
-		cmd.Flags().StringP("output", "o", "table", "output format. Can be 'json' or 'table'")
+		cmd.Flags().StringP("output", "o", "table", "output format. Can be 'json', 'jsonl', 'table' or 'sarif'")
+	} else {
+		cmd.Flags().StringP("output", "o", "text", "output format of the changes. Can be 'text', printing the "+
+			"modified files on dry runs, or 'json', printing the changed references")
+		cmd.Flags().String("out-dir", "", "directory to write the modified files to, under their relative path, "+
+			"instead of overwriting them")
+		cmd.Flags().Bool("progress", false, "print the number of files processed so far to stderr, if it's a terminal")
+	}
+}
+
+// DeclareTraversalFlags declares the flags configuring which files are processed when
+// traversing a directory.
+func DeclareTraversalFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("respect-gitignore", false,
+		"skip the files ignored by git, along with .git, node_modules and vendor directories")
+	cmd.Flags().Bool("follow-symlinks", false, "walk into the directories symbolic links point to, skipping cycles")
+	cmd.Flags().Int("max-depth", 0, "maximum number of directory levels traversed below the processed one, 0 for no limit")
+}
+
+// DeclareDriftFlags declares the flags of the drift command, which only reports the drifted
+// references without modifying any file.
+func DeclareDriftFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolP("quiet", "q", false, "don't print the references which failed to resolve")
+	cmd.Flags().StringP("regex", "r", "", "regex to match artifact references")
+	cmd.Flags().StringP("output", "o", "text", "output format of the drifted references. Can be 'text' or 'json'")
+	DeclareTraversalFlags(cmd)
+	declareJobsFlag(cmd)
+}
+
+// NewDriftHelper returns the helper of a command declared with DeclareDriftFlags
+func NewDriftHelper(cmd *cobra.Command) (*Helper, error) {
+	quiet, err := cmd.Flags().GetBool("quiet")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quiet flag: %w", err)
+	}
+	regex, err := cmd.Flags().GetString("regex")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get regex flag: %w", err)
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get output flag: %w", err)
+	}
+	jobs, err := cmd.Flags().GetInt("jobs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs flag: %w", err)
+	}
+
+	return &Helper{
+		Cmd:    cmd,
+		Quiet:  quiet,
+		Regex:  regex,
+		Jobs:   jobs,
+		Output: output,
+	}, nil
+}
+
+// declareJobsFlag declares the flag limiting the number of files processed concurrently
+func declareJobsFlag(cmd *cobra.Command) {
+	// Same as replacer.DefaultMaxConcurrency, which can't be imported from here
+	cmd.Flags().IntP("jobs", "j", runtime.NumCPU()*4, "maximum number of files processed concurrently, 0 for no limit")
+}
+
+// DeclareCacheFlags declares the flags configuring the persistent cache of resolved references.
+func DeclareCacheFlags(cmd *cobra.Command) {
+	cmd.Flags().String("cache-dir", "", "directory to persist resolved checksums and digests in across runs")
+	cmd.Flags().Duration("cache-ttl", 24*time.Hour, "how long the persisted checksums and digests are reused for")
+	cmd.Flags().Duration("cache-negative-ttl", store.DefaultNegativeTTL,
+		"how long the persisted references which don't exist are remembered for, 0 to disable")
+	cmd.Flags().String("lock-file", store.DefaultLockFileName, "lockfile recording the resolved checksums and digests")
+	cmd.Flags().Bool("write-lock", false, "record the resolved checksums and digests in the lockfile")
+	cmd.Flags().Bool("use-lock", false, "resolve the references recorded in the lockfile from it rather than the network")
+	cmd.Flags().Bool("prune-lock", false,
+		"drop the lockfile entries the run doesn't come across, for runs over all the files and references it covers")
+}
+
+// DeclareGitHubTokenFlags declares the flags reading the GitHub token from elsewhere
+// than the environment, which some security policies forbid.
+func DeclareGitHubTokenFlags(cmd *cobra.Command) {
+	cmd.Flags().String("github-token-file", "",
+		"file to read the GitHub token from instead of the "+GitHubTokenEnvKey+" environment variable")
+	cmd.Flags().String("github-token-command", "",
+		"command printing the GitHub token, e.g. 'gh auth token', instead of the "+GitHubTokenEnvKey+" environment variable")
+	cmd.MarkFlagsMutuallyExclusive("github-token-file", "github-token-command")
+}
+
+// ResolveGitHubToken returns the GitHub token read from the file or printed by the
+// command set through the token flags, if any, or else the one of the environment.
+func ResolveGitHubToken(cmd *cobra.Command) (string, error) {
+	return resolveGitHubToken(cmd, os.Getenv)
+}
+
+func resolveGitHubToken(cmd *cobra.Command, getenv func(string) string) (string, error) {
+	if f := cmd.Flags().Lookup("github-token-file"); f != nil && f.Value.String() != "" {
+		content, err := os.ReadFile(f.Value.String())
+		if err != nil {
+			return "", fmt.Errorf("failed to read the GitHub token file: %w", err)
+		}
+		token := strings.TrimSpace(string(content))
+		if token == "" {
+			return "", fmt.Errorf("the GitHub token file %s is empty", f.Value.String())
+		}
+		return token, nil
+	}
+
+	if f := cmd.Flags().Lookup("github-token-command"); f != nil && f.Value.String() != "" {
+		args := strings.Fields(f.Value.String())
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		helper := exec.CommandContext(ctx, args[0], args[1:]...) // nolint:gosec // The command is chosen by the user
+		helper.Stderr = cmd.ErrOrStderr()
+		out, err := helper.Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to run the GitHub token command: %w", err)
+		}
+		token := strings.TrimSpace(string(out))
+		if token == "" {
+			return "", errors.New("the GitHub token command didn't print a token")
+		}
+		return token, nil
+	}
+
+	return getenv(GitHubTokenEnvKey), nil
+}
+
+// openCache returns the persistent cache configured through the cache flags,
+// or nil if no cache directory is set.
+func openCache(cmd *cobra.Command) (*store.FileCacher, error) {
+	dir, err := cmd.Flags().GetString("cache-dir")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache-dir flag: %w", err)
+	}
+	if dir == "" {
+		return nil, nil
+	}
+	ttl, err := cmd.Flags().GetDuration("cache-ttl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache-ttl flag: %w", err)
+	}
+	negativeTTL, err := cmd.Flags().GetDuration("cache-negative-ttl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache-negative-ttl flag: %w", err)
+	}
+	cache, err := store.NewFileCacher(filepath.Join(dir, cacheFileName), ttl)
+	if err != nil {
+		return nil, err
+	}
+	cache.SetNegativeTTL(negativeTTL)
+	return cache, nil
+}
+
+// openLockFile returns the lockfile configured through the lock flags, wrapping the
+// given cache, which may be nil, or nil if the lockfile is neither used nor written.
+func openLockFile(cmd *cobra.Command, cache *store.FileCacher) (*store.LockFile, error) {
+	path, err := cmd.Flags().GetString("lock-file")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lock-file flag: %w", err)
+	}
+	record, err := cmd.Flags().GetBool("write-lock")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get write-lock flag: %w", err)
+	}
+	use, err := cmd.Flags().GetBool("use-lock")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get use-lock flag: %w", err)
+	}
+	prune, err := cmd.Flags().GetBool("prune-lock")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prune-lock flag: %w", err)
+	}
+	if !record && !use {
+		return nil, nil
+	}
+	// Avoid wrapping a nil pointer in a non-nil interface
+	var next store.RefCacher
+	if cache != nil {
+		next = cache
+	}
+	lock, err := store.NewLockFile(path, next, use, record)
+	if err != nil {
+		return nil, err
+	}
+	if prune {
+		lock = lock.WithPrune()
+	}
+	return lock, nil
+}
+
+// OpenCaches returns the cache to resolve the references through, i.e. the lockfile
+// configured through the lock flags wrapping the persistent cache configured through the
+// cache flags, or nil if neither is set. The returned function saves them, logging the
+// failures, and is meant to be deferred.
+func (r *Helper) OpenCaches() (store.RefCacher, func(), error) {
+	cache, err := openCache(r.Cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	lock, err := openLockFile(r.Cmd, cache)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	save := func() {
+		if lock != nil {
+			if err := lock.Save(); err != nil {
+				r.Logf("Failed to save the lockfile: %v\n", err)
+			}
+		}
+		if cache != nil {
+			if err := cache.Save(); err != nil {
+				r.Logf("Failed to save the cache: %v\n", err)
+			}
+		}
+	}
+	// Avoid wrapping a nil pointer in a non-nil interface
+	switch {
+	case lock != nil:
+		return lock, save, nil
+	case cache != nil:
+		return cache, save, nil
+	default:
+		return nil, save, nil
 	}
 }
 
@@ -165,13 +524,73 @@ func (r *Helper) Logf(format string, args ...interface{}) {
 	}
 }
 
+// Logger returns the logger the replacer logs the resolution of each reference to, i.e.
+// the command's stderr if the command is verbose, or nil otherwise. Quiet commands don't
+// log anything even if verbose.
+func (r *Helper) Logger() *slog.Logger {
+	if !r.Verbose || r.Quiet {
+		return nil
+	}
+	return slog.New(slog.NewTextHandler(r.Cmd.ErrOrStderr(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// ProgressFunc returns the function printing the number of files processed out of the
+// ones found so far to the command's stderr, or nil if the progress wasn't asked for or
+// stderr isn't a terminal. Quiet and verbose commands don't print it either, the latter
+// as the line would be mixed up with the logs.
+func (r *Helper) ProgressFunc() func(done, total int) {
+	if !r.Progress || r.Quiet || r.Verbose || !isTerminal(r.Cmd.ErrOrStderr()) {
+		return nil
+	}
+	r.progress = &progressPrinter{w: r.Cmd.ErrOrStderr()}
+	return r.progress.update
+}
+
+// EndProgress moves past the progress line printed by the function returned by ProgressFunc,
+// if any, so that what is printed next, e.g. the output or the error of the command, starts
+// on a line of its own. It's a no-op if no progress was printed since the last call.
+func (r *Helper) EndProgress() {
+	if r.progress != nil {
+		r.progress.end()
+	}
+}
+
 // ProcessOutput processes the given output files.
 // If the command is quiet, the output is discarded.
 // If the command is a dry run, the output is written to the command's stdout.
-// Otherwise, the output is written to the given filesystem.
-func (r *Helper) ProcessOutput(path string, processed []string, modified map[string]string) error {
-	basedir := filepath.Dir(path)
-	bfs := osfs.New(basedir, osfs.WithBoundOS())
+// Otherwise, the output is written to the given filesystem, or mirrored under the OutDir
+// if set, creating the missing directories.
+// If the output format is json, the given changes are written to the command's stdout,
+// in place of the output of dry runs, e.g. to plan the changes in automation.
+func (r *Helper) ProcessOutput(
+	path string,
+	processed []string,
+	modified map[string]string,
+	changes []interfaces.ReferenceChange,
+) error {
+	r.EndProgress()
+	var plan bool
+	switch r.Output {
+	case "", "text":
+	case "json":
+		plan = true
+	default:
+		return fmt.Errorf("unknown output format: %s", r.Output)
+	}
+
+	// The files are relative to the parent of the path, which must be absolute for the
+	// bound file system to accept them, e.g. when processing ., and resolved like by the
+	// replacers if it's a link
+	path = traverse.RealPath(path)
+	bfs := osfs.New(filepath.Dir(path), osfs.WithBoundOS())
+	flag := os.O_WRONLY | os.O_TRUNC
+	if r.OutDir != "" && !r.DryRun {
+		if err := os.MkdirAll(r.OutDir, 0750); err != nil {
+			return fmt.Errorf("failed to create the output directory %s: %w", r.OutDir, err)
+		}
+		bfs = osfs.New(r.OutDir, osfs.WithBoundOS())
+		flag |= os.O_CREATE
+	}
 	var out io.Writer
 	for _, path := range processed {
 		if !r.Quiet {
@@ -179,12 +598,12 @@ func (r *Helper) ProcessOutput(path string, processed []string, modified map[str
 		}
 	}
 	for path, content := range modified {
-		if r.Quiet {
+		if r.Quiet || (r.DryRun && plan) {
 			out = io.Discard
 		} else if r.DryRun {
 			out = r.Cmd.OutOrStdout()
 		} else {
-			f, err := bfs.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
+			f, err := bfs.OpenFile(path, flag, 0644)
 			if err != nil {
 				return fmt.Errorf("failed to open file %s: %w", path, err)
 			}
@@ -206,6 +625,81 @@ func (r *Helper) ProcessOutput(path string, processed []string, modified map[str
 		}
 	}
 
+	if plan && !r.Quiet {
+		if err := writeChanges(r.Cmd.OutOrStdout(), changes); err != nil {
+			return fmt.Errorf("failed to write the changes: %w", err)
+		}
+	}
+
+	if r.ErrOnModified && len(modified) > 0 {
+		return fmt.Errorf("%w in %d files", ErrModified, len(modified))
+	}
+	return nil
+}
+
+// writeChanges writes the changes to the given writer as a JSON array
+func writeChanges(w io.Writer, changes []interfaces.ReferenceChange) error {
+	if changes == nil {
+		changes = []interfaces.ReferenceChange{}
+	}
+	jsonBytes, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(jsonBytes))
+	return err
+}
+
+// PrintSummary prints the one-line summary of a run to the command's stderr if it was
+// asked for, unless the command is quiet.
+func (r *Helper) PrintSummary(s Summary) {
+	if !r.Summary {
+		return
+	}
+	verb := "pinned"
+	if r.Unpin {
+		verb = "unpinned"
+	}
+	r.Logf("Summary: %d files processed, %d files modified, %d references %s, %d skipped, %d errored\n",
+		s.FilesProcessed, s.FilesModified, s.Pinned, verb, s.Skipped, s.Errored)
+}
+
+// PrintSkipped prints the references skipped for a known reason to the command's stderr
+// if it was asked for, unless the command is quiet.
+func (r *Helper) PrintSkipped(skipped []interfaces.SkippedReference) {
+	if !r.ReportSkipped {
+		return
+	}
+	for _, s := range skipped {
+		r.Logf("Skipped: %s:%d: %s (%s)\n", s.Path, s.Line, s.Reference, s.Reason)
+	}
+}
+
+// ProcessStdin processes the content read from the command's stdin with the given
+// function, e.g. a replacer's ParseFile, and writes the result to the command's stdout.
+// If the command is a dry run, the original content is written instead.
+func (r *Helper) ProcessStdin(process func(ctx context.Context, f io.Reader) (bool, string, error)) error {
+	original, err := io.ReadAll(r.Cmd.InOrStdin())
+	if err != nil {
+		return fmt.Errorf("failed to read from stdin: %w", err)
+	}
+	modified, content, err := process(r.Cmd.Context(), bytes.NewReader(original))
+	if err != nil {
+		return err
+	}
+	if !modified || r.DryRun {
+		content = string(original)
+	}
+	if modified {
+		r.Logf("Modified: <stdin>\n")
+	}
+	if _, err := fmt.Fprint(r.Cmd.OutOrStdout(), content); err != nil {
+		return fmt.Errorf("failed to write to stdout: %w", err)
+	}
+
+	if r.ErrOnModified && modified {
+		return ErrModified
+	}
 	return nil
 }
 
@@ -214,3 +708,14 @@ func IsPath(pathOrRef string) bool {
 	_, err := os.Stat(pathOrRef)
 	return err == nil
 }
+
+// ExplainRateLimit replaces errors caused by the exhausted GitHub API rate limit
+// with a message explaining how to get around it
+func ExplainRateLimit(err error) error {
+	var rateLimitErr *ghrest.RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		return err
+	}
+	return fmt.Errorf("%w\nSet the %s environment variable to get a higher rate limit, "+
+		"or pass --wait-on-rate-limit to wait for it to reset", rateLimitErr, GitHubTokenEnvKey)
+}
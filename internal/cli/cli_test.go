@@ -1,7 +1,12 @@
 package cli
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -9,6 +14,8 @@ import (
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
 )
 
 func TestNewHelper(t *testing.T) {
@@ -22,21 +29,48 @@ func TestNewHelper(t *testing.T) {
 	}{
 		{
 			name:    "ValidFlags",
-			cmdArgs: []string{"--dry-run", "--quiet", "--error", "--regex", "test"},
+			cmdArgs: []string{"--dry-run", "--quiet", "--error", "--unpin", "--regex", "test", "--jobs", "2", "--summary"},
 			expected: &Helper{
 				DryRun:        true,
 				Quiet:         true,
 				ErrOnModified: true,
+				Unpin:         true,
 				Regex:         "test",
+				Jobs:          2,
+				Summary:       true,
 			},
 			expectedError: false,
 		},
 		{
 			name:          "MissingFlags",
 			cmdArgs:       []string{},
+			expected:      &Helper{Jobs: runtime.NumCPU() * 4},
+			expectedError: false,
+		},
+		{
+			name:          "DiscoverConfig",
+			cmdArgs:       []string{"--discover-config"},
+			expected:      &Helper{Jobs: runtime.NumCPU() * 4, DiscoverConfig: ".frizbee.yml"},
+			expectedError: false,
+		},
+		{
+			name:          "Verbose",
+			cmdArgs:       []string{"-v"},
+			expected:      &Helper{Jobs: runtime.NumCPU() * 4, Verbose: true},
+			expectedError: false,
+		},
+		{
+			name:          "NoJobsLimit",
+			cmdArgs:       []string{"-j", "0"},
 			expected:      &Helper{},
 			expectedError: false,
 		},
+		{
+			name:          "InvalidJobs",
+			cmdArgs:       []string{"--jobs", "many"},
+			expected:      nil,
+			expectedError: true,
+		},
 		{
 			name:          "InvalidFlags",
 			cmdArgs:       []string{"--nonexistent"},
@@ -72,7 +106,12 @@ func TestNewHelper(t *testing.T) {
 				assert.Equal(t, tt.expected.DryRun, helper.DryRun)
 				assert.Equal(t, tt.expected.Quiet, helper.Quiet)
 				assert.Equal(t, tt.expected.ErrOnModified, helper.ErrOnModified)
+				assert.Equal(t, tt.expected.Unpin, helper.Unpin)
 				assert.Equal(t, tt.expected.Regex, helper.Regex)
+				assert.Equal(t, tt.expected.Jobs, helper.Jobs)
+				assert.Equal(t, tt.expected.Summary, helper.Summary)
+				assert.Equal(t, tt.expected.DiscoverConfig, helper.DiscoverConfig)
+				assert.Equal(t, tt.expected.Verbose, helper.Verbose)
 			}
 		})
 	}
@@ -87,6 +126,7 @@ func TestProcessOutput(t *testing.T) {
 		path           string
 		processed      []string
 		modified       map[string]string
+		changes        []interfaces.ReferenceChange
 		expectedOutput string
 		expectError    bool
 	}{
@@ -115,6 +155,68 @@ func TestProcessOutput(t *testing.T) {
 			expectedOutput: "Processed: file1.txt\nModified: file1.txt\nnew content",
 			expectError:    false,
 		},
+		{
+			name: "DryRunJSONPlan",
+			helper: &Helper{
+				DryRun: true,
+				Output: "json",
+				Cmd:    &cobra.Command{},
+			},
+			path:      "test/path",
+			processed: []string{"file1.txt"},
+			modified:  map[string]string{"file1.txt": "new content"},
+			changes: []interfaces.ReferenceChange{
+				{Path: "file1.txt", Line: 3, Before: "uses: actions/checkout@v4",
+					After: "uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4", Type: "action"},
+			},
+			expectedOutput: `Processed: file1.txt
+Modified: file1.txt
+[
+  {
+    "file": "file1.txt",
+    "line": 3,
+    "before": "uses: actions/checkout@v4",
+    "after": "uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4",
+    "type": "action"
+  }
+]
+`,
+			expectError: false,
+		},
+		{
+			name: "DryRunJSONPlanNoChanges",
+			helper: &Helper{
+				DryRun: true,
+				Output: "json",
+				Cmd:    &cobra.Command{},
+			},
+			path:           "test/path",
+			processed:      []string{"file1.txt"},
+			expectedOutput: "Processed: file1.txt\n[]\n",
+			expectError:    false,
+		},
+		{
+			name: "UnknownOutput",
+			helper: &Helper{
+				DryRun: true,
+				Output: "sarif",
+				Cmd:    &cobra.Command{},
+			},
+			path:        "test/path",
+			modified:    map[string]string{"file1.txt": "new content"},
+			expectError: true,
+		},
+		{
+			name: "ErrOnModified",
+			helper: &Helper{
+				DryRun:        true,
+				ErrOnModified: true,
+				Cmd:           &cobra.Command{},
+			},
+			path:        "test/path",
+			modified:    map[string]string{"file1.txt": "new content"},
+			expectError: true,
+		},
 		{
 			name: "ErrorOpeningFile",
 			helper: &Helper{
@@ -152,7 +254,7 @@ func TestProcessOutput(t *testing.T) {
 			}
 
 			// Process the output using the in-memory filesystem
-			err := tt.helper.ProcessOutput(tt.path, tt.processed, tt.modified)
+			err := tt.helper.ProcessOutput(tt.path, tt.processed, tt.modified, tt.changes)
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -163,6 +265,496 @@ func TestProcessOutput(t *testing.T) {
 	}
 }
 
+func TestProcessOutputOutDir(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	original := map[string]string{
+		"repo/compose.yaml":              "image: nginx:1.25\n",
+		"repo/.github/workflows/ci.yml":  "uses: actions/checkout@v4\n",
+		"repo/deploy/charts/values.yaml": "image: redis:7\n",
+	}
+	for path, content := range original {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(src, path)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(src, path), []byte(content), 0600))
+	}
+	modified := map[string]string{
+		"repo/compose.yaml":             "image: nginx@sha256:1234 # 1.25\n",
+		"repo/.github/workflows/ci.yml": "uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4\n",
+	}
+
+	outDir := filepath.Join(t.TempDir(), "pinned")
+	cmd := &cobra.Command{}
+	cmd.SetErr(io.Discard)
+	helper := &Helper{OutDir: outDir, Cmd: cmd}
+	assert.NoError(t, helper.ProcessOutput(filepath.Join(src, "repo"), nil, modified, nil))
+
+	// The modified files are mirrored under the output directory, the others aren't copied
+	var written []string
+	assert.NoError(t, filepath.WalkDir(outDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(outDir, path)
+		if err != nil {
+			return err
+		}
+		written = append(written, filepath.ToSlash(rel))
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, modified[filepath.ToSlash(rel)], string(content))
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{"repo/compose.yaml", "repo/.github/workflows/ci.yml"}, written)
+
+	// The original files are left untouched
+	for path, content := range original {
+		got, err := os.ReadFile(filepath.Join(src, path))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(got))
+	}
+}
+
+// TestProcessOutputRelativePath runs from the processed directory, so it can't be parallel
+func TestProcessOutputRelativePath(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "deploy"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "deploy", "pod.yaml"), []byte("image: nginx:1.25\n"), 0600))
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { assert.NoError(t, os.Chdir(wd)) })
+
+	// The modified files are relative to the parent of the current directory
+	cmd := &cobra.Command{}
+	cmd.SetErr(io.Discard)
+	helper := &Helper{Cmd: cmd}
+	modified := map[string]string{filepath.Base(dir) + "/deploy/pod.yaml": "image: nginx@sha256:1234 # 1.25\n"}
+	assert.NoError(t, helper.ProcessOutput(".", nil, modified, nil))
+
+	got, err := os.ReadFile(filepath.Join(dir, "deploy", "pod.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "image: nginx@sha256:1234 # 1.25\n", string(got))
+}
+
+func TestLogger(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		helper     Helper
+		wantLogger bool
+	}{
+		{name: "Verbose", helper: Helper{Verbose: true}, wantLogger: true},
+		{name: "NotVerbose", helper: Helper{}},
+		{name: "VerboseButQuiet", helper: Helper{Verbose: true, Quiet: true}},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := &cobra.Command{}
+			stderr := &strings.Builder{}
+			cmd.SetErr(stderr)
+			tt.helper.Cmd = cmd
+
+			logger := tt.helper.Logger()
+			if !tt.wantLogger {
+				assert.Nil(t, logger)
+				return
+			}
+			logger.Debug("cache hit", "reference", "actions/checkout@v4")
+			assert.Contains(t, stderr.String(), `level=DEBUG msg="cache hit" reference=actions/checkout@v4`)
+		})
+	}
+}
+
+func TestProgressFunc(t *testing.T) {
+	t.Parallel()
+
+	// Nothing is printed unless asked for, and never to anything but a terminal
+	for _, helper := range []*Helper{
+		{Cmd: &cobra.Command{}},
+		{Progress: true, Cmd: &cobra.Command{}},
+	} {
+		stderr := &strings.Builder{}
+		helper.Cmd.SetErr(stderr)
+		assert.Nil(t, helper.ProgressFunc())
+		assert.NoError(t, helper.ProcessOutput(t.TempDir(), nil, nil, nil))
+		assert.Empty(t, stderr.String())
+	}
+}
+
+func TestProgressPrinter(t *testing.T) {
+	t.Parallel()
+
+	stderr := &strings.Builder{}
+	p := &progressPrinter{w: stderr}
+	p.end()
+	assert.Empty(t, stderr.String(), "Nothing to move past before the first update")
+
+	p.update(0, 1)
+	p.update(0, 2)
+	p.update(1, 2)
+	p.update(2, 2)
+	p.end()
+	assert.Equal(t, "\rProcessed 0/1 files\rProcessed 0/2 files\rProcessed 1/2 files\rProcessed 2/2 files\n",
+		stderr.String())
+}
+
+func TestEndProgress(t *testing.T) {
+	t.Parallel()
+
+	// The progress line is ended once, whether by ProcessOutput or on the error path
+	stderr := &strings.Builder{}
+	helper := &Helper{Cmd: &cobra.Command{}, progress: &progressPrinter{w: stderr}}
+	helper.progress.update(1, 2)
+	helper.EndProgress()
+	helper.EndProgress()
+	assert.Equal(t, "\rProcessed 1/2 files\n", stderr.String())
+
+	// Nothing to end without any progress
+	assert.NotPanics(t, (&Helper{}).EndProgress)
+}
+
+func TestOpenCachesSharedLockFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "frizbee.lock")
+
+	// run stands for a command resolving the given references with the lockfile
+	run := func(refs map[string]string, args ...string) {
+		cmd := &cobra.Command{}
+		DeclareCacheFlags(cmd)
+		assert.NoError(t, cmd.ParseFlags(append([]string{"--write-lock", "--lock-file", path}, args...)))
+		cache, save, err := (&Helper{Cmd: cmd}).OpenCaches()
+		assert.NoError(t, err)
+		for ref, sum := range refs {
+			cache.Store(ref, sum)
+		}
+		save()
+	}
+	recorded := func() []string {
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		var content struct {
+			References map[string]string `json:"references"`
+		}
+		assert.NoError(t, json.Unmarshal(data, &content))
+		refs := make([]string, 0, len(content.References))
+		for ref := range content.References {
+			refs = append(refs, ref)
+		}
+		return refs
+	}
+
+	// The images run keeps the entries of the actions run
+	run(map[string]string{"actions/checkout@v4": "11bd71901bbe5b1630ceea73d27597364c9af683"})
+	run(map[string]string{"alpine:3.18": "sha256:deadbeef"})
+	assert.ElementsMatch(t, []string{"actions/checkout@v4", "alpine:3.18"}, recorded())
+
+	// Unless asked to prune them
+	run(map[string]string{"alpine:3.18": "sha256:deadbeef"}, "--prune-lock")
+	assert.ElementsMatch(t, []string{"alpine:3.18"}, recorded())
+}
+
+func TestPrintSummary(t *testing.T) {
+	t.Parallel()
+
+	summary := Summary{FilesProcessed: 12, FilesModified: 3, Pinned: 5, Skipped: 7, Errored: 1}
+
+	testCases := []struct {
+		name           string
+		helper         Helper
+		expectedOutput string
+	}{
+		{
+			name:   "Pin",
+			helper: Helper{Summary: true},
+			expectedOutput: "Summary: 12 files processed, 3 files modified, 5 references pinned, " +
+				"7 skipped, 1 errored\n",
+		},
+		{
+			name:   "Unpin",
+			helper: Helper{Summary: true, Unpin: true},
+			expectedOutput: "Summary: 12 files processed, 3 files modified, 5 references unpinned, " +
+				"7 skipped, 1 errored\n",
+		},
+		{
+			name:           "NotAskedFor",
+			helper:         Helper{},
+			expectedOutput: "",
+		},
+		{
+			name:           "Quiet",
+			helper:         Helper{Summary: true, Quiet: true},
+			expectedOutput: "",
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stderr := &strings.Builder{}
+			tt.helper.Cmd = &cobra.Command{}
+			tt.helper.Cmd.SetErr(stderr)
+
+			tt.helper.PrintSummary(summary)
+			assert.Equal(t, tt.expectedOutput, stderr.String())
+		})
+	}
+}
+
+func TestPrintSkipped(t *testing.T) {
+	t.Parallel()
+
+	skipped := []interfaces.SkippedReference{
+		{Path: "repo/compose.yml", Line: 4, Reference: "image: nginx:latest", Reason: "excluded-tag"},
+		{Path: "repo/Dockerfile", Line: 1, Reference: "FROM scratch", Reason: "excluded-image"},
+	}
+
+	testCases := []struct {
+		name           string
+		helper         Helper
+		expectedOutput string
+	}{
+		{
+			name:   "Report",
+			helper: Helper{ReportSkipped: true},
+			expectedOutput: "Skipped: repo/compose.yml:4: image: nginx:latest (excluded-tag)\n" +
+				"Skipped: repo/Dockerfile:1: FROM scratch (excluded-image)\n",
+		},
+		{
+			name:           "NotAskedFor",
+			helper:         Helper{},
+			expectedOutput: "",
+		},
+		{
+			name:           "Quiet",
+			helper:         Helper{ReportSkipped: true, Quiet: true},
+			expectedOutput: "",
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stderr := &strings.Builder{}
+			tt.helper.Cmd = &cobra.Command{}
+			tt.helper.Cmd.SetErr(stderr)
+
+			tt.helper.PrintSkipped(skipped)
+			assert.Equal(t, tt.expectedOutput, stderr.String())
+		})
+	}
+}
+
+func TestListedJSON(t *testing.T) {
+	t.Parallel()
+
+	listed := Listed{
+		EntityRef: interfaces.EntityRef{Name: "actions/checkout", Ref: "v4", Type: "action"},
+		Pinned:    false,
+	}
+	jsonBytes, err := json.Marshal(listed)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name": "actions/checkout", "ref": "v4", "type": "action", "tag": "", "prefix": "", "pinned": false}`,
+		string(jsonBytes))
+}
+
+func TestProcessStdin(t *testing.T) {
+	t.Parallel()
+
+	pin := func(_ context.Context, f io.Reader) (bool, string, error) {
+		content, err := io.ReadAll(f)
+		if err != nil {
+			return false, "", err
+		}
+		pinned := strings.ReplaceAll(string(content), "@v4", "@sha")
+		return pinned != string(content), pinned, nil
+	}
+
+	testCases := []struct {
+		name           string
+		helper         *Helper
+		input          string
+		process        func(context.Context, io.Reader) (bool, string, error)
+		expectedOutput string
+		expectedError  error
+	}{
+		{
+			name:           "Modified",
+			helper:         &Helper{},
+			input:          "uses: actions/checkout@v4\n",
+			process:        pin,
+			expectedOutput: "uses: actions/checkout@sha\n",
+		},
+		{
+			name:           "NotModified",
+			helper:         &Helper{},
+			input:          "uses: actions/checkout@v3",
+			process:        pin,
+			expectedOutput: "uses: actions/checkout@v3",
+		},
+		{
+			name:           "DryRun",
+			helper:         &Helper{DryRun: true},
+			input:          "uses: actions/checkout@v4\n",
+			process:        pin,
+			expectedOutput: "uses: actions/checkout@v4\n",
+		},
+		{
+			name:           "ErrOnModified",
+			helper:         &Helper{ErrOnModified: true},
+			input:          "uses: actions/checkout@v4\n",
+			process:        pin,
+			expectedOutput: "uses: actions/checkout@sha\n",
+			expectedError:  ErrModified,
+		},
+		{
+			name:           "ErrOnModifiedNotModified",
+			helper:         &Helper{ErrOnModified: true},
+			input:          "uses: actions/checkout@v3\n",
+			process:        pin,
+			expectedOutput: "uses: actions/checkout@v3\n",
+		},
+		{
+			name:   "ProcessError",
+			helper: &Helper{},
+			input:  "uses: actions/checkout@v4\n",
+			process: func(context.Context, io.Reader) (bool, string, error) {
+				return false, "", io.ErrUnexpectedEOF
+			},
+			expectedError: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var stdout, stderr strings.Builder
+			tt.helper.Cmd = &cobra.Command{}
+			tt.helper.Cmd.SetContext(context.Background())
+			tt.helper.Cmd.SetIn(strings.NewReader(tt.input))
+			tt.helper.Cmd.SetOut(&stdout)
+			tt.helper.Cmd.SetErr(&stderr)
+
+			err := tt.helper.ProcessStdin(tt.process)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedOutput, stdout.String())
+		})
+	}
+}
+
+func TestResolveGitHubToken(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("file-token\n"), 0600))
+	emptyFile := filepath.Join(dir, "empty")
+	assert.NoError(t, os.WriteFile(emptyFile, []byte("\n"), 0600))
+
+	testCases := []struct {
+		name          string
+		cmdArgs       []string
+		env           string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "Env",
+			env:      "env-token",
+			expected: "env-token",
+		},
+		{
+			name:     "NoToken",
+			expected: "",
+		},
+		{
+			name:     "FileOverridesEnv",
+			cmdArgs:  []string{"--github-token-file", tokenFile},
+			env:      "env-token",
+			expected: "file-token",
+		},
+		{
+			name:     "CommandOverridesEnv",
+			cmdArgs:  []string{"--github-token-command", "echo command-token"},
+			env:      "env-token",
+			expected: "command-token",
+		},
+		{
+			name:          "MissingFile",
+			cmdArgs:       []string{"--github-token-file", filepath.Join(dir, "missing")},
+			env:           "env-token",
+			expectedError: true,
+		},
+		{
+			name:          "EmptyFile",
+			cmdArgs:       []string{"--github-token-file", emptyFile},
+			expectedError: true,
+		},
+		{
+			name:          "FailingCommand",
+			cmdArgs:       []string{"--github-token-command", "false"},
+			expectedError: true,
+		},
+		{
+			name:          "FileAndCommand",
+			cmdArgs:       []string{"--github-token-file", tokenFile, "--github-token-command", "echo command-token"},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var token string
+			var err error
+			cmd := &cobra.Command{
+				RunE: func(cmd *cobra.Command, _ []string) error {
+					token, err = resolveGitHubToken(cmd, func(key string) string {
+						assert.Equal(t, GitHubTokenEnvKey, key)
+						return tt.env
+					})
+					return err
+				},
+				SilenceUsage:  true,
+				SilenceErrors: true,
+			}
+			DeclareGitHubTokenFlags(cmd)
+			cmd.SetArgs(tt.cmdArgs)
+
+			if tt.expectedError {
+				assert.Error(t, cmd.Execute())
+				return
+			}
+			assert.NoError(t, cmd.Execute())
+			assert.Equal(t, tt.expected, token)
+		})
+	}
+}
+
 func TestIsPath(t *testing.T) {
 	t.Parallel()
 
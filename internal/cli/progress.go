@@ -0,0 +1,53 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// progressPrinter prints the number of files processed out of the ones found so far,
+// rewriting the same line of a terminal as they're processed
+type progressPrinter struct {
+	w       io.Writer
+	printed bool
+}
+
+// update rewrites the progress line, the counts only grow so the line never shrinks
+func (p *progressPrinter) update(done, total int) {
+	fmt.Fprintf(p.w, "\rProcessed %d/%d files", done, total) // nolint:errcheck
+	p.printed = true
+}
+
+// end moves past the progress line, if any was printed, so it's kept above the output
+func (p *progressPrinter) end() {
+	if p.printed {
+		fmt.Fprintln(p.w) // nolint:errcheck
+		p.printed = false
+	}
+}
+
+// isTerminal returns true if the writer is a terminal rather than, e.g., a pipe or a file
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,171 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+)
+
+// ErrUnresolved is returned when some of the references listed in a file couldn't be resolved
+var ErrUnresolved = errors.New("references couldn't be resolved")
+
+// ResolvedReference is the outcome of resolving a reference listed in a file
+type ResolvedReference struct {
+	Original string `json:"original"`
+	// Pinned is the reference pinned by its checksum or digest, or the original one if it
+	// was skipped, e.g. because it's excluded or already pinned
+	Pinned string `json:"pinned,omitempty"`
+	// MediaType is the media type of the manifest a pinned image refers to, e.g. an OCI
+	// image index for multi-platform images
+	MediaType string `json:"media_type,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DeclareResolveFlags declares the flags of the commands resolving the references listed in a file.
+func DeclareResolveFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("from-file", "f", "", "file listing one reference per line, - for stdin")
+	cmd.Flags().StringP("output", "o", "text", "output format. Can be 'text', 'table' or 'json'")
+	_ = cmd.MarkFlagRequired("from-file")
+}
+
+// ResolveFromFile resolves the references listed in the file given by the from-file flag
+// with the given function, e.g. a replacer's ParseString, and writes them to the command's
+// stdout in the format given by the output flag. Every reference is attempted, and
+// ErrUnresolved is returned at the end if any failed.
+func ResolveFromFile(cmd *cobra.Command, parse func(ctx context.Context, ref string) (*interfaces.EntityRef, error)) error {
+	path, err := cmd.Flags().GetString("from-file")
+	if err != nil {
+		return fmt.Errorf("failed to get from-file flag: %w", err)
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to get output flag: %w", err)
+	}
+
+	in := cmd.InOrStdin()
+	if path != "-" {
+		f, err := os.Open(path) // nolint:gosec // The file is chosen by the user
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer f.Close() // nolint:errcheck
+		in = f
+	}
+
+	refs, err := ResolveReferences(cmd.Context(), in, parse)
+	if err != nil {
+		return err
+	}
+	if err := WriteResolvedReferences(cmd.OutOrStdout(), refs, output); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, ref := range refs {
+		if ref.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d %w", failed, ErrUnresolved)
+	}
+	return nil
+}
+
+// ResolveReferences resolves the references listed one per line in the given reader with the
+// given function. Blank lines and comments starting with # are ignored. A reference failing
+// to resolve is reported in its ResolvedReference rather than stopping the others.
+func ResolveReferences(
+	ctx context.Context,
+	f io.Reader,
+	parse func(ctx context.Context, ref string) (*interfaces.EntityRef, error),
+) ([]ResolvedReference, error) {
+	var refs []ResolvedReference
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		resolved := ResolvedReference{Original: line}
+		res, err := parse(ctx, line)
+		switch {
+		case errors.Is(err, interfaces.ErrReferenceSkipped):
+			resolved.Pinned = line
+		case err != nil:
+			resolved.Error = ExplainRateLimit(err).Error()
+		default:
+			resolved.Pinned = fmt.Sprintf("%s@%s", res.Name, res.Ref)
+			resolved.MediaType = res.MediaType
+		}
+		refs = append(refs, resolved)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the references: %w", err)
+	}
+	return refs, nil
+}
+
+// WriteResolvedReferences writes the resolved references to the given writer in the given
+// output format, i.e. text, with one "original -> pinned" line per reference, table or json.
+func WriteResolvedReferences(w io.Writer, refs []ResolvedReference, output string) error {
+	switch output {
+	case "text":
+		for _, ref := range refs {
+			pinned := ref.Pinned
+			if ref.Error != "" {
+				pinned = "error: " + ref.Error
+			}
+			if _, err := fmt.Fprintf(w, "%s -> %s\n", ref.Original, pinned); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "table":
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"Original", "Pinned", "Error"})
+		for _, ref := range refs {
+			table.Append([]string{ref.Original, ref.Pinned, ref.Error})
+		}
+		table.Render()
+		return nil
+	case "json":
+		if refs == nil {
+			refs = []ResolvedReference{}
+		}
+		jsonBytes, err := json.MarshalIndent(refs, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(jsonBytes))
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s", output)
+	}
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+)
+
+const refsFile = `# actions to pin
+actions/checkout@v4
+
+actions/does-not-exist@v1
+actions/cache@0c52d547c9bc32b1aa3301fd7a9cb496313a4491
+`
+
+// fakeParse resolves actions/checkout, skips checksums and fails everything else
+func fakeParse(_ context.Context, ref string) (*interfaces.EntityRef, error) {
+	name, tag, _ := strings.Cut(ref, "@")
+	switch {
+	case name == "actions/checkout":
+		return &interfaces.EntityRef{Name: name, Ref: "11bd71901bbe5b1630ceea73d27597364c9af683", Tag: tag}, nil
+	case len(tag) == 40:
+		return nil, fmt.Errorf("already pinned: %w", interfaces.ErrReferenceSkipped)
+	default:
+		return nil, errors.New("not found")
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	t.Parallel()
+
+	refs, err := ResolveReferences(context.Background(), strings.NewReader(refsFile), fakeParse)
+	require.NoError(t, err)
+	assert.Equal(t, []ResolvedReference{
+		{Original: "actions/checkout@v4", Pinned: "actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Original: "actions/does-not-exist@v1", Error: "not found"},
+		{Original: "actions/cache@0c52d547c9bc32b1aa3301fd7a9cb496313a4491",
+			Pinned: "actions/cache@0c52d547c9bc32b1aa3301fd7a9cb496313a4491"},
+	}, refs)
+}
+
+func TestResolveFromFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "refs.txt")
+	require.NoError(t, os.WriteFile(path, []byte(refsFile), 0600))
+
+	testCases := []struct {
+		name           string
+		cmdArgs        []string
+		stdin          string
+		expectedOutput string
+		expectedError  error
+	}{
+		{
+			name:    "Text",
+			cmdArgs: []string{"--from-file", path},
+			expectedOutput: `actions/checkout@v4 -> actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683
+actions/does-not-exist@v1 -> error: not found
+actions/cache@0c52d547c9bc32b1aa3301fd7a9cb496313a4491 -> actions/cache@0c52d547c9bc32b1aa3301fd7a9cb496313a4491
+`,
+			expectedError: ErrUnresolved,
+		},
+		{
+			name:    "JSON",
+			cmdArgs: []string{"--from-file", path, "-o", "json"},
+			expectedOutput: `[
+  {
+    "original": "actions/checkout@v4",
+    "pinned": "actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683"
+  },
+  {
+    "original": "actions/does-not-exist@v1",
+    "error": "not found"
+  },
+  {
+    "original": "actions/cache@0c52d547c9bc32b1aa3301fd7a9cb496313a4491",
+    "pinned": "actions/cache@0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+  }
+]
+`,
+			expectedError: ErrUnresolved,
+		},
+		{
+			name:           "Stdin",
+			cmdArgs:        []string{"--from-file", "-"},
+			stdin:          "actions/checkout@v4\n",
+			expectedOutput: "actions/checkout@v4 -> actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683\n",
+		},
+		{
+			name:    "MissingFile",
+			cmdArgs: []string{"--from-file", filepath.Join(t.TempDir(), "missing.txt")},
+		},
+		{
+			name:    "UnknownOutput",
+			cmdArgs: []string{"--from-file", path, "-o", "sarif"},
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := &cobra.Command{
+				RunE: func(cmd *cobra.Command, _ []string) error {
+					return ResolveFromFile(cmd, fakeParse)
+				},
+				SilenceUsage:  true,
+				SilenceErrors: true,
+			}
+			DeclareResolveFlags(cmd)
+			stdout := &bytes.Buffer{}
+			cmd.SetOut(stdout)
+			cmd.SetIn(strings.NewReader(tt.stdin))
+			cmd.SetArgs(tt.cmdArgs)
+
+			err := cmd.Execute()
+			switch {
+			case tt.expectedOutput == "":
+				assert.Error(t, err)
+			case tt.expectedError != nil:
+				assert.ErrorIs(t, err, tt.expectedError)
+				assert.Equal(t, tt.expectedOutput, stdout.String())
+			default:
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedOutput, stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sarif provides utilities to report references in the SARIF format.
+package sarif
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/stacklok/frizbee/internal/cli"
+	"github.com/stacklok/frizbee/pkg/replacer"
+)
+
+const (
+	// Version is the SARIF version of the generated logs
+	Version = "2.1.0"
+	// Schema is the JSON schema of the generated logs
+	Schema = "https://json.schemastore.org/sarif-2.1.0.json"
+	// RuleUnpinnedReference is the ID of the rule reporting references not pinned by a digest
+	RuleUnpinnedReference = "unpinned-reference"
+
+	toolName = "frizbee"
+	toolURI  = "https://github.com/stacklok/frizbee"
+)
+
+// Log is the top-level SARIF object
+type Log struct {
+	Version string `json:"version"`
+	Schema  string `json:"$schema"`
+	Runs    []Run  `json:"runs"`
+}
+
+// Run describes a single invocation of the tool
+type Run struct {
+	Tool    Tool     `json:"tool"`
+	Results []Result `json:"results"`
+}
+
+// Tool describes the tool that produced the results
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+// Driver describes the tool component that produced the results
+type Driver struct {
+	Name           string `json:"name"`
+	Version        string `json:"version,omitempty"`
+	InformationURI string `json:"informationUri"`
+	Rules          []Rule `json:"rules"`
+}
+
+// Rule describes a rule the results may refer to
+type Rule struct {
+	ID               string  `json:"id"`
+	ShortDescription Message `json:"shortDescription"`
+}
+
+// Result is a single reported finding
+type Result struct {
+	RuleID    string     `json:"ruleId"`
+	Level     string     `json:"level"`
+	Message   Message    `json:"message"`
+	Locations []Location `json:"locations"`
+}
+
+// Message is a plain text message
+type Message struct {
+	Text string `json:"text"`
+}
+
+// Location is the place a result was found at
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+// PhysicalLocation is a location within a file
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           Region           `json:"region"`
+}
+
+// ArtifactLocation is the location of a file
+type ArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// Region is a region within a file
+type Region struct {
+	StartLine int `json:"startLine"`
+}
+
+// FromListResult returns a SARIF log reporting every reference in the list result
+// that's not pinned by a checksum or digest. The paths of the list result are
+// relative to the given base directory.
+func FromListResult(res *replacer.ListResult, base string) *Log {
+	results := make([]Result, 0)
+	for _, loc := range res.Unpinned() {
+		text := fmt.Sprintf("%s %s is referenced by %s rather than by a checksum or digest", loc.Type, loc.Name, loc.Ref)
+		if loc.SkipReason != "" {
+			text += fmt.Sprintf(", it's skipped when pinning: %s", loc.SkipReason)
+		}
+		results = append(results, Result{
+			RuleID: RuleUnpinnedReference,
+			Level:  "warning",
+			Message: Message{
+				Text: text,
+			},
+			Locations: []Location{{
+				PhysicalLocation: PhysicalLocation{
+					ArtifactLocation: ArtifactLocation{URI: filepath.ToSlash(filepath.Join(base, loc.Path))},
+					Region:           Region{StartLine: loc.Line},
+				},
+			}},
+		})
+	}
+
+	return &Log{
+		Version: Version,
+		Schema:  Schema,
+		Runs: []Run{{
+			Tool: Tool{
+				Driver: Driver{
+					Name:           toolName,
+					Version:        cli.CLIVersion,
+					InformationURI: toolURI,
+					Rules: []Rule{{
+						ID:               RuleUnpinnedReference,
+						ShortDescription: Message{Text: "Reference not pinned by a digest"},
+					}},
+				},
+			},
+			Results: results,
+		}},
+	}
+}
+
+// Write writes the SARIF log to the given writer as indented JSON
+func (l *Log) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(l); err != nil {
+		return fmt.Errorf("failed to encode SARIF log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package sarif
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/replacer/image"
+)
+
+func TestFromListResult(t *testing.T) {
+	t.Parallel()
+
+	res := &replacer.ListResult{
+		Locations: []replacer.EntityLocation{
+			{
+				EntityRef: interfaces.EntityRef{Name: "actions/checkout", Ref: "v4", Type: actions.ReferenceType},
+				Path:      "workflows/ci.yml",
+				Line:      12,
+			},
+			{
+				EntityRef: interfaces.EntityRef{
+					Name: "actions/setup-go",
+					Ref:  "0c52d547c9bc32b1aa3301fd7a9cb496313a4491",
+					Type: actions.ReferenceType,
+				},
+				Path: "workflows/ci.yml",
+				Line: 15,
+			},
+			{
+				EntityRef: interfaces.EntityRef{Name: "nginx", Ref: "1.25", Type: image.ReferenceType},
+				Path:      "deploy/app.yaml",
+				Line:      3,
+			},
+			{
+				EntityRef: interfaces.EntityRef{
+					Name: "nginx",
+					Ref:  "sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec",
+					Type: image.ReferenceType,
+				},
+				Path: "deploy/app.yaml",
+				Line: 9,
+			},
+			{
+				EntityRef:  interfaces.EntityRef{Name: "redis", Ref: "latest", Type: image.ReferenceType},
+				Path:       "deploy/app.yaml",
+				Line:       14,
+				SkipReason: interfaces.SkipExcludedTag,
+			},
+		},
+	}
+
+	log := FromListResult(res, ".github")
+	require.Equal(t, Version, log.Version)
+	require.Len(t, log.Runs, 1)
+	require.Equal(t, "frizbee", log.Runs[0].Tool.Driver.Name)
+
+	results := log.Runs[0].Results
+	require.Len(t, results, 3, "only unpinned references should be reported")
+	require.Equal(t, RuleUnpinnedReference, results[0].RuleID)
+	require.Equal(t, ".github/workflows/ci.yml", results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	require.Equal(t, 12, results[0].Locations[0].PhysicalLocation.Region.StartLine)
+	require.Contains(t, results[0].Message.Text, "actions/checkout")
+	require.Equal(t, ".github/deploy/app.yaml", results[1].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	require.Equal(t, 3, results[1].Locations[0].PhysicalLocation.Region.StartLine)
+	require.NotContains(t, results[1].Message.Text, "skipped")
+	require.Contains(t, results[2].Message.Text, "skipped when pinning: excluded-tag")
+
+	var buf bytes.Buffer
+	require.NoError(t, log.Write(&buf))
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, Schema, decoded["$schema"])
+}
+
+func TestFromListResultNoFindings(t *testing.T) {
+	t.Parallel()
+
+	log := FromListResult(&replacer.ListResult{}, ".")
+
+	var buf bytes.Buffer
+	require.NoError(t, log.Write(&buf))
+	require.Contains(t, buf.String(), `"results": []`)
+}
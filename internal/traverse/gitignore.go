@@ -0,0 +1,147 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package traverse
+
+import (
+	"bufio"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// gitignoreFileName is the name of the files listing the ignored paths of their directory
+const gitignoreFileName = ".gitignore"
+
+// DefaultIgnored returns the patterns of the directories skipped when honoring .gitignore
+// files, even if they aren't listed in any
+func DefaultIgnored() []string {
+	return []string{".git/", "node_modules/", "vendor/"}
+}
+
+// ignorePattern is a pattern of a .gitignore file
+type ignorePattern struct {
+	// dir is the directory of the .gitignore file, the pattern is matched relative to it
+	dir     string
+	pattern string
+	// anchored patterns are matched against the whole relative path, the others against the name
+	anchored bool
+	dirOnly  bool
+	negate   bool
+}
+
+// ignoreRules are the patterns of the .gitignore files of a directory and its parents,
+// the last matching pattern wins like in git
+type ignoreRules struct {
+	patterns []ignorePattern
+}
+
+// newIgnoreRules returns the rules ignoring the DefaultIgnored directories anywhere under root
+func newIgnoreRules(root string) *ignoreRules {
+	r := &ignoreRules{}
+	for _, line := range DefaultIgnored() {
+		if p, ok := parseIgnorePattern(root, line); ok {
+			r.patterns = append(r.patterns, p)
+		}
+	}
+	return r
+}
+
+// withDir returns the rules extended with the patterns of the .gitignore file of
+// the given directory, if any. The receiver is left untouched.
+func (r *ignoreRules) withDir(bfs billy.Filesystem, dir string) *ignoreRules {
+	f, err := bfs.Open(filepath.Join(dir, gitignoreFileName))
+	if err != nil {
+		return r
+	}
+	defer f.Close() // nolint:errcheck
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p, ok := parseIgnorePattern(dir, scanner.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	// An unreadable .gitignore file is as good as a missing one
+	if scanner.Err() != nil || len(patterns) == 0 {
+		return r
+	}
+
+	return &ignoreRules{patterns: append(append([]ignorePattern{}, r.patterns...), patterns...)}
+}
+
+// ignored returns true if the given path is ignored
+func (r *ignoreRules) ignored(p string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range r.patterns {
+		if pattern.match(p, isDir) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+// parseIgnorePattern parses a line of the .gitignore file of the given directory.
+// It's false for blank lines and comments.
+func parseIgnorePattern(dir, line string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	p := ignorePattern{dir: dir}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	// A separator at the beginning or in the middle anchors the pattern to the directory
+	p.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignorePattern{}, false
+	}
+	p.pattern = line
+
+	return p, true
+}
+
+// match returns true if the pattern matches the given path
+func (p *ignorePattern) match(fullPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(p.dir, fullPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	if !p.anchored {
+		matched, err := path.Match(p.pattern, path.Base(rel))
+		return err == nil && matched
+	}
+	return config.MatchPath(p.pattern, rel)
+}
@@ -0,0 +1,115 @@
+package traverse
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		gitignore string
+		path      string
+		isDir     bool
+		expected  bool
+	}{
+		{
+			name:     "DefaultIgnored",
+			path:     "repo/sub/node_modules",
+			isDir:    true,
+			expected: true,
+		},
+		{
+			name:     "DefaultIgnoredFile",
+			path:     "repo/vendor",
+			isDir:    false,
+			expected: false,
+		},
+		{
+			name:      "Name",
+			gitignore: "*.log\n",
+			path:      "repo/a/b/debug.log",
+			expected:  true,
+		},
+		{
+			name:      "Comment",
+			gitignore: "# *.log\n",
+			path:      "repo/debug.log",
+			expected:  false,
+		},
+		{
+			name:      "DirectoryOnly",
+			gitignore: "build/\n",
+			path:      "repo/build",
+			isDir:     false,
+			expected:  false,
+		},
+		{
+			name:      "Anchored",
+			gitignore: "/build\n",
+			path:      "repo/sub/build",
+			isDir:     true,
+			expected:  false,
+		},
+		{
+			name:      "AnchoredRoot",
+			gitignore: "/build\n",
+			path:      "repo/build",
+			isDir:     true,
+			expected:  true,
+		},
+		{
+			name:      "MiddleSeparator",
+			gitignore: "deploy/*.yaml\n",
+			path:      "repo/deploy/app.yaml",
+			expected:  true,
+		},
+		{
+			name:      "DoubleStar",
+			gitignore: "charts/**/generated\n",
+			path:      "repo/charts/a/b/generated",
+			isDir:     true,
+			expected:  true,
+		},
+		{
+			name:      "Negated",
+			gitignore: "*.yaml\n!keep.yaml\n",
+			path:      "repo/keep.yaml",
+			expected:  false,
+		},
+		{
+			name:      "NegatedThenIgnored",
+			gitignore: "!keep.yaml\n*.yaml\n",
+			path:      "repo/keep.yaml",
+			expected:  true,
+		},
+		{
+			name:      "EscapedHash",
+			gitignore: "\\#notes.yaml\n",
+			path:      "repo/#notes.yaml",
+			expected:  true,
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := memfs.New()
+			if tt.gitignore != "" {
+				f, _ := fs.Create("repo/.gitignore")
+				_, _ = f.Write([]byte(tt.gitignore))
+				assert.NoError(t, f.Close())
+			}
+
+			rules := newIgnoreRules("repo").withDir(fs, "repo")
+			assert.Equal(t, tt.expected, rules.ignored(tt.path, tt.isDir))
+		})
+	}
+}
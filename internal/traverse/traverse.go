@@ -17,10 +17,13 @@
 package traverse
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/go-git/go-billy/v5"
@@ -33,11 +36,87 @@ type GhwFunc func(path string) error
 // FuncTraverse is a function that gets called with each file in a directory.
 type FuncTraverse func(path string, info fs.FileInfo) error
 
-// YamlDockerfiles traverses all yaml/yml in the given directory
-// and calls the given function with each workflow.
-func YamlDockerfiles(bfs billy.Filesystem, base string, fun GhwFunc) error {
+// Option configures a traversal.
+type Option func(*options)
+
+type options struct {
+	gitignore      bool
+	maxDepth       int
+	followSymlinks bool
+	logger         *slog.Logger
+}
+
+// WithGitignore skips the files and directories ignored by the .gitignore files
+// found in the traversed directory, along with the DefaultIgnored directories.
+func WithGitignore() Option {
+	return func(o *options) {
+		o.gitignore = true
+	}
+}
+
+// WithMaxDepth stops descending into the directories more than the given number of levels
+// below the traversed one, e.g. 1 only visits the files directly in it. 0 means no limit.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithFollowSymlinks walks into the directories symbolic links point to, e.g. shared
+// workflows, under their real path. Every directory is walked at most once, so the
+// files reached through several links aren't processed twice and cycles of links
+// end. Links pointing outside of the filesystem aren't followed, see WithLogger.
+func WithFollowSymlinks() Option {
+	return func(o *options) {
+		o.followSymlinks = true
+	}
+}
+
+// WithLogger logs the symbolic links which aren't followed as they point outside of the
+// filesystem at warning level, e.g. a shared workflows directory in another part of the
+// repository than the traversed one. Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// DefaultYAMLExtensions returns the extensions of the YAML files traversed by YamlDockerfiles,
+// including the common suffixes of templated YAML files, e.g. Helm templates.
+func DefaultYAMLExtensions() []string {
+	return []string{".yml", ".yaml", ".yml.tpl", ".yaml.tpl", ".gotmpl", ".yml.j2", ".yaml.j2"}
+}
+
+// QuadletExtension is the extension of the Podman quadlet container units traversed by
+// YamlDockerfiles, which reference their image through an Image key.
+const QuadletExtension = ".container"
+
+// JSONExtension is the extension of the JSON files traversed when JSON manifests are enabled,
+// see the json_manifests option of the images.
+const JSONExtension = ".json"
+
+// NixExtension is the extension of the Nix files traversed when Nix images are enabled,
+// see the nix_images option of the images.
+const NixExtension = ".nix"
+
+// YamlDockerfiles traverses all yaml/yml files, Dockerfiles and quadlet container units
+// in the given directory and calls the given function with each workflow. Files with any
+// of the given extensions, e.g. .yaml.tmpl, are traversed on top of the default ones.
+func YamlDockerfiles(bfs billy.Filesystem, base string, extensions []string, fun GhwFunc, opts ...Option) error {
+	return Files(bfs, base, YamlOrDockerfileMatcher(extensions), fun, opts...)
+}
+
+// TerraformFiles traverses all Terraform and OpenTofu configuration files in the
+// given directory and calls the given function with each of them.
+func TerraformFiles(bfs billy.Filesystem, base string, fun GhwFunc, opts ...Option) error {
+	return Files(bfs, base, isTerraform, fun, opts...)
+}
+
+// Files traverses the given directory and calls the given function with each
+// file accepted by match.
+func Files(bfs billy.Filesystem, base string, match func(info fs.FileInfo) bool, fun GhwFunc, opts ...Option) error {
 	return Traverse(bfs, base, func(path string, info fs.FileInfo) error {
-		if !isYAMLOrDockerfile(info) {
+		if !match(info) {
 			return nil
 		}
 
@@ -46,45 +125,89 @@ func YamlDockerfiles(bfs billy.Filesystem, base string, fun GhwFunc) error {
 		}
 
 		return nil
-	})
+	}, opts...)
 }
 
 // Traverse traverses the given directory and calls the given function with each file.
-func Traverse(bfs billy.Filesystem, base string, fun FuncTraverse) error {
+func Traverse(bfs billy.Filesystem, base string, fun FuncTraverse, opts ...Option) error {
 	return Walk(bfs, base, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
 
 		return fun(path, info)
-	})
+	}, opts...)
 }
 
-// isYAMLOrDockerfile returns true if the given file is a YAML or Dockerfile.
-func isYAMLOrDockerfile(info fs.FileInfo) bool {
-	// Skip if not a file
-	if info.IsDir() {
-		return false
-	}
+// YamlOrDockerfileMatcher returns a function returning true if the given file is a
+// Dockerfile, a quadlet container unit or has one of the default YAML extensions or
+// of the given ones.
+func YamlOrDockerfileMatcher(extensions []string) func(info fs.FileInfo) bool {
+	extensions = append(DefaultYAMLExtensions(), extensions...)
+	extensions = append(extensions, QuadletExtension)
+	return func(info fs.FileInfo) bool {
+		// Skip if not a file
+		if info.IsDir() {
+			return false
+		}
 
-	// Filter out files that are not yml, yaml or dockerfiles
-	if strings.HasSuffix(info.Name(), ".yml") || strings.HasSuffix(info.Name(), ".yaml") ||
-		strings.Contains(strings.ToLower(info.Name()), "dockerfile") {
-		return true
+		if IsDockerfile(info.Name()) {
+			return true
+		}
+		// Filter out files that don't have any of the extensions
+		return slices.ContainsFunc(extensions, func(ext string) bool {
+			return ext != "" && strings.HasSuffix(info.Name(), ext)
+		})
 	}
+}
 
-	return false
+// IsDockerfile returns true if the file with the given name is a Dockerfile, e.g.
+// Dockerfile.alpine or build.dockerfile
+func IsDockerfile(name string) bool {
+	return strings.Contains(strings.ToLower(name), "dockerfile")
 }
 
-// walk recursively descends path, calling walkFn
+// isTerraform returns true if the given file is a Terraform or OpenTofu configuration file.
+func isTerraform(info fs.FileInfo) bool {
+	return !info.IsDir() && (strings.HasSuffix(info.Name(), ".tf") || strings.HasSuffix(info.Name(), ".tofu"))
+}
+
+// maxSymlinks is how many symbolic links are resolved in a path before giving up,
+// like the limit of Linux
+const maxSymlinks = 40
+
+// errTooManySymlinks is returned when resolving a path needs more than maxSymlinks links,
+// e.g. because of a cycle of links
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// walker holds the state shared by all the directories of a traversal
+type walker struct {
+	bfs      billy.Filesystem
+	walkFn   filepath.WalkFunc
+	maxDepth int
+	// visited are the directories read so far when following symbolic links, so that a
+	// link to one of them, e.g. a parent, isn't walked again. It's nil otherwise.
+	visited map[string]bool
+	// logger logs the links which aren't followed, if set
+	logger *slog.Logger
+}
+
+// walk recursively descends path, calling walkFn, skipping the paths ignored by rules if set
+// and not reading the directories depth levels below the root if maxDepth is reached
 // adapted from https://golang.org/src/path/filepath/path.go
-func walk(bfs billy.Filesystem, path string, info os.FileInfo, walkFn filepath.WalkFunc) error {
-	if !info.IsDir() {
-		return walkFn(path, info, nil)
+func (w *walker) walk(path string, info os.FileInfo, rules *ignoreRules, depth int) error {
+	if !info.IsDir() || (w.maxDepth > 0 && depth >= w.maxDepth) {
+		return w.walkFn(path, info, nil)
+	}
+	if w.visited != nil {
+		if w.visited[path] {
+			return nil
+		}
+		w.visited[path] = true
 	}
 
-	names, err := readDirNames(bfs, path)
-	err1 := walkFn(path, info, err)
+	names, err := readDirNames(w.bfs, path)
+	err1 := w.walkFn(path, info, err)
 	// If err != nil, walk can't walk into this directory.
 	// err1 != nil means walkFn want walk to skip this directory or stop walking.
 	// Therefore, if one of err and err1 isn't nil, walk will return.
@@ -96,15 +219,21 @@ func walk(bfs billy.Filesystem, path string, info os.FileInfo, walkFn filepath.W
 		return err1
 	}
 
+	if rules != nil {
+		rules = rules.withDir(w.bfs, path)
+	}
 	for _, name := range names {
 		filename := filepath.Join(path, name)
-		fileInfo, err := bfs.Lstat(filename)
+		fileInfo, err := w.bfs.Lstat(filename)
 		if err != nil {
-			if err := walkFn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+			if err := w.walkFn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
 				return err
 			}
-		} else {
-			err = walk(bfs, filename, fileInfo, walkFn)
+		} else if rules == nil || !rules.ignored(filename, fileInfo.IsDir()) {
+			if w.visited != nil && fileInfo.Mode()&os.ModeSymlink != 0 {
+				filename, fileInfo = w.followLink(filename, fileInfo)
+			}
+			err = w.walk(filename, fileInfo, rules, depth+1)
 			if err != nil {
 				if !fileInfo.IsDir() || err != filepath.SkipDir {
 					return err
@@ -115,6 +244,87 @@ func walk(bfs billy.Filesystem, path string, info os.FileInfo, walkFn filepath.W
 	return nil
 }
 
+// followLink returns the real path and the info of the directory the given symbolic link
+// points to. The link itself is returned if it points to anything else, is broken or points
+// outside of the filesystem, so it's handled like without following links.
+func (w *walker) followLink(link string, info os.FileInfo) (string, os.FileInfo) {
+	resolved, err := realPath(w.bfs, link)
+	if err == nil && (resolved == ".." || strings.HasPrefix(resolved, ".."+string(filepath.Separator))) {
+		err = errors.New("the link points outside of the filesystem")
+	}
+	if err != nil {
+		if w.logger != nil {
+			w.logger.Warn("not following symbolic link", "link", link, "error", err)
+		}
+		return link, info
+	}
+	target, err := w.bfs.Lstat(resolved)
+	if err != nil || !target.IsDir() {
+		return link, info
+	}
+	return resolved, target
+}
+
+// realPath returns the given path with all its symbolic links resolved, including those
+// of its parent directories
+func realPath(bfs billy.Filesystem, path string) (string, error) {
+	sep := string(filepath.Separator)
+	var resolved string
+	if filepath.IsAbs(path) {
+		resolved = sep
+	}
+	parts := strings.Split(filepath.Clean(path), sep)
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" || part == "." || part == ".." {
+			// The resolved path has no links left, so going up is a plain join
+			resolved = filepath.Join(resolved, part)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := bfs.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("%s: %w", path, errTooManySymlinks)
+		}
+		target, err := bfs.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = sep
+		}
+		// The target isn't cleaned as its .. elements apply to the resolved links
+		parts = append(strings.Split(target, sep), parts...)
+	}
+	if resolved == "" {
+		return ".", nil
+	}
+	return resolved, nil
+}
+
+// RealPath returns the absolute path of the given path of the OS with its symbolic links
+// resolved, e.g. to bind a file system to the parent of the directory a linked
+// .github/workflows points to, or the path made absolute if it can't be resolved.
+func RealPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
+
 // Walk walks the file tree rooted at root, calling fn for each file or
 // directory in the tree, including root. All errors that arise visiting files
 // and directories are filtered by fn: see the WalkFunc documentation for
@@ -122,15 +332,43 @@ func walk(bfs billy.Filesystem, path string, info os.FileInfo, walkFn filepath.W
 //
 // The files are walked in lexical order, which makes the output deterministic
 // but requires Walk to read an entire directory into memory before proceeding
-// to walk that directory. Walk does not follow symbolic links.
+// to walk that directory. Walk does not follow symbolic links, unless
+// WithFollowSymlinks is given. The root is walked under its own path even if it's
+// a link to a directory.
+//
+// With WithGitignore, the ignored paths are skipped without calling fn. The root
+// itself is never skipped. With WithMaxDepth, fn is still called for the directories
+// at the maximum depth, but they aren't read.
 //
 // Function adapted from https://github.com/golang/go/blob/3b770f2ccb1fa6fecc22ea822a19447b10b70c5c/src/path/filepath/path.go#L500
-func Walk(bfs billy.Filesystem, root string, walkFn filepath.WalkFunc) error {
+func Walk(bfs billy.Filesystem, root string, walkFn filepath.WalkFunc, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var rules *ignoreRules
+	if o.gitignore {
+		rules = newIgnoreRules(root)
+	}
+	w := &walker{bfs: bfs, walkFn: walkFn, maxDepth: o.maxDepth, logger: o.logger}
+	if o.followSymlinks {
+		w.visited = map[string]bool{}
+	}
+
 	info, err := bfs.Lstat(root)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		// The root was given explicitly, so it's walked even if it's a link, e.g. to a
+		// shared workflows directory. The links below it are only followed if asked to.
+		if resolved, err := realPath(bfs, root); err == nil {
+			if target, err := bfs.Lstat(resolved); err == nil && target.IsDir() {
+				info = target
+			}
+		}
+	}
 	if err != nil {
 		err = walkFn(root, nil, err)
 	} else {
-		err = walk(bfs, root, info, walkFn)
+		err = w.walk(root, info, rules, 0)
 	}
 
 	if err == filepath.SkipDir {
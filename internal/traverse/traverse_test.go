@@ -2,12 +2,16 @@ package traverse
 
 import (
 	"errors"
+	"log/slog"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestYamlDockerfiles(t *testing.T) {
@@ -17,6 +21,9 @@ func TestYamlDockerfiles(t *testing.T) {
 		name        string
 		fsContent   map[string]string
 		baseDir     string
+		extensions  []string
+		gitignore   bool
+		maxDepth    int
 		expected    []string
 		expectError bool
 	}{
@@ -75,6 +82,104 @@ func TestYamlDockerfiles(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "WithTemplatedFiles",
+			fsContent: map[string]string{
+				"base/templates/deployment.yaml.tpl": "content",
+				"base/helmfile.yaml.gotmpl":          "content",
+				"base/templates/_helpers.tpl":        "content",
+			},
+			baseDir: "base",
+			expected: []string{
+				"base/templates/deployment.yaml.tpl",
+				"base/helmfile.yaml.gotmpl",
+			},
+			expectError: false,
+		},
+		{
+			name: "WithExtraExtensions",
+			fsContent: map[string]string{
+				"base/file.yml":           "content",
+				"base/deployment.yaml.in": "content",
+				"base/not_included.txt":   "content",
+			},
+			baseDir:    "base",
+			extensions: []string{".yaml.in"},
+			expected: []string{
+				"base/file.yml",
+				"base/deployment.yaml.in",
+			},
+			expectError: false,
+		},
+		{
+			name: "WithIgnoredDirectories",
+			fsContent: map[string]string{
+				"base/.gitignore":                   "build/\n*.generated.yaml\n",
+				"base/file.yml":                     "content",
+				"base/build/file.yml":               "content",
+				"base/api.generated.yaml":           "content",
+				"base/node_modules/pkg/action.yml":  "content",
+				"base/vendor/mod/Dockerfile":        "content",
+				"base/.git/hooks/config.yaml":       "content",
+				"base/nested/.gitignore":            "!keep.generated.yaml\n",
+				"base/nested/keep.generated.yaml":   "content",
+				"base/nested/Dockerfile":            "content",
+				"base/nested/other.generated.yaml":  "content",
+				"base/nested/build/compose.yaml":    "content",
+				"base/unrelated/build.yaml/file.md": "content",
+			},
+			baseDir:   "base",
+			gitignore: true,
+			expected: []string{
+				"base/file.yml",
+				"base/nested/keep.generated.yaml",
+				"base/nested/Dockerfile",
+			},
+			expectError: false,
+		},
+		{
+			name: "WithoutGitignore",
+			fsContent: map[string]string{
+				"base/.gitignore":          "build/\n",
+				"base/build/file.yml":      "content",
+				"base/vendor/compose.yaml": "content",
+			},
+			baseDir: "base",
+			expected: []string{
+				"base/build/file.yml",
+				"base/vendor/compose.yaml",
+			},
+			expectError: false,
+		},
+		{
+			name: "WithMaxDepth",
+			fsContent: map[string]string{
+				"base/compose.yaml":                "content",
+				"base/nested/Dockerfile":           "content",
+				"base/nested/deep/file.yml":        "content",
+				"base/nested/deep/deeper/file.yml": "content",
+			},
+			baseDir:  "base",
+			maxDepth: 2,
+			expected: []string{
+				"base/compose.yaml",
+				"base/nested/Dockerfile",
+			},
+			expectError: false,
+		},
+		{
+			name: "WithMaxDepthOnlyBase",
+			fsContent: map[string]string{
+				"base/compose.yaml":      "content",
+				"base/nested/Dockerfile": "content",
+			},
+			baseDir:  "base",
+			maxDepth: 1,
+			expected: []string{
+				"base/compose.yaml",
+			},
+			expectError: false,
+		},
 		{
 			name: "ErrorInProcessingFile",
 			fsContent: map[string]string{
@@ -98,14 +203,21 @@ func TestYamlDockerfiles(t *testing.T) {
 				assert.NoError(t, f.Close())
 			}
 
+			var opts []Option
+			if tt.gitignore {
+				opts = append(opts, WithGitignore())
+			}
+			if tt.maxDepth > 0 {
+				opts = append(opts, WithMaxDepth(tt.maxDepth))
+			}
 			var processedFiles []string
-			err := YamlDockerfiles(fs, tt.baseDir, func(path string) error {
+			err := YamlDockerfiles(fs, tt.baseDir, tt.extensions, func(path string) error {
 				if tt.expectError {
 					return errors.New("error in processing file")
 				}
 				processedFiles = append(processedFiles, path)
 				return nil
-			})
+			}, opts...)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -124,6 +236,7 @@ func TestTraverse(t *testing.T) {
 		name        string
 		fsContent   map[string]string
 		baseDir     string
+		maxDepth    int
 		expected    []string
 		expectError bool
 	}{
@@ -144,6 +257,23 @@ func TestTraverse(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "TraverseWithMaxDepth",
+			fsContent: map[string]string{
+				"base/file1.txt":          "content",
+				"base/nested/file":        "content",
+				"base/nested/deeper/file": "content",
+			},
+			baseDir:  "base",
+			maxDepth: 1,
+			// The directories at the maximum depth are visited but not read
+			expected: []string{
+				"base",
+				"base/file1.txt",
+				"base/nested",
+			},
+			expectError: false,
+		},
 		{
 			name: "TraverseWithError",
 			fsContent: map[string]string{
@@ -174,7 +304,7 @@ func TestTraverse(t *testing.T) {
 				}
 				processedFiles = append(processedFiles, path)
 				return nil
-			})
+			}, WithMaxDepth(tt.maxDepth))
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -186,14 +316,15 @@ func TestTraverse(t *testing.T) {
 	}
 }
 
-func TestIsYAMLOrDockerfile(t *testing.T) {
+func TestYAMLOrDockerfileMatcher(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
-		name     string
-		fileName string
-		isDir    bool
-		expected bool
+		name       string
+		fileName   string
+		isDir      bool
+		extensions []string
+		expected   bool
 	}{
 		{
 			name:     "YAMLFile",
@@ -219,6 +350,50 @@ func TestIsYAMLOrDockerfile(t *testing.T) {
 			isDir:    false,
 			expected: true,
 		},
+		{
+			name:     "YAMLTemplate",
+			fileName: "deployment.yaml.tpl",
+			isDir:    false,
+			expected: true,
+		},
+		{
+			name:     "GoTemplate",
+			fileName: "helmfile.yaml.gotmpl",
+			isDir:    false,
+			expected: true,
+		},
+		{
+			name:     "QuadletContainer",
+			fileName: "web.container",
+			isDir:    false,
+			expected: true,
+		},
+		{
+			name:     "JSONFile",
+			fileName: "pod.json",
+			isDir:    false,
+			expected: false,
+		},
+		{
+			name:     "HelmHelpers",
+			fileName: "_helpers.tpl",
+			isDir:    false,
+			expected: false,
+		},
+		{
+			name:       "ExtraExtension",
+			fileName:   "deployment.yaml.tmpl",
+			isDir:      false,
+			extensions: []string{".yaml.tmpl"},
+			expected:   true,
+		},
+		{
+			name:       "EmptyExtension",
+			fileName:   "config.txt",
+			isDir:      false,
+			extensions: []string{""},
+			expected:   false,
+		},
 		{
 			name:     "NonYAMLOrDockerfile",
 			fileName: "config.txt",
@@ -231,6 +406,13 @@ func TestIsYAMLOrDockerfile(t *testing.T) {
 			isDir:    true,
 			expected: false,
 		},
+		{
+			name:       "DirectoryWithExtension",
+			fileName:   "templates.yaml",
+			isDir:      true,
+			extensions: []string{".yaml"},
+			expected:   false,
+		},
 	}
 
 	for _, tt := range testCases {
@@ -244,12 +426,205 @@ func TestIsYAMLOrDockerfile(t *testing.T) {
 				dir:  tt.isDir,
 			}
 
-			result := isYAMLOrDockerfile(info)
+			result := YamlOrDockerfileMatcher(tt.extensions)(info)
 			assert.Equal(t, tt.expected, result)
 		})
 	}
 }
 
+func TestIsTerraform(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		fileName string
+		isDir    bool
+		expected bool
+	}{
+		{
+			name:     "TerraformFile",
+			fileName: "main.tf",
+			expected: true,
+		},
+		{
+			name:     "OpenTofuFile",
+			fileName: "main.tofu",
+			expected: true,
+		},
+		{
+			name:     "TerraformVariables",
+			fileName: "prod.tfvars",
+			expected: false,
+		},
+		{
+			name:     "YAMLFile",
+			fileName: "config.yaml",
+			expected: false,
+		},
+		{
+			name:     "Directory",
+			fileName: "modules.tf",
+			isDir:    true,
+			expected: false,
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			info := &fileInfoMock{
+				name: tt.fileName,
+				dir:  tt.isDir,
+			}
+
+			assert.Equal(t, tt.expected, isTerraform(info))
+		})
+	}
+}
+
+func TestYamlDockerfilesFollowSymlinks(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	for _, name := range []string{"repo/compose.yaml", "repo/nested/app.yaml", "shared/workflows/ci.yml"} {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	links := map[string]string{
+		// A shared directory outside of the base
+		"repo/.github/workflows": "../../shared/workflows",
+		// A directory walked anyway
+		"repo/alias": "nested",
+		// Cycles back to the base and to a parent
+		"repo/loop":      ".",
+		"repo/nested/up": "..",
+	}
+	for link, target := range links {
+		require.NoError(t, fs.Symlink(target, link))
+	}
+
+	testCases := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{
+			name:     "WithoutFollowSymlinks",
+			expected: []string{"repo/compose.yaml", "repo/nested/app.yaml"},
+		},
+		{
+			name:     "WithFollowSymlinks",
+			opts:     []Option{WithFollowSymlinks()},
+			expected: []string{"repo/compose.yaml", "repo/nested/app.yaml", "shared/workflows/ci.yml"},
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var processedFiles []string
+			err := YamlDockerfiles(fs, "repo", nil, func(path string) error {
+				processedFiles = append(processedFiles, path)
+				return nil
+			}, tt.opts...)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.expected, processedFiles)
+		})
+	}
+}
+
+func TestWalkLinkedRoot(t *testing.T) {
+	t.Parallel()
+
+	// memfs doesn't resolve the links within paths, so the files are on disk
+	fs := osfs.New(t.TempDir())
+	f, err := fs.Create("ci/workflows/ci.yml")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, fs.MkdirAll("repo/.github", 0755))
+	require.NoError(t, fs.Symlink("../../ci/workflows", "repo/.github/workflows"))
+
+	// The root is walked even without following the links below it
+	var processedFiles []string
+	err = YamlDockerfiles(fs, "repo/.github/workflows", nil, func(path string) error {
+		processedFiles = append(processedFiles, path)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"repo/.github/workflows/ci.yml"}, processedFiles)
+}
+
+func TestWalkLogsLinksNotFollowed(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/compose.yaml")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, fs.Symlink("../../outside", "repo/escape"))
+
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	var processedFiles []string
+	err = YamlDockerfiles(fs, "repo", nil, func(path string) error {
+		processedFiles = append(processedFiles, path)
+		return nil
+	}, WithFollowSymlinks(), WithLogger(logger))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"repo/compose.yaml"}, processedFiles)
+	assert.Contains(t, logs.String(), "not following symbolic link")
+	assert.Contains(t, logs.String(), "link=repo/escape")
+}
+
+func TestRealPath(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	require.NoError(t, fs.MkdirAll("repo/nested/deeper", 0755))
+	require.NoError(t, fs.Symlink("nested", "repo/link"))
+	require.NoError(t, fs.Symlink("link", "repo/chain"))
+	// The .. applies to the directory the link points to
+	require.NoError(t, fs.Symlink("../deep/..", "repo/nested/parent"))
+	require.NoError(t, fs.Symlink("nested/deeper", "repo/deep"))
+	require.NoError(t, fs.Symlink("cycle2", "repo/cycle1"))
+	require.NoError(t, fs.Symlink("cycle1", "repo/cycle2"))
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{path: "repo/nested", expected: "repo/nested"},
+		{path: "repo/link", expected: "repo/nested"},
+		{path: "repo/chain", expected: "repo/nested"},
+		{path: "repo/nested/parent", expected: "repo/nested"},
+		{path: "repo/cycle1"},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.path, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := realPath(fs, tt.path)
+			if tt.expected == "" {
+				assert.ErrorIs(t, err, errTooManySymlinks)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
 // fileInfoMock is a mock implementation of os.FileInfo for testing.
 type fileInfoMock struct {
 	name string
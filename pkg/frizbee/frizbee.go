@@ -0,0 +1,116 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package frizbee provides functions resolving a single reference to its pinned form,
+// for library users who don't need to replace the references of whole files.
+package frizbee
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/replacer"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/store"
+)
+
+// Option configures the resolution of a reference
+type Option func(*options)
+
+type options struct {
+	cfg      *config.Config
+	token    string
+	client   interfaces.REST
+	cache    store.RefCacher
+	keychain authn.Keychain
+}
+
+// WithConfig sets the configuration, the default configuration is used otherwise
+func WithConfig(cfg *config.Config) Option {
+	return func(o *options) {
+		o.cfg = cfg
+	}
+}
+
+// WithGitHubToken authenticates the requests to the GitHub API with the given token,
+// they're anonymous otherwise
+func WithGitHubToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithGitHubClient sets the client used to talk to the GitHub API, it takes precedence
+// over WithGitHubToken
+func WithGitHubClient(client interfaces.REST) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithCache sets the cache of resolved references, e.g. to share one across calls.
+// Each call uses a cache of its own otherwise.
+func WithCache(cache store.RefCacher) Option {
+	return func(o *options) {
+		o.cache = cache
+	}
+}
+
+// WithKeychain sets the keychain used to authenticate against container registries,
+// the default docker config is used otherwise
+func WithKeychain(keychain authn.Keychain) Option {
+	return func(o *options) {
+		o.keychain = keychain
+	}
+}
+
+// ResolveAction returns the GitHub Action reference, e.g. actions/checkout@v4, pinned by
+// the checksum of the commit its tag or branch points at. The error matches
+// interfaces.ErrReferenceSkipped if the reference is excluded or already pinned.
+func ResolveAction(ctx context.Context, ref string, opts ...Option) (*interfaces.EntityRef, error) {
+	return resolve(ctx, ref, replacer.WithActionsParser(), opts)
+}
+
+// ResolveImage returns the container image reference, e.g. ghcr.io/stacklok/minder/server:latest,
+// pinned by its digest. The error matches interfaces.ErrReferenceSkipped if the reference
+// is excluded or already pinned.
+func ResolveImage(ctx context.Context, ref string, opts ...Option) (*interfaces.EntityRef, error) {
+	return resolve(ctx, ref, replacer.WithImageParser(), opts)
+}
+
+// resolve resolves the reference with a replacer using the given parser
+func resolve(ctx context.Context, ref string, parser replacer.Option, opts []Option) (*interfaces.EntityRef, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r, err := replacer.New(parser, replacer.WithConfig(o.cfg), replacer.WithGitHubToken(o.token))
+	if err != nil {
+		return nil, err
+	}
+	if o.client != nil {
+		r = r.WithGitHubClient(o.client)
+	}
+	if o.cache != nil {
+		r = r.WithCache(o.cache)
+	}
+	if o.keychain != nil {
+		r = r.WithKeychain(o.keychain)
+	}
+	return r.ParseString(ctx, ref)
+}
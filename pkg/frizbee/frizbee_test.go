@@ -0,0 +1,143 @@
+package frizbee
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/store"
+)
+
+const checkoutSHA = "11bd71901bbe5b1630ceea73d27597364c9af683"
+
+// mockREST answers the GitHub API requests from canned responses, keyed by their path
+type mockREST struct {
+	responses map[string]string
+	requests  int
+}
+
+func (*mockREST) NewRequest(method, url string, _ any) (*http.Request, error) {
+	return http.NewRequest(method, "https://api.github.com/"+url, nil)
+}
+
+func (m *mockREST) Do(_ context.Context, req *http.Request) (*http.Response, error) {
+	m.requests++
+	body, ok := m.responses[req.URL.Path]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func newMockREST() *mockREST {
+	return &mockREST{responses: map[string]string{
+		"/repos/actions/checkout/git/refs/tags/v4": `{"object": {"sha": "` + checkoutSHA + `", "type": "commit"}}`,
+	}}
+}
+
+func TestResolveAction(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		ref     string
+		opts    func(client interfaces.REST) []Option
+		want    *interfaces.EntityRef
+		wantErr error
+	}{
+		{
+			name: "tag",
+			ref:  "actions/checkout@v4",
+			opts: func(client interfaces.REST) []Option { return []Option{WithGitHubClient(client)} },
+			want: &interfaces.EntityRef{Name: "actions/checkout", Ref: checkoutSHA, Type: "action", Tag: "v4"},
+		},
+		{
+			name: "excluded",
+			ref:  "actions/checkout@v4",
+			opts: func(client interfaces.REST) []Option {
+				cfg := config.DefaultConfig()
+				cfg.GHActions.Exclude = []string{"actions/checkout"}
+				return []Option{WithGitHubClient(client), WithConfig(cfg)}
+			},
+			wantErr: interfaces.ErrReferenceSkipped,
+		},
+		{
+			name:    "already pinned",
+			ref:     "actions/checkout@" + checkoutSHA,
+			opts:    func(client interfaces.REST) []Option { return []Option{WithGitHubClient(client)} },
+			wantErr: interfaces.ErrReferenceSkipped,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ResolveAction(context.Background(), tt.ref, tt.opts(newMockREST())...)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResolveActionCache(t *testing.T) {
+	t.Parallel()
+
+	client := newMockREST()
+	cache := store.NewRefCacher()
+	for i := 0; i < 2; i++ {
+		got, err := ResolveAction(context.Background(), "actions/checkout@v4", WithGitHubClient(client), WithCache(cache))
+		require.NoError(t, err)
+		require.Equal(t, checkoutSHA, got.Ref)
+	}
+	// The second call is answered from the cache shared across the calls
+	require.Equal(t, 1, client.requests)
+}
+
+func TestResolveImage(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	ref, err := name.ParseReference(host + "/app:v1")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	mediaType, err := img.MediaType()
+	require.NoError(t, err)
+
+	got, err := ResolveImage(context.Background(), host+"/app:v1")
+	require.NoError(t, err)
+	require.Equal(t, &interfaces.EntityRef{
+		Name:      host + "/app",
+		Ref:       digest.String(),
+		Type:      "container",
+		Tag:       "v1",
+		MediaType: string(mediaType),
+	}, got)
+
+	_, err = ResolveImage(context.Background(), host+"/app@"+digest.String())
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+}
@@ -19,6 +19,7 @@ package interfaces
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/stacklok/frizbee/pkg/utils/config"
@@ -28,8 +29,71 @@ import (
 var (
 	// ErrReferenceSkipped is returned when the reference is skipped.
 	ErrReferenceSkipped = errors.New("reference skipped")
+	// ErrInvalidReference is returned when the matched text can't be parsed as a
+	// reference, e.g. because it's templated, as opposed to a reference failing to resolve.
+	ErrInvalidReference = errors.New("invalid reference")
+	// ErrReplacedInDocument is returned along with ErrReferenceSkipped when the matched
+	// reference is replaced through ReplaceInDocument instead, so it's only counted once.
+	ErrReplacedInDocument = errors.New("reference replaced in document")
 )
 
+// SkipReason tells why a reference was skipped rather than pinned
+type SkipReason string
+
+const (
+	// SkipExcludedTag is the reason of references skipped because of their tag, e.g. latest
+	SkipExcludedTag SkipReason = "excluded-tag"
+	// SkipExcludedImage is the reason of references skipped because of their name, e.g. scratch
+	SkipExcludedImage SkipReason = "excluded-image"
+	// SkipNoTag is the reason of references skipped because they have no explicit tag and
+	// default to an excluded one, e.g. ubuntu standing for ubuntu:latest
+	SkipNoTag SkipReason = "no-tag"
+	// SkipLocalPath is the reason of references to a local path rather than to a remote entity
+	SkipLocalPath SkipReason = "local-path"
+	// SkipVariable is the reason of references holding a variable left to expand, e.g.
+	// FROM ${REGISTRY}/app:${TAG} in Dockerfiles with build arguments
+	SkipVariable SkipReason = "variable"
+	// SkipStage is the reason of Dockerfile FROM instructions building upon an earlier
+	// stage of the same Dockerfile rather than upon an image
+	SkipStage SkipReason = "stage"
+	// SkipShallowClone is the reason of Terraform module sources cloned with a depth, e.g.
+	// ?depth=1, as a shallow clone only fetches the tip of a branch or tag, not a commit
+	SkipShallowClone SkipReason = "shallow-clone"
+	// SkipExcludedAction is the reason of references skipped because of their action, i.e.
+	// one which isn't included or is excluded by the configuration
+	SkipExcludedAction SkipReason = "excluded-action"
+	// SkipExcludedBranch is the reason of references to a branch which isn't resolved, e.g.
+	// main, excluded by default
+	SkipExcludedBranch SkipReason = "excluded-branch"
+)
+
+// SkippedError is returned when a reference is skipped for a known reason, e.g. to report
+// the references left mutable on purpose. It matches ErrReferenceSkipped.
+type SkippedError struct {
+	Reference string
+	Reason    SkipReason
+}
+
+// Error implements the error interface
+func (e *SkippedError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", ErrReferenceSkipped, e.Reference, e.Reason)
+}
+
+// Is makes the error match ErrReferenceSkipped
+func (*SkippedError) Is(target error) bool {
+	return target == ErrReferenceSkipped
+}
+
+// GetSkipReason returns the reason the reference was skipped for if the error is, or
+// wraps, a SkippedError, or an empty reason otherwise
+func GetSkipReason(err error) SkipReason {
+	var skipped *SkippedError
+	if errors.As(err, &skipped) {
+		return skipped.Reason
+	}
+	return ""
+}
+
 // EntityRef represents an action reference.
 type EntityRef struct {
 	Name   string `json:"name"`
@@ -37,6 +101,51 @@ type EntityRef struct {
 	Type   string `json:"type"`
 	Tag    string `json:"tag"`
 	Prefix string `json:"prefix"`
+	// MediaType is the media type of the manifest a resolved image digest refers to, e.g.
+	// an OCI image index for multi-platform images. It's empty if unknown, e.g. for the
+	// references which were only listed rather than resolved.
+	MediaType string `json:"media_type,omitempty"`
+	// Version is the release a moving Tag such as v4 pointed at when resolved, e.g.
+	// v4.1.1, if it was looked up.
+	Version string `json:"version,omitempty"`
+}
+
+// DocumentReference is a reference found by a parser replacing the references of a
+// whole document rather than line by line, e.g. the rev of a pre-commit hook repository
+// whose repo is held by a sibling key, along with the outcome of resolving it
+type DocumentReference struct {
+	// Line is the 1-based line number
+	Line int
+	// Reference is the reference as written, e.g. rev: v1.2.0
+	Reference string
+	// Ref is the reference it was pinned to, nil if it wasn't
+	Ref *EntityRef
+	// Pinned is the reference as rewritten, empty if it wasn't
+	Pinned string
+	// Err is the error resolving the reference, matching ErrReferenceSkipped if it was
+	// skipped, nil if it was pinned
+	Err error
+}
+
+// SkippedReference is a reference left untouched for a known reason
+type SkippedReference struct {
+	// Path is the path of the file, empty when parsing a single file
+	Path string `json:"path,omitempty"`
+	// Line is the 1-based line number
+	Line      int        `json:"line"`
+	Reference string     `json:"reference"`
+	Reason    SkipReason `json:"reason"`
+}
+
+// ReferenceChange is a reference rewritten by pinning, or unpinning, it
+type ReferenceChange struct {
+	// Path is the path of the file
+	Path string `json:"file"`
+	// Line is the 1-based line number
+	Line   int    `json:"line"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Type   string `json:"type"`
 }
 
 // Parser is an interface to replace references with digests
@@ -46,6 +155,25 @@ type Parser interface {
 	GetRegex() string
 	Replace(ctx context.Context, matchedLine string, restIf REST, cfg config.Config) (*EntityRef, error)
 	ConvertToEntityRef(reference string) (*EntityRef, error)
+	// Unpin reverts a reference pinned by its digest back to the given tag.
+	// Implementations should prefer a tag embedded in the reference itself,
+	// e.g. name:tag@digest, over the provided one.
+	Unpin(matchedLine, tag string) (*EntityRef, error)
+}
+
+// Resolver resolves the references to the checksum or digest they're pinned by. The
+// parsers go through the GitHub API and the container registries unless given another
+// one, e.g. backed by an artifact proxy serving the digests through a private API.
+type Resolver interface {
+	// ResolveActionChecksum returns the checksum of the commit the tag or branch of the
+	// action points at, e.g. actions/checkout and v4. The action may include the
+	// subdirectory it lives in, e.g. owner/repo/sub/dir.
+	ResolveActionChecksum(ctx context.Context, action, ref string) (string, error)
+	// ResolveImageDigest returns the digest of the manifest the fully qualified image
+	// reference points at, e.g. index.docker.io/library/alpine:3.20, along with its media
+	// type if known. Given a platform, e.g. linux/arm64, it's the digest of the image of
+	// that platform rather than of the multi-platform index.
+	ResolveImageDigest(ctx context.Context, imageRef, platform string) (digest string, mediaType string, err error)
 }
 
 // The REST interface allows to wrap clients to talk to remotes
@@ -56,3 +184,10 @@ type REST interface {
 	// Do executes an HTTP request.
 	Do(ctx context.Context, req *http.Request) (*http.Response, error)
 }
+
+// HostREST is implemented by the REST clients also talking to other hosts than their
+// own, e.g. GitHub Enterprise Servers next to github.com
+type HostREST interface {
+	// ForHost returns the client of the given host, if any.
+	ForHost(host string) (REST, bool)
+}
@@ -17,30 +17,47 @@
 package actions
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-github/v66/github"
+	"golang.org/x/sync/singleflight"
 
+	"github.com/stacklok/frizbee/internal/traverse"
 	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/replacer/image"
 	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
 	"github.com/stacklok/frizbee/pkg/utils/store"
 )
 
 const (
 	prefixUses   = "uses: "
 	prefixDocker = "docker://"
+	// prefixGit and prefixGitHubURL are stripped from the actions referenced by their repository URL
+	prefixGit       = "git::"
+	prefixGitHubURL = "https://github.com/"
 	// GitHubActionsRegex is regular expression pattern to match GitHub Actions usage
 	GitHubActionsRegex = `uses:\s*[^\s]+/[^\s]+@[^\s]+|uses:\s*docker://[^\s]+:[^\s]+`
 	// ReferenceType is the type of the reference
 	ReferenceType = "action"
+	// maxTagDepth is how many annotated tags pointing at other tags are dereferenced
+	maxTagDepth = 10
+	// maxPeeledTags is how many annotated release tags are dereferenced at most when
+	// resolving a version
+	maxPeeledTags = 5
 )
 
 var (
@@ -48,19 +65,44 @@ var (
 	ErrInvalidAction = errors.New("invalid action")
 	// ErrInvalidActionReference is returned when parsing the action reference fails.
 	ErrInvalidActionReference = errors.New("action reference is not a tag nor branch")
+	// ErrCommitNotFound is returned when verifying a resolved commit which doesn't exist,
+	// e.g. because the tag was force-updated in the meantime.
+	ErrCommitNotFound = errors.New("resolved commit not found")
+
+	// movingTagRegex matches the tags moving along the releases of an action, e.g. v4 or v4.1
+	movingTagRegex = regexp.MustCompile(`^v?\d+(\.\d+)?$`)
 )
 
+// defaultRegex is GitHubActionsRegex compiled once for all the parsers
+var defaultRegex = regexp.MustCompile(GitHubActionsRegex)
+
 // Parser is a struct to replace action references with digests
 type Parser struct {
 	regex string
-	cache store.RefCacher
+	// compiled is the compiled regex, or nil along with regexErr if it's invalid
+	compiled   *regexp.Regexp
+	regexErr   error
+	cache      store.RefCacher
+	remoteOpts []remote.Option
+	retry      retry.Policy
+	// verifyCommits checks that the resolved commits exist before pinning them
+	verifyCommits bool
+	// lookupEnv looks up the variables expanded in the references before resolving them, if set
+	lookupEnv func(string) (string, bool)
+	// lookups deduplicates the concurrent resolutions of the same reference
+	lookups singleflight.Group
+	// logger logs the cache lookups at debug level, if set
+	logger *slog.Logger
+	// resolver resolves the checksums and digests instead of GitHub and the registries, if set
+	resolver interfaces.Resolver
 }
 
 // New creates a new Parser
 func New() *Parser {
 	return &Parser{
-		regex: GitHubActionsRegex,
-		cache: store.NewRefCacher(),
+		regex:    GitHubActionsRegex,
+		compiled: defaultRegex,
+		cache:    store.NewRefCacher(),
 	}
 }
 
@@ -69,9 +111,45 @@ func (p *Parser) SetCache(cache store.RefCacher) {
 	p.cache = cache
 }
 
+// SetRemoteOptions sets additional options used when resolving docker:// references
+func (p *Parser) SetRemoteOptions(opts ...remote.Option) {
+	p.remoteOpts = opts
+}
+
+// SetRetryPolicy sets the policy to retry docker:// image resolutions failing with transient errors
+func (p *Parser) SetRetryPolicy(policy retry.Policy) {
+	p.retry = policy
+}
+
+// SetResolver sets the resolver returning the checksums of the actions and the digests
+// of the docker:// images instead of the GitHub API and the registries. The commits are
+// still verified and the versions still resolved through the GitHub API, if asked to.
+func (p *Parser) SetResolver(resolver interfaces.Resolver) {
+	p.resolver = resolver
+}
+
+// SetVerifyCommits sets whether the resolved commits are checked to exist before
+// being pinned, at the cost of an additional API request per reference.
+func (p *Parser) SetVerifyCommits(verify bool) {
+	p.verifyCommits = verify
+}
+
+// SetEnvLookup sets the function looking up the $VAR and ${VAR} variables expanded in
+// the action and docker:// references before resolving them, e.g. os.LookupEnv. The
+// references holding variables it doesn't know are skipped.
+func (p *Parser) SetEnvLookup(lookup func(string) (string, bool)) {
+	p.lookupEnv = lookup
+}
+
+// SetLogger sets the logger the cache hits and misses are logged to at debug level
+func (p *Parser) SetLogger(logger *slog.Logger) {
+	p.logger = logger
+}
+
 // SetRegex returns the regular expression pattern to match GitHub Actions usage
 func (p *Parser) SetRegex(regex string) {
 	p.regex = regex
+	p.compiled, p.regexErr = regexp.Compile(regex)
 }
 
 // GetRegex returns the regular expression pattern to match GitHub Actions usage
@@ -79,6 +157,26 @@ func (p *Parser) GetRegex() string {
 	return p.regex
 }
 
+// CompiledRegex returns the regular expression pattern to match GitHub Actions usage, compiled
+// once when set rather than for every file, or the error compiling it if it's invalid
+func (p *Parser) CompiledRegex() (*regexp.Regexp, error) {
+	return p.compiled, p.regexErr
+}
+
+// MatchFile returns false for the files traversed for container images which can't
+// reference actions, i.e. Dockerfiles, quadlet container units, JSON manifests and Nix
+// files, so they're left to the image parser when the parsers are combined
+func (*Parser) MatchFile(path string) bool {
+	name := filepath.Base(path)
+	if traverse.IsDockerfile(name) {
+		return false
+	}
+	return !slices.ContainsFunc(
+		[]string{traverse.QuadletExtension, traverse.JSONExtension, traverse.NixExtension},
+		func(ext string) bool { return strings.HasSuffix(name, ext) },
+	)
+}
+
 // Replace replaces the action reference with the digest
 func (p *Parser) Replace(
 	ctx context.Context,
@@ -90,13 +188,22 @@ func (p *Parser) Replace(
 	var actionRef *interfaces.EntityRef
 	hasUsesPrefix := false
 
-	// Trim the uses prefix
+	// Trim the uses prefix, along with the quotes around the value, if any
 	if strings.HasPrefix(matchedLine, prefixUses) {
-		matchedLine = strings.TrimPrefix(matchedLine, prefixUses)
+		matchedLine = unquote(strings.TrimPrefix(matchedLine, prefixUses))
 		hasUsesPrefix = true
 	}
+	if p.lookupEnv != nil {
+		matchedLine = image.ExpandVariables(matchedLine, p.lookupEnv)
+		if strings.Contains(matchedLine, "$") {
+			return nil, &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipVariable}
+		}
+	}
 	// Determine if the action reference has a docker prefix
 	if strings.HasPrefix(matchedLine, prefixDocker) {
+		if cfg.GHActions.SkipDocker {
+			return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+		}
 		actionRef, err = p.replaceDocker(ctx, matchedLine, restIf, cfg)
 	} else {
 		actionRef, err = p.replaceAction(ctx, matchedLine, restIf, cfg)
@@ -122,8 +229,11 @@ func (p *Parser) replaceAction(
 ) (*interfaces.EntityRef, error) {
 
 	// If the value is a local path or should be excluded, skip it
-	if isLocal(matchedLine) || shouldExclude(&cfg.GHActions, matchedLine) {
-		return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+	if isLocal(matchedLine) {
+		return nil, &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipLocalPath}
+	}
+	if shouldExclude(&cfg.GHActions, matchedLine) {
+		return nil, &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipExcludedAction}
 	}
 
 	// Parse the action reference
@@ -133,31 +243,16 @@ func (p *Parser) replaceAction(
 	}
 
 	// Check if the parsed reference should be excluded
-	if shouldExclude(&cfg.GHActions, act) {
-		return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
-	}
-	var sum string
-
-	// Check if we have a cache
-	if p.cache != nil {
-		// Check if we have a cached value
-		if val, ok := p.cache.Load(matchedLine); ok {
-			sum = val
-		} else {
-			// Get the checksum for the action reference
-			sum, err = GetChecksum(ctx, cfg.GHActions, restIf, act, ref)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get checksum for action '%s': %w", matchedLine, err)
-			}
-			// Store the checksum in the cache
-			p.cache.Store(matchedLine, sum)
-		}
-	} else {
-		// Get the checksum for the action reference
-		sum, err = GetChecksum(ctx, cfg.GHActions, restIf, act, ref)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get checksum for action '%s': %w", matchedLine, err)
-		}
+	if !isIncluded(&cfg.GHActions, act) || shouldExclude(&cfg.GHActions, act) {
+		return nil, &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipExcludedAction}
+	}
+	// Resolve the actions prefixed by another GitHub host through the client of that host
+	restIf, repoAct := selectHost(restIf, act)
+
+	// Get the checksum for the action reference, reusing the cached one if any
+	sum, err := p.getChecksum(ctx, cfg.GHActions, restIf, matchedLine, repoAct, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksum for action '%s': %w", matchedLine, err)
 	}
 
 	// Compare the digest with the reference and return the original reference if they already match
@@ -165,11 +260,29 @@ func (p *Parser) replaceAction(
 		return nil, fmt.Errorf("image already referenced by digest: %s %w", matchedLine, interfaces.ErrReferenceSkipped)
 	}
 
+	if p.verifyCommits {
+		if err := VerifyCommit(ctx, restIf, repoAct, sum); err != nil {
+			return nil, fmt.Errorf("failed to verify checksum for action '%s': %w", matchedLine, err)
+		}
+	}
+
+	// Record the release the moving tag points at along with the tag itself, if asked to.
+	// The action is pinned anyway, the moving tag being the only one recorded if the
+	// release can't be looked up.
+	var version string
+	if cfg.GHActions.ResolveVersion {
+		version, err = p.getVersion(ctx, restIf, matchedLine, repoAct, ref, sum)
+		if err != nil && p.logger != nil {
+			p.logger.WarnContext(ctx, "failed to resolve version", "reference", matchedLine, "error", err)
+		}
+	}
+
 	return &interfaces.EntityRef{
-		Name: act,
-		Ref:  sum,
-		Type: ReferenceType,
-		Tag:  ref,
+		Name:    act,
+		Ref:     sum,
+		Type:    ReferenceType,
+		Tag:     ref,
+		Version: version,
 	}, nil
 }
 
@@ -183,19 +296,26 @@ func (p *Parser) replaceDocker(
 	trimmedRef := strings.TrimPrefix(matchedLine, prefixDocker)
 
 	// If the value is a local path or should be excluded, skip it
-	if isLocal(trimmedRef) || shouldExclude(&cfg.GHActions, trimmedRef) {
-		return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+	if isLocal(trimmedRef) {
+		return nil, &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipLocalPath}
+	}
+	if shouldExclude(&cfg.GHActions, trimmedRef) {
+		return nil, &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipExcludedAction}
 	}
 
 	// Get the digest of the docker:// image reference
-	actionRef, err := image.GetImageDigestFromRef(ctx, trimmedRef, cfg.Platform, p.cache)
+	var actionRef *interfaces.EntityRef
+	err := p.retry.Do(ctx, func() (err error) {
+		actionRef, err = p.getImageDigest(ctx, trimmedRef, &cfg)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	// Check if the parsed reference should be excluded
-	if shouldExclude(&cfg.GHActions, actionRef.Name) {
-		return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+	if !isIncluded(&cfg.GHActions, actionRef.Name) || shouldExclude(&cfg.GHActions, actionRef.Name) {
+		return nil, &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipExcludedAction}
 	}
 
 	// Add back the docker prefix
@@ -206,9 +326,131 @@ func (p *Parser) replaceDocker(
 	return actionRef, nil
 }
 
+// getChecksum returns the checksum for the action reference like GetChecksumCached.
+// Concurrent lookups of the same reference, e.g. of files processed in parallel, share
+// a single resolution rather than all missing the cache and reaching the API.
+func (p *Parser) getChecksum(
+	ctx context.Context,
+	cfg config.GHActions,
+	restIf interfaces.REST,
+	key, action, ref string,
+) (string, error) {
+	store.LogLookup(ctx, p.logger, p.cache, key, key)
+	v, err, _ := p.lookups.Do(key, func() (any, error) {
+		if p.resolver != nil {
+			// The resolver doesn't tell the tags from the branches, so the branch filter
+			// applies to every reference it would resolve
+			if !IsChecksum(ref) && skipBranch(cfg.Filter, ref) {
+				return "", &interfaces.SkippedError{Reference: ref, Reason: interfaces.SkipExcludedBranch}
+			}
+			return getChecksumCached(p.cache, key, func() (string, error) {
+				if IsChecksum(ref) {
+					return ref, nil
+				}
+				return p.resolver.ResolveActionChecksum(ctx, action, ref)
+			})
+		}
+		return GetChecksumCached(ctx, cfg, restIf, p.cache, key, action, ref)
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// getVersion returns the release the moving tag points at like GetVersion, caching it
+// next to the checksum of the action reference and sharing the concurrent lookups like
+// getChecksum.
+func (p *Parser) getVersion(
+	ctx context.Context,
+	restIf interfaces.REST,
+	key, action, ref, sum string,
+) (string, error) {
+	key += "#version"
+	v, err, _ := p.lookups.Do(key, func() (any, error) {
+		if p.cache != nil {
+			if version, ok := store.LoadMetadata(p.cache, key); ok {
+				return version, nil
+			}
+		}
+		version, err := GetVersion(ctx, restIf, action, ref, sum)
+		if err != nil {
+			return "", err
+		}
+		if p.cache != nil {
+			store.StoreMetadata(p.cache, key, version)
+		}
+		return version, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// getImageDigest returns the digest of a docker:// image reference like
+// image.GetImageDigestFromRefWithConfig, sharing the concurrent lookups like getChecksum
+func (p *Parser) getImageDigest(ctx context.Context, imageRef string, cfg *config.Config) (*interfaces.EntityRef, error) {
+	v, err, _ := p.lookups.Do(prefixDocker+imageRef+"#"+cfg.Platform, func() (any, error) {
+		if p.resolver != nil {
+			return image.GetImageDigestFromResolver(ctx, imageRef, cfg, p.cache, p.resolver)
+		}
+		return image.GetImageDigestFromRefWithConfig(ctx, imageRef, cfg, p.cache, p.remoteOpts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The result is shared, so each caller gets its own copy to modify
+	ref := *v.(*interfaces.EntityRef)
+	return &ref, nil
+}
+
+// Unpin reverts an action reference pinned by its checksum back to the given tag
+func (_ *Parser) Unpin(matchedLine, tag string) (*interfaces.EntityRef, error) {
+	var actionRef *interfaces.EntityRef
+	var err error
+	hasUsesPrefix := false
+
+	// Trim the uses prefix, along with the quotes around the value, if any
+	if strings.HasPrefix(matchedLine, prefixUses) {
+		matchedLine = unquote(strings.TrimPrefix(matchedLine, prefixUses))
+		hasUsesPrefix = true
+	}
+	// Determine if the action reference has a docker prefix
+	if strings.HasPrefix(matchedLine, prefixDocker) {
+		actionRef, err = image.UnpinImageRef(strings.TrimPrefix(matchedLine, prefixDocker), tag)
+		if err != nil {
+			return nil, err
+		}
+		actionRef.Prefix = prefixDocker
+	} else {
+		act, ref, err := ParseActionReference(matchedLine)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse action reference '%s': %w", matchedLine, err)
+		}
+		// Only references pinned by a checksum with a known tag can be unpinned
+		if !IsChecksum(ref) || tag == "" {
+			return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+		}
+		actionRef = &interfaces.EntityRef{
+			Name: act,
+			Ref:  ref,
+			Type: ReferenceType,
+			Tag:  tag,
+		}
+	}
+
+	// Add back the uses prefix
+	if hasUsesPrefix {
+		actionRef.Prefix = fmt.Sprintf("%s%s", prefixUses, actionRef.Prefix)
+	}
+
+	return actionRef, nil
+}
+
 // ConvertToEntityRef converts an action reference to an EntityRef
 func (_ *Parser) ConvertToEntityRef(reference string) (*interfaces.EntityRef, error) {
-	reference = strings.TrimPrefix(reference, prefixUses)
+	reference = unquote(strings.TrimPrefix(reference, prefixUses))
 	refType := ReferenceType
 	separator := "@"
 	// Update the separator in case this is a docker reference with a digest
@@ -221,7 +463,7 @@ func (_ *Parser) ConvertToEntityRef(reference string) (*interfaces.EntityRef, er
 	}
 	frags := strings.Split(reference, separator)
 	if len(frags) != 2 {
-		return nil, fmt.Errorf("invalid action reference: %s", reference)
+		return nil, fmt.Errorf("invalid action reference: %s %w", reference, interfaces.ErrInvalidReference)
 	}
 
 	return &interfaces.EntityRef{
@@ -231,30 +473,57 @@ func (_ *Parser) ConvertToEntityRef(reference string) (*interfaces.EntityRef, er
 	}, nil
 }
 
+// unquote returns the value without the single or double quotes around it, if any
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
 // isLocal returns true if the input is a local path.
 func isLocal(input string) bool {
 	return strings.HasPrefix(input, "./") || strings.HasPrefix(input, "../")
 }
 
 func shouldExclude(cfg *config.GHActions, input string) bool {
-	for _, e := range cfg.Exclude {
-		if e == input {
-			return true
-		}
-	}
-	return false
+	return config.MatchAny(cfg.Exclude, input)
+}
+
+// isIncluded returns true if the action matches the include list, or if there's none
+func isIncluded(cfg *config.GHActions, action string) bool {
+	return len(cfg.Include) == 0 || config.MatchAny(cfg.Include, action)
 }
 
-// ParseActionReference parses an action reference into action and reference.
+// ParseActionReference parses an action reference into action and reference. Actions
+// referenced by their repository URL, e.g. https://github.com/actions/checkout.git@v4,
+// are returned in their shorthand form, i.e. actions/checkout.
 func ParseActionReference(input string) (action string, reference string, err error) {
-	frags := strings.Split(input, "@")
+	frags := strings.Split(normalizeAction(input), "@")
 	if len(frags) != 2 {
-		return "", "", fmt.Errorf("invalid action reference: %s", input)
+		return "", "", fmt.Errorf("invalid action reference: %s %w", input, interfaces.ErrInvalidReference)
 	}
 
 	return frags[0], frags[1], nil
 }
 
+// normalizeAction strips the git:: and https://github.com/ prefixes of the actions
+// referenced by their repository URL, along with the .git suffix of the repository
+func normalizeAction(action string) string {
+	action = strings.TrimPrefix(action, prefixGit)
+	action = strings.TrimPrefix(action, prefixGitHubURL)
+	owner, rest, found := strings.Cut(action, "/")
+	if !found {
+		return action
+	}
+	// The suffix is followed either by the subdirectory of the action or by its reference
+	repo, sub := rest, ""
+	if i := strings.IndexAny(rest, "/@"); i >= 0 {
+		repo, sub = rest[:i], rest[i:]
+	}
+	return owner + "/" + strings.TrimSuffix(repo, ".git") + sub
+}
+
 // GetChecksum returns the checksum for a given action and tag.
 func GetChecksum(ctx context.Context, cfg config.GHActions, restIf interfaces.REST, action, ref string) (string, error) {
 	owner, repo, err := parseActionFragments(action)
@@ -263,11 +532,11 @@ func GetChecksum(ctx context.Context, cfg config.GHActions, restIf interfaces.RE
 	}
 
 	// Check if we're using a checksum
-	if isChecksum(ref) {
+	if IsChecksum(ref) {
 		return ref, nil
 	}
 
-	res, err := getCheckSumForTag(ctx, restIf, owner, repo, ref)
+	res, err := getCheckSumForTag(ctx, restIf, owner, repo, ref, cfg.ResolveTagObject)
 	if err != nil {
 		return "", fmt.Errorf("failed to get checksum for tag: %w", err)
 	} else if res != "" {
@@ -275,10 +544,10 @@ func GetChecksum(ctx context.Context, cfg config.GHActions, restIf interfaces.RE
 	}
 
 	// check branch
-	if excludeBranch(cfg.Filter.ExcludeBranches, ref) {
+	if skipBranch(cfg.Filter, ref) {
 		// if a branch is excluded, we won't know if it's a valid reference
 		// but that's OK - we just won't touch that reference
-		return "", fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, ref)
+		return "", &interfaces.SkippedError{Reference: ref, Reason: interfaces.SkipExcludedBranch}
 	}
 
 	res, err = getCheckSumForBranch(ctx, restIf, owner, repo, ref)
@@ -291,24 +560,236 @@ func GetChecksum(ctx context.Context, cfg config.GHActions, restIf interfaces.RE
 	return "", ErrInvalidActionReference
 }
 
+// GetChecksumCached returns the checksum for a given action and tag like GetChecksum,
+// reusing the checksum stored under the given key of the cache, if any. If the cache
+// implements store.NegativeCacher, references which are neither a tag nor a branch
+// are remembered as well, so they aren't looked up again. The cache may be nil.
+func GetChecksumCached(
+	ctx context.Context,
+	cfg config.GHActions,
+	restIf interfaces.REST,
+	cache store.RefCacher,
+	key, action, ref string,
+) (string, error) {
+	return getChecksumCached(cache, key, func() (string, error) {
+		return GetChecksum(ctx, cfg, restIf, action, ref)
+	})
+}
+
+// getChecksumCached returns the checksum stored under the given key of the cache, if any,
+// or the one returned by the given function otherwise, like GetChecksumCached
+func getChecksumCached(cache store.RefCacher, key string, resolve func() (string, error)) (string, error) {
+	if cache == nil {
+		return resolve()
+	}
+	if sum, ok := cache.Load(key); ok {
+		return sum, nil
+	}
+	negCache, hasNegCache := cache.(store.NegativeCacher)
+	if hasNegCache && negCache.IsMissing(key) {
+		return "", fmt.Errorf("%w (cached)", ErrInvalidActionReference)
+	}
+
+	sum, err := resolve()
+	if err != nil {
+		// Only a reference known not to exist is remembered, other errors may be transient
+		if hasNegCache && errors.Is(err, ErrInvalidActionReference) {
+			negCache.StoreMissing(key)
+		}
+		return "", err
+	}
+	cache.Store(key, sum)
+	return sum, nil
+}
+
+// VerifyCommit returns ErrCommitNotFound if the commit with the given checksum doesn't
+// exist in the repository of the action.
+func VerifyCommit(ctx context.Context, restIf interfaces.REST, action, sum string) error {
+	owner, repo, err := parseActionFragments(action)
+	if err != nil {
+		return err
+	}
+	path, err := url.JoinPath("repos", owner, repo, "commits", sum)
+	if err != nil {
+		return fmt.Errorf("failed to join path: %w", err)
+	}
+
+	req, err := restIf.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return fmt.Errorf("cannot create REST request: %w", err)
+	}
+	resp, err := restIf.Do(ctx, req)
+	if resp != nil {
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity {
+			return fmt.Errorf("%w: %s@%s", ErrCommitNotFound, action, sum)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to do API request: %w", err)
+	}
+	return nil
+}
+
+// GetVersion returns the release tag of the action the given moving tag, e.g. v4 or v4.1,
+// points at, i.e. the most specific tag starting with it which resolves to the same commit,
+// e.g. v4.1.1. The given checksum is the one the moving tag resolved to, either of its
+// commit or, if pinned to the tag object, of its annotated tag. An empty string is returned
+// if the tag isn't a moving one or no release matches. The tags starting with the moving
+// one are listed in a single request, and the releases are looked at most recent first,
+// dereferencing at most maxPeeledTags annotated ones.
+func GetVersion(ctx context.Context, restIf interfaces.REST, action, ref, sum string) (string, error) {
+	if !movingTagRegex.MatchString(ref) {
+		return "", nil
+	}
+	owner, repo, err := parseActionFragments(action)
+	if err != nil {
+		return "", err
+	}
+
+	refs, err := listMatchingTags(ctx, restIf, owner, repo, ref)
+	if err != nil {
+		return "", err
+	}
+	commit := sum
+	var releases []*github.Reference
+	for _, r := range refs {
+		name := strings.TrimPrefix(r.GetRef(), "refs/tags/")
+		switch {
+		case name == ref && r.GetObject().GetType() == "tag" && r.GetObject().GetSHA() == sum:
+			// Pinned to the tag object, the releases are compared by commit
+			if commit, err = peelTag(ctx, restIf, owner, repo, sum); err != nil {
+				return "", err
+			}
+		case strings.HasPrefix(name, ref+"."):
+			releases = append(releases, r)
+		}
+	}
+	// Prefer v4.1.1 over v4.1 when both point at the commit
+	slices.SortFunc(releases, func(a, b *github.Reference) int {
+		return compareVersions(b.GetRef(), a.GetRef())
+	})
+
+	peeled := 0
+	for _, r := range releases {
+		sha := r.GetObject().GetSHA()
+		if r.GetObject().GetType() == "tag" {
+			if peeled == maxPeeledTags {
+				break
+			}
+			peeled++
+			if sha, err = peelTag(ctx, restIf, owner, repo, sha); err != nil {
+				return "", err
+			}
+		}
+		if sha == commit {
+			return strings.TrimPrefix(r.GetRef(), "refs/tags/"), nil
+		}
+	}
+	return "", nil
+}
+
+// listMatchingTags returns the references of the tags of the repository starting with
+// the given prefix, along with the object they point at
+func listMatchingTags(ctx context.Context, restIf interfaces.REST, owner, repo, prefix string) ([]*github.Reference, error) {
+	path, err := url.JoinPath("repos", owner, repo, "git", "matching-refs", "tags", prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join path: %w", err)
+	}
+
+	req, err := restIf.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create REST request: %w", err)
+	}
+	resp, err := restIf.Do(ctx, req)
+	if resp != nil {
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to do API request: %w", err)
+	}
+
+	var refs []*github.Reference
+	if err := json.NewDecoder(resp.Body).Decode(&refs); err != nil {
+		return nil, fmt.Errorf("cannot decode response: %w", err)
+	}
+	return refs, nil
+}
+
+// compareVersions compares the numeric fragments of the given tags, e.g. refs/tags/v4.1.1
+// is greater than refs/tags/v4.1 which is greater than refs/tags/v4.0.2. Fragments which
+// aren't numbers, e.g. of pre-releases, compare lower than any number.
+func compareVersions(a, b string) int {
+	fa := strings.Split(strings.TrimPrefix(strings.TrimPrefix(a, "refs/tags/"), "v"), ".")
+	fb := strings.Split(strings.TrimPrefix(strings.TrimPrefix(b, "refs/tags/"), "v"), ".")
+	for i := 0; i < len(fa) && i < len(fb); i++ {
+		na, errA := strconv.Atoi(fa[i])
+		if errA != nil {
+			na = -1
+		}
+		nb, errB := strconv.Atoi(fb[i])
+		if errB != nil {
+			nb = -1
+		}
+		if na != nb {
+			return cmp.Compare(na, nb)
+		}
+	}
+	return cmp.Compare(len(fa), len(fb))
+}
+
+// selectHost returns the client of the host prefixing the action, e.g. ghe.example-corp.com
+// in ghe.example-corp.com/team/action, along with the action without it, if the client
+// talks to that host. Otherwise the client and action are returned as they are.
+func selectHost(restIf interfaces.REST, action string) (interfaces.REST, string) {
+	hostREST, ok := restIf.(interfaces.HostREST)
+	if !ok {
+		return restIf, action
+	}
+	host, rest, found := strings.Cut(action, "/")
+	if !found {
+		return restIf, action
+	}
+	if client, ok := hostREST.ForHost(host); ok {
+		return client, rest
+	}
+	return restIf, action
+}
+
+// parseActionFragments returns the owner and repository of the action. Actions living in
+// a subdirectory of their repository, at any depth, e.g. owner/repo/sub/dir, are resolved
+// through the tags and branches of the repository.
 func parseActionFragments(action string) (owner string, repo string, err error) {
-	frags := strings.Split(action, "/")
+	frags := strings.Split(normalizeAction(action), "/")
 
 	// if we have more than 2 fragments, we're probably dealing with
 	// sub-actions, so we take the first two fragments as the owner and repo
-	if len(frags) < 2 {
+	if len(frags) < 2 || slices.Contains(frags, "") {
 		return "", "", fmt.Errorf("%w: '%s' reference is incorrect", ErrInvalidAction, action)
 	}
 
 	return frags[0], frags[1], nil
 }
 
-// isChecksum returns true if the input is a checksum.
-func isChecksum(ref string) bool {
+// IsChecksum returns true if the input is a checksum.
+func IsChecksum(ref string) bool {
 	return len(ref) == 40
 }
 
-func getCheckSumForTag(ctx context.Context, restIf interfaces.REST, owner, repo, tag string) (string, error) {
+// getCheckSumForTag returns the checksum of the commit the tag points at. Annotated tags,
+// including tags of tags, are dereferenced to their commit, unless resolveTagObject is set
+// in which case the checksum of the tag object itself is returned. Lightweight tags point
+// at the commit directly either way.
+func getCheckSumForTag(
+	ctx context.Context,
+	restIf interfaces.REST,
+	owner, repo, tag string,
+	resolveTagObject bool,
+) (string, error) {
 	path, err := url.JoinPath("repos", owner, repo, "git", "refs", "tags", tag)
 	if err != nil {
 		return "", fmt.Errorf("failed to join path: %w", err)
@@ -318,19 +799,34 @@ func getCheckSumForTag(ctx context.Context, restIf interfaces.REST, owner, repo,
 	if err != nil {
 		return "", err
 	}
-
-	if otype == "commit" {
+	if otype != "tag" || resolveTagObject {
 		return sha, nil
 	}
-
-	// assume otype == "tag"
-	path, err = url.JoinPath("repos", owner, repo, "git", "tags", sha)
+	sha, err = peelTag(ctx, restIf, owner, repo, sha)
 	if err != nil {
-		return "", fmt.Errorf("failed to join path: %w", err)
+		return "", fmt.Errorf("failed to dereference tag %s: %w", tag, err)
 	}
+	return sha, nil
+}
 
-	sha, _, err = doGetReference(ctx, restIf, path)
-	return sha, err
+// peelTag returns the checksum of the commit the annotated tag object with the given
+// checksum points at, dereferencing the tags pointing at other tags
+func peelTag(ctx context.Context, restIf interfaces.REST, owner, repo, sha string) (string, error) {
+	otype := "tag"
+	for depth := 0; otype == "tag"; depth++ {
+		if depth == maxTagDepth {
+			return "", fmt.Errorf("tag is nested more than %d times", maxTagDepth)
+		}
+		path, err := url.JoinPath("repos", owner, repo, "git", "tags", sha)
+		if err != nil {
+			return "", fmt.Errorf("failed to join path: %w", err)
+		}
+		sha, otype, err = doGetReference(ctx, restIf, path)
+		if err != nil {
+			return "", err
+		}
+	}
+	return sha, nil
 }
 
 func getCheckSumForBranch(ctx context.Context, restIf interfaces.REST, owner, repo, branch string) (string, error) {
@@ -343,6 +839,16 @@ func getCheckSumForBranch(ctx context.Context, restIf interfaces.REST, owner, re
 	return sha, err
 }
 
+// skipBranch returns true if the branch shouldn't be resolved, i.e. it isn't one of the
+// included branches if any are set, or it's one of the excluded ones otherwise. The included
+// branches take precedence so that, e.g., main can be allowed despite the default excludes.
+func skipBranch(filter config.Filter, branch string) bool {
+	if len(filter.IncludeBranches) > 0 {
+		return !slices.Contains(filter.IncludeBranches, "*") && !slices.Contains(filter.IncludeBranches, branch)
+	}
+	return excludeBranch(filter.ExcludeBranches, branch)
+}
+
 func excludeBranch(excludes []string, branch string) bool {
 	if len(excludes) == 0 {
 		return false
@@ -368,7 +874,8 @@ func doGetReference(ctx context.Context, restIf interfaces.REST, path string) (s
 		}()
 	}
 
-	if err != nil && resp.StatusCode != http.StatusNotFound {
+	// The client gives up without any response on network errors, e.g. once out of retries
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
 		return "", "", fmt.Errorf("failed to do API request: %w", err)
 	} else if resp.StatusCode == http.StatusNotFound {
 		// No error, but no tag found
@@ -2,11 +2,18 @@ package actions
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/utils/config"
 	"github.com/stacklok/frizbee/pkg/utils/ghrest"
 	"github.com/stacklok/frizbee/pkg/utils/store"
@@ -71,6 +78,10 @@ func TestReplaceLocalPath(t *testing.T) {
 			name:        "Replace local path",
 			matchedLine: "./local/path",
 		},
+		{
+			name:        "Replace local docker path",
+			matchedLine: "docker://./local/path",
+		},
 	}
 
 	for _, tt := range tests {
@@ -81,6 +92,7 @@ func TestReplaceLocalPath(t *testing.T) {
 			_, err := parser.Replace(ctx, tt.matchedLine, restIf, cfg)
 			require.Error(t, err, "Should return error for local path")
 			require.Contains(t, err.Error(), "reference skipped", "Error should indicate reference skipped")
+			require.Equal(t, interfaces.SkipLocalPath, interfaces.GetSkipReason(err))
 		})
 	}
 }
@@ -115,6 +127,39 @@ func TestReplaceExcludedPath(t *testing.T) {
 	}
 }
 
+func TestReplaceSkipDocker(t *testing.T) {
+	t.Parallel()
+
+	parser := New()
+	ctx := context.Background()
+	cfg := config.Config{GHActions: config.GHActions{SkipDocker: true}}
+	restIf := &ghrest.Client{}
+
+	tests := []struct {
+		name        string
+		matchedLine string
+	}{
+		{
+			name:        "Skip docker step",
+			matchedLine: "docker://registry.example.com/dev/builder:latest",
+		},
+		{
+			name:        "Skip docker step with uses prefix",
+			matchedLine: "uses: docker://registry.example.com/dev/builder:latest",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := parser.Replace(ctx, tt.matchedLine, restIf, cfg)
+			require.ErrorIs(t, err, interfaces.ErrReferenceSkipped, "Docker steps should be skipped")
+		})
+	}
+}
+
 func TestConvertToEntityRef(t *testing.T) {
 	t.Parallel()
 
@@ -126,6 +171,8 @@ func TestConvertToEntityRef(t *testing.T) {
 		wantErr   bool
 	}{
 		{"Valid action reference", "uses: actions/checkout@v2", false},
+		{"Double-quoted action reference", `uses: "actions/checkout@v2"`, false},
+		{"Single-quoted action reference", "uses: 'actions/checkout@v2'", false},
 		{"Valid docker reference", "docker://mydocker/image:tag", false},
 		{"Invalid reference format", "invalid-reference", true},
 	}
@@ -141,6 +188,8 @@ func TestConvertToEntityRef(t *testing.T) {
 			} else {
 				require.NoError(t, err, "Expected no error but got %v", err)
 				require.NotNil(t, ref, "EntityRef should not be nil")
+				require.NotContains(t, ref.Name+ref.Ref, `"`, "Quotes should be trimmed")
+				require.NotContains(t, ref.Name+ref.Ref, "'", "Quotes should be trimmed")
 			}
 		})
 	}
@@ -172,7 +221,12 @@ func TestIsLocal(t *testing.T) {
 func TestShouldExclude(t *testing.T) {
 	t.Parallel()
 
-	cfg := &config.GHActions{Filter: config.Filter{Exclude: []string{"actions/checkout", "actions/setup"}}}
+	cfg := &config.GHActions{Filter: config.Filter{Exclude: []string{
+		"actions/checkout",
+		"actions/setup",
+		"my-internal-org/*",
+		"*/trivy-action",
+	}}}
 
 	tests := []struct {
 		name  string
@@ -181,6 +235,10 @@ func TestShouldExclude(t *testing.T) {
 	}{
 		{"Excluded path", "actions/checkout", true},
 		{"Non-excluded path", "actions/unknown", false},
+		{"Excluded by org pattern", "my-internal-org/deploy", true},
+		{"Org pattern doesn't match nested paths", "my-internal-org/deploy/subdir", false},
+		{"Excluded by name pattern", "aquasecurity/trivy-action", true},
+		{"Name pattern doesn't match other actions", "aquasecurity/tfsec-action", false},
 	}
 
 	for _, tt := range tests {
@@ -193,6 +251,379 @@ func TestShouldExclude(t *testing.T) {
 	}
 }
 
+func TestShouldExcludeAllFirstParty(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.GHActions{Filter: config.Filter{Exclude: []string{"actions/*"}}}
+
+	require.True(t, shouldExclude(cfg, "actions/checkout"))
+	require.True(t, shouldExclude(cfg, "actions/setup-go"))
+	require.False(t, shouldExclude(cfg, "github/codeql-action"))
+}
+
+func TestIncludeThenExclude(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		filter   config.Filter
+		action   string
+		included bool
+	}{
+		{"No include list", config.Filter{}, "actions/checkout", true},
+		{"Included", config.Filter{Include: []string{"actions/*"}}, "actions/checkout", true},
+		{"Not included", config.Filter{Include: []string{"actions/*"}}, "github/codeql-action", false},
+		{
+			"Included but excluded",
+			config.Filter{Include: []string{"actions/*"}, Exclude: []string{"actions/cache"}},
+			"actions/cache",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &config.GHActions{Filter: tt.filter}
+			require.Equal(t, tt.included, isIncluded(cfg, tt.action) && !shouldExclude(cfg, tt.action))
+		})
+	}
+}
+
+func TestReplaceNotIncluded(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{GHActions: config.GHActions{Filter: config.Filter{Include: []string{"actions/*"}}}}
+	_, err := New().Replace(context.Background(), "uses: github/codeql-action/init@v3", nil, cfg)
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+}
+
+func TestGetChecksumEnterprise(t *testing.T) {
+	t.Parallel()
+
+	const sha = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/example-corp/deploy-action/git/refs/tags/v1.2.0" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ref": "refs/tags/v1.2.0", "object": {"sha": "` + sha + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	got, err := GetChecksum(context.Background(), config.GHActions{}, client, "example-corp/deploy-action", "v1.2.0")
+	require.NoError(t, err)
+	require.Equal(t, sha, got)
+}
+
+// unreachableREST fails every request without a response, like the client on network errors
+type unreachableREST struct{}
+
+func (unreachableREST) NewRequest(method, url string, _ any) (*http.Request, error) {
+	return http.NewRequest(method, "https://api.github.com/"+url, nil)
+}
+
+func (unreachableREST) Do(context.Context, *http.Request) (*http.Response, error) {
+	return nil, errors.New("dial tcp: lookup api.github.com: no such host")
+}
+
+func TestGetChecksumNetworkError(t *testing.T) {
+	t.Parallel()
+
+	_, err := GetChecksum(context.Background(), config.GHActions{}, unreachableREST{}, "actions/checkout", "v4")
+	require.ErrorContains(t, err, "no such host")
+}
+
+func TestGetChecksumBranches(t *testing.T) {
+	t.Parallel()
+
+	const sha = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/my-org/deploy-action/git/refs/heads/main",
+			"/api/v3/repos/my-org/deploy-action/git/refs/heads/dev":
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + sha + `", "type": "commit"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		filter  config.Filter
+		branch  string
+		skipped bool
+	}{
+		{"No filter", config.Filter{}, "dev", false},
+		{"Excluded", config.Filter{ExcludeBranches: []string{"main", "master"}}, "main", true},
+		{"All excluded", config.Filter{ExcludeBranches: []string{"*"}}, "dev", true},
+		{"Included", config.Filter{IncludeBranches: []string{"main"}}, "main", false},
+		{"Not included", config.Filter{IncludeBranches: []string{"main"}}, "dev", true},
+		{"Included despite excluded", config.Filter{
+			IncludeBranches: []string{"main"},
+			ExcludeBranches: []string{"main", "master"},
+		}, "main", false},
+		{"All included", config.Filter{IncludeBranches: []string{"*"}, ExcludeBranches: []string{"*"}}, "dev", false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := GetChecksum(context.Background(), config.GHActions{Filter: tt.filter}, client, "my-org/deploy-action", tt.branch)
+			if tt.skipped {
+				require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, sha, got)
+		})
+	}
+}
+
+func TestGetChecksumCachedMissing(t *testing.T) {
+	t.Parallel()
+
+	const sha = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+	var requests atomic.Int32
+	var created atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !created.Load() || r.URL.Path != "/api/v3/repos/example-corp/deploy-action/git/refs/tags/v9.9.9" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ref": "refs/tags/v9.9.9", "object": {"sha": "` + sha + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	cache := store.NewRefCacher()
+	const key = "example-corp/deploy-action@v9.9.9"
+
+	// The tag and the branch are both looked up once
+	_, err = GetChecksumCached(ctx, config.GHActions{}, client, cache, key, "example-corp/deploy-action", "v9.9.9")
+	require.ErrorIs(t, err, ErrInvalidActionReference)
+	lookups := requests.Load()
+	require.Positive(t, lookups)
+
+	_, err = GetChecksumCached(ctx, config.GHActions{}, client, cache, key, "example-corp/deploy-action", "v9.9.9")
+	require.ErrorIs(t, err, ErrInvalidActionReference)
+	require.Equal(t, lookups, requests.Load(), "the missing reference must not be looked up again")
+
+	// A fresh cache, e.g. once the negative entry expired, resolves the created tag
+	created.Store(true)
+	got, err := GetChecksumCached(ctx, config.GHActions{}, client, store.NewRefCacher(), key, "example-corp/deploy-action", "v9.9.9")
+	require.NoError(t, err)
+	require.Equal(t, sha, got)
+}
+
+func TestGetChecksumTags(t *testing.T) {
+	t.Parallel()
+
+	const (
+		commitSHA = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+		tagSHA    = "1d96c772d19495a3b5c517cd2bc0cb401ea0529f"
+		outerSHA  = "2b6a709cf9c4025c5438138008beaddbb02086f0"
+	)
+	responses := map[string]string{
+		"/api/v3/repos/example-corp/deploy-action/git/refs/tags/lightweight": `{"object": {"sha": "` + commitSHA + `", "type": "commit"}}`,
+		"/api/v3/repos/example-corp/deploy-action/git/refs/tags/annotated":   `{"object": {"sha": "` + tagSHA + `", "type": "tag"}}`,
+		"/api/v3/repos/example-corp/deploy-action/git/refs/tags/nested":      `{"object": {"sha": "` + outerSHA + `", "type": "tag"}}`,
+		"/api/v3/repos/example-corp/deploy-action/git/tags/" + outerSHA:      `{"sha": "` + outerSHA + `", "object": {"sha": "` + tagSHA + `", "type": "tag"}}`,
+		"/api/v3/repos/example-corp/deploy-action/git/tags/" + tagSHA:        `{"sha": "` + tagSHA + `", "object": {"sha": "` + commitSHA + `", "type": "commit"}}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name             string
+		tag              string
+		resolveTagObject bool
+		want             string
+	}{
+		{name: "lightweight tag", tag: "lightweight", want: commitSHA},
+		{name: "lightweight tag resolving tag objects", tag: "lightweight", resolveTagObject: true, want: commitSHA},
+		{name: "annotated tag", tag: "annotated", want: commitSHA},
+		{name: "annotated tag resolving tag objects", tag: "annotated", resolveTagObject: true, want: tagSHA},
+		{name: "tag of a tag", tag: "nested", want: commitSHA},
+		{name: "tag of a tag resolving tag objects", tag: "nested", resolveTagObject: true, want: outerSHA},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.GHActions{ResolveTagObject: tt.resolveTagObject}
+			got, err := GetChecksum(context.Background(), cfg, client, "example-corp/deploy-action", tt.tag)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReplaceVerifyCommits(t *testing.T) {
+	t.Parallel()
+
+	const (
+		existingSHA = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+		staleSHA    = "1d96c772d19495a3b5c517cd2bc0cb401ea0529f"
+	)
+	responses := map[string]string{
+		"/api/v3/repos/example-corp/deploy-action/git/refs/tags/v1":       `{"object": {"sha": "` + existingSHA + `", "type": "commit"}}`,
+		"/api/v3/repos/example-corp/deploy-action/git/refs/tags/v2":       `{"object": {"sha": "` + staleSHA + `", "type": "commit"}}`,
+		"/api/v3/repos/example-corp/deploy-action/commits/" + existingSHA: `{"sha": "` + existingSHA + `"}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		matchedLine string
+		verify      bool
+		want        string
+		wantErr     error
+	}{
+		{name: "existing commit", matchedLine: "uses: example-corp/deploy-action@v1", verify: true, want: existingSHA},
+		{name: "missing commit", matchedLine: "uses: example-corp/deploy-action@v2", verify: true, wantErr: ErrCommitNotFound},
+		{name: "missing commit without verification", matchedLine: "uses: example-corp/deploy-action@v2", want: staleSHA},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			parser := New()
+			parser.SetVerifyCommits(tt.verify)
+			got, err := parser.Replace(context.Background(), tt.matchedLine, client, config.Config{})
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got.Ref)
+		})
+	}
+}
+
+func TestReplaceEnvExpansion(t *testing.T) {
+	t.Parallel()
+
+	const sha = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/example-corp/deploy-action/git/refs/tags/v1" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + sha + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	parser := New()
+	parser.SetEnvLookup(func(key string) (string, bool) {
+		if key == "ORG" {
+			return "example-corp", true
+		}
+		return "", false
+	})
+
+	got, err := parser.Replace(context.Background(), "uses: ${ORG}/deploy-action@v1", client, config.Config{})
+	require.NoError(t, err)
+	require.Equal(t, "example-corp/deploy-action", got.Name)
+	require.Equal(t, sha, got.Ref)
+
+	// The references holding unset variables are skipped rather than resolved
+	_, err = parser.Replace(context.Background(), "uses: docker://$REGISTRY/builder:1.0", client, config.Config{})
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+	require.Equal(t, interfaces.SkipVariable, interfaces.GetSkipReason(err))
+}
+
+func TestReplaceConcurrentLookups(t *testing.T) {
+	t.Parallel()
+
+	// Count the tag lookups served by the API, holding them until released
+	const sha = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/example-corp/deploy-action/git/refs/tags/v1" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches.Add(1)
+		<-release
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + sha + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	const lookups = 20
+	parser := New()
+	var wg sync.WaitGroup
+	results := make([]*interfaces.EntityRef, lookups)
+	errs := make([]error, lookups)
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = parser.Replace(context.Background(), "uses: example-corp/deploy-action@v1", client, config.Config{})
+		}(i)
+	}
+
+	// Give the other lookups time to pile up behind the first one
+	require.Eventually(t, func() bool { return fetches.Load() > 0 }, 5*time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := 0; i < lookups; i++ {
+		require.NoError(t, errs[i])
+		require.Equal(t, sha, results[i].Ref)
+		require.Equal(t, "uses: ", results[i].Prefix)
+	}
+	require.Equal(t, int32(1), fetches.Load(), "concurrent lookups of the same action should share a single fetch")
+}
+
 func TestParseActionReference(t *testing.T) {
 	t.Parallel()
 
@@ -204,6 +635,12 @@ func TestParseActionReference(t *testing.T) {
 		wantErr    bool
 	}{
 		{"Valid action reference", "actions/checkout@v2", "actions/checkout", "v2", false},
+		{"Repository URL", "https://github.com/actions/checkout@v4", "actions/checkout", "v4", false},
+		{"Repository URL with .git", "https://github.com/actions/checkout.git@v4", "actions/checkout", "v4", false},
+		{"Git repository URL", "git::https://github.com/actions/checkout.git@v4", "actions/checkout", "v4", false},
+		{"Repository URL with subpath", "https://github.com/anchore/sbom-action.git/download-syft@v0",
+			"anchore/sbom-action/download-syft", "v0", false},
+		{"Repository URL without reference", "https://github.com/actions/checkout", "", "", true},
 		{"Invalid reference format", "invalid-reference", "", "", true},
 	}
 
@@ -224,6 +661,228 @@ func TestParseActionReference(t *testing.T) {
 	}
 }
 
+func TestParseActionFragments(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		action    string
+		wantOwner string
+		wantRepo  string
+		wantErr   bool
+	}{
+		{"Repository", "actions/checkout", "actions", "checkout", false},
+		{"Subpath", "anchore/sbom-action/download-syft", "anchore", "sbom-action", false},
+		{"Nested subpath", "owner/repo/sub/dir", "owner", "repo", false},
+		{"Deeply nested subpath", "owner/repo/a/b/c/d", "owner", "repo", false},
+		{"Reusable workflow", "owner/repo/.github/workflows/ci.yml", "owner", "repo", false},
+		{"Repository URL", "https://github.com/actions/checkout", "actions", "checkout", false},
+		{"Repository URL with .git", "https://github.com/actions/checkout.git", "actions", "checkout", false},
+		{"Git repository URL with subpath", "git::https://github.com/owner/repo.git/sub", "owner", "repo", false},
+		{"Repository URL without repository", "https://github.com/owner", "", "", true},
+		{"No repository", "owner", "", "", true},
+		{"Empty repository", "owner//sub", "", "", true},
+		{"Trailing slash", "owner/repo/", "", "", true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			owner, repo, err := parseActionFragments(tt.action)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidAction)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantOwner, owner)
+			require.Equal(t, tt.wantRepo, repo)
+		})
+	}
+}
+
+func TestReplaceSubpathActions(t *testing.T) {
+	t.Parallel()
+
+	const (
+		majorSHA   = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+		majorTag   = "5b2ba2ee4c5d3ad2b5fdab1b6dbd5cbe7ae39f66"
+		minorSHA   = "1d96c772d19495a3b5c517cd2bc0cb401ea0529f"
+		semverSHA  = "8e5e7e5ab8b370d6c329ec480221332ada57f0ab"
+		branchSHA  = "a81bbbf8298c0fa03ea29cdc473d45769f953675"
+		apiRefTags = "/api/v3/repos/owner/repo/git/refs/tags/"
+	)
+	responses := map[string]string{
+		// The major version moves along the releases as an annotated tag
+		apiRefTags + "v1": `{"object": {"sha": "` + majorTag + `", "type": "tag"}}`,
+		"/api/v3/repos/owner/repo/git/tags/" + majorTag: `{"object": {"sha": "` + majorSHA + `", "type": "commit"}}`,
+		apiRefTags + "v1.2":                             `{"object": {"sha": "` + minorSHA + `", "type": "commit"}}`,
+		apiRefTags + "1.2.3":                            `{"object": {"sha": "` + semverSHA + `", "type": "commit"}}`,
+		"/api/v3/repos/owner/repo/git/refs/heads/main":  `{"object": {"sha": "` + branchSHA + `", "type": "commit"}}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		matchedLine string
+		wantName    string
+		wantRef     string
+		wantTag     string
+	}{
+		{"moving major tag", "uses: owner/repo/sub/dir@v1", "owner/repo/sub/dir", majorSHA, "v1"},
+		{"minor tag", "uses: owner/repo/sub/dir@v1.2", "owner/repo/sub/dir", minorSHA, "v1.2"},
+		{"semver tag without v", "uses: owner/repo/sub/dir@1.2.3", "owner/repo/sub/dir", semverSHA, "1.2.3"},
+		{"single subpath", "uses: owner/repo/setup@v1", "owner/repo/setup", majorSHA, "v1"},
+		{"deep subpath", "uses: owner/repo/a/b/c/setup@1.2.3", "owner/repo/a/b/c/setup", semverSHA, "1.2.3"},
+		{"branch", "uses: owner/repo/sub/dir@main", "owner/repo/sub/dir", branchSHA, "main"},
+		{"repository", "uses: owner/repo@v1.2", "owner/repo", minorSHA, "v1.2"},
+		{"reusable workflow", "uses: owner/repo/.github/workflows/ci.yml@v1", "owner/repo/.github/workflows/ci.yml", majorSHA, "v1"},
+		{"reusable workflow on a branch", "uses: owner/repo/.github/workflows/ci.yaml@main",
+			"owner/repo/.github/workflows/ci.yaml", branchSHA, "main"},
+		{"repository URL", "uses: https://github.com/owner/repo@v1.2", "owner/repo", minorSHA, "v1.2"},
+		{"repository URL with .git", "uses: https://github.com/owner/repo.git/sub/dir@v1", "owner/repo/sub/dir", majorSHA, "v1"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := New().Replace(context.Background(), tt.matchedLine, client, config.Config{})
+			require.NoError(t, err)
+			require.Equal(t, tt.wantName, got.Name, "The full action name is kept")
+			require.Equal(t, tt.wantRef, got.Ref)
+			require.Equal(t, tt.wantTag, got.Tag)
+			require.Equal(t, "uses: ", got.Prefix)
+		})
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	t.Parallel()
+
+	const (
+		releaseSHA = "11bd71901bbe5b1630ceea73d27597364c9af683"
+		olderSHA   = "b4ffde65f46336ab88eb53be808477a3936bae11"
+		// The annotated tag objects of v5 and v5.0.1, pointing at releaseSHA
+		majorTagSHA   = "2b2cd6a6ec5f4fd2d9cf0e8a5f1bd1e8e1fa7cd1"
+		releaseTagSHA = "3c3de7b7fd6a5ae3eadf1f9b6a2ce2f9f2ab8de2"
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/owner/repo/git/matching-refs/tags/v4", "/api/v3/repos/owner/repo/git/matching-refs/tags/v4.1":
+			_, _ = w.Write([]byte(`[
+				{"ref": "refs/tags/v4", "object": {"sha": "` + releaseSHA + `", "type": "commit"}},
+				{"ref": "refs/tags/v4.1", "object": {"sha": "` + releaseSHA + `", "type": "commit"}},
+				{"ref": "refs/tags/v4.1.0", "object": {"sha": "` + olderSHA + `", "type": "commit"}},
+				{"ref": "refs/tags/v4.1.1", "object": {"sha": "` + releaseSHA + `", "type": "commit"}},
+				{"ref": "refs/tags/v4.2.0-rc1", "object": {"sha": "c85c95e3d7251135ab7dc9ce3241c5835cc595a9", "type": "commit"}},
+				{"ref": "refs/tags/v40.0.0", "object": {"sha": "` + releaseSHA + `", "type": "commit"}}
+			]`))
+		case "/api/v3/repos/owner/repo/git/matching-refs/tags/v5":
+			_, _ = w.Write([]byte(`[
+				{"ref": "refs/tags/v5", "object": {"sha": "` + majorTagSHA + `", "type": "tag"}},
+				{"ref": "refs/tags/v5.0.1", "object": {"sha": "` + releaseTagSHA + `", "type": "tag"}}
+			]`))
+		case "/api/v3/repos/owner/repo/git/tags/" + majorTagSHA, "/api/v3/repos/owner/repo/git/tags/" + releaseTagSHA:
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + releaseSHA + `", "type": "commit"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		action  string
+		ref     string
+		sum     string
+		want    string
+		wantErr bool
+	}{
+		{"major tag", "owner/repo", "v4", releaseSHA, "v4.1.1", false},
+		{"minor tag", "owner/repo", "v4.1", releaseSHA, "v4.1.1", false},
+		{"subpath action", "owner/repo/sub/dir", "v4", releaseSHA, "v4.1.1", false},
+		{"annotated tags", "owner/repo", "v5", releaseSHA, "v5.0.1", false},
+		{"pinned to the tag object", "owner/repo", "v5", majorTagSHA, "v5.0.1", false},
+		{"no matching release", "owner/repo", "v4", "0000000000000000000000000000000000000000", "", false},
+		{"concrete tag", "owner/repo", "v4.1.0", olderSHA, "", false},
+		{"branch", "owner/repo", "main", releaseSHA, "", false},
+		{"unknown repository", "owner/missing", "v4", releaseSHA, "", true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := GetVersion(context.Background(), client, tt.action, tt.ref, tt.sum)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReplaceResolveVersion(t *testing.T) {
+	t.Parallel()
+
+	const sum = "11bd71901bbe5b1630ceea73d27597364c9af683"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/actions/checkout/git/refs/tags/v4", "/api/v3/repos/actions/cache/git/refs/tags/v4":
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + sum + `", "type": "commit"}}`))
+		case "/api/v3/repos/actions/checkout/git/matching-refs/tags/v4":
+			_, _ = w.Write([]byte(`[{"ref": "refs/tags/v4.1.1", "object": {"sha": "` + sum + `", "type": "commit"}}]`))
+		case "/api/v3/repos/actions/cache/git/matching-refs/tags/v4":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	cfg := config.Config{GHActions: config.GHActions{ResolveVersion: true}}
+	got, err := New().Replace(context.Background(), "uses: actions/checkout@v4", client, cfg)
+	require.NoError(t, err)
+	require.Equal(t, sum, got.Ref)
+	require.Equal(t, "v4", got.Tag, "the moving tag is kept to unpin it")
+	require.Equal(t, "v4.1.1", got.Version)
+
+	// A failed lookup doesn't prevent pinning
+	got, err = New().Replace(context.Background(), "uses: actions/cache@v4", client, cfg)
+	require.NoError(t, err)
+	require.Equal(t, sum, got.Ref)
+	require.Equal(t, "v4", got.Tag)
+	require.Empty(t, got.Version)
+
+	got, err = New().Replace(context.Background(), "uses: actions/checkout@v4", client, config.Config{})
+	require.NoError(t, err)
+	require.Equal(t, "v4", got.Tag)
+	require.Empty(t, got.Version, "the release isn't looked up by default")
+}
+
 func TestGetChecksum(t *testing.T) {
 	t.Parallel()
 
@@ -321,3 +980,55 @@ func TestGetChecksum(t *testing.T) {
 		})
 	}
 }
+
+func TestUnpin(t *testing.T) {
+	t.Parallel()
+
+	parser := New()
+
+	tests := []struct {
+		name        string
+		matchedLine string
+		tag         string
+		wantPrefix  string
+		wantName    string
+		wantErr     bool
+	}{
+		{"Pinned action", "uses: actions/checkout@ee0669bd1cc54295c223e0bb666b733df41de1c5", "v2", "uses: ", "actions/checkout", false},
+		{"Pinned action without a tag", "uses: actions/checkout@ee0669bd1cc54295c223e0bb666b733df41de1c5", "", "", "", true},
+		{"Action referenced by tag", "uses: actions/checkout@v2", "v2", "", "", true},
+		{
+			"Quoted pinned action",
+			`uses: "actions/checkout@ee0669bd1cc54295c223e0bb666b733df41de1c5"`,
+			"v2",
+			"uses: ",
+			"actions/checkout",
+			false,
+		},
+		{
+			"Pinned docker action",
+			"uses: docker://index.docker.io/avtodev/markdown-lint@sha256:6aeedc2f49138ce7a1cd0adffc1b1c0321b841dc2102408967d9301c031949ee",
+			"v1",
+			"uses: docker://",
+			"index.docker.io/avtodev/markdown-lint",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ref, err := parser.Unpin(tt.matchedLine, tt.tag)
+			if tt.wantErr {
+				require.Error(t, err, "Expected error but got none")
+				return
+			}
+			require.NoError(t, err, "Expected no error but got %v", err)
+			require.Equal(t, tt.wantPrefix, ref.Prefix, "Prefix should be preserved")
+			require.Equal(t, tt.wantName, ref.Name, "Name should be parsed correctly")
+			require.Equal(t, tt.tag, ref.Tag, "Tag should be set correctly")
+		})
+	}
+}
@@ -0,0 +1,298 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package circleci provides utilities to work with CircleCI orbs.
+package circleci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+	"github.com/stacklok/frizbee/pkg/utils/store"
+)
+
+const (
+	// OrbRegex is the regular expression pattern to match CircleCI orb references, i.e.
+	// the entries of the orbs key such as aws-cli: circleci/aws-cli@3.1. Development
+	// versions, i.e. dev:<label>, are mutable by design and not matched.
+	OrbRegex = `[\w-]+:\s*[a-z0-9][a-z0-9_-]*/[a-z0-9][a-z0-9_-]*@(?:volatile|\d+(?:\.\d+){0,2})\b`
+	// ReferenceType is the type of the reference
+	ReferenceType = "orb"
+	// DefaultHost is the CircleCI host serving the orb registry
+	DefaultHost = "https://circleci.com"
+
+	// graphQLPath is the path of the GraphQL API the CircleCI CLI talks to
+	graphQLPath = "/graphql-unstable"
+	// orbVersionQuery resolves an orb reference to the published version it stands for
+	orbVersionQuery = `query($orbVersionRef: String!) {
+	orbVersion(orbVersionRef: $orbVersionRef) {
+		version
+	}
+}`
+)
+
+var (
+	// ErrOrbVersionNotFound is returned when no published version matches the orb reference
+	ErrOrbVersionNotFound = errors.New("orb version not found")
+
+	// pinnedVersion matches the full semantic versions orbs are published with, which are immutable
+	pinnedVersion = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+)
+
+// defaultRegex is OrbRegex compiled once for all the parsers
+var defaultRegex = regexp.MustCompile(OrbRegex)
+
+// Parser is a struct to replace orb references with the immutable version they resolve to
+type Parser struct {
+	regex string
+	// compiled is the compiled regex, or nil along with regexErr if it's invalid
+	compiled *regexp.Regexp
+	regexErr error
+	cache    store.RefCacher
+	client   *http.Client
+	host     string
+	token    string
+	retry    retry.Policy
+	// logger logs the cache lookups at debug level, if set
+	logger *slog.Logger
+}
+
+// New creates a new Parser
+func New() *Parser {
+	return &Parser{
+		regex:    OrbRegex,
+		compiled: defaultRegex,
+		cache:    store.NewRefCacher(),
+		client:   &http.Client{},
+		host:     DefaultHost,
+	}
+}
+
+// SetCache sets the cache to store the resolved orb versions
+func (p *Parser) SetCache(cache store.RefCacher) {
+	p.cache = cache
+}
+
+// SetLogger sets the logger the cache hits and misses are logged to at debug level
+func (p *Parser) SetLogger(logger *slog.Logger) {
+	p.logger = logger
+}
+
+// SetRegex sets the regular expression pattern to match orb references
+func (p *Parser) SetRegex(regex string) {
+	p.regex = regex
+	p.compiled, p.regexErr = regexp.Compile(regex)
+}
+
+// GetRegex returns the regular expression pattern to match orb references
+func (p *Parser) GetRegex() string {
+	return p.regex
+}
+
+// CompiledRegex returns the regular expression pattern to match orb references, compiled
+// once when set rather than for every file, or the error compiling it if it's invalid
+func (p *Parser) CompiledRegex() (*regexp.Regexp, error) {
+	return p.compiled, p.regexErr
+}
+
+// SetHost sets the CircleCI host, e.g. of a CircleCI server installation
+func (p *Parser) SetHost(host string) {
+	p.host = strings.TrimSuffix(host, "/")
+}
+
+// SetToken sets the personal API token used to resolve private orbs
+func (p *Parser) SetToken(token string) {
+	p.token = token
+}
+
+// SetRetryPolicy sets the policy to retry orb resolutions failing with transient errors
+func (p *Parser) SetRetryPolicy(policy retry.Policy) {
+	p.retry = policy
+}
+
+// Replace replaces the orb reference with the full version it currently resolves to
+func (p *Parser) Replace(
+	ctx context.Context,
+	matchedLine string,
+	_ interfaces.REST,
+	cfg config.Config,
+) (*interfaces.EntityRef, error) {
+	orbRef, err := p.ConvertToEntityRef(matchedLine)
+	if err != nil {
+		return nil, err
+	}
+	ref := orbRef.Name + "@" + orbRef.Ref
+
+	// Skip the excluded orbs and the ones already referenced by an immutable version
+	if !isIncluded(&cfg.CircleCI, orbRef.Name) || config.MatchAny(cfg.CircleCI.Exclude, orbRef.Name) {
+		return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+	}
+	if IsPinnedVersion(orbRef.Ref) {
+		return nil, fmt.Errorf("orb already referenced by version: %s %w", matchedLine, interfaces.ErrReferenceSkipped)
+	}
+
+	store.LogLookup(ctx, p.logger, p.cache, ref, ref)
+	version, ok := "", false
+	if p.cache != nil {
+		version, ok = p.cache.Load(ref)
+	}
+	if !ok {
+		err := p.retry.Do(ctx, func() (err error) {
+			version, err = p.resolve(ctx, ref)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve orb '%s': %w", ref, err)
+		}
+		if p.cache != nil {
+			p.cache.Store(ref, version)
+		}
+	}
+
+	return &interfaces.EntityRef{
+		Name:   orbRef.Name,
+		Ref:    version,
+		Type:   ReferenceType,
+		Tag:    orbRef.Ref,
+		Prefix: orbRef.Prefix,
+	}, nil
+}
+
+// Unpin reverts an orb reference pinned by its full version back to the given tag
+func (p *Parser) Unpin(matchedLine, tag string) (*interfaces.EntityRef, error) {
+	orbRef, err := p.ConvertToEntityRef(matchedLine)
+	if err != nil {
+		return nil, err
+	}
+	// Only references pinned by a full version with a known tag can be unpinned
+	if !IsPinnedVersion(orbRef.Ref) || tag == "" {
+		return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+	}
+	orbRef.Tag = tag
+	return orbRef, nil
+}
+
+// ConvertToEntityRef converts an orb reference, optionally preceded by its key, to an EntityRef
+func (*Parser) ConvertToEntityRef(reference string) (*interfaces.EntityRef, error) {
+	var prefix string
+	if key, ref, ok := strings.Cut(reference, ":"); ok && !strings.Contains(key, "/") {
+		ref = strings.TrimLeft(ref, " \t")
+		prefix = reference[:len(reference)-len(ref)]
+		reference = ref
+	}
+
+	name, version, ok := strings.Cut(reference, "@")
+	if !ok || name == "" || version == "" || strings.Count(name, "/") != 1 {
+		return nil, fmt.Errorf("invalid orb reference: %s %w", reference, interfaces.ErrInvalidReference)
+	}
+
+	return &interfaces.EntityRef{
+		Name:   name,
+		Ref:    version,
+		Type:   ReferenceType,
+		Prefix: prefix,
+	}, nil
+}
+
+// IsPinnedVersion returns true if the orb version is a full semantic version, which
+// can't be republished, as opposed to a version range such as 3, 3.1 or volatile
+func IsPinnedVersion(version string) bool {
+	return pinnedVersion.MatchString(version)
+}
+
+// isIncluded returns true if the orb matches the include list, or if there's none
+func isIncluded(cfg *config.CircleCI, orb string) bool {
+	return len(cfg.Include) == 0 || config.MatchAny(cfg.Include, orb)
+}
+
+// graphQLRequest is the body of a GraphQL API request
+type graphQLRequest struct {
+	Query     string            `json:"query"`
+	Variables map[string]string `json:"variables"`
+}
+
+// orbVersionResponse is the body of the response to orbVersionQuery
+type orbVersionResponse struct {
+	Data struct {
+		OrbVersion *struct {
+			Version string `json:"version"`
+		} `json:"orbVersion"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// resolve returns the published version the orb reference resolves to, e.g. 3.1.4 for circleci/aws-cli@3
+func (p *Parser) resolve(ctx context.Context, ref string) (string, error) {
+	body, err := json.Marshal(graphQLRequest{
+		Query:     orbVersionQuery,
+		Variables: map[string]string{"orbVersionRef": ref},
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.host+graphQLPath, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if p.token != "" {
+		req.Header.Set("Circle-Token", p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// Network errors are worth retrying, unless the context is done
+		if ctx.Err() != nil {
+			return "", err
+		}
+		return "", retry.Transient(fmt.Errorf("failed to do API request: %w", err), 0)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+			return "", retry.Transient(err, retry.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+		}
+		return "", err
+	}
+
+	var res orbVersionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("cannot decode response: %w", err)
+	}
+	if len(res.Errors) > 0 {
+		return "", fmt.Errorf("%w: %s", ErrOrbVersionNotFound, res.Errors[0].Message)
+	}
+	if res.Data.OrbVersion == nil || !IsPinnedVersion(res.Data.OrbVersion.Version) {
+		return "", ErrOrbVersionNotFound
+	}
+
+	return res.Data.OrbVersion.Version, nil
+}
@@ -0,0 +1,294 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+)
+
+// newTestAPI returns a fake CircleCI GraphQL API resolving the given orb references
+// to their version, any other reference is unknown. The stacklok orbs are private.
+func newTestAPI(t *testing.T, versions map[string]string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method != http.MethodPost || r.URL.Path != graphQLPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Circle-Token") == "bad" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req graphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ref := req.Variables["orbVersionRef"]
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case ref == "circleci/flaky@1" && requests.Load() == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case strings.HasPrefix(ref, "stacklok/") && r.Header.Get("Circle-Token") != "s3cr3t":
+			// Private orbs are only visible when authenticated
+			_, _ = w.Write([]byte(`{"data":{"orbVersion":null}}`))
+		case ref == "circleci/broken@1":
+			_, _ = w.Write([]byte(`{"data":{"orbVersion":null},"errors":[{"message":"no such orb"}]}`))
+		case versions[ref] != "":
+			_, _ = w.Write([]byte(`{"data":{"orbVersion":{"version":"` + versions[ref] + `"}}}`))
+		default:
+			_, _ = w.Write([]byte(`{"data":{"orbVersion":null}}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+func TestReplace(t *testing.T) {
+	t.Parallel()
+
+	versions := map[string]string{
+		"circleci/aws-cli@3":       "3.2.0",
+		"circleci/aws-cli@3.1":     "3.1.5",
+		"circleci/node@volatile":   "5.2.0",
+		"circleci/flaky@1":         "1.0.3",
+		"stacklok/private-orb@0.1": "0.1.2",
+		"circleci/not-semver@1":    "1.0",
+	}
+
+	tests := []struct {
+		name        string
+		ref         string
+		token       string
+		cfg         config.Config
+		want        *interfaces.EntityRef
+		wantErr     error
+		wantAnyErr  bool
+		wantSkipped bool
+	}{
+		{
+			name: "major version",
+			ref:  "aws-cli: circleci/aws-cli@3",
+			want: &interfaces.EntityRef{
+				Name: "circleci/aws-cli", Ref: "3.2.0", Type: ReferenceType, Tag: "3", Prefix: "aws-cli: ",
+			},
+		},
+		{
+			name: "minor version without key",
+			ref:  "circleci/aws-cli@3.1",
+			want: &interfaces.EntityRef{Name: "circleci/aws-cli", Ref: "3.1.5", Type: ReferenceType, Tag: "3.1"},
+		},
+		{
+			name: "volatile",
+			ref:  "node:   circleci/node@volatile",
+			want: &interfaces.EntityRef{
+				Name: "circleci/node", Ref: "5.2.0", Type: ReferenceType, Tag: "volatile", Prefix: "node:   ",
+			},
+		},
+		{
+			name: "transient error retried",
+			ref:  "flaky: circleci/flaky@1",
+			want: &interfaces.EntityRef{
+				Name: "circleci/flaky", Ref: "1.0.3", Type: ReferenceType, Tag: "1", Prefix: "flaky: ",
+			},
+		},
+		{
+			name:  "private orb",
+			ref:   "private: stacklok/private-orb@0.1",
+			token: "s3cr3t",
+			want: &interfaces.EntityRef{
+				Name: "stacklok/private-orb", Ref: "0.1.2", Type: ReferenceType, Tag: "0.1", Prefix: "private: ",
+			},
+		},
+		{
+			name:        "already pinned",
+			ref:         "aws-cli: circleci/aws-cli@3.1.4",
+			wantSkipped: true,
+		},
+		{
+			name:        "excluded",
+			ref:         "aws-cli: circleci/aws-cli@3",
+			cfg:         config.Config{CircleCI: config.CircleCI{Filter: config.Filter{Exclude: []string{"circleci/*"}}}},
+			wantSkipped: true,
+		},
+		{
+			name:        "not included",
+			ref:         "aws-cli: circleci/aws-cli@3",
+			cfg:         config.Config{CircleCI: config.CircleCI{Filter: config.Filter{Include: []string{"stacklok/*"}}}},
+			wantSkipped: true,
+		},
+		{
+			name:    "unknown orb",
+			ref:     "missing: circleci/missing@1",
+			wantErr: ErrOrbVersionNotFound,
+		},
+		{
+			name:    "API error",
+			ref:     "broken: circleci/broken@1",
+			wantErr: ErrOrbVersionNotFound,
+		},
+		{
+			name:    "resolved to a version range",
+			ref:     "not-semver: circleci/not-semver@1",
+			wantErr: ErrOrbVersionNotFound,
+		},
+		{
+			name:       "unauthorized",
+			ref:        "aws-cli: circleci/aws-cli@3",
+			token:      "bad",
+			wantAnyErr: true,
+		},
+		{
+			name:    "invalid reference",
+			ref:     "aws-cli: aws-cli@3",
+			wantErr: interfaces.ErrInvalidReference,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, _ := newTestAPI(t, versions)
+			p := New()
+			p.SetHost(srv.URL + "/")
+			p.SetToken(tt.token)
+			p.SetRetryPolicy(retry.Policy{MaxAttempts: 2})
+
+			got, err := p.Replace(context.Background(), tt.ref, nil, tt.cfg)
+			switch {
+			case tt.wantSkipped:
+				require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+				return
+			case tt.wantErr != nil:
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			case tt.wantAnyErr:
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReplace_Cache(t *testing.T) {
+	t.Parallel()
+
+	srv, requests := newTestAPI(t, map[string]string{"circleci/node@5": "5.2.0"})
+	p := New()
+	p.SetHost(srv.URL)
+
+	for i := 0; i < 3; i++ {
+		got, err := p.Replace(context.Background(), "node: circleci/node@5", nil, config.Config{})
+		require.NoError(t, err)
+		require.Equal(t, "5.2.0", got.Ref)
+	}
+	require.Equal(t, int32(1), requests.Load())
+}
+
+func TestConvertToEntityRef(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    *interfaces.EntityRef
+		wantErr bool
+	}{
+		{
+			name: "with key",
+			ref:  "aws-cli: circleci/aws-cli@3.1.4",
+			want: &interfaces.EntityRef{Name: "circleci/aws-cli", Ref: "3.1.4", Type: ReferenceType, Prefix: "aws-cli: "},
+		},
+		{
+			name: "without key",
+			ref:  "circleci/node@volatile",
+			want: &interfaces.EntityRef{Name: "circleci/node", Ref: "volatile", Type: ReferenceType},
+		},
+		{name: "no version", ref: "node: circleci/node", wantErr: true},
+		{name: "no namespace", ref: "node: node@5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := New().ConvertToEntityRef(tt.ref)
+			if tt.wantErr {
+				require.ErrorIs(t, err, interfaces.ErrInvalidReference)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUnpin(t *testing.T) {
+	t.Parallel()
+
+	got, err := New().Unpin("aws-cli: circleci/aws-cli@3.1.4", "3")
+	require.NoError(t, err)
+	require.Equal(t, &interfaces.EntityRef{
+		Name: "circleci/aws-cli", Ref: "3.1.4", Type: ReferenceType, Tag: "3", Prefix: "aws-cli: ",
+	}, got)
+
+	_, err = New().Unpin("aws-cli: circleci/aws-cli@3.1.4", "")
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+	_, err = New().Unpin("aws-cli: circleci/aws-cli@3", "3")
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+}
+
+func TestOrbRegex(t *testing.T) {
+	t.Parallel()
+
+	re := regexp.MustCompile(OrbRegex)
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{line: "  aws-cli: circleci/aws-cli@3.1.4", want: []string{"aws-cli: circleci/aws-cli@3.1.4"}},
+		{line: "  node: circleci/node@5 # LTS", want: []string{"node: circleci/node@5"}},
+		{line: "  slack: circleci/slack@volatile", want: []string{"slack: circleci/slack@volatile"}},
+		{line: "orbs: {node: circleci/node@5, go: circleci/go@1.7}", want: []string{
+			"node: circleci/node@5", "go: circleci/go@1.7",
+		}},
+		{line: "  dev: circleci/node@dev:alpha"},
+		{line: "  - image: cimg/node:20.1"},
+		{line: "  - uses: actions/checkout@v4"},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, re.FindAllString(tt.line, -1), tt.line)
+	}
+}
+
+func TestIsPinnedVersion(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, IsPinnedVersion("3.1.4"))
+	require.False(t, IsPinnedVersion("3.1"))
+	require.False(t, IsPinnedVersion("3"))
+	require.False(t, IsPinnedVersion("volatile"))
+	require.False(t, IsPinnedVersion("dev:alpha"))
+}
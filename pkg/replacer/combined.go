@@ -0,0 +1,203 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replacer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/stacklok/frizbee/internal/traverse"
+)
+
+// ErrNotCombinable is returned when combining a replacer whose parser traverses files of its
+// own, e.g. Terraform configurations, which can't be processed in the same traversal
+var ErrNotCombinable = errors.New("replacer can't be combined with others")
+
+// fileMatcher is implemented by parsers which only handle some of the files traversed
+// when they're combined with others, e.g. the GitHub Actions one leaves Dockerfiles out
+type fileMatcher interface {
+	MatchFile(path string) bool
+}
+
+// CombinedReplacer runs several replacers over a directory in a single traversal, e.g. to
+// pin both the GitHub Actions and the container images of a repository in one pass
+type CombinedReplacer struct {
+	replacers []*Replacer
+}
+
+// NewCombinedReplacer returns a replacer running the given ones over each file they handle,
+// in order, each one replacing the references in the content left by the previous ones.
+// The traversal, e.g. the gitignore handling or the maximum depth, the maximum
+// concurrency and the progress function are those of the first replacer, while the
+// files each replacer handles follow its own configuration, e.g. its excluded paths.
+func NewCombinedReplacer(replacers ...*Replacer) *CombinedReplacer {
+	return &CombinedReplacer{replacers: replacers}
+}
+
+// ParsePath parses and replaces the references of all the replacers in the provided directory
+func (c *CombinedReplacer) ParsePath(ctx context.Context, dir string) (*ReplaceResult, error) {
+	bfs, base := osFS(dir)
+	return c.ParsePathInFS(ctx, bfs, base)
+}
+
+// ParsePathInFS parses and replaces the references of all the replacers in the provided file
+// system. The unresolved references fail it if any of the replacers fails on them.
+func (c *CombinedReplacer) ParsePathInFS(ctx context.Context, bfs billy.Filesystem, base string) (*ReplaceResult, error) {
+	failOnUnresolved := slices.ContainsFunc(c.replacers, func(r *Replacer) bool {
+		return r.failOnUnresolved
+	})
+	return c.replaceInFS(bfs, base, failOnUnresolved, func(r *Replacer) fileReplaceFunc {
+		return r.parseFileFunc(ctx, bfs, base)
+	})
+}
+
+// UnpinPath reverts the references of all the replacers pinned by their digest in the
+// provided directory back to their tags
+func (c *CombinedReplacer) UnpinPath(ctx context.Context, dir string) (*ReplaceResult, error) {
+	bfs, base := osFS(dir)
+	return c.UnpinPathInFS(ctx, bfs, base)
+}
+
+// UnpinPathInFS reverts the references of all the replacers pinned by their digest in the
+// provided file system back to their tags
+func (c *CombinedReplacer) UnpinPathInFS(ctx context.Context, bfs billy.Filesystem, base string) (*ReplaceResult, error) {
+	return c.replaceInFS(bfs, base, false, func(r *Replacer) fileReplaceFunc {
+		return func(_ string, f io.Reader) (fileResult, error) {
+			return unpinReferencesInFile(ctx, f, r.parser)
+		}
+	})
+}
+
+// combinedHandler is one of the replacers of a CombinedReplacer along with the files it handles
+type combinedHandler struct {
+	replacer *Replacer
+	// match tells whether a file is one the replacer traverses on its own
+	match   func(info fs.FileInfo) bool
+	include func(path string) bool
+	replace fileReplaceFunc
+}
+
+// handles returns true if the given file of the base directory is processed by the replacer.
+// A file given as the base is processed whatever its name, like by the replacer on its own.
+func (h *combinedHandler) handles(base, path string, info fs.FileInfo) bool {
+	if m, ok := h.replacer.parser.(fileMatcher); ok && !m.MatchFile(path) {
+		return false
+	}
+	if h.include != nil && !h.include(path) {
+		return false
+	}
+	if path == base {
+		return true
+	}
+	return !isExcluded(&h.replacer.cfg, base, path) && h.match(info)
+}
+
+// replaceInFS traverses the given file system once and applies the function returned by
+// replaceFn for each replacer to the files it handles, merging their results file by file
+func (c *CombinedReplacer) replaceInFS(
+	bfs billy.Filesystem,
+	base string,
+	failOnUnresolved bool,
+	replaceFn func(r *Replacer) fileReplaceFunc,
+) (*ReplaceResult, error) {
+	if len(c.replacers) == 0 {
+		return nil, fmt.Errorf("%w: no replacer to combine", ErrNotCombinable)
+	}
+	handlers := make([]*combinedHandler, 0, len(c.replacers))
+	for _, r := range c.replacers {
+		if r.regexErr != nil {
+			return nil, r.regexErr
+		}
+		if _, ok := r.parser.(fileTraverser); ok {
+			return nil, fmt.Errorf("%w: %T traverses files of its own", ErrNotCombinable, r.parser)
+		}
+		handlers = append(handlers, &combinedHandler{
+			replacer: r,
+			match:    traverse.YamlOrDockerfileMatcher(traversedExtensions(&r.cfg)),
+			include:  r.includeFile(base),
+			replace:  replaceFn(r),
+		})
+	}
+
+	// The handlers of every file walked, set while walking and read while processing
+	var mu sync.Mutex
+	byFile := make(map[string][]*combinedHandler)
+	walk := func(fn func(path string) error) error {
+		return traverse.Traverse(bfs, base, func(path string, info fs.FileInfo) error {
+			if info.IsDir() {
+				return nil
+			}
+			var fileHandlers []*combinedHandler
+			for _, h := range handlers {
+				if h.handles(base, path, info) {
+					fileHandlers = append(fileHandlers, h)
+				}
+			}
+			if len(fileHandlers) == 0 {
+				return nil
+			}
+			mu.Lock()
+			byFile[path] = fileHandlers
+			mu.Unlock()
+			return fn(path)
+		}, traverseOptions(&c.replacers[0].cfg, c.replacers[0].logger)...)
+	}
+
+	return replaceInFS(bfs, walk, c.replacers[0].maxConcurrency, failOnUnresolved, nil, c.replacers[0].progress,
+		func(path string, f io.Reader) (fileResult, error) {
+			mu.Lock()
+			fileHandlers := byFile[path]
+			mu.Unlock()
+
+			var res fileResult
+			for _, h := range fileHandlers {
+				fileRes, err := h.replace(path, f)
+				if err != nil {
+					return fileResult{}, err
+				}
+				res = mergeFileResults(res, fileRes)
+				// The next replacer processes the content left by this one
+				f = strings.NewReader(fileRes.content)
+			}
+			return res, nil
+		})
+}
+
+// mergeFileResults returns the result of replacing the references of a file with the result
+// of next added to prev, the content being the one left by next
+func mergeFileResults(prev, next fileResult) fileResult {
+	return fileResult{
+		modified: prev.modified || next.modified,
+		content:  next.content,
+		stats: FileStats{
+			Matched:  prev.stats.Matched + next.stats.Matched,
+			Modified: prev.stats.Modified + next.stats.Modified,
+			Skipped:  prev.stats.Skipped + next.stats.Skipped,
+			Errored:  prev.stats.Errored + next.stats.Errored,
+		},
+		errors:  append(prev.errors, next.errors...),
+		skipped: append(prev.skipped, next.skipped...),
+		changes: append(prev.changes, next.changes...),
+	}
+}
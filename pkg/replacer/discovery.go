@@ -0,0 +1,99 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replacer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// configResolver resolves the configuration of each processed file, i.e. the one of the
+// nearest configuration file found walking up from its directory to the processed one, like
+// .editorconfig files, merged on top of the fallback one like on top of the files it
+// extends. The configurations are cached by directory, as the files of a directory share
+// theirs.
+type configResolver struct {
+	bfs  billy.Filesystem
+	base string
+	// name is the name of the configuration files, discovery is disabled if empty
+	name string
+	// fallback is the configuration of the files without a configuration file above them
+	fallback config.Config
+
+	mu    sync.Mutex
+	byDir map[string]config.Config
+}
+
+// newConfigResolver returns a resolver of the configuration of the files processed under
+// the base directory, falling back to the given one
+func newConfigResolver(bfs billy.Filesystem, base, name string, fallback config.Config) *configResolver {
+	return &configResolver{
+		bfs:      bfs,
+		base:     filepath.Clean(base),
+		name:     name,
+		fallback: fallback,
+		byDir:    make(map[string]config.Config),
+	}
+}
+
+// forFile returns the configuration of the file at the given path
+func (c *configResolver) forFile(path string) (config.Config, error) {
+	if c.name == "" {
+		return c.fallback, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.forDir(filepath.Dir(path))
+}
+
+// forDir returns the configuration of the files of the given directory, caching it along
+// with the one of every directory walked through
+func (c *configResolver) forDir(dir string) (config.Config, error) {
+	if cfg, ok := c.byDir[dir]; ok {
+		return cfg, nil
+	}
+
+	cfgPath := filepath.Join(dir, c.name)
+	_, err := c.bfs.Stat(cfgPath)
+	switch {
+	case err == nil:
+		// The discovered configuration refines the one of the processed directory
+		parsed, err := config.MergeConfigFileFromFS(c.bfs, cfgPath, &c.fallback)
+		if err != nil {
+			return config.Config{}, fmt.Errorf("failed to read config file %s: %w", cfgPath, err)
+		}
+		c.byDir[dir] = *config.MergeUserConfig(parsed)
+	case !os.IsNotExist(err):
+		return config.Config{}, fmt.Errorf("failed to check for config file %s: %w", cfgPath, err)
+	case dir == c.base || dir == "." || dir == string(filepath.Separator):
+		// Don't walk up past the processed directory
+		c.byDir[dir] = c.fallback
+	default:
+		cfg, err := c.forDir(filepath.Dir(dir))
+		if err != nil {
+			return config.Config{}, err
+		}
+		c.byDir[dir] = cfg
+	}
+	return c.byDir[dir], nil
+}
@@ -0,0 +1,224 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replacer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/ghrest"
+)
+
+// DriftedReference is a reference pinned by a checksum or digest whose tag, recorded in its
+// trailing "# tag" comment, resolves to another checksum or digest by now, i.e. the tag was
+// moved since the reference was pinned
+type DriftedReference struct {
+	// Path is the path of the file, empty when checking a single file
+	Path string `json:"path,omitempty"`
+	// Line is the 1-based line number
+	Line int    `json:"line"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Tag  string `json:"tag"`
+	// Pinned is the checksum or digest the reference is pinned by
+	Pinned string `json:"pinned"`
+	// Current is the checksum or digest the tag resolves to now
+	Current string `json:"current"`
+}
+
+// DriftResult holds the result of checking the pinned references for drift
+type DriftResult struct {
+	// Drifted holds the references whose tag moved, sorted by file and line
+	Drifted []DriftedReference
+	// Errors holds the references whose tag failed to resolve
+	Errors []ReferenceError
+}
+
+// DriftPath checks whether the tags of the references pinned in the provided directory
+// still resolve to the checksum or digest they're pinned by. Only the references with
+// a recorded tag, i.e. a trailing "# tag" comment, can be checked.
+func (r *Replacer) DriftPath(ctx context.Context, dir string) (*DriftResult, error) {
+	bfs, base := osFS(dir)
+	return r.DriftPathInFS(ctx, bfs, base)
+}
+
+// DriftPathInFS is like DriftPath for the provided file system
+func (r *Replacer) DriftPathInFS(ctx context.Context, bfs billy.Filesystem, base string) (*DriftResult, error) {
+	if r.regexErr != nil {
+		return nil, r.regexErr
+	}
+
+	var eg errgroup.Group
+	var mu sync.Mutex
+	setConcurrencyLimit(&eg, r.maxConcurrency)
+
+	res := DriftResult{
+		Drifted: make([]DriftedReference, 0),
+		Errors:  make([]ReferenceError, 0),
+	}
+	configs := newConfigResolver(bfs, base, r.configName, r.cfg)
+	include := r.includeFile(base)
+
+	err := traverseFiles(r.parser, bfs, base, &r.cfg, r.logger, func(path string) error {
+		if include != nil && !include(path) {
+			return nil
+		}
+		eg.Go(func() error {
+			cfg, err := configs.forFile(path)
+			if err != nil {
+				return err
+			}
+			file, err := bfs.Open(path)
+			if err != nil {
+				return fmt.Errorf("failed to open file %s: %w", path, err)
+			}
+			// nolint:errcheck // ignore error
+			defer file.Close()
+
+			drifted, refErrs, err := driftInFile(ctx, file, r.parser, r.rest, cfg, r.timeouts)
+			if err != nil {
+				return fmt.Errorf("failed to check references in %s: %w", path, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, d := range drifted {
+				d.Path = path
+				res.Drifted = append(res.Drifted, d)
+			}
+			for _, e := range refErrs {
+				e.Path = path
+				res.Errors = append(res.Errors, e)
+			}
+			return nil
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(res.Drifted, func(i, j int) bool {
+		if res.Drifted[i].Path != res.Drifted[j].Path {
+			return res.Drifted[i].Path < res.Drifted[j].Path
+		}
+		return res.Drifted[i].Line < res.Drifted[j].Line
+	})
+	sort.Slice(res.Errors, func(i, j int) bool {
+		if res.Errors[i].Path != res.Errors[j].Path {
+			return res.Errors[i].Path < res.Errors[j].Path
+		}
+		return res.Errors[i].Line < res.Errors[j].Line
+	})
+	return &res, nil
+}
+
+// driftInFile returns the references pinned in the given file whose recorded tag resolves
+// to another checksum or digest than the pinned one, along with the ones whose tag failed
+// to resolve. The references without a recorded tag are ignored.
+func driftInFile(
+	ctx context.Context,
+	f io.Reader,
+	parser interfaces.Parser,
+	rest interfaces.REST,
+	cfg config.Config,
+	timeouts refTimeouts,
+) ([]DriftedReference, []ReferenceError, error) {
+	var drifted []DriftedReference
+	var refErrs []ReferenceError
+
+	re, err := compileRegex(parser)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scanner := newLineScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		line := scanner.Text()
+		lineNumber++
+
+		// Skip commented lines
+		if strings.HasPrefix(strings.TrimLeft(line, " \t\n\r"), "#") {
+			continue
+		}
+
+		matches := findMatches(re, parser, line)
+		for i, match := range matches {
+			// The tag comment, if any, sits between this match and the next one
+			end := len(line)
+			if i+1 < len(matches) {
+				end = matches[i+1][0]
+			}
+			c := tagCommentRegex.FindStringSubmatch(line[match[1]:end])
+			if c == nil {
+				continue
+			}
+			matchedLine := line[match[0]:match[1]]
+
+			pinned, err := parser.ConvertToEntityRef(matchedLine)
+			if err != nil || !IsPinned(*pinned) {
+				continue
+			}
+			ret, err := parser.Unpin(matchedLine, c[1])
+			if err != nil {
+				continue
+			}
+
+			unpinned := formatUnpinned(parser, matchedLine, ret)
+			current, err := timeouts.replace(ctx, parser, unpinned, rest, cfg)
+			switch {
+			case errors.Is(err, ghrest.ErrRateLimited):
+				// The remaining references can't be resolved either
+				return nil, nil, err
+			case err != nil && isUnresolved(err):
+				refErrs = append(refErrs, ReferenceError{Line: lineNumber, Reference: unpinned, Err: err})
+			case err != nil:
+				// Skipped, e.g. excluded
+			case current.Ref != pinned.Ref:
+				drifted = append(drifted, DriftedReference{
+					Line:    lineNumber,
+					Type:    ret.Type,
+					Name:    ret.Name,
+					Tag:     ret.Tag,
+					Pinned:  pinned.Ref,
+					Current: current.Ref,
+				})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return drifted, refErrs, nil
+}
@@ -0,0 +1,250 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// ErrNoMatchingTag is returned when no tag of an image satisfies its tag constraint
+var ErrNoMatchingTag = errors.New("no tag matches the constraint")
+
+var (
+	// tagVersionRegex matches the tags made of a version, e.g. 3.18.5, v1.2 or 20.10.0-alpine
+	tagVersionRegex = regexp.MustCompile(`^(v?)(\d+(?:\.\d+){0,2})(-[\w.-]+)?$`)
+	// tagConstraintRegex matches the tag constraints, e.g. 3.18.*, ^3.18 or ~20.10-alpine
+	tagConstraintRegex = regexp.MustCompile(`^([\^~]?)(v?)((?:\d+|\*)(?:\.(?:\d+|\*)){0,2})(-[\w.-]+)?$`)
+)
+
+// tagVersion is a tag made of a version, with up to three numbers. The tags sharing the
+// same prefix and suffix, e.g. -alpine, are the ones compared with each other.
+type tagVersion struct {
+	prefix  string
+	numbers []int
+	suffix  string
+}
+
+// tagConstraint is a constraint on the version of a tag. Without an operator, the numbers
+// are the ones the version must start with, e.g. 3.18 for 3.18.*. With the ^ operator, the
+// version must be at least the numbers without changing the left-most non-zero one, and
+// with the ~ operator without changing the minor version, if given, or the major one.
+type tagConstraint struct {
+	op      string
+	prefix  string
+	numbers []int
+	suffix  string
+}
+
+// parseTagVersion parses a tag made of a version, e.g. 3.18.5
+func parseTagVersion(tag string) (tagVersion, bool) {
+	m := tagVersionRegex.FindStringSubmatch(tag)
+	if m == nil {
+		return tagVersion{}, false
+	}
+	numbers, ok := parseNumbers(strings.Split(m[2], "."))
+	if !ok {
+		return tagVersion{}, false
+	}
+	return tagVersion{prefix: m[1], numbers: numbers, suffix: m[3]}, true
+}
+
+// parseTagConstraint parses a tag constraint, e.g. 3.18.*, ^3.18 or ~3.18. Plain tags,
+// e.g. 3.18, aren't constraints.
+func parseTagConstraint(tag string) (tagConstraint, bool) {
+	m := tagConstraintRegex.FindStringSubmatch(tag)
+	if m == nil {
+		return tagConstraint{}, false
+	}
+	parts := strings.Split(m[3], ".")
+	// Wildcards only stand for the trailing numbers, and can't be combined with an operator
+	wildcard := slices.Index(parts, "*")
+	switch {
+	case m[1] == "" && wildcard < 0:
+		return tagConstraint{}, false
+	case m[1] != "" && wildcard >= 0:
+		return tagConstraint{}, false
+	case wildcard >= 0 && slices.ContainsFunc(parts[wildcard:], func(p string) bool { return p != "*" }):
+		return tagConstraint{}, false
+	case wildcard >= 0:
+		parts = parts[:wildcard]
+	}
+	numbers, ok := parseNumbers(parts)
+	if !ok {
+		return tagConstraint{}, false
+	}
+	return tagConstraint{op: m[1], prefix: m[2], numbers: numbers, suffix: m[4]}, true
+}
+
+// parseNumbers parses the numbers of a version
+func parseNumbers(parts []string) ([]int, bool) {
+	numbers := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, true
+}
+
+// padNumbers returns the numbers of a version padded with zeros up to three numbers
+func padNumbers(numbers []int) []int {
+	padded := make([]int, 3)
+	copy(padded, numbers)
+	return padded
+}
+
+// matches returns true if the version satisfies the constraint
+func (c tagConstraint) matches(v tagVersion) bool {
+	if v.prefix != c.prefix || v.suffix != c.suffix {
+		return false
+	}
+	version := padNumbers(v.numbers)
+	if c.op == "" {
+		return slices.Equal(version[:len(c.numbers)], c.numbers)
+	}
+
+	lower := padNumbers(c.numbers)
+	if slices.Compare(version, lower) < 0 {
+		return false
+	}
+	// The upper bound is the next version of the number which can't change
+	bump := 0
+	switch c.op {
+	case "^":
+		for bump < len(c.numbers)-1 && c.numbers[bump] == 0 {
+			bump++
+		}
+	case "~":
+		bump = min(1, len(c.numbers)-1)
+	}
+	upper := make([]int, 3)
+	copy(upper, c.numbers[:bump+1])
+	upper[bump]++
+	return slices.Compare(version, upper) < 0
+}
+
+// newestMatchingTag returns the newest of the tags satisfying the constraint. Of the tags
+// standing for the same version, e.g. 3.18 and 3.18.0, the most specific one is preferred.
+func newestMatchingTag(tags []string, c tagConstraint) (string, bool) {
+	var newest string
+	var newestVersion tagVersion
+	for _, tag := range tags {
+		v, ok := parseTagVersion(tag)
+		if !ok || !c.matches(v) {
+			continue
+		}
+		cmp := slices.Compare(padNumbers(v.numbers), padNumbers(newestVersion.numbers))
+		if newest == "" || cmp > 0 || (cmp == 0 && len(v.numbers) > len(newestVersion.numbers)) {
+			newest, newestVersion = tag, v
+		}
+	}
+	return newest, newest != ""
+}
+
+// splitTagConstraint splits an image reference with a tag constraint, e.g. alpine:3.18.*,
+// into its repository and constraint. References pinned by a digest have no constraint.
+func splitTagConstraint(imageRef string) (string, tagConstraint, bool) {
+	i := strings.LastIndex(imageRef, ":")
+	if i < 0 || strings.Contains(imageRef[i:], "/") || strings.Contains(imageRef, "@") {
+		return "", tagConstraint{}, false
+	}
+	c, ok := parseTagConstraint(imageRef[i+1:])
+	return imageRef[:i], c, ok
+}
+
+// ResolveTagConstraint returns the image reference with its tag constraint, e.g.
+// alpine:3.18.* or node:^20-alpine, replaced by the newest tag of the image satisfying
+// it, e.g. alpine:3.18.5. The tags of the image are listed through the registry mirror
+// configured for it, if any. References without a constraint are returned as is.
+func ResolveTagConstraint(
+	ctx context.Context,
+	imageRef string,
+	cfg *config.Config,
+	extraOpts ...remote.Option,
+) (string, error) {
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	repoStr, c, ok := splitTagConstraint(imageRef)
+	if !ok {
+		return imageRef, nil
+	}
+	repo, err := name.NewRepository(repoStr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", interfaces.ErrInvalidReference, err)
+	}
+	resolveRef, err := mirrorReference(repo.Tag("latest"), cfg.Images.RegistryMirrors)
+	if err != nil {
+		return "", err
+	}
+
+	tags, err := remote.List(resolveRef.Context(), remoteOptions(ctx, extraOpts)...)
+	if err != nil {
+		return "", markTransient(ctx, err)
+	}
+	tag, ok := newestMatchingTag(tags, c)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNoMatchingTag, imageRef)
+	}
+	return repoStr + ":" + tag, nil
+}
+
+// resolveTagConstraint resolves the tag constraint of the image reference of the matched
+// line like ResolveTagConstraint, if resolving constraints is enabled. The images filtered
+// out by the configuration are skipped before listing their tags.
+func (p *Parser) resolveTagConstraint(
+	ctx context.Context,
+	matchedLine, imageRef string,
+	cfg *config.Config,
+) (string, error) {
+	if !cfg.Images.ResolveConstraints {
+		return imageRef, nil
+	}
+	repoStr, _, ok := splitTagConstraint(imageRef)
+	if !ok {
+		return imageRef, nil
+	}
+	if repo, err := name.NewRepository(repoStr); err == nil && excludedImage(cfg.Images.ImageFilter, repo.Tag("latest")) {
+		return "", &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipExcludedImage}
+	}
+
+	var resolved string
+	err := p.retry.Do(ctx, func() error {
+		v, err, _ := p.lookups.Do("constraint#"+imageRef, func() (any, error) {
+			return ResolveTagConstraint(ctx, imageRef, cfg, p.remoteOpts...)
+		})
+		if err != nil {
+			return err
+		}
+		resolved = v.(string)
+		return nil
+	})
+	return resolved, err
+}
@@ -0,0 +1,128 @@
+package image
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// constraintTags are the tags of an image the constraints are resolved against
+var constraintTags = []string{
+	"latest", "edge", "3", "3.17", "3.17.9", "3.18", "3.18.0", "3.18.4", "3.18.5", "3.19.0-rc1",
+	"3.19.0", "3.19.1", "4.0.0", "0.2.3", "0.2.9", "0.3.0", "v1.4.2", "v1.5.0",
+	"20.10.0-alpine", "20.11.1-alpine", "21.0.0-alpine", "20.12.0",
+}
+
+func TestNewestMatchingTag(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		// Wildcards
+		{"3.18.*", "3.18.5"},
+		{"3.*", "3.19.1"},
+		{"3.*.*", "3.19.1"},
+		{"*", "20.12.0"},
+		{"3.20.*", ""},
+		// Caret
+		{"^3.18", "3.19.1"},
+		{"^3.18.5", "3.19.1"},
+		{"^3", "3.19.1"},
+		{"^4", "4.0.0"},
+		{"^0.2.3", "0.2.9"},
+		{"^5", ""},
+		// Tilde
+		{"~3.18", "3.18.5"},
+		{"~3.18.4", "3.18.5"},
+		{"~3", "3.19.1"},
+		// Prefixes and suffixes
+		{"^v1.4", "v1.5.0"},
+		{"^20-alpine", "20.11.1-alpine"},
+		{"20.10.*-alpine", "20.10.0-alpine"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.constraint, func(t *testing.T) {
+			t.Parallel()
+
+			c, ok := parseTagConstraint(tt.constraint)
+			require.True(t, ok)
+			got, ok := newestMatchingTag(constraintTags, c)
+			require.Equal(t, tt.want != "", ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseTagConstraintPlainTags(t *testing.T) {
+	t.Parallel()
+
+	for _, tag := range []string{"3.18", "latest", "3.*.1", "^3.*", "^latest", "3.18.x", "~"} {
+		_, ok := parseTagConstraint(tag)
+		require.False(t, ok, "%s isn't a constraint", tag)
+	}
+}
+
+func TestReplaceTagConstraint(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "alpine:3.18.4", "alpine:3.18.5", "alpine:3.19.0", "node:20.11.1-alpine", "node:20.12.0")
+	cfg := config.Config{Images: config.Images{ResolveConstraints: true}}
+
+	tests := []struct {
+		name        string
+		matchedLine string
+		wantTag     string
+		wantDigest  string
+		wantPrefix  string
+	}{
+		{"Wildcard", "image: " + host + "/alpine:3.18.*", "3.18.5", digests["alpine:3.18.5"], "image: "},
+		{"Caret", "image: " + host + "/alpine:^3.18", "3.19.0", digests["alpine:3.19.0"], "image: "},
+		{"Tilde", host + "/alpine:~3.18.4", "3.18.5", digests["alpine:3.18.5"], ""},
+		{"Suffix", "FROM " + host + "/node:^20-alpine", "20.11.1-alpine", digests["node:20.11.1-alpine"], "FROM "},
+		{"Plain tag", "image: " + host + "/alpine:3.18.4", "3.18.4", digests["alpine:3.18.4"], "image: "},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := New().Replace(context.Background(), tt.matchedLine, nil, cfg)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantTag, got.Tag)
+			require.Equal(t, tt.wantDigest, got.Ref)
+			require.Equal(t, tt.wantPrefix, got.Prefix)
+		})
+	}
+
+	t.Run("No matching tag", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := New().Replace(context.Background(), "image: "+host+"/alpine:^4", nil, cfg)
+		require.ErrorIs(t, err, ErrNoMatchingTag)
+	})
+
+	t.Run("Excluded image", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := cfg
+		cfg.Images.ExcludeImages = []string{"alpine"}
+		_, err := New().Replace(context.Background(), "image: "+host+"/alpine:3.18.*", nil, cfg)
+		require.Equal(t, interfaces.SkipExcludedImage, interfaces.GetSkipReason(err))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := New().Replace(context.Background(), "image: "+host+"/alpine:3.18.*", nil, config.Config{})
+		require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+	})
+}
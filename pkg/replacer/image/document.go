@@ -0,0 +1,188 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// documentImage is an image referenced by a YAML document along with the way to pin it
+type documentImage struct {
+	ref  string
+	line int // 1-based
+	// pin returns the edit pinning the image by the given digest, false if it can't be pinned
+	pin func(lines []string, digest string) (yamlEdit, bool)
+}
+
+// yamlEdit inserts text at a position of the document
+type yamlEdit struct {
+	line   int // 0-based
+	column int // 0-based, in runes
+	text   string
+}
+
+// ReplaceInDocument pins the images that can't be matched line by line as they're
+// split across several keys of a YAML mapping, i.e.
+//   - the repository and tag keys of Helm chart values, see appendHelmImages
+//   - the images transformer of Kustomize, see appendKustomizeImages
+//   - the image keys of JSON documents if json_manifests is set, see appendJSONImages
+//   - the pullImage calls of Nix files if nix_images is set, see replaceNixImages
+//
+// The rest of the document is left untouched. Content that isn't valid YAML is returned as is.
+// The images found are returned along with the outcome of pinning them, except the ones
+// pinned already.
+func (p *Parser) ReplaceInDocument(
+	ctx context.Context,
+	content string,
+	_ interfaces.REST,
+	cfg config.Config,
+) (string, bool, []interfaces.DocumentReference) {
+	if cfg.Images.NixImages && nixPullImageRegex.MatchString(content) {
+		return p.replaceNixImages(ctx, content, &cfg)
+	}
+	var images []documentImage
+	jsonDoc := cfg.Images.JSONManifests && isJSONDocument(content)
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			if !errors.Is(err, io.EOF) {
+				// Not a YAML document, e.g. a Dockerfile
+				return content, false, nil
+			}
+			break
+		}
+		images = appendKustomizeImages(images, &doc)
+		images = appendHelmImages(images, &doc)
+		if jsonDoc {
+			images = p.appendJSONImages(images, &doc)
+		}
+	}
+
+	lines := strings.Split(content, "\n")
+	var edits []yamlEdit
+	var refs []interfaces.DocumentReference
+	for _, img := range images {
+		// The digest is appended to the reference, so its variables are kept in the document
+		ref := p.expandEnv(img.ref)
+		if err := skipImageRef(&cfg, img.ref, ref); err != nil {
+			refs = append(refs, interfaces.DocumentReference{Line: img.line, Reference: img.ref, Err: err})
+			continue
+		}
+
+		pinned, err := p.resolveDocumentImage(ctx, ref, &cfg)
+		if err != nil {
+			refs = append(refs, interfaces.DocumentReference{Line: img.line, Reference: img.ref, Err: err})
+			continue
+		}
+
+		edit, ok := img.pin(lines, pinned.Ref)
+		if !ok {
+			err := fmt.Errorf("image reference %s can't be rewritten - %w", img.ref, interfaces.ErrReferenceSkipped)
+			refs = append(refs, interfaces.DocumentReference{Line: img.line, Reference: img.ref, Err: err})
+			continue
+		}
+		edits = append(edits, edit)
+		refs = append(refs, interfaces.DocumentReference{
+			Line: img.line, Reference: img.ref, Ref: pinned, Pinned: img.ref + "@" + pinned.Ref,
+		})
+	}
+	if len(edits) == 0 {
+		return content, false, refs
+	}
+
+	return applyEdits(lines, edits), true, refs
+}
+
+// resolveDocumentImage resolves the image within the timeout set through SetResolveTimeout, if any
+func (p *Parser) resolveDocumentImage(ctx context.Context, ref string, cfg *config.Config) (*interfaces.EntityRef, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	var pinned *interfaces.EntityRef
+	err := p.retry.Do(ctx, func() (err error) {
+		pinned, err = p.getImageDigest(ctx, ref, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pinned, p.checkPlatforms(ctx, pinned, cfg)
+}
+
+// mappingValue returns the value of the given key of the mapping node, if any
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// scalarEnd returns the position right after the value of the given scalar node,
+// i.e. before the closing quote of a quoted value. It's false if the node spans
+// several lines or doesn't match the content, e.g. because it uses escapes.
+func scalarEnd(lines []string, node *yaml.Node) (yamlEdit, bool) {
+	if node.Kind != yaml.ScalarNode || node.Line < 1 || node.Line > len(lines) {
+		return yamlEdit{}, false
+	}
+	line := []rune(lines[node.Line-1])
+	start := node.Column - 1
+	value := []rune(node.Value)
+
+	if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 {
+		// Skip the opening quote
+		start++
+	}
+	end := start + len(value)
+	if start < 0 || end > len(line) || string(line[start:end]) != node.Value {
+		return yamlEdit{}, false
+	}
+
+	return yamlEdit{line: node.Line - 1, column: end}, true
+}
+
+// applyEdits applies the given edits to the lines of the document and returns the document
+func applyEdits(lines []string, edits []yamlEdit) string {
+	// Apply the edits from the end so they don't shift the positions of the remaining ones,
+	// flow mappings may well hold several images on the same line
+	sort.Slice(edits, func(i, j int) bool {
+		if edits[i].line != edits[j].line {
+			return edits[i].line > edits[j].line
+		}
+		return edits[i].column > edits[j].column
+	})
+
+	for _, e := range edits {
+		line := []rune(lines[e.line])
+		lines[e.line] = string(line[:e.column]) + e.text + string(line[e.column:])
+	}
+	return strings.Join(lines, "\n")
+}
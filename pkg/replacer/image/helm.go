@@ -0,0 +1,128 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	helmKeyImage      = "image"
+	helmKeyRegistry   = "registry"
+	helmKeyRepository = "repository"
+	helmKeyTag        = "tag"
+	helmKeyDigest     = "digest"
+)
+
+// appendHelmImages appends the images referenced through the repository and tag keys
+// of the same mapping in the given node and its children, as commonly done in Helm
+// chart values, e.g.
+//
+//	image:
+//	  registry: docker.io
+//	  repository: bitnami/nginx
+//	  tag: 1.25.3
+//
+// Only the mappings held by a key naming an image, e.g. image or sidecarImage, are
+// considered, as repository and tag keys are common elsewhere, e.g. in the inputs of
+// GitHub Actions. The digest is written to an empty digest key of the mapping if there
+// is one, otherwise it's appended to the tag, i.e. 1.25.3@sha256:..., which works with
+// the usual {{ .repository }}:{{ .tag }} templates.
+func appendHelmImages(images []documentImage, node *yaml.Node) []documentImage {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind != yaml.MappingNode || !isHelmImageKey(key.Value) {
+				continue
+			}
+			if img, ok := helmImageFromMapping(value); ok {
+				images = append(images, img)
+			}
+		}
+	}
+	for _, child := range node.Content {
+		images = appendHelmImages(images, child)
+	}
+	return images
+}
+
+// isHelmImageKey returns true if the key names an image, e.g. image, initImage or kubectl_image
+func isHelmImageKey(key string) bool {
+	return strings.Contains(strings.ToLower(key), helmKeyImage)
+}
+
+// helmImageFromMapping returns the image referenced by the repository and tag keys of the mapping, if any
+func helmImageFromMapping(node *yaml.Node) (documentImage, bool) {
+	var registry, repository string
+	var tag, digest *yaml.Node
+	var hasDigest bool
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			continue
+		}
+		switch key.Value {
+		case helmKeyRegistry:
+			registry = value.Value
+		case helmKeyRepository:
+			repository = value.Value
+		case helmKeyTag:
+			tag = value
+		case helmKeyDigest:
+			hasDigest = true
+			// Only an empty quoted value can be filled in without changing the layout
+			if value.Value == "" && value.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 {
+				digest = value
+			}
+		}
+	}
+
+	switch {
+	case repository == "" || tag == nil || tag.Value == "":
+		// Not an image, or the tag defaults to the chart's appVersion which we can't know
+		return documentImage{}, false
+	case strings.Contains(tag.Value, "@") || strings.Contains(repository, "@"):
+		// Already pinned
+		return documentImage{}, false
+	case strings.LastIndex(repository, ":") > strings.LastIndex(repository, "/"):
+		// The repository holds a tag already, i.e. it's not a plain repository
+		return documentImage{}, false
+	case hasDigest && digest == nil:
+		// The digest is either set already or can't be filled in
+		return documentImage{}, false
+	}
+
+	ref := repository + ":" + tag.Value
+	if registry != "" {
+		ref = registry + "/" + ref
+	}
+	return documentImage{
+		ref:  ref,
+		line: tag.Line,
+		pin: func(lines []string, d string) (yamlEdit, bool) {
+			if digest != nil {
+				edit, ok := scalarEnd(lines, digest)
+				edit.text = d
+				return edit, ok
+			}
+			edit, ok := scalarEnd(lines, tag)
+			edit.text = "@" + d
+			return edit, ok
+		},
+	}, true
+}
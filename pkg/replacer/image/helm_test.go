@@ -0,0 +1,201 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+func TestReplaceInDocument(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "nginx:1.25.3", "bitnami/redis:7.2", "busybox:1.36")
+
+	tests := []struct {
+		name         string
+		content      string
+		want         string
+		wantModified bool
+		// wantErr is the image failing to resolve, if any
+		wantErr *interfaces.DocumentReference
+	}{
+		{
+			name: "repository and tag",
+			content: `replicaCount: 1
+image:
+  repository: {{HOST}}/nginx
+  pullPolicy: IfNotPresent
+  # Overrides the image tag whose default is the chart appVersion.
+  tag: "1.25.3"
+service:
+  type: ClusterIP
+`,
+			want: `replicaCount: 1
+image:
+  repository: {{HOST}}/nginx
+  pullPolicy: IfNotPresent
+  # Overrides the image tag whose default is the chart appVersion.
+  tag: "1.25.3@{{nginx:1.25.3}}"
+service:
+  type: ClusterIP
+`,
+			wantModified: true,
+		},
+		{
+			name: "registry, repository and empty digest",
+			content: `image:
+  registry: {{HOST}}
+  repository: bitnami/redis
+  tag: 7.2 # keep me
+  digest: ""
+`,
+			want: `image:
+  registry: {{HOST}}
+  repository: bitnami/redis
+  tag: 7.2 # keep me
+  digest: "{{bitnami/redis:7.2}}"
+`,
+			wantModified: true,
+		},
+		{
+			name: "nested images and flow mappings",
+			content: `controller:
+  image:
+    repository: {{HOST}}/nginx
+    tag: 1.25.3
+  sidecars:
+    - name: helper
+      image: {repository: {{HOST}}/busybox, tag: '1.36'}
+---
+other: {mainImage: {repository: {{HOST}}/nginx, tag: 1.25.3}, init_image: {repository: {{HOST}}/busybox, tag: 1.36}}
+`,
+			want: `controller:
+  image:
+    repository: {{HOST}}/nginx
+    tag: 1.25.3@{{nginx:1.25.3}}
+  sidecars:
+    - name: helper
+      image: {repository: {{HOST}}/busybox, tag: '1.36@{{busybox:1.36}}'}
+---
+other: {mainImage: {repository: {{HOST}}/nginx, tag: 1.25.3@{{nginx:1.25.3}}}, init_image: {repository: {{HOST}}/busybox, tag: 1.36@{{busybox:1.36}}}}
+`,
+			wantModified: true,
+		},
+		{
+			name: "already pinned",
+			content: `image:
+  repository: {{HOST}}/nginx
+  tag: 1.25.3@{{nginx:1.25.3}}
+sidecarImage:
+  repository: {{HOST}}/busybox
+  tag: "1.36"
+  digest: {{busybox:1.36}}
+`,
+		},
+		{
+			name: "not held by an image key",
+			content: `steps:
+  - uses: org/release-action@v1
+    with:
+      repository: {{HOST}}/nginx
+      tag: 1.25.3
+`,
+		},
+		{
+			name: "no tag, unknown image and excluded tag",
+			content: `image:
+  repository: {{HOST}}/nginx
+  tag: ""
+missingImage:
+  repository: {{HOST}}/missing
+  tag: "1.0"
+latestImage:
+  repository: {{HOST}}/nginx
+  tag: latest
+`,
+			wantErr: &interfaces.DocumentReference{Line: 6, Reference: "{{HOST}}/missing:1.0"},
+		},
+		{
+			name: "not yaml",
+			content: `FROM {{HOST}}/nginx:1.25.3
+RUN echo "{"
+`,
+		},
+	}
+
+	expand := func(s string) string {
+		s = strings.ReplaceAll(s, "{{HOST}}", host)
+		for ref, digest := range digests {
+			s = strings.ReplaceAll(s, "{{"+ref+"}}", digest)
+		}
+		return s
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.Config{Images: config.Images{ImageFilter: config.ImageFilter{ExcludeTags: []string{"latest"}}}}
+			got, modified, refs := New().ReplaceInDocument(context.Background(), expand(tt.content), nil, cfg)
+			errs := unresolvedRefs(refs)
+			if tt.wantErr != nil {
+				require.Len(t, errs, 1)
+				require.Equal(t, tt.wantErr.Line, errs[0].Line)
+				require.Equal(t, expand(tt.wantErr.Reference), errs[0].Reference)
+			} else {
+				require.Empty(t, errs)
+			}
+			require.Equal(t, tt.wantModified, modified)
+			want := tt.want
+			if !tt.wantModified {
+				want = tt.content
+			}
+			require.Equal(t, expand(want), got)
+		})
+	}
+}
+
+func TestReplaceInDocument_ResolveTimeout(t *testing.T) {
+	t.Parallel()
+
+	// A registry which never answers
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	content := "image:\n  repository: " + host + "/nginx\n  tag: 1.25.3\n"
+	p := New()
+	p.SetResolveTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	got, modified, refs := p.ReplaceInDocument(context.Background(), content, nil, config.Config{})
+	require.False(t, modified)
+	require.Equal(t, content, got)
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Len(t, refs, 1)
+	require.ErrorIs(t, refs[0].Err, context.DeadlineExceeded)
+}
+
+// unresolvedRefs returns the references which failed to resolve, leaving out the ones
+// pinned or skipped
+func unresolvedRefs(refs []interfaces.DocumentReference) []interfaces.DocumentReference {
+	var errs []interfaces.DocumentReference
+	for _, ref := range refs {
+		if ref.Err != nil && !errors.Is(ref.Err, interfaces.ErrReferenceSkipped) {
+			errs = append(errs, ref)
+		}
+	}
+	return errs
+}
@@ -20,58 +20,187 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	dockerparser "github.com/moby/buildkit/frontend/dockerfile/parser"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/stacklok/frizbee/internal/cli"
 	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
 	"github.com/stacklok/frizbee/pkg/utils/store"
 )
 
 const (
-	// ContainerImageRegex is regular expression pattern to match container image usage in YAML
+	// ContainerImageRegex is regular expression pattern to match container image usage in YAML,
+	// including Bitbucket Pipelines pipes, Dockerfiles and Podman quadlet units
 	// nolint:lll
-	ContainerImageRegex = `image\s*:\s*["']?([^\s"']+/[^\s"']+|[^\s"']+)(:[^\s"']+)?(@[^\s"']+)?["']?|FROM\s+(--platform=[^\s]+[^\s]*\s+)?([^\s]+(/[^\s]+)?(:[^\s]+)?(@[^\s]+)?)`
+	ContainerImageRegex = `image\s*:\s*["']?([^\s"']+/[^\s"']+|[^\s"']+)(:[^\s"']+)?(@[^\s"']+)?["']?|\bname\s*:\s*["']?[^\s"']+:[^\s"']+["']?|\bcontainer\s*:\s*["']?[^\s"']*[/:][^\s"']+["']?|\bpipe\s*:\s*["']?[^\s"']+:[^\s"']+["']?|FROM\s+(--platform=[^\s]+[^\s]*\s+)?([^\s]+(/[^\s]+)?(:[^\s]+)?(@[^\s]+)?)|^Image=[^\s]+`
 	prefixFROM          = "FROM "
 	prefixImage         = "image: "
+	prefixName          = "name: "
+	prefixContainer     = "container: "
+	prefixPipe          = "pipe: "
+	prefixQuadletImage  = "Image="
+	// prefixDockerTransport is the skopeo-style transport of images in a registry, e.g.
+	// docker://nginx:1.25, which is stripped before resolving them
+	prefixDockerTransport = "docker://"
 	// ReferenceType is the type of the reference
 	ReferenceType = "container"
 )
 
+// ErrPlatformNotFound is returned when an image isn't available for a required platform
+var ErrPlatformNotFound = errors.New("image not available for platform")
+
+// defaultRegex is ContainerImageRegex compiled once for all the parsers
+var defaultRegex = regexp.MustCompile(ContainerImageRegex)
+
 // Parser is a struct to replace container image references with digests
 type Parser struct {
 	regex string
-	cache store.RefCacher
+	// compiled is the compiled regex, or nil along with regexErr if it's invalid
+	compiled   *regexp.Regexp
+	regexErr   error
+	keys       []string
+	cache      store.RefCacher
+	remoteOpts []remote.Option
+	retry      retry.Policy
+	timeout    time.Duration
+	platforms  []v1.Platform
+	// lookupEnv looks up the variables expanded in the references before resolving them, if set
+	lookupEnv func(string) (string, bool)
+	// lookups deduplicates the concurrent resolutions of the same image
+	lookups singleflight.Group
+	// logger logs the cache lookups at debug level, if set
+	logger *slog.Logger
+	// buildArgKeys are the Compose build args holding base images, see SetBuildArgKeys
+	buildArgKeys []string
+	// resolver resolves the digests instead of the registries, if set
+	resolver interfaces.Resolver
 }
 
 type unresolvedImage struct {
 	imageRef string
 	flags    []string
+	// stage is the name of the build stage defined by the FROM instruction, if any
+	stage string
 }
 
 // New creates a new Parser
 func New() *Parser {
 	return &Parser{
-		regex: ContainerImageRegex,
-		cache: store.NewRefCacher(),
+		regex:    ContainerImageRegex,
+		compiled: defaultRegex,
+		cache:    store.NewRefCacher(),
 	}
 }
 
+// SetResolver sets the resolver returning the digests of the images instead of the
+// registries. The platforms set through SetPlatforms are still checked against the
+// registries.
+func (p *Parser) SetResolver(resolver interfaces.Resolver) {
+	p.resolver = resolver
+}
+
 // SetCache sets the cache to store the image references
 func (p *Parser) SetCache(cache store.RefCacher) {
 	p.cache = cache
 }
 
+// SetRemoteOptions sets additional options used when talking to container registries
+func (p *Parser) SetRemoteOptions(opts ...remote.Option) {
+	p.remoteOpts = opts
+}
+
+// SetRetryPolicy sets the policy to retry image resolutions failing with transient errors
+func (p *Parser) SetRetryPolicy(policy retry.Policy) {
+	p.retry = policy
+}
+
+// SetResolveTimeout bounds the time spent resolving a single image pinned by
+// ReplaceInDocument, zero means no timeout. The images matched line by line are
+// bounded through the context given to Replace.
+func (p *Parser) SetResolveTimeout(d time.Duration) {
+	p.timeout = d
+}
+
+// SetPlatforms sets the platforms every pinned image must be available for, i.e. the
+// platforms of a multi-platform index or the platform of a single image
+func (p *Parser) SetPlatforms(platforms []v1.Platform) {
+	p.platforms = platforms
+}
+
+// SetEnvLookup sets the function looking up the $VAR and ${VAR} variables expanded in
+// the image references before resolving them, e.g. os.LookupEnv. The variables it
+// doesn't know are left as is, so their references are skipped.
+func (p *Parser) SetEnvLookup(lookup func(string) (string, bool)) {
+	p.lookupEnv = lookup
+}
+
+// SetLogger sets the logger the cache hits and misses are logged to at debug level
+func (p *Parser) SetLogger(logger *slog.Logger) {
+	p.logger = logger
+}
+
+// SetImageKeys sets additional YAML keys referencing container images, e.g. sandbox_image,
+// and replaces the regular expression pattern with one matching them as well
+func (p *Parser) SetImageKeys(keys []string) {
+	p.keys = keys
+	p.SetRegex(p.keyedRegex())
+}
+
+// SetBuildArgKeys sets the build args of Compose files holding base images, e.g. BASE_IMAGE,
+// and replaces the regular expression pattern with one matching them as well, in both the
+// mapping form, i.e. BASE_IMAGE: node:18, and the list form, i.e. - BASE_IMAGE=node:18
+func (p *Parser) SetBuildArgKeys(keys []string) {
+	p.buildArgKeys = keys
+	p.SetRegex(p.keyedRegex())
+}
+
+// keyedRegex returns ContainerImageRegex extended to match the container images referenced
+// by the image keys and build args set on the parser
+func (p *Parser) keyedRegex() string {
+	regex := ContainerImageRegexWithKeys(append(slices.Clone(p.keys), p.buildArgKeys...))
+	if len(p.buildArgKeys) == 0 {
+		return regex
+	}
+	alternatives := make([]string, 0, len(p.buildArgKeys))
+	for _, k := range p.buildArgKeys {
+		alternatives = append(alternatives, regexp.QuoteMeta(k))
+	}
+	return regex + `|- (?:` + strings.Join(alternatives, "|") + `)=[^\s"']+`
+}
+
+// ContainerImageRegexWithKeys returns ContainerImageRegex extended to match container
+// images referenced by the given YAML keys besides the image key
+func ContainerImageRegexWithKeys(keys []string) string {
+	if len(keys) == 0 {
+		return ContainerImageRegex
+	}
+	alternatives := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		alternatives = append(alternatives, regexp.QuoteMeta(k))
+	}
+	alternatives = append(alternatives, "image")
+	return strings.Replace(ContainerImageRegex, `image\s*:`, `(?:`+strings.Join(alternatives, "|")+`)\s*:`, 1)
+}
+
 // SetRegex sets the regular expression pattern to match container image usage
 func (p *Parser) SetRegex(regex string) {
 	p.regex = regex
+	p.compiled, p.regexErr = regexp.Compile(regex)
 }
 
 // GetRegex returns the regular expression pattern to match container image usage
@@ -79,6 +208,12 @@ func (p *Parser) GetRegex() string {
 	return p.regex
 }
 
+// CompiledRegex returns the regular expression pattern to match container image usage, compiled
+// once when set rather than for every file, or the error compiling it if it's invalid
+func (p *Parser) CompiledRegex() (*regexp.Regexp, error) {
+	return p.compiled, p.regexErr
+}
+
 // Replace replaces the container image reference with the digest
 func (p *Parser) Replace(
 	ctx context.Context,
@@ -88,10 +223,12 @@ func (p *Parser) Replace(
 ) (*interfaces.EntityRef, error) {
 	var imageRef string
 	var extraArgs string
+	var transport string
+	var err error
 
 	// Trim the prefix
 	hasFROMPrefix := false
-	hasImagePrefix := false
+	keyPrefix := ""
 	// Check if the image reference has the FROM prefix, i.e. Dockerfile
 	if strings.HasPrefix(matchedLine, prefixFROM) {
 		parsedFrom, err := getRefFromDockerfileFROM(matchedLine)
@@ -99,51 +236,175 @@ func (p *Parser) Replace(
 			return nil, err
 		}
 
+		imageRef, err = p.resolveTagConstraint(ctx, matchedLine, p.expandEnv(parsedFrom.imageRef), &cfg)
+		if err != nil {
+			return nil, err
+		}
 		// Check if the image reference should be excluded, i.e. scratch
-		if shouldSkipImageRef(&cfg, parsedFrom.imageRef) {
-			return nil, fmt.Errorf("image reference %s should be excluded - %w", matchedLine, interfaces.ErrReferenceSkipped)
+		if err := skipImageRef(&cfg, matchedLine, imageRef); err != nil {
+			return nil, err
 		}
 
-		imageRef = parsedFrom.imageRef
 		extraArgs = strings.Join(parsedFrom.flags, " ")
 		if extraArgs != "" {
 			extraArgs += " "
 		}
 
 		hasFROMPrefix = true
-	} else if strings.HasPrefix(matchedLine, prefixImage) {
-		// Check if the image reference has the image prefix, i.e. Kubernetes or Docker Compose YAML
-		imageRef = strings.TrimPrefix(matchedLine, prefixImage)
+	} else if keyPrefix = p.getYAMLKeyPrefix(matchedLine); keyPrefix != "" {
+		// Check if the image reference has a YAML key prefix, i.e. Kubernetes, Docker Compose or GitLab CI YAML
+		transport, imageRef, err = splitTransport(matchedLine, p.expandEnv(strings.TrimPrefix(matchedLine, keyPrefix)))
+		if err != nil {
+			return nil, err
+		}
+		imageRef, err = p.resolveTagConstraint(ctx, matchedLine, imageRef, &cfg)
+		if err != nil {
+			return nil, err
+		}
 		// Check if the image reference should be excluded, i.e. scratch
-		if shouldSkipImageRef(&cfg, imageRef) {
-			return nil, fmt.Errorf("image reference %s should be excluded - %w", matchedLine, interfaces.ErrReferenceSkipped)
+		if err := skipImageRef(&cfg, matchedLine, imageRef); err != nil {
+			return nil, err
 		}
-		hasImagePrefix = true
 	} else {
-		imageRef = matchedLine
+		transport, imageRef, err = splitTransport(matchedLine, p.expandEnv(matchedLine))
+		if err != nil {
+			return nil, err
+		}
+		if imageRef, err = p.resolveTagConstraint(ctx, matchedLine, imageRef, &cfg); err != nil {
+			return nil, err
+		}
 	}
 
 	// Get the digest of the image reference
-	imageRefWithDigest, err := GetImageDigestFromRef(ctx, imageRef, cfg.Platform, p.cache)
+	var imageRefWithDigest *interfaces.EntityRef
+	err = p.retry.Do(ctx, func() (err error) {
+		imageRefWithDigest, err = p.getImageDigest(ctx, imageRef, &cfg)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	if err := p.checkPlatforms(ctx, imageRefWithDigest, &cfg); err != nil {
+		return nil, err
+	}
 
 	// Add the prefix back
 	if hasFROMPrefix {
 		imageRefWithDigest.Prefix = fmt.Sprintf("%s%s%s", prefixFROM, extraArgs, imageRefWithDigest.Prefix)
-	} else if hasImagePrefix {
-		imageRefWithDigest.Prefix = fmt.Sprintf("%s%s", prefixImage, imageRefWithDigest.Prefix)
+	} else {
+		imageRefWithDigest.Prefix = fmt.Sprintf("%s%s%s", keyPrefix, transport, imageRefWithDigest.Prefix)
 	}
 
 	// Return the reference
 	return imageRefWithDigest, nil
 }
 
+// checkPlatforms returns ErrPlatformNotFound if the pinned image isn't available for
+// all the platforms set through SetPlatforms
+func (p *Parser) checkPlatforms(ctx context.Context, pinned *interfaces.EntityRef, cfg *config.Config) error {
+	if len(p.platforms) == 0 {
+		return nil
+	}
+
+	var available []v1.Platform
+	err := p.retry.Do(ctx, func() (err error) {
+		available, _, err = GetPlatforms(ctx, pinned.Name+"@"+pinned.Ref, cfg, p.remoteOpts...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, want := range p.platforms {
+		if !slices.ContainsFunc(available, func(have v1.Platform) bool { return have.Satisfies(want) }) {
+			missing = append(missing, want.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w %s: %s:%s", ErrPlatformNotFound, strings.Join(missing, ", "), pinned.Name, pinned.Tag)
+	}
+	return nil
+}
+
+// IsDockerfileRef returns true if the entity returned by Replace or Unpin was
+// referenced by a Dockerfile FROM instruction
+func IsDockerfileRef(e *interfaces.EntityRef) bool {
+	return e.Type == ReferenceType && strings.HasPrefix(e.Prefix, prefixFROM)
+}
+
+// IsQuadletRef returns true if the entity returned by Replace or Unpin was referenced
+// by the Image key of a Podman quadlet unit, e.g. a .container file
+func IsQuadletRef(e *interfaces.EntityRef) bool {
+	return e.Type == ReferenceType && strings.HasPrefix(e.Prefix, prefixQuadletImage)
+}
+
+// Unpin reverts the container image reference pinned by its digest back to the given tag
+func (p *Parser) Unpin(matchedLine, tag string) (*interfaces.EntityRef, error) {
+	var imageRef string
+	var prefix string
+
+	// Check if the image reference has the FROM prefix, i.e. Dockerfile
+	if strings.HasPrefix(matchedLine, prefixFROM) {
+		parsedFrom, err := getRefFromDockerfileFROM(matchedLine)
+		if err != nil {
+			return nil, err
+		}
+		imageRef = parsedFrom.imageRef
+		prefix = prefixFROM
+		if extraArgs := strings.Join(parsedFrom.flags, " "); extraArgs != "" {
+			prefix += extraArgs + " "
+		}
+	} else if prefix = p.getYAMLKeyPrefix(matchedLine); prefix != "" {
+		imageRef = strings.TrimPrefix(matchedLine, prefix)
+	} else {
+		imageRef = matchedLine
+	}
+	if strings.HasPrefix(imageRef, prefixDockerTransport) {
+		imageRef = strings.TrimPrefix(imageRef, prefixDockerTransport)
+		prefix += prefixDockerTransport
+	}
+
+	imageRefWithTag, err := UnpinImageRef(imageRef, tag)
+	if err != nil {
+		return nil, err
+	}
+	imageRefWithTag.Prefix = prefix
+
+	return imageRefWithTag, nil
+}
+
+// UnpinImageRef reverts a container image reference pinned by its digest back
+// to the given tag. A tag embedded in the reference, i.e. name:tag@digest, takes
+// precedence over the provided one.
+func UnpinImageRef(imageRef, tag string) (*interfaces.EntityRef, error) {
+	// Only references pinned by a digest can be unpinned
+	if _, err := name.NewDigest(imageRef); err != nil {
+		return nil, fmt.Errorf("image not referenced by digest: %s %w", imageRef, interfaces.ErrReferenceSkipped)
+	}
+	repo, digest, _ := strings.Cut(imageRef, "@")
+
+	// Prefer the tag embedded in the reference, if any
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	if tag == "" {
+		return nil, fmt.Errorf("no tag found to unpin image: %s %w", imageRef, interfaces.ErrReferenceSkipped)
+	}
+
+	return &interfaces.EntityRef{
+		Name: repo,
+		Ref:  digest,
+		Type: ReferenceType,
+		Tag:  tag,
+	}, nil
+}
+
 // ConvertToEntityRef converts a container image reference to an EntityRef
-func (_ *Parser) ConvertToEntityRef(reference string) (*interfaces.EntityRef, error) {
-	reference = strings.TrimPrefix(reference, prefixImage)
+func (p *Parser) ConvertToEntityRef(reference string) (*interfaces.EntityRef, error) {
+	reference = strings.TrimPrefix(reference, p.getYAMLKeyPrefix(reference))
 	reference = strings.TrimPrefix(reference, prefixFROM)
+	reference = strings.TrimPrefix(reference, prefixDockerTransport)
 	var sep string
 	var frags []string
 	if strings.Contains(reference, "@") {
@@ -168,82 +429,519 @@ func (_ *Parser) ConvertToEntityRef(reference string) (*interfaces.EntityRef, er
 	}, nil
 }
 
+// getImageDigest returns the digest of a container image reference like
+// GetImageDigestFromRefWithConfig.
+// Concurrent lookups of the same reference, e.g. of files processed in parallel, share a
+// single resolution rather than all missing the cache and reaching the registry.
+func (p *Parser) getImageDigest(ctx context.Context, imageRef string, cfg *config.Config) (*interfaces.EntityRef, error) {
+	if p.logger != nil {
+		var platform *v1.Platform
+		if cfg.Platform != "" {
+			platform, _ = config.ParsePlatform(cfg.Platform)
+		}
+		store.LogLookup(ctx, p.logger, p.cache, digestCacheKey(imageRef, platform), imageRef)
+	}
+	v, err, _ := p.lookups.Do(imageRef+"#"+cfg.Platform, func() (any, error) {
+		if p.resolver != nil {
+			return GetImageDigestFromResolver(ctx, imageRef, cfg, p.cache, p.resolver)
+		}
+		return GetImageDigestFromRefWithConfig(ctx, imageRef, cfg, p.cache, p.remoteOpts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The result is shared, so each caller gets its own copy to modify
+	ref := *v.(*interfaces.EntityRef)
+	return &ref, nil
+}
+
 // GetImageDigestFromRef returns the digest of a container image reference
-// from a name.Reference.
-func GetImageDigestFromRef(ctx context.Context, imageRef, platform string, cache store.RefCacher) (*interfaces.EntityRef, error) {
+// from a name.Reference. The given remote options are applied on top of the
+// defaults, e.g. to authenticate using a different keychain.
+func GetImageDigestFromRef(
+	ctx context.Context,
+	imageRef, platform string,
+	cache store.RefCacher,
+	extraOpts ...remote.Option,
+) (*interfaces.EntityRef, error) {
+	return GetImageDigestFromRefWithConfig(ctx, imageRef, &config.Config{Platform: platform}, cache, extraOpts...)
+}
+
+// GetImageDigestFromRefWithConfig returns the digest of a container image reference like
+// GetImageDigestFromRef, for the platform of the given configuration and through its
+// registry mirrors, if any. A nil configuration resolves the reference as is, for the
+// default platform.
+func GetImageDigestFromRefWithConfig(
+	ctx context.Context,
+	imageRef string,
+	cfg *config.Config,
+	cache store.RefCacher,
+	extraOpts ...remote.Option,
+) (*interfaces.EntityRef, error) {
+	return getImageDigest(ctx, imageRef, cfg, cache,
+		func(ctx context.Context, ref name.Reference, platform *v1.Platform) (string, string, error) {
+			return fetchDigest(ctx, ref, platform, remoteOptions(ctx, extraOpts))
+		})
+}
+
+// GetImageDigestFromResolver returns the digest of a container image reference like
+// GetImageDigestFromRef, but asks the given resolver for it rather than the registry
+func GetImageDigestFromResolver(
+	ctx context.Context,
+	imageRef string,
+	cfg *config.Config,
+	cache store.RefCacher,
+	resolver interfaces.Resolver,
+) (*interfaces.EntityRef, error) {
+	return getImageDigest(ctx, imageRef, cfg, cache,
+		func(ctx context.Context, ref name.Reference, platform *v1.Platform) (string, string, error) {
+			var p string
+			if platform != nil {
+				p = platform.String()
+			}
+			return resolver.ResolveImageDigest(ctx, ref.Name(), p)
+		})
+}
+
+// digestFetcher returns the digest of the manifest the reference points at for the given
+// platform, if any, along with its media type
+type digestFetcher func(ctx context.Context, ref name.Reference, platform *v1.Platform) (string, string, error)
+
+// getImageDigest returns the digest of a container image reference fetched with the given
+// function, going through the registry mirrors and the cache
+func getImageDigest(
+	ctx context.Context,
+	imageRef string,
+	cfg *config.Config,
+	cache store.RefCacher,
+	fetch digestFetcher,
+) (*interfaces.EntityRef, error) {
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+
 	// Parse the image reference
 	ref, err := name.ParseReference(imageRef)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", interfaces.ErrInvalidReference, err)
 	}
-	opts := []remote.Option{
-		remote.WithContext(ctx),
-		remote.WithUserAgent(cli.UserAgent),
-		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+	// A reference pinned by a digest, i.e. name@digest or name:tag@digest, can only resolve
+	// to that digest so there's no need to ask the registry
+	if digest, ok := ref.(name.Digest); ok {
+		if cache != nil {
+			store.MarkUsed(cache, digest.DigestStr())
+		}
+		return nil, fmt.Errorf("image already referenced by digest: %s %w", imageRef, interfaces.ErrReferenceSkipped)
+	}
+	// Resolve the reference through a registry mirror, if one is configured
+	resolveRef, err := mirrorReference(ref, cfg.Images.RegistryMirrors)
+	if err != nil {
+		return nil, err
 	}
-
 	// Set the platform if provided
-	if platform != "" {
-		platformSplit := strings.Split(platform, "/")
-		if len(platformSplit) != 2 {
-			return nil, errors.New("platform must be in the format os/arch")
+	var platform *v1.Platform
+	if cfg.Platform != "" {
+		if platform, err = config.ParsePlatform(cfg.Platform); err != nil {
+			return nil, err
 		}
-		opts = append(opts, remote.WithPlatform(v1.Platform{
-			OS:           platformSplit[0],
-			Architecture: platformSplit[1],
-		}))
 	}
 
-	// Get the digest of the image reference
-	var digest string
-
+	// Get the digest of the image reference, along with the media type of its manifest
+	cacheKey := digestCacheKey(imageRef, platform)
+	var digest, mediaType string
 	if cache != nil {
-		if d, ok := cache.Load(imageRef); ok {
-			digest = d
-		} else {
-			desc, err := remote.Get(ref, opts...)
-			if err != nil {
-				return nil, err
-			}
-			digest = desc.Digest.String()
-			cache.Store(imageRef, digest)
+		if value, ok := cache.Load(cacheKey); ok {
+			digest, mediaType = store.SplitValue(value)
 		}
-	} else {
-		desc, err := remote.Get(ref, opts...)
+	}
+	if digest == "" {
+		digest, mediaType, err = fetch(ctx, resolveRef, platform)
 		if err != nil {
 			return nil, err
 		}
-		digest = desc.Digest.String()
+		if cache != nil {
+			cache.Store(cacheKey, store.JoinValue(digest, mediaType))
+		}
 	}
 
-	// Compare the digest with the reference and return the original reference if they already match
-	if digest == ref.Identifier() {
-		return nil, fmt.Errorf("image already referenced by digest: %s %w", imageRef, interfaces.ErrReferenceSkipped)
+	// Keep the original registry host in the output unless asked to rewrite it
+	rewritten := cfg.Images.RewriteRegistry && resolveRef.Context().RegistryStr() != ref.Context().RegistryStr()
+	if cfg.Images.RewriteRegistry {
+		ref = resolveRef
+	}
+	repo := ref.Context().Name()
+	// Keep the repository as written as well if asked to, e.g. golang rather than
+	// index.docker.io/library/golang, unless its registry host was rewritten
+	if cfg.Images.PreserveShortNames && !rewritten {
+		repo = writtenRepository(imageRef)
 	}
 
 	return &interfaces.EntityRef{
-		Name: ref.Context().Name(),
-		Ref:  digest,
-		Type: ReferenceType,
-		Tag:  ref.Identifier(),
+		Name:      repo,
+		Ref:       digest,
+		Type:      ReferenceType,
+		Tag:       ref.Identifier(),
+		MediaType: mediaType,
 	}, nil
 }
 
+// writtenRepository returns the repository of the image reference as written, i.e.
+// without its tag
+func writtenRepository(imageRef string) string {
+	if i := strings.LastIndex(imageRef, ":"); i > strings.LastIndex(imageRef, "/") {
+		return imageRef[:i]
+	}
+	return imageRef
+}
+
+// digestCacheKey returns the key the digest of the image reference is cached under. The
+// platform selects the image of multi-platform references, so it's part of the key.
+func digestCacheKey(imageRef string, platform *v1.Platform) string {
+	if platform == nil {
+		return imageRef
+	}
+	return imageRef + "#" + platform.String()
+}
+
+// fetchDigest returns the digest of the manifest the reference points at, along with its
+// media type. For a multi-platform index, it's the image of the given platform, if any.
+func fetchDigest(
+	ctx context.Context,
+	ref name.Reference,
+	platform *v1.Platform,
+	opts []remote.Option,
+) (string, string, error) {
+	// A HEAD request returns the digest and the media type without the manifest, which is
+	// all there's to know unless the image of a platform is picked out of an index. Some
+	// registries don't answer them properly though, e.g. without the digest header, so GET
+	// is tried next unless the registry turned the reference down, e.g. as not found.
+	if platform == nil {
+		desc, err := remote.Head(ref, opts...)
+		if err == nil {
+			return desc.Digest.String(), string(desc.MediaType), nil
+		}
+		var transportErr *transport.Error
+		if errors.As(err, &transportErr) && transportErr.StatusCode != http.StatusMethodNotAllowed {
+			return "", "", markTransient(ctx, err)
+		}
+	}
+
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return "", "", markTransient(ctx, err)
+	}
+	if platform == nil || !desc.MediaType.IsIndex() {
+		return desc.Digest.String(), string(desc.MediaType), nil
+	}
+
+	// The index manifest is part of the descriptor, no further request is needed
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return "", "", err
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return "", "", err
+	}
+	for _, m := range manifest.Manifests {
+		if m.Platform != nil && m.Platform.Satisfies(*platform) {
+			return m.Digest.String(), string(m.MediaType), nil
+		}
+	}
+	return "", "", fmt.Errorf("%w %s: %s", ErrPlatformNotFound, platform, ref)
+}
+
+// GetMediaType returns the media type of the manifest the image reference refers to, e.g.
+// an OCI image index for multi-platform images, asking the registry for it
+func GetMediaType(ctx context.Context, imageRef string, opts ...remote.Option) (string, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", interfaces.ErrInvalidReference, err)
+	}
+	_, mediaType, err := fetchDigest(ctx, ref, nil, remoteOptions(ctx, opts))
+	if err != nil {
+		return "", err
+	}
+	return mediaType, nil
+}
+
+// MediaType returns the media type of the manifest the listed image reference refers to
+// like GetMediaType, through the registry options of the parser
+func (p *Parser) MediaType(ctx context.Context, e interfaces.EntityRef) (string, error) {
+	sep := ":"
+	if strings.HasPrefix(e.Ref, "sha256:") {
+		sep = "@"
+	}
+	return GetMediaType(ctx, e.Name+sep+e.Ref, p.remoteOpts...)
+}
+
+// GetPlatforms returns the platforms the image reference is available for, along with
+// whether it points at a multi-platform index rather than a single image. A nil
+// configuration resolves the reference as is.
+func GetPlatforms(
+	ctx context.Context,
+	imageRef string,
+	cfg *config.Config,
+	extraOpts ...remote.Option,
+) ([]v1.Platform, bool, error) {
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", interfaces.ErrInvalidReference, err)
+	}
+	ref, err = mirrorReference(ref, cfg.Images.RegistryMirrors)
+	if err != nil {
+		return nil, false, err
+	}
+	desc, err := remote.Get(ref, remoteOptions(ctx, extraOpts)...)
+	if err != nil {
+		return nil, false, markTransient(ctx, err)
+	}
+
+	if !desc.MediaType.IsIndex() {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, false, err
+		}
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return nil, false, markTransient(ctx, err)
+		}
+		// Images built without setting their platform don't record it
+		if cf.Platform() == nil {
+			return nil, false, nil
+		}
+		return []v1.Platform{*cf.Platform()}, false, nil
+	}
+
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return nil, true, err
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, true, err
+	}
+	var platforms []v1.Platform
+	for _, m := range manifest.Manifests {
+		// Skip the attestation manifests, their platform is unknown/unknown
+		if m.Platform == nil || m.Platform.OS == "unknown" {
+			continue
+		}
+		platforms = append(platforms, *m.Platform)
+	}
+	return platforms, true, nil
+}
+
+// remoteOptions returns the default options to talk to registries followed by the given ones
+func remoteOptions(ctx context.Context, extraOpts []remote.Option) []remote.Option {
+	opts := []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithUserAgent(cli.UserAgent),
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+	}
+	return append(opts, extraOpts...)
+}
+
+// markTransient marks server errors, rate limiting and network errors of a
+// registry as transient, so they can be retried
+func markTransient(ctx context.Context, err error) error {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		if transportErr.StatusCode >= http.StatusInternalServerError ||
+			transportErr.StatusCode == http.StatusTooManyRequests {
+			return retry.Transient(err, 0)
+		}
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && ctx.Err() == nil {
+		return retry.Transient(err, 0)
+	}
+	return err
+}
+
+// mirrorReference returns the reference with its registry host replaced by the
+// configured mirror, or the reference itself if there's no mirror for its registry
+func mirrorReference(ref name.Reference, mirrors map[string]string) (name.Reference, error) {
+	for registry, mirror := range mirrors {
+		reg, err := name.NewRegistry(registry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry %s: %w", registry, err)
+		}
+		// Compare the normalized hosts, i.e. docker.io is index.docker.io
+		if reg.RegistryStr() != ref.Context().RegistryStr() {
+			continue
+		}
+
+		// RepositoryStr includes the implicit library/ namespace of Docker Hub images
+		repo, err := name.NewRepository(mirror + "/" + ref.Context().RepositoryStr())
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry mirror %s: %w", mirror, err)
+		}
+		if digest, ok := ref.(name.Digest); ok {
+			return repo.Digest(digest.DigestStr()), nil
+		}
+		return repo.Tag(ref.Identifier()), nil
+	}
+	return ref, nil
+}
+
 func shouldSkipImageRef(cfg *config.Config, ref string) bool {
+	_, skip := imageSkipReason(cfg, ref)
+	return skip
+}
+
+// skipImageRef returns an error matching ErrReferenceSkipped if the image reference of
+// the matched line should be skipped, typed as a SkippedError if the reason is known
+func skipImageRef(cfg *config.Config, matchedLine, ref string) error {
+	reason, skip := imageSkipReason(cfg, ref)
+	switch {
+	case !skip:
+		return nil
+	case reason == "":
+		return fmt.Errorf("image reference %s should be excluded - %w", matchedLine, interfaces.ErrReferenceSkipped)
+	default:
+		return &interfaces.SkippedError{Reference: matchedLine, Reason: reason}
+	}
+}
+
+// splitTransport splits the skopeo-style docker:// transport off the image reference, if
+// any. References using a local transport, e.g. an OCI layout, are skipped.
+func splitTransport(matchedLine, ref string) (string, string, error) {
+	if strings.HasPrefix(ref, prefixDockerTransport) {
+		return prefixDockerTransport, strings.TrimPrefix(ref, prefixDockerTransport), nil
+	}
+	// The transports of images stored locally rather than in a registry can't be pinned
+	// by a registry digest
+	for _, t := range []string{"oci:", "containers-storage:"} {
+		if strings.HasPrefix(ref, t) {
+			return "", ref, &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipLocalPath}
+		}
+	}
+	return "", ref, nil
+}
+
+// imageSkipReason returns true if the image reference should be skipped, along with the
+// reason why. The reason is empty for references which can't be parsed, e.g. templated ones.
+func imageSkipReason(cfg *config.Config, ref string) (interfaces.SkipReason, bool) {
+	// Variables, e.g. build arguments, are only known when building
+	if hasVariable(ref) {
+		return interfaces.SkipVariable, true
+	}
+
 	// Parse the image reference
 	nameRef, err := name.ParseReference(ref)
 	if err != nil {
 		// we wouldn't know how to resolve this reference, so let's skip
+		return "", true
+	}
+
+	if excludedImage(cfg.Images.ImageFilter, nameRef) {
+		return interfaces.SkipExcludedImage, true
+	}
+
+	tag := nameRef.Identifier()
+	if !slices.Contains(cfg.Images.ImageFilter.ExcludeTags, tag) {
+		return "", false
+	}
+	// References without a tag stand for the default one, i.e. latest
+	if !strings.HasSuffix(ref, ":"+tag) {
+		return interfaces.SkipNoTag, true
+	}
+	return interfaces.SkipExcludedTag, true
+}
+
+// SkipReason returns the reason the reference of the matched line would be skipped for
+// when replacing it, without resolving it, or an empty reason if it wouldn't be skipped
+// or its reason is unknown
+func (p *Parser) SkipReason(matchedLine string, cfg config.Config) interfaces.SkipReason {
+	ref := matchedLine
+	if strings.HasPrefix(matchedLine, prefixFROM) {
+		parsedFrom, err := getRefFromDockerfileFROM(matchedLine)
+		if err != nil {
+			return ""
+		}
+		ref = parsedFrom.imageRef
+	} else if keyPrefix := p.getYAMLKeyPrefix(matchedLine); keyPrefix != "" {
+		ref = strings.TrimPrefix(matchedLine, keyPrefix)
+	} else {
+		return ""
+	}
+	_, ref, err := splitTransport(matchedLine, p.expandEnv(ref))
+	if err != nil {
+		return interfaces.GetSkipReason(err)
+	}
+	reason, _ := imageSkipReason(&cfg, ref)
+	return reason
+}
+
+// excludedImage returns true if the image isn't one of the included images, if any, or
+// is one of the excluded ones
+func excludedImage(filter config.ImageFilter, nameRef name.Reference) bool {
+	if len(filter.IncludeImages) > 0 && !matchImageName(filter.IncludeImages, nameRef) {
 		return true
 	}
+	return matchImageName(filter.ExcludeImages, nameRef)
+}
+
+// matchImageName returns true if any of the patterns matches the short image name,
+// e.g. ubuntu, the repository, e.g. library/ubuntu, or the fully qualified name,
+// e.g. index.docker.io/library/ubuntu
+func matchImageName(patterns []string, nameRef name.Reference) bool {
+	return config.MatchAny(patterns, getImageNameFromRef(nameRef)) ||
+		config.MatchAny(patterns, nameRef.Context().RepositoryStr()) ||
+		config.MatchAny(patterns, nameRef.Context().Name())
+}
+
+// ParentKeys returns the keys the name key of GitLab CI must be nested under to reference
+// an image, i.e. the image mapping or the services list. The other references are images
+// wherever they are.
+func (*Parser) ParentKeys(matchedLine string) []string {
+	if strings.HasPrefix(matchedLine, prefixName) {
+		return []string{"image", "services"}
+	}
+	return nil
+}
 
-	imageName := getImageNameFromRef(nameRef)
-	if slices.Contains(cfg.Images.ImageFilter.ExcludeImages, imageName) {
+// KeepMatch returns false if the container key matched at the offset of the line is
+// only the end of a longer key, e.g. init-container, which the word boundary of the
+// regular expression pattern doesn't rule out as it holds a hyphen or a dot
+func (*Parser) KeepMatch(line string, start int) bool {
+	if start == 0 || !strings.HasPrefix(line[start:], "container") {
 		return true
 	}
+	return !strings.ContainsRune("-.", rune(line[start-1]))
+}
 
-	tag := nameRef.Identifier()
-	return slices.Contains(cfg.Images.ImageFilter.ExcludeTags, tag)
+// getYAMLKeyPrefix returns the YAML key prefix of the matched line, if any.
+// Besides the plain image key, GitLab CI references images through a name key
+// in both the image mapping and the services list, while GitHub Actions and Azure
+// Pipelines jobs reference them through a container key. The keys set through
+// SetImageKeys are recognized as well, along with the INI-style Image key of Podman
+// quadlet units and the Compose build args set through SetBuildArgKeys.
+func (p *Parser) getYAMLKeyPrefix(line string) string {
+	for _, prefix := range []string{prefixImage, prefixName, prefixContainer, prefixPipe, prefixQuadletImage} {
+		if strings.HasPrefix(line, prefix) {
+			return prefix
+		}
+	}
+	for _, key := range p.keys {
+		if prefix := key + ": "; strings.HasPrefix(line, prefix) {
+			return prefix
+		}
+	}
+	for _, key := range p.buildArgKeys {
+		for _, prefix := range []string{key + ": ", "- " + key + "="} {
+			if strings.HasPrefix(line, prefix) {
+				return prefix
+			}
+		}
+	}
+	return ""
 }
 
 // TODO(jakub): this is a bit of a hack, but I didn't find a better way to get just the name
@@ -259,24 +957,96 @@ func getImageNameFromRef(nameRef name.Reference) string {
 func getRefFromDockerfileFROM(line string) (unresolvedImage, error) {
 	parseResult, err := dockerparser.Parse(strings.NewReader(line))
 	if err != nil {
-		return unresolvedImage{}, fmt.Errorf("failed to parse Dockerfile line: %w", err)
+		return unresolvedImage{}, fmt.Errorf("failed to parse Dockerfile line: %w: %w", interfaces.ErrInvalidReference, err)
 	}
 
 	if len(parseResult.AST.Children) == 0 ||
 		parseResult.AST.Children[0] == nil ||
 		strings.ToUpper(parseResult.AST.Children[0].Value) != "FROM" {
-		return unresolvedImage{}, errors.New("invalid Dockerfile line: the first parsed node is not FROM")
+		return unresolvedImage{}, fmt.Errorf("%w: the first parsed node of the Dockerfile line is not FROM",
+			interfaces.ErrInvalidReference)
 	}
 
 	fromNode := parseResult.AST.Children[0]
 
 	imgNode := parseResult.AST.Children[0].Next
 	if imgNode == nil {
-		return unresolvedImage{}, errors.New("invalid Dockerfile line: no image node found")
+		return unresolvedImage{}, fmt.Errorf("%w: no image node found in the Dockerfile line", interfaces.ErrInvalidReference)
+	}
+
+	// The optional AS keyword names the build stage for later instructions to refer to
+	var stage string
+	if asNode := imgNode.Next; asNode != nil && strings.EqualFold(asNode.Value, "AS") && asNode.Next != nil {
+		stage = asNode.Next.Value
 	}
 
 	return unresolvedImage{
 		imageRef: imgNode.Value,
 		flags:    fromNode.Flags,
+		stage:    stage,
 	}, nil
 }
+
+// hasVariable returns true if the image reference holds a variable left to expand,
+// in either the $VAR or the ${VAR} form
+func hasVariable(ref string) bool {
+	return strings.Contains(ref, "$")
+}
+
+// expandEnv expands the variables of the image reference set through SetEnvLookup, if any
+func (p *Parser) expandEnv(ref string) string {
+	if p.lookupEnv == nil {
+		return ref
+	}
+	return ExpandVariables(ref, p.lookupEnv)
+}
+
+// variableRegex matches the variables in either the ${VAR} or the $VAR form
+var variableRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// ExpandVariables expands the $VAR and ${VAR} variables of the reference known to the
+// given lookup function, e.g. os.LookupEnv. Unlike os.Expand, the unknown variables are
+// left as is rather than expanded to an empty string.
+func ExpandVariables(ref string, lookup func(string) (string, bool)) string {
+	if !hasVariable(ref) {
+		return ref
+	}
+	return variableRegex.ReplaceAllStringFunc(ref, func(v string) string {
+		m := variableRegex.FindStringSubmatch(v)
+		name := m[1]
+		if name == "" {
+			name = m[2]
+		}
+		if value, ok := lookup(name); ok {
+			return value
+		}
+		return v
+	})
+}
+
+// DefinedStage returns the name of the build stage defined by the Dockerfile line, i.e.
+// FROM image AS name, if any. Stage names are case-insensitive, so it's lowercased.
+func (*Parser) DefinedStage(line string) string {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, prefixFROM) {
+		return ""
+	}
+	parsedFrom, err := getRefFromDockerfileFROM(line)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsedFrom.stage)
+}
+
+// UsedStage returns the name of the build stage the FROM instruction of the matched line
+// builds upon, should its image be named after a stage defined earlier in the Dockerfile
+func (*Parser) UsedStage(matchedLine string) string {
+	if !strings.HasPrefix(matchedLine, prefixFROM) {
+		return ""
+	}
+	parsedFrom, err := getRefFromDockerfileFROM(matchedLine)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsedFrom.imageRef)
+}
@@ -2,8 +2,26 @@ package image
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/stretchr/testify/require"
 
 	"github.com/stacklok/frizbee/pkg/interfaces"
@@ -168,6 +186,7 @@ func TestConvertToEntityRef(t *testing.T) {
 		{"Valid container reference with tag", "ghcr.io/stacklok/minder/helm/minder:0.20231123.829_ref.26ca90b", false},
 		{"Valid container reference with digest", "ghcr.io/stacklok/minder/helm/minder@sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec", false},
 		{"Invalid reference format", "invalid:reference:format", true},
+		{"Docker transport", "docker://nginx:1.25", false},
 	}
 
 	for _, tt := range tests {
@@ -235,6 +254,415 @@ func TestGetImageDigestFromRef(t *testing.T) {
 	}
 }
 
+func TestGetImageDigestFromRefCache(t *testing.T) {
+	t.Parallel()
+
+	// Count the manifest lookups served by the registry
+	var fetches atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			fetches.Add(1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	refstr := strings.TrimPrefix(srv.URL, "http://") + "/cached:1.0.0"
+	want := pushRandomImage(t, refstr)
+	fetches.Store(0)
+
+	ctx := context.Background()
+	cache := store.NewRefCacher()
+	for i := 0; i < 3; i++ {
+		got, err := GetImageDigestFromRef(ctx, refstr, "", cache)
+		require.NoError(t, err)
+		require.Equal(t, want, got.Ref)
+	}
+	require.Equal(t, int32(1), fetches.Load(), "subsequent lookups should be served from the cache")
+
+	// Without a cache every lookup hits the registry
+	_, err := GetImageDigestFromRef(ctx, refstr, "", nil)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), fetches.Load())
+}
+
+func TestReplaceConcurrentLookups(t *testing.T) {
+	t.Parallel()
+
+	// Count the manifest lookups served by the registry, holding them until released
+	var fetches atomic.Int32
+	var hold atomic.Bool
+	release := make(chan struct{})
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			fetches.Add(1)
+			if hold.Load() {
+				<-release
+			}
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	refstr := strings.TrimPrefix(srv.URL, "http://") + "/herd:1.0.0"
+	want := pushRandomImage(t, refstr)
+	fetches.Store(0)
+	hold.Store(true)
+
+	const lookups = 20
+	parser := New()
+	var wg sync.WaitGroup
+	results := make([]*interfaces.EntityRef, lookups)
+	errs := make([]error, lookups)
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = parser.Replace(context.Background(), refstr, nil, config.Config{})
+		}(i)
+	}
+
+	// Give the other lookups time to pile up behind the first one
+	require.Eventually(t, func() bool { return fetches.Load() > 0 }, 5*time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := 0; i < lookups; i++ {
+		require.NoError(t, errs[i])
+		require.Equal(t, want, results[i].Ref)
+	}
+	require.Equal(t, int32(1), fetches.Load(), "concurrent lookups of the same image should share a single fetch")
+}
+
+func TestGetImageDigestFromRefPinned(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost && r.Method != http.MethodPatch {
+			requests.Add(1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	repo := strings.TrimPrefix(srv.URL, "http://") + "/pinned"
+	digest := pushRandomImage(t, repo+":1.0.0")
+	requests.Store(0)
+
+	for _, refstr := range []string{repo + "@" + digest, repo + ":1.0.0@" + digest} {
+		got, err := GetImageDigestFromRef(context.Background(), refstr, "", nil)
+		require.ErrorIs(t, err, interfaces.ErrReferenceSkipped, refstr)
+		require.Nil(t, got)
+	}
+	require.Zero(t, requests.Load(), "references pinned by digest shouldn't be resolved")
+}
+
+func TestGetImageDigestFromRefPlatform(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newTestRegistry(t)
+	refstr := host + "/multi:1.0.0"
+	digests := pushIndex(t, refstr, "linux/amd64", "linux/arm64", "linux/arm/v6", "linux/arm/v7")
+
+	tests := []struct {
+		name     string
+		platform string
+		want     string
+		wantErr  error
+	}{
+		{name: "no platform", want: digests[""]},
+		{name: "amd64", platform: "linux/amd64", want: digests["linux/amd64"]},
+		{name: "arm64", platform: "linux/arm64", want: digests["linux/arm64"]},
+		{name: "arm variant", platform: "linux/arm/v7", want: digests["linux/arm/v7"]},
+		{name: "missing platform", platform: "windows/amd64", wantErr: ErrPlatformNotFound},
+		{name: "missing variant", platform: "linux/arm/v5", wantErr: ErrPlatformNotFound},
+		{name: "malformed platform", platform: "linux/amd64/v1/x", wantErr: config.ErrInvalidPlatform},
+	}
+
+	// The cache is shared, the platform must be part of the key
+	cache := store.NewRefCacher()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetImageDigestFromRef(context.Background(), refstr, tt.platform, cache)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got.Ref)
+		})
+	}
+}
+
+// manifestRequests serves a registry counting the requests to its manifests by method.
+// HEAD requests to the manifests are rejected once rejectHead is set, as some registries do.
+func manifestRequests(tb testing.TB) (host string, requests *sync.Map, rejectHead *atomic.Bool) {
+	tb.Helper()
+
+	requests, rejectHead = &sync.Map{}, &atomic.Bool{}
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			n, _ := requests.LoadOrStore(r.Method, new(atomic.Int32))
+			n.(*atomic.Int32).Add(1)
+			if rejectHead.Load() && r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	tb.Cleanup(srv.Close)
+
+	return strings.TrimPrefix(srv.URL, "http://"), requests, rejectHead
+}
+
+// countRequests returns the number of requests to the manifests with the given method
+func countRequests(requests *sync.Map, method string) int32 {
+	n, ok := requests.Load(method)
+	if !ok {
+		return 0
+	}
+	return n.(*atomic.Int32).Load()
+}
+
+func TestGetImageDigestFromRefHead(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		platform     string
+		tag          string
+		headRejected bool
+		wantHead     int32
+		wantGet      int32
+		wantErr      bool
+	}{
+		{name: "digest only", tag: "1.0.0", wantHead: 1},
+		{name: "head rejected", tag: "1.0.0", headRejected: true, wantHead: 1, wantGet: 1},
+		{name: "image of a platform", tag: "1.0.0", platform: "linux/arm64", wantGet: 1},
+		{name: "not found", tag: "2.0.0", wantHead: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			host, requests, rejectHead := manifestRequests(t)
+			digests := pushIndex(t, host+"/multi:1.0.0", "linux/amd64", "linux/arm64")
+			requests.Clear()
+			rejectHead.Store(tt.headRejected)
+
+			got, err := GetImageDigestFromRef(context.Background(), host+"/multi:"+tt.tag, tt.platform, nil)
+			require.Equal(t, tt.wantHead, countRequests(requests, http.MethodHead))
+			require.Equal(t, tt.wantGet, countRequests(requests, http.MethodGet))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, digests[tt.platform], got.Ref)
+			if tt.platform == "" {
+				require.Equal(t, string(types.OCIImageIndex), got.MediaType, "The media type is returned by HEAD requests")
+			}
+		})
+	}
+}
+
+// BenchmarkGetImageDigestFromRef compares resolving the digest of a large multi-platform
+// index with a HEAD request to fetching its manifest, as for registries rejecting HEAD
+// requests
+func BenchmarkGetImageDigestFromRef(b *testing.B) {
+	platforms := make([]string, 0, 64)
+	for i := 0; i < 64; i++ {
+		platforms = append(platforms, fmt.Sprintf("linux/arch%d", i))
+	}
+
+	for _, bm := range []struct {
+		name         string
+		headRejected bool
+	}{
+		{"Head", false},
+		{"Get", true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			host, _, rejectHead := manifestRequests(b)
+			refstr := host + "/multi:1.0.0"
+			pushIndex(b, refstr, platforms...)
+			rejectHead.Store(bm.headRejected)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := GetImageDigestFromRef(context.Background(), refstr, "", nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetImageDigestFromRefMediaType(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newTestRegistry(t, "single:1.0.0")
+	pushIndex(t, host+"/multi:1.0.0", "linux/amd64", "linux/arm64")
+
+	tests := []struct {
+		name     string
+		refstr   string
+		platform string
+		want     types.MediaType
+	}{
+		{name: "multi-platform index", refstr: host + "/multi:1.0.0", want: types.OCIImageIndex},
+		{name: "image of an index", refstr: host + "/multi:1.0.0", platform: "linux/arm64", want: types.DockerManifestSchema2},
+		{name: "single image", refstr: host + "/single:1.0.0", want: types.DockerManifestSchema2},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The media type is cached along with the digest
+			cache := store.NewRefCacher()
+			for i := 0; i < 2; i++ {
+				got, err := GetImageDigestFromRef(context.Background(), tt.refstr, tt.platform, cache)
+				require.NoError(t, err)
+				require.Equal(t, string(tt.want), got.MediaType)
+				require.Equal(t, tt.platform == "" && tt.refstr == host+"/multi:1.0.0", types.MediaType(got.MediaType).IsIndex())
+				if tt.platform == "" {
+					value, ok := cache.Load(tt.refstr)
+					require.True(t, ok)
+					require.Equal(t, got.Ref+" "+string(tt.want), value, "a single entry holds both")
+				}
+			}
+
+			// Asked for the listed references, which aren't resolved
+			if tt.platform == "" {
+				got, err := GetMediaType(context.Background(), tt.refstr)
+				require.NoError(t, err)
+				require.Equal(t, string(tt.want), got)
+			}
+		})
+	}
+}
+
+func TestGetPlatforms(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newTestRegistry(t)
+	pushIndex(t, host+"/multi:1.0.0", "linux/amd64", "linux/arm/v7")
+
+	single, err := random.Image(64, 1)
+	require.NoError(t, err)
+	single, err = mutate.ConfigFile(single, &v1.ConfigFile{OS: "linux", Architecture: "arm64"})
+	require.NoError(t, err)
+	ref, err := name.ParseReference(host + "/single:1.0.0")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, single))
+
+	platforms, isIndex, err := GetPlatforms(context.Background(), host+"/multi:1.0.0", nil)
+	require.NoError(t, err)
+	require.True(t, isIndex)
+	require.Equal(t, []v1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+	}, platforms)
+
+	platforms, isIndex, err = GetPlatforms(context.Background(), host+"/single:1.0.0", nil)
+	require.NoError(t, err)
+	require.False(t, isIndex)
+	require.Equal(t, []v1.Platform{{OS: "linux", Architecture: "arm64"}}, platforms)
+
+	_, _, err = GetPlatforms(context.Background(), host+"/missing:1.0.0", nil)
+	require.Error(t, err)
+}
+
+func TestGetImageDigestFromRefMirror(t *testing.T) {
+	t.Parallel()
+
+	mirror, digests := newTestRegistry(t, "library/app:v1.0.0")
+
+	tests := []struct {
+		name     string
+		refstr   string
+		images   config.Images
+		wantName string
+		wantErr  bool
+	}{
+		{
+			name:     "resolve through mirror",
+			refstr:   "app:v1.0.0",
+			images:   config.Images{RegistryMirrors: map[string]string{"docker.io": mirror}},
+			wantName: "index.docker.io/library/app",
+		},
+		{
+			name:   "resolve through mirror and rewrite registry",
+			refstr: "docker.io/library/app:v1.0.0",
+			images: config.Images{
+				RegistryMirrors: map[string]string{"index.docker.io": mirror},
+				RewriteRegistry: true,
+			},
+			wantName: mirror + "/library/app",
+		},
+		{
+			name:   "preserve short name",
+			refstr: "app:v1.0.0",
+			images: config.Images{
+				RegistryMirrors:    map[string]string{"docker.io": mirror},
+				PreserveShortNames: true,
+			},
+			wantName: "app",
+		},
+		{
+			name:   "preserve short name unless rewritten",
+			refstr: "app:v1.0.0",
+			images: config.Images{
+				RegistryMirrors:    map[string]string{"docker.io": mirror},
+				RewriteRegistry:    true,
+				PreserveShortNames: true,
+			},
+			wantName: mirror + "/library/app",
+		},
+		{
+			name:     "no mirror for registry",
+			refstr:   mirror + "/library/app:v1.0.0",
+			images:   config.Images{RegistryMirrors: map[string]string{"ghcr.io": "ghcr.invalid"}},
+			wantName: mirror + "/library/app",
+		},
+		{
+			name:    "invalid mirror",
+			refstr:  "app:v1.0.0",
+			images:  config.Images{RegistryMirrors: map[string]string{"docker.io": "in valid"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := GetImageDigestFromRefWithConfig(context.Background(), tt.refstr, &config.Config{Images: tt.images}, nil)
+			if tt.wantErr {
+				require.Error(t, err)
+				require.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantName, got.Name)
+			require.Equal(t, digests["library/app:v1.0.0"], got.Ref)
+			require.Equal(t, "v1.0.0", got.Tag)
+		})
+	}
+}
+
 func TestShouldSkipImage(t *testing.T) {
 	t.Parallel()
 
@@ -271,3 +699,545 @@ func TestShouldSkipImage(t *testing.T) {
 		})
 	}
 }
+
+func TestShouldSkipImagePatterns(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{
+		Images: config.Images{
+			ImageFilter: config.ImageFilter{
+				ExcludeImages: []string{"busybox", "ghcr.io/stacklok/*", "*/minder-*", "[invalid"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		ref  string
+		skip bool
+	}{
+		{"Exact short name", "busybox:1.36", true},
+		{"Registry and org pattern", "ghcr.io/stacklok/frizbee:v0.1.0", true},
+		{"Registry and org pattern doesn't match other orgs", "ghcr.io/other/frizbee:v0.1.0", false},
+		{"Repository pattern", "stacklok/minder-server:v1.0.0", true},
+		{"Repository pattern doesn't match other images", "stacklok/frizbee:v0.1.0", false},
+		{"Unrelated image", "ubuntu:22.04", false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.skip, shouldSkipImageRef(cfg, tt.ref))
+		})
+	}
+}
+
+func TestShouldSkipImageIncludes(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{
+		Images: config.Images{
+			ImageFilter: config.ImageFilter{
+				IncludeImages: []string{"ghcr.io/stacklok/*", "nginx"},
+				ExcludeImages: []string{"ghcr.io/stacklok/legacy"},
+				ExcludeTags:   []string{"latest"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		ref  string
+		skip bool
+	}{
+		{"Included by pattern", "ghcr.io/stacklok/minder:v1.0.0", false},
+		{"Included by name", "nginx:1.25", false},
+		{"Not included", "ubuntu:22.04", true},
+		{"Included but excluded image", "ghcr.io/stacklok/legacy:v0.1.0", true},
+		{"Included but excluded tag", "nginx:latest", true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.skip, shouldSkipImageRef(cfg, tt.ref))
+		})
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{
+		Images: config.Images{
+			ImageFilter: config.ImageFilter{
+				ExcludeImages: []string{"scratch"},
+				ExcludeTags:   []string{"latest"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		matchedLine string
+		reason      interfaces.SkipReason
+	}{
+		{"Excluded tag", "image: ubuntu:latest", interfaces.SkipExcludedTag},
+		{"No tag", "image: ubuntu", interfaces.SkipNoTag},
+		{"Excluded image", "FROM scratch", interfaces.SkipExcludedImage},
+		{"Excluded image with flags", "FROM --platform=linux/amd64 scratch", interfaces.SkipExcludedImage},
+		{"Not skipped", "image: ubuntu:22.04", ""},
+		{"Templated", "image: ubuntu:${VERSION}", interfaces.SkipVariable},
+		{"Build argument", "FROM $BASE_IMAGE", interfaces.SkipVariable},
+		{"Braced build arguments", "FROM ${REGISTRY}/app:${TAG}", interfaces.SkipVariable},
+		{"Docker transport", "image: docker://ubuntu:latest", interfaces.SkipExcludedTag},
+		{"OCI layout transport", "image: oci:/tmp/layout:v1", interfaces.SkipLocalPath},
+		{"Containers storage transport", "image: containers-storage:localhost/app:v1", interfaces.SkipLocalPath},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := New()
+			require.Equal(t, tt.reason, p.SkipReason(tt.matchedLine, cfg))
+			if tt.reason == "" {
+				return
+			}
+			// Replace gives up before resolving the reference, with the same reason
+			_, err := p.Replace(context.Background(), tt.matchedLine, nil, cfg)
+			require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+			require.Equal(t, tt.reason, interfaces.GetSkipReason(err))
+		})
+	}
+}
+
+func TestExpandVariables(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{"REGISTRY": "ghcr.io/stacklok", "TAG": "1.2.3", "EMPTY": ""}
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{"No variables", "ubuntu:22.04", "ubuntu:22.04"},
+		{"Braced variable", "${REGISTRY}/app:1.2.3", "ghcr.io/stacklok/app:1.2.3"},
+		{"Plain variables", "$REGISTRY/app:$TAG", "ghcr.io/stacklok/app:1.2.3"},
+		{"Set to empty", "app${EMPTY}:1.2.3", "app:1.2.3"},
+		{"Unset variables are left as is", "${UNSET}/app:$TAG", "${UNSET}/app:1.2.3"},
+		{"Unset plain variable", "$UNSET/app:1.2.3", "$UNSET/app:1.2.3"},
+		{"Not a variable", "app:$1", "app:$1"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expected, ExpandVariables(tt.ref, lookup))
+		})
+	}
+}
+
+func TestSkipReasonEnvExpansion(t *testing.T) {
+	t.Parallel()
+
+	p := New()
+	p.SetEnvLookup(func(key string) (string, bool) {
+		if key == "TAG" {
+			return "latest", true
+		}
+		return "", false
+	})
+	cfg := config.Config{Images: config.Images{ImageFilter: config.ImageFilter{ExcludeTags: []string{"latest"}}}}
+
+	// The references are classified once expanded, the unset variables are left to skip
+	require.Equal(t, interfaces.SkipExcludedTag, p.SkipReason("image: ubuntu:${TAG}", cfg))
+	require.Equal(t, interfaces.SkipVariable, p.SkipReason("FROM ${REGISTRY}/ubuntu:${TAG}", cfg))
+}
+
+func TestDockerfileStages(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		line    string
+		defined string
+		used    string
+	}{
+		{"Named stage", "FROM golang:1.22 AS builder", "builder", "golang:1.22"},
+		{"Lowercase keyword and mixed case name", "FROM golang:1.22 as Builder", "builder", "golang:1.22"},
+		{"Indented with flags", "  FROM --platform=$BUILDPLATFORM golang:1.22 AS builder", "builder", ""},
+		{"Stage reuse", "FROM Builder", "", "builder"},
+		{"Unnamed stage", "FROM alpine:3.20", "", "alpine:3.20"},
+		{"Not a FROM instruction", "COPY --from=builder /app /app", "", ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := New()
+			require.Equal(t, tt.defined, p.DefinedStage(tt.line))
+			require.Equal(t, tt.used, p.UsedStage(tt.line))
+		})
+	}
+}
+
+func TestIsDockerfileRef(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		ref  interfaces.EntityRef
+		want bool
+	}{
+		{"FROM instruction", interfaces.EntityRef{Type: ReferenceType, Prefix: "FROM "}, true},
+		{"FROM instruction with flags", interfaces.EntityRef{Type: ReferenceType, Prefix: "FROM --platform=linux/amd64 "}, true},
+		{"YAML key", interfaces.EntityRef{Type: ReferenceType, Prefix: "image: "}, false},
+		{"Image named after FROM", interfaces.EntityRef{Name: "FROM", Type: ReferenceType, Prefix: "image: "}, false},
+		{"Quadlet Image key", interfaces.EntityRef{Type: ReferenceType, Prefix: "Image="}, false},
+		{"Action", interfaces.EntityRef{Type: "action", Prefix: "FROM "}, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, IsDockerfileRef(&tt.ref))
+		})
+	}
+}
+
+func TestUnpinImageRef(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		ref      string
+		tag      string
+		wantName string
+		wantTag  string
+		wantErr  bool
+	}{
+		{
+			name:     "Digest with tag comment",
+			ref:      "ghcr.io/stacklok/minder/server@sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec",
+			tag:      "v1.0.0",
+			wantName: "ghcr.io/stacklok/minder/server",
+			wantTag:  "v1.0.0",
+		},
+		{
+			name:     "Embedded tag takes precedence",
+			ref:      "localhost:5000/golang:1.22.2@sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec",
+			tag:      "other",
+			wantName: "localhost:5000/golang",
+			wantTag:  "1.22.2",
+		},
+		{
+			name:    "Digest without a tag",
+			ref:     "localhost:5000/golang@sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec",
+			wantErr: true,
+		},
+		{
+			name:    "Not pinned by digest",
+			ref:     "golang:1.22.2",
+			tag:     "1.22.2",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := UnpinImageRef(tt.ref, tt.tag)
+			if tt.wantErr {
+				require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantName, got.Name)
+			require.Equal(t, tt.wantTag, got.Tag)
+		})
+	}
+}
+
+func TestContainerImageRegex(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"Image key", "    image: nginx:1.25", []string{"image: nginx:1.25"}},
+		{"Dockerfile FROM", "FROM golang:1.22.2 AS builder", []string{"FROM golang:1.22.2"}},
+		{"GitLab CI image mapping name", "    name: ruby:3.1", []string{"name: ruby:3.1"}},
+		{"GitLab CI services list name", "  - name: redis:6", []string{"name: redis:6"}},
+		{"Name without a tag", "  - name: kube-apiserver", nil},
+		{"Name with spaces", "    - name: Run the build: linux", nil},
+		{"Key ending in name", "    hostname: db:5432", nil},
+		{"Azure Pipelines container", "  container: ubuntu:22.04", []string{"container: ubuntu:22.04"}},
+		{"Azure Pipelines container without a tag", "  container: mcr.microsoft.com/dotnet/sdk", []string{"container: mcr.microsoft.com/dotnet/sdk"}},
+		{"Azure Pipelines container alias", "  container: linux", nil},
+		{"Azure Pipelines container variable", "  container: $(buildImage)", nil},
+		{"Container mapping", "    container:", nil},
+		{"Key ending in container", "    sidecar_container: envoy:1.30", nil},
+		{"Quadlet Image key", "Image=docker.io/library/nginx:1.25", []string{"Image=docker.io/library/nginx:1.25"}},
+		{"Quadlet key ending in Image", "ContainerImage=nginx:1.25", nil},
+		{"Quadlet environment", "Environment=Image=nginx:1.25", nil},
+		{"Bitbucket Pipelines pipe", "  - pipe: atlassian/aws-s3-deploy:1.1.0", []string{"pipe: atlassian/aws-s3-deploy:1.1.0"}},
+		{"Bitbucket Pipelines pipe with the docker transport", "  - pipe: docker://acme/notify-pipe:2.0", []string{"pipe: docker://acme/notify-pipe:2.0"}},
+		{"Key ending in pipe", "    data_pipe: kafka:9092", nil},
+	}
+
+	re := regexp.MustCompile(ContainerImageRegex)
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, re.FindAllString(tt.line, -1))
+		})
+	}
+}
+
+func TestParser_KeepMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"Container key", "    container: ubuntu:22.04", []string{"container: ubuntu:22.04"}},
+		{"Container key in a sequence", "  - container: ubuntu:22.04", []string{"container: ubuntu:22.04"}},
+		{"Hyphenated key ending in container", "    init-container: envoy:1.30", nil},
+		{"Dotted key ending in container", "    sidecar.container: envoy:1.30", nil},
+		{"Image key ending in container", "    image: acme/container:1.0", []string{"image: acme/container:1.0"}},
+	}
+
+	p := New()
+	re := regexp.MustCompile(p.GetRegex())
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			for _, match := range re.FindAllStringIndex(tt.line, -1) {
+				if p.KeepMatch(tt.line, match[0]) {
+					got = append(got, tt.line[match[0]:match[1]])
+				}
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestContainerImageRegexWithKeys(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, ContainerImageRegex, ContainerImageRegexWithKeys(nil))
+
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"Custom key", "    sandbox_image: registry.k8s.io/pause:3.9", []string{"sandbox_image: registry.k8s.io/pause:3.9"}},
+		{"Custom key prefixing another one", "    initImage: busybox:1.36", []string{"initImage: busybox:1.36"}},
+		{"Custom key with regex characters", "    jaeger.image: jaegertracing/all-in-one:1.57", []string{"jaeger.image: jaegertracing/all-in-one:1.57"}},
+		{"Image key", "    image: nginx:1.25", []string{"image: nginx:1.25"}},
+		{"Dockerfile FROM", "FROM golang:1.22.2 AS builder", []string{"FROM golang:1.22.2"}},
+		{"Unknown key", "    sidecarImage: envoy:1.30", nil},
+	}
+
+	re := regexp.MustCompile(ContainerImageRegexWithKeys([]string{"sandbox_image", "init", "initImage", "jaeger.image"}))
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, re.FindAllString(tt.line, -1))
+		})
+	}
+}
+
+func TestSetBuildArgKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"Mapping form", "        BASE_IMAGE: node:18", []string{"BASE_IMAGE: node:18"}},
+		{"List form", "        - BASE_IMAGE=node:18", []string{"- BASE_IMAGE=node:18"}},
+		{"Other build arg", "        - VERSION=1.2.3", nil},
+		{"Key ending in a build arg", "        - MY_BASE_IMAGE=node:18", nil},
+		{"Dockerfile ARG", "ARG BASE_IMAGE=node:18", nil},
+		{"Image key", "    image: nginx:1.25", []string{"image: nginx:1.25"}},
+	}
+
+	p := New()
+	p.SetImageKeys([]string{"sandbox_image"})
+	p.SetBuildArgKeys([]string{"BASE_IMAGE", "BUILDER_IMAGE"})
+	re := regexp.MustCompile(p.GetRegex())
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, re.FindAllString(tt.line, -1))
+		})
+	}
+	require.Equal(t, []string{"sandbox_image: registry.k8s.io/pause:3.9"},
+		re.FindAllString("    sandbox_image: registry.k8s.io/pause:3.9", -1), "the image keys are still matched")
+	require.Equal(t, "- BASE_IMAGE=", p.getYAMLKeyPrefix("- BASE_IMAGE=node:18"))
+	require.Equal(t, "BUILDER_IMAGE: ", p.getYAMLKeyPrefix("BUILDER_IMAGE: golang:1.22"))
+}
+
+func TestReplaceYAMLKeys(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "redis:6", "ruby:3.1")
+
+	tests := []struct {
+		name        string
+		matchedLine string
+		wantPrefix  string
+		wantTag     string
+		wantDigest  string
+	}{
+		{"Image key", "image: " + host + "/ruby:3.1", "image: ", "3.1", digests["ruby:3.1"]},
+		{"GitLab CI image mapping name", "name: " + host + "/ruby:3.1", "name: ", "3.1", digests["ruby:3.1"]},
+		{"GitLab CI services list name", "name: " + host + "/redis:6", "name: ", "6", digests["redis:6"]},
+		{"Custom image key", "sandbox_image: " + host + "/ruby:3.1", "sandbox_image: ", "3.1", digests["ruby:3.1"]},
+		{"Azure Pipelines container", "container: " + host + "/redis:6", "container: ", "6", digests["redis:6"]},
+		{"Bitbucket Pipelines pipe", "pipe: " + host + "/ruby:3.1", "pipe: ", "3.1", digests["ruby:3.1"]},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p := New()
+			p.SetImageKeys([]string{"sandbox_image"})
+			got, err := p.Replace(context.Background(), tt.matchedLine, nil, config.Config{})
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPrefix, got.Prefix)
+			require.Equal(t, tt.wantTag, got.Tag)
+			require.Equal(t, tt.wantDigest, got.Ref)
+		})
+	}
+}
+
+func TestReplaceDockerTransport(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "nginx:1.25")
+
+	tests := []struct {
+		name        string
+		matchedLine string
+		wantPrefix  string
+	}{
+		{"Argument", "docker://" + host + "/nginx:1.25", "docker://"},
+		{"Image key", "image: docker://" + host + "/nginx:1.25", "image: docker://"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p := New()
+			got, err := p.Replace(context.Background(), tt.matchedLine, nil, config.Config{})
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPrefix, got.Prefix)
+			require.Equal(t, host+"/nginx", got.Name)
+			require.Equal(t, "1.25", got.Tag)
+			require.Equal(t, digests["nginx:1.25"], got.Ref)
+
+			// The transport is kept when reverting to the tag
+			pinned := got.Prefix + got.Name + "@" + got.Ref
+			unpinned, err := p.Unpin(pinned, got.Tag)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPrefix, unpinned.Prefix)
+			require.Equal(t, host+"/nginx", unpinned.Name)
+		})
+	}
+}
+
+// newTestRegistry starts an in-memory container registry serving a random image
+// for each of the given repository:tag references. It returns the registry host
+// along with the digest of each pushed reference.
+func newTestRegistry(t *testing.T, refs ...string) (string, map[string]string) {
+	t.Helper()
+
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	digests := make(map[string]string, len(refs))
+	for _, r := range refs {
+		digests[r] = pushRandomImage(t, host+"/"+r)
+	}
+
+	return host, digests
+}
+
+// pushIndex pushes an index of random images for the given platforms, along with an
+// attestation manifest, and returns the digests of the images by platform and of the
+// index under the empty platform
+func pushIndex(t testing.TB, refstr string, platforms ...string) map[string]string {
+	t.Helper()
+
+	digests := make(map[string]string, len(platforms)+1)
+	idx := v1.ImageIndex(empty.Index)
+	for _, platform := range append(platforms, "unknown/unknown") {
+		p, err := v1.ParsePlatform(platform)
+		require.NoError(t, err)
+		img, err := random.Image(64, 1)
+		require.NoError(t, err)
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: p},
+		})
+		digest, err := img.Digest()
+		require.NoError(t, err)
+		digests[platform] = digest.String()
+	}
+	delete(digests, "unknown/unknown")
+
+	ref, err := name.ParseReference(refstr)
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(ref, idx))
+	digest, err := idx.Digest()
+	require.NoError(t, err)
+	digests[""] = digest.String()
+
+	return digests
+}
+
+// pushRandomImage pushes a random image to the given reference and returns its digest
+func pushRandomImage(t *testing.T, refstr string) string {
+	t.Helper()
+
+	ref, err := name.ParseReference(refstr)
+	require.NoError(t, err)
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	return digest.String()
+}
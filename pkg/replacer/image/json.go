@@ -0,0 +1,75 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// jsonKeyImage is the key referencing container images in JSON documents, matched
+// regardless of its case as CloudFormation container definitions use Image
+const jsonKeyImage = "image"
+
+// isJSONDocument returns true if the given content is a JSON object or array
+func isJSONDocument(content string) bool {
+	content = strings.TrimSpace(content)
+	return strings.HasPrefix(content, "{") || strings.HasPrefix(content, "[")
+}
+
+// appendJSONImages appends the images referenced by the image keys, at any depth, of
+// the given JSON document, e.g. a Kubernetes manifest
+//
+//	{"spec": {"containers": [{"name": "web", "image": "nginx:1.25"}]}}
+//
+// or a CloudFormation template. They can't be matched line by line as the keys are
+// quoted. The digest is appended to the reference, i.e. nginx:1.25@sha256:..., since
+// JSON has no comments to record the tag in.
+func (p *Parser) appendJSONImages(images []documentImage, node *yaml.Node) []documentImage {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if !p.isJSONImageKey(key.Value) || value.Kind != yaml.ScalarNode || value.Tag != "!!str" {
+				continue
+			}
+			if value.Value == "" || strings.ContainsAny(value.Value, "@ \t") {
+				// Not an image, or already pinned
+				continue
+			}
+			images = append(images, documentImage{
+				ref:  value.Value,
+				line: value.Line,
+				pin: func(lines []string, digest string) (yamlEdit, bool) {
+					edit, ok := scalarEnd(lines, value)
+					edit.text = "@" + digest
+					return edit, ok
+				},
+			})
+		}
+	}
+	for _, child := range node.Content {
+		images = p.appendJSONImages(images, child)
+	}
+	return images
+}
+
+// isJSONImageKey returns true if the given key references a container image, i.e. it's
+// the image key or one of the keys set through SetImageKeys
+func (p *Parser) isJSONImageKey(key string) bool {
+	return strings.EqualFold(key, jsonKeyImage) || slices.Contains(p.keys, key)
+}
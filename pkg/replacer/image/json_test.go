@@ -0,0 +1,157 @@
+package image
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+func TestReplaceJSONImages(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "nginx:1.25.3", "app:v1", "busybox:1.36")
+
+	tests := []struct {
+		name          string
+		content       string
+		want          string
+		keys          []string
+		jsonManifests bool
+		wantModified  bool
+		// wantErr is the image failing to resolve, if any
+		wantErr *interfaces.DocumentReference
+	}{
+		{
+			name: "pod spec",
+			content: `{
+  "kind": "Pod",
+  "spec": {
+    "containers": [
+      {"name": "web", "image": "{{HOST}}/nginx:1.25.3"},
+      {"name": "app", "image": "{{HOST}}/app:v1@{{app:v1}}"}
+    ]
+  }
+}
+`,
+			want: `{
+  "kind": "Pod",
+  "spec": {
+    "containers": [
+      {"name": "web", "image": "{{HOST}}/nginx:1.25.3@{{nginx:1.25.3}}"},
+      {"name": "app", "image": "{{HOST}}/app:v1@{{app:v1}}"}
+    ]
+  }
+}
+`,
+			jsonManifests: true,
+			wantModified:  true,
+		},
+		{
+			name: "CloudFormation container definition",
+			content: `{
+  "Resources": {
+    "TaskDefinition": {
+      "Type": "AWS::ECS::TaskDefinition",
+      "Properties": {
+        "ContainerDefinitions": [
+          {"Name": "app", "Image": "{{HOST}}/app:v1"},
+          {"Name": "sidecar", "Image": {"Fn::Sub": "${Registry}/sidecar:1"}}
+        ]
+      }
+    }
+  }
+}`,
+			want: `{
+  "Resources": {
+    "TaskDefinition": {
+      "Type": "AWS::ECS::TaskDefinition",
+      "Properties": {
+        "ContainerDefinitions": [
+          {"Name": "app", "Image": "{{HOST}}/app:v1@{{app:v1}}"},
+          {"Name": "sidecar", "Image": {"Fn::Sub": "${Registry}/sidecar:1"}}
+        ]
+      }
+    }
+  }
+}`,
+			jsonManifests: true,
+			wantModified:  true,
+		},
+		{
+			name:          "additional image keys",
+			content:       `[{"initImage": "{{HOST}}/busybox:1.36", "replicas": 1}]`,
+			want:          `[{"initImage": "{{HOST}}/busybox:1.36@{{busybox:1.36}}", "replicas": 1}]`,
+			keys:          []string{"initImage"},
+			jsonManifests: true,
+			wantModified:  true,
+		},
+		{
+			name: "image failing to resolve",
+			content: `{
+  "containers": [
+    {"name": "web", "image": "{{HOST}}/nginx:1.25.3"},
+    {"name": "missing", "image": "{{HOST}}/missing:1.0"}
+  ]
+}`,
+			want: `{
+  "containers": [
+    {"name": "web", "image": "{{HOST}}/nginx:1.25.3@{{nginx:1.25.3}}"},
+    {"name": "missing", "image": "{{HOST}}/missing:1.0"}
+  ]
+}`,
+			jsonManifests: true,
+			wantModified:  true,
+			wantErr:       &interfaces.DocumentReference{Line: 4, Reference: "{{HOST}}/missing:1.0"},
+		},
+		{
+			name:    "JSON manifests disabled",
+			content: `{"spec": {"containers": [{"name": "web", "image": "{{HOST}}/nginx:1.25.3"}]}}`,
+		},
+		{
+			name:          "YAML document",
+			content:       "spec:\n  containers:\n    - image: {{HOST}}/nginx:1.25.3\n",
+			jsonManifests: true,
+		},
+	}
+
+	expand := func(s string) string {
+		s = strings.ReplaceAll(s, "{{HOST}}", host)
+		for ref, digest := range digests {
+			s = strings.ReplaceAll(s, "{{"+ref+"}}", digest)
+		}
+		return s
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := New()
+			if tt.keys != nil {
+				p.SetImageKeys(tt.keys)
+			}
+			cfg := config.Config{Images: config.Images{JSONManifests: tt.jsonManifests}}
+			got, modified, refs := p.ReplaceInDocument(context.Background(), expand(tt.content), nil, cfg)
+			errs := unresolvedRefs(refs)
+			if tt.wantErr != nil {
+				require.Len(t, errs, 1)
+				require.Equal(t, tt.wantErr.Line, errs[0].Line)
+				require.Equal(t, expand(tt.wantErr.Reference), errs[0].Reference)
+			} else {
+				require.Empty(t, errs)
+			}
+			require.Equal(t, tt.wantModified, modified)
+			want := tt.want
+			if !tt.wantModified {
+				want = tt.content
+			}
+			require.Equal(t, expand(want), got)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"fmt"
+	"os"
+
+	dockerconfig "github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// dockerConfigKeychain resolves the credentials of registries from a given docker
+// config.json, including through the credential helpers it configures
+type dockerConfigKeychain struct {
+	cf *configfile.ConfigFile
+}
+
+// NewDockerConfigKeychain returns a keychain reading the credentials of registries from
+// the docker config.json at the given path, e.g. one at a custom DOCKER_CONFIG location
+// in CI, rather than from the default locations authn.DefaultKeychain looks at
+func NewDockerConfigKeychain(path string) (authn.Keychain, error) {
+	f, err := os.Open(path) // nolint:gosec // The file is chosen by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to open docker config %s: %w", path, err)
+	}
+	defer f.Close() // nolint:errcheck
+
+	cf, err := dockerconfig.LoadFromReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse docker config %s: %w", path, err)
+	}
+	return &dockerConfigKeychain{cf: cf}, nil
+}
+
+// Resolve implements authn.Keychain, like authn.DefaultKeychain does for the default config
+func (k *dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	var empty types.AuthConfig
+	// The repository may have credentials of its own, otherwise the registry's apply
+	for _, key := range []string{target.String(), target.RegistryStr()} {
+		// Docker Hub is keyed by its legacy address for historical reasons
+		if key == name.DefaultRegistry {
+			key = authn.DefaultAuthKey
+		}
+		cfg, err := k.cf.GetAuthConfig(key)
+		if err != nil {
+			return nil, err
+		}
+		// The server address is always set, it doesn't tell whether credentials were found
+		cfg.ServerAddress = ""
+		if cfg != empty {
+			return authn.FromConfig(authn.AuthConfig{
+				Username:      cfg.Username,
+				Password:      cfg.Password,
+				Auth:          cfg.Auth,
+				IdentityToken: cfg.IdentityToken,
+				RegistryToken: cfg.RegistryToken,
+			}), nil
+		}
+	}
+	return authn.Anonymous, nil
+}
@@ -0,0 +1,103 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	kustomizeKeyKind    = "kind"
+	kustomizeKeyImages  = "images"
+	kustomizeKeyName    = "name"
+	kustomizeKeyNewName = "newName"
+	kustomizeKeyNewTag  = "newTag"
+	kustomizeKeyDigest  = "digest"
+)
+
+// appendKustomizeImages appends the images set by the images transformer of the
+// given Kustomization document, e.g.
+//
+//	images:
+//	  - name: nginx
+//	    newTag: 1.25.3
+//
+// They're pinned by adding a digest key to the entry, which Kustomize supports
+// natively, leaving the name and newTag keys in place. Entries with a digest
+// already or without a newTag are left untouched.
+func appendKustomizeImages(images []documentImage, doc *yaml.Node) []documentImage {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return images
+	}
+	root := doc.Content[0]
+	if kind := mappingValue(root, kustomizeKeyKind); kind != nil && kind.Value != "Kustomization" && kind.Value != "Component" {
+		return images
+	}
+	list := mappingValue(root, kustomizeKeyImages)
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return images
+	}
+
+	for _, entry := range list.Content {
+		if img, ok := kustomizeImageFromEntry(entry); ok {
+			images = append(images, img)
+		}
+	}
+	return images
+}
+
+// kustomizeImageFromEntry returns the image set by the entry of the images transformer, if any
+func kustomizeImageFromEntry(entry *yaml.Node) (documentImage, bool) {
+	// A key can only be added to block mappings without changing the layout
+	if entry.Kind != yaml.MappingNode || entry.Style&yaml.FlowStyle != 0 || len(entry.Content) == 0 {
+		return documentImage{}, false
+	}
+	name := mappingValue(entry, kustomizeKeyName)
+	newTag := mappingValue(entry, kustomizeKeyNewTag)
+	if name == nil || name.Value == "" || newTag == nil || newTag.Value == "" ||
+		mappingValue(entry, kustomizeKeyDigest) != nil {
+		return documentImage{}, false
+	}
+
+	repository := name.Value
+	if newName := mappingValue(entry, kustomizeKeyNewName); newName != nil && newName.Value != "" {
+		repository = newName.Value
+	}
+	if strings.Contains(repository, "@") {
+		return documentImage{}, false
+	}
+
+	// The digest goes right after the last value of the entry, aligned with its keys
+	last := entry.Content[len(entry.Content)-1]
+	indent := entry.Content[0].Column - 1
+	return documentImage{
+		ref:  repository + ":" + newTag.Value,
+		line: newTag.Line,
+		pin: func(lines []string, digest string) (yamlEdit, bool) {
+			end, ok := scalarEnd(lines, last)
+			if !ok {
+				return yamlEdit{}, false
+			}
+			return yamlEdit{
+				line:   end.line,
+				column: len([]rune(lines[end.line])),
+				text:   "\n" + strings.Repeat(" ", indent) + kustomizeKeyDigest + ": " + digest,
+			}, true
+		},
+	}, true
+}
@@ -0,0 +1,115 @@
+package image
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+func TestReplaceKustomizeImages(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "nginx:1.25.3", "postgres:16", "redis:7.2")
+
+	tests := []struct {
+		name         string
+		content      string
+		want         string
+		wantModified bool
+	}{
+		{
+			name: "multiple images, one already pinned",
+			content: `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - deployment.yaml
+images:
+  - name: nginx
+    newName: {{HOST}}/nginx
+    newTag: 1.25.3
+  - name: {{HOST}}/postgres
+    newTag: "16" # LTS
+  - name: {{HOST}}/redis
+    newTag: "7.2"
+    digest: {{redis:7.2}}
+  - name: busybox
+    newName: {{HOST}}/busybox
+namespace: prod
+`,
+			want: `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - deployment.yaml
+images:
+  - name: nginx
+    newName: {{HOST}}/nginx
+    newTag: 1.25.3
+    digest: {{nginx:1.25.3}}
+  - name: {{HOST}}/postgres
+    newTag: "16" # LTS
+    digest: {{postgres:16}}
+  - name: {{HOST}}/redis
+    newTag: "7.2"
+    digest: {{redis:7.2}}
+  - name: busybox
+    newName: {{HOST}}/busybox
+namespace: prod
+`,
+			wantModified: true,
+		},
+		{
+			name: "component without kind and indentless list",
+			content: `images:
+- name: {{HOST}}/redis
+  newTag: "7.2"
+`,
+			want: `images:
+- name: {{HOST}}/redis
+  newTag: "7.2"
+  digest: {{redis:7.2}}
+`,
+			wantModified: true,
+		},
+		{
+			name: "not a kustomization",
+			content: `kind: ConfigMap
+images:
+  - name: {{HOST}}/redis
+    newTag: "7.2"
+`,
+		},
+		{
+			name: "flow mapping entry",
+			content: `images:
+  - {name: {{HOST}}/redis, newTag: "7.2"}
+`,
+		},
+	}
+
+	expand := func(s string) string {
+		s = strings.ReplaceAll(s, "{{HOST}}", host)
+		for ref, digest := range digests {
+			s = strings.ReplaceAll(s, "{{"+ref+"}}", digest)
+		}
+		return s
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, modified, _ := New().ReplaceInDocument(context.Background(), expand(tt.content), nil, config.Config{})
+			require.Equal(t, tt.wantModified, modified)
+			want := tt.want
+			if !tt.wantModified {
+				want = tt.content
+			}
+			require.Equal(t, expand(want), got)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+const (
+	nixAttrImageName     = "imageName"
+	nixAttrImageDigest   = "imageDigest"
+	nixAttrFinalImageTag = "finalImageTag"
+)
+
+var (
+	// nixPullImageRegex matches the start of the attribute set given to the pullImage
+	// function of Nix dockerTools
+	nixPullImageRegex = regexp.MustCompile(`\bpullImage\s*\{`)
+	// nixAttrRegex matches the string attributes of a pullImage call which identify the image
+	nixAttrRegex = regexp.MustCompile(`\b(imageName|imageDigest|finalImageTag)\s*=\s*"([^"]*)"\s*;`)
+	// nixPlaceholderDigest matches the digests standing for one left to fill in, i.e. an
+	// empty one or one made of zeros
+	nixPlaceholderDigest = regexp.MustCompile(`^(sha256:0*)?$`)
+)
+
+// nixEdit replaces the content between two offsets of a Nix file
+type nixEdit struct {
+	start, end int
+	text       string
+}
+
+// nixAttr is a string attribute of a pullImage call, with the offsets of its value
+// and of its end, i.e. right after the semicolon
+type nixAttr struct {
+	value      string
+	start, end int
+	attrEnd    int
+}
+
+// replaceNixImages fills in the imageDigest of the pullImage calls of Nix dockerTools
+// with a tag, i.e.
+//
+//	pullImage {
+//	  imageName = "nginx";
+//	  imageDigest = "";
+//	  finalImageTag = "1.25";
+//	  sha256 = lib.fakeHash;
+//	}
+//
+// when it's missing or a placeholder, i.e. empty or made of zeros. The digests already
+// set are left untouched, as are the calls without a finalImageTag. The sha256 of the
+// pulled image can only be computed by Nix, so it's left for Nix to tell. The images
+// found are returned along with the outcome of pinning them, on the line of their imageName.
+func (p *Parser) replaceNixImages(
+	ctx context.Context,
+	content string,
+	cfg *config.Config,
+) (string, bool, []interfaces.DocumentReference) {
+	var edits []nixEdit
+	var refs []interfaces.DocumentReference
+	for _, loc := range nixPullImageRegex.FindAllStringIndex(content, -1) {
+		end := matchingBrace(content, loc[1]-1)
+		if end < 0 {
+			continue
+		}
+		attrs := nixAttrs(content, loc[1], end)
+		imageName, hasName := attrs[nixAttrImageName]
+		tag, hasTag := attrs[nixAttrFinalImageTag]
+		digest, hasDigest := attrs[nixAttrImageDigest]
+		if !hasName || !hasTag || (hasDigest && !nixPlaceholderDigest.MatchString(digest.value)) {
+			continue
+		}
+
+		docRef := interfaces.DocumentReference{
+			Line:      strings.Count(content[:imageName.start], "\n") + 1,
+			Reference: imageName.value + ":" + tag.value,
+		}
+		ref := p.expandEnv(docRef.Reference)
+		if docRef.Err = skipImageRef(cfg, docRef.Reference, ref); docRef.Err != nil {
+			refs = append(refs, docRef)
+			continue
+		}
+		pinned, err := p.resolveDocumentImage(ctx, ref, cfg)
+		if err != nil {
+			// Leave the reference as is, like the ones matched line by line
+			docRef.Err = err
+			refs = append(refs, docRef)
+			continue
+		}
+		docRef.Ref = pinned
+		docRef.Pinned = docRef.Reference + "@" + pinned.Ref
+		refs = append(refs, docRef)
+
+		if hasDigest {
+			edits = append(edits, nixEdit{start: digest.start, end: digest.end, text: pinned.Ref})
+			continue
+		}
+		// The digest goes right after the image name, on a line of its own unless the
+		// attributes are all on the same line
+		lineStart := strings.LastIndex(content[:imageName.attrEnd], "\n") + 1
+		line := content[lineStart:imageName.attrEnd]
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		sep := " "
+		if strings.HasPrefix(strings.TrimSpace(line), nixAttrImageName) {
+			sep = "\n" + indent
+		}
+		edits = append(edits, nixEdit{
+			start: imageName.attrEnd,
+			end:   imageName.attrEnd,
+			text:  sep + nixAttrImageDigest + ` = "` + pinned.Ref + `";`,
+		})
+	}
+	if len(edits) == 0 {
+		return content, false, refs
+	}
+
+	// Apply the edits from the end so they don't shift the offsets of the remaining ones
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+	for _, e := range edits {
+		content = content[:e.start] + e.text + content[e.end:]
+	}
+	return content, true, refs
+}
+
+// nixAttrs returns the string attributes identifying the image found between the given
+// offsets of the content, by name
+func nixAttrs(content string, start, end int) map[string]nixAttr {
+	attrs := make(map[string]nixAttr)
+	for _, m := range nixAttrRegex.FindAllStringSubmatchIndex(content[start:end], -1) {
+		attrs[content[start+m[2]:start+m[3]]] = nixAttr{
+			value:   content[start+m[4] : start+m[5]],
+			start:   start + m[4],
+			end:     start + m[5],
+			attrEnd: start + m[1],
+		}
+	}
+	return attrs
+}
+
+// matchingBrace returns the offset of the brace closing the one at the given offset,
+// or -1 if it isn't closed
+func matchingBrace(content string, open int) int {
+	depth := 0
+	for i := open; i < len(content); i++ {
+		switch content[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
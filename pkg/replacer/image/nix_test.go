@@ -0,0 +1,96 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+func TestReplaceNixImages(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "nginx:1.25", "redis:7.2", "postgres:16", "busybox:1.36", "alpine:3.19")
+
+	content, err := os.ReadFile(filepath.Join("testdata", "images.nix"))
+	require.NoError(t, err)
+	nix := strings.ReplaceAll(string(content), "REGISTRY", host)
+
+	tests := []struct {
+		name         string
+		content      string
+		nixImages    bool
+		wantModified bool
+		want         string
+		// wantErr is the image failing to resolve, if any
+		wantErr *interfaces.DocumentReference
+	}{
+		{
+			name: "Nix images disabled",
+			want: nix,
+		},
+		{
+			name:         "Nix images",
+			nixImages:    true,
+			wantModified: true,
+			want: strings.NewReplacer(
+				`imageName = "`+host+`/nginx";
+    imageDigest = "";`,
+				`imageName = "`+host+`/nginx";
+    imageDigest = "`+digests["nginx:1.25"]+`";`,
+				`imageName = "`+host+`/redis";`,
+				`imageName = "`+host+`/redis";
+    imageDigest = "`+digests["redis:7.2"]+`";`,
+				`imageDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"`,
+				`imageDigest = "`+digests["postgres:16"]+`"`,
+			).Replace(nix),
+		},
+		{
+			name: "image failing to resolve",
+			content: `{ dockerTools, lib }:
+dockerTools.pullImage {
+  imageName = "` + host + `/missing";
+  imageDigest = "";
+  finalImageTag = "1.0";
+  sha256 = lib.fakeHash;
+}
+`,
+			nixImages: true,
+			wantErr:   &interfaces.DocumentReference{Line: 3, Reference: host + "/missing:1.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.Config{Images: config.Images{NixImages: tt.nixImages}}
+			content := nix
+			if tt.content != "" {
+				content = tt.content
+			}
+			got, modified, refs := New().ReplaceInDocument(context.Background(), content, nil, cfg)
+			errs := unresolvedRefs(refs)
+			if tt.wantErr != nil {
+				require.Len(t, errs, 1)
+				require.Equal(t, tt.wantErr.Line, errs[0].Line)
+				require.Equal(t, tt.wantErr.Reference, errs[0].Reference)
+			} else {
+				require.Empty(t, errs)
+			}
+			require.Equal(t, tt.wantModified, modified)
+			if !tt.wantModified {
+				require.Equal(t, content, got)
+				return
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
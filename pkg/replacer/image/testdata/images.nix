@@ -0,0 +1,45 @@
+{ pkgs ? import <nixpkgs> { } }:
+
+let
+  inherit (pkgs) dockerTools lib;
+in
+{
+  # The digest is a placeholder left to fill in
+  nginx = dockerTools.pullImage {
+    imageName = "REGISTRY/nginx";
+    imageDigest = "";
+    finalImageName = "nginx";
+    finalImageTag = "1.25";
+    sha256 = lib.fakeHash;
+  };
+
+  # The digest is missing altogether
+  redis = dockerTools.pullImage {
+    imageName = "REGISTRY/redis";
+    finalImageTag = "7.2";
+    sha256 = lib.fakeHash;
+  };
+
+  postgres = dockerTools.pullImage { imageName = "REGISTRY/postgres"; imageDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"; finalImageTag = "16"; sha256 = lib.fakeHash; };
+
+  # Already pinned digests are left untouched
+  busybox = dockerTools.pullImage {
+    imageName = "REGISTRY/busybox";
+    imageDigest = "sha256:1ccc0a0ca577e5fb5a0bdf2150a1a9f842f47c8865e861fa0062c5d343eb8cac";
+    finalImageTag = "1.36";
+    sha256 = "sha256-K2P4q2Nz9PhhLjBpYH1SM+LrTjQfmSvmuqRfVsnVDiU=";
+  };
+
+  # Images without a tag aren't resolved
+  alpine = dockerTools.pullImage {
+    imageName = "REGISTRY/alpine";
+    imageDigest = "";
+    sha256 = lib.fakeHash;
+  };
+
+  app = dockerTools.buildImage {
+    name = "app";
+    tag = "latest";
+    fromImage = nginx;
+  };
+}
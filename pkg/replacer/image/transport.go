@@ -0,0 +1,92 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// registryTransport talks to the insecure registries without verifying their TLS
+// certificates, falling back to plain HTTP, and to the other ones strictly
+type registryTransport struct {
+	strict   http.RoundTripper
+	insecure http.RoundTripper
+	// insecureHosts holds the insecure registries, as host or host:port
+	insecureHosts []string
+}
+
+// NewRegistryTransport returns the transport to pass to remote.WithTransport to talk to
+// the given insecure registries, as host or host:port, without verifying their TLS
+// certificates and falling back to plain HTTP. The other registries are verified
+// strictly, against the given certificate authorities if any or the system ones otherwise.
+func NewRegistryTransport(insecureHosts []string, rootCAs *x509.CertPool) http.RoundTripper {
+	strict := newTLSTransport()
+	strict.TLSClientConfig.RootCAs = rootCAs
+	if len(insecureHosts) == 0 {
+		return strict
+	}
+
+	insecure := newTLSTransport()
+	insecure.TLSClientConfig.InsecureSkipVerify = true // nolint:gosec // Only for the registries listed as insecure
+	return &registryTransport{strict: strict, insecure: insecure, insecureHosts: insecureHosts}
+}
+
+// newTLSTransport returns a copy of the default transport of remote with a TLS configuration to modify
+func newTLSTransport() *http.Transport {
+	t := remote.DefaultTransport.(*http.Transport).Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.isInsecure(req.URL.Host) {
+		return t.strict.RoundTrip(req)
+	}
+
+	resp, err := t.insecure.RoundTrip(req)
+	if err == nil || req.URL.Scheme != "https" || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+
+	// The registry may not serve HTTPS at all, try again over plain HTTP
+	httpReq := req.Clone(req.Context())
+	httpReq.URL.Scheme = "http"
+	if req.GetBody != nil {
+		if httpReq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.insecure.RoundTrip(httpReq)
+}
+
+// isInsecure returns true if the host, along with its port if any, is one of the insecure
+// registries. A registry listed without a port matches any port.
+func (t *registryTransport) isInsecure(host string) bool {
+	if slices.Contains(t.insecureHosts, host) {
+		return true
+	}
+	hostname, _, err := net.SplitHostPort(host)
+	return err == nil && slices.Contains(t.insecureHosts, hostname)
+}
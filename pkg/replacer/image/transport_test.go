@@ -0,0 +1,113 @@
+package image
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTransport records the URLs it's asked for, failing the HTTPS ones if tlsErr is set
+type fakeTransport struct {
+	tlsErr error
+
+	mu   sync.Mutex
+	urls []string
+}
+
+func (f *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.urls = append(f.urls, req.URL.String())
+	f.mu.Unlock()
+	if f.tlsErr != nil && req.URL.Scheme == "https" {
+		return nil, f.tlsErr
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestRegistryTransport(t *testing.T) {
+	t.Parallel()
+
+	errTLS := errors.New("tls: failed to verify certificate")
+
+	tests := []struct {
+		name         string
+		url          string
+		wantStrict   []string
+		wantInsecure []string
+		wantErr      error
+	}{
+		{
+			name:       "not listed",
+			url:        "https://ghcr.io/v2/",
+			wantStrict: []string{"https://ghcr.io/v2/"},
+		},
+		{
+			name:         "listed with its port",
+			url:          "https://registry.internal:5000/v2/",
+			wantInsecure: []string{"https://registry.internal:5000/v2/", "http://registry.internal:5000/v2/"},
+		},
+		{
+			name:         "listed without a port",
+			url:          "https://mirror.internal/v2/",
+			wantInsecure: []string{"https://mirror.internal/v2/", "http://mirror.internal/v2/"},
+		},
+		{
+			name:         "listed without a port, any port",
+			url:          "https://mirror.internal:8443/v2/",
+			wantInsecure: []string{"https://mirror.internal:8443/v2/", "http://mirror.internal:8443/v2/"},
+		},
+		{
+			name:       "listed with another port",
+			url:        "https://registry.internal:6000/v2/",
+			wantStrict: []string{"https://registry.internal:6000/v2/"},
+			wantErr:    errTLS,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Both transports fail verifying certificates, the insecure one doesn't fall back to HTTP
+			strict := &fakeTransport{}
+			if tt.wantErr != nil {
+				strict.tlsErr = tt.wantErr
+			}
+			insecure := &fakeTransport{tlsErr: errTLS}
+			transport := &registryTransport{
+				strict:        strict,
+				insecure:      insecure,
+				insecureHosts: []string{"registry.internal:5000", "mirror.internal"},
+			}
+
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			require.NoError(t, err)
+			resp, err := transport.RoundTrip(req)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+			}
+			require.Equal(t, tt.wantStrict, strict.urls)
+			require.Equal(t, tt.wantInsecure, insecure.urls)
+		})
+	}
+}
+
+func TestNewRegistryTransport(t *testing.T) {
+	t.Parallel()
+
+	// Without insecure registries, every registry is verified strictly
+	_, ok := NewRegistryTransport(nil, nil).(*http.Transport)
+	require.True(t, ok)
+
+	transport, ok := NewRegistryTransport([]string{"registry.internal"}, nil).(*registryTransport)
+	require.True(t, ok)
+	require.True(t, transport.insecure.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+	require.False(t, transport.strict.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+}
@@ -0,0 +1,134 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replacer
+
+import (
+	"errors"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/replacer/circleci"
+	"github.com/stacklok/frizbee/pkg/replacer/image"
+	"github.com/stacklok/frizbee/pkg/replacer/precommit"
+	"github.com/stacklok/frizbee/pkg/replacer/terraform"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// ErrNoParser is returned by New when no parser option is given
+var ErrNoParser = errors.New("no parser selected, use WithActionsParser, WithImageParser, " +
+	"WithCircleCIParser, WithTerraformParser or WithPreCommitParser")
+
+// Option configures a Replacer created by New
+type Option func(*options)
+
+type options struct {
+	newParser     func() interfaces.Parser
+	cfg           *config.Config
+	token         string
+	regex         string
+	cacheDisabled bool
+}
+
+// WithActionsParser makes the replacer pin GitHub Actions
+func WithActionsParser() Option {
+	return func(o *options) {
+		o.newParser = func() interfaces.Parser { return actions.New() }
+	}
+}
+
+// WithImageParser makes the replacer pin container images
+func WithImageParser() Option {
+	return func(o *options) {
+		o.newParser = func() interfaces.Parser { return image.New() }
+	}
+}
+
+// WithCircleCIParser makes the replacer pin CircleCI orbs
+func WithCircleCIParser() Option {
+	return func(o *options) {
+		o.newParser = func() interfaces.Parser { return circleci.New() }
+	}
+}
+
+// WithTerraformParser makes the replacer pin Terraform and OpenTofu module sources
+func WithTerraformParser() Option {
+	return func(o *options) {
+		o.newParser = func() interfaces.Parser { return terraform.New() }
+	}
+}
+
+// WithPreCommitParser makes the replacer pin the revs of pre-commit hook repositories
+func WithPreCommitParser() Option {
+	return func(o *options) {
+		o.newParser = func() interfaces.Parser { return precommit.New() }
+	}
+}
+
+// WithConfig sets the configuration, the default configuration is used otherwise
+func WithConfig(cfg *config.Config) Option {
+	return func(o *options) {
+		o.cfg = cfg
+	}
+}
+
+// WithGitHubToken authenticates the GitHub client with the given token
+func WithGitHubToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithRegex sets a user-provided regex for the parser, New fails with ErrInvalidRegex if
+// it doesn't compile
+func WithRegex(regex string) Option {
+	return func(o *options) {
+		o.regex = regex
+	}
+}
+
+// WithCacheDisabled disables caching
+func WithCacheDisabled() Option {
+	return func(o *options) {
+		o.cacheDisabled = true
+	}
+}
+
+// New creates a new replacer configured by the given options. One of WithActionsParser,
+// WithImageParser, WithCircleCIParser, WithTerraformParser or WithPreCommitParser must be
+// given, the last one wins.
+// Further settings are available through the builder methods of the returned replacer.
+func New(opts ...Option) (*Replacer, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.newParser == nil {
+		return nil, ErrNoParser
+	}
+
+	r := newReplacer(o.newParser(), o.cfg).
+		WithUserRegex(o.regex)
+	if r.regexErr != nil {
+		return nil, r.regexErr
+	}
+	if o.token != "" {
+		r = r.WithGitHubClientFromToken(o.token)
+	}
+	if o.cacheDisabled {
+		r = r.WithCacheDisabled()
+	}
+	return r, nil
+}
@@ -0,0 +1,378 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package precommit provides utilities to work with pre-commit hook repositories.
+package precommit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"gopkg.in/yaml.v3"
+
+	"github.com/stacklok/frizbee/internal/traverse"
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/store"
+)
+
+const (
+	// RevRegex is the regular expression pattern to match the rev of hook repositories.
+	// The repo is held by a sibling key, so the revs are pinned through ReplaceInDocument.
+	RevRegex = `rev:\s*['"]?[^\s'"#]+['"]?`
+	// ReferenceType is the type of the reference
+	ReferenceType = "hook"
+	// ConfigFileName is the name of the pre-commit configuration file
+	ConfigFileName = ".pre-commit-config.yaml"
+
+	keyRepos   = "repos"
+	keyRepo    = "repo"
+	keyRev     = "rev"
+	githubHost = "github.com"
+)
+
+// revValue matches the value of the rev key, without its quotes
+var revValue = regexp.MustCompile(`^(rev:\s*['"]?)([^\s'"#]+)`)
+
+// defaultRegex is RevRegex compiled once for all the parsers
+var defaultRegex = regexp.MustCompile(RevRegex)
+
+// Parser is a struct to replace the revs of pre-commit hook repositories with commit checksums
+type Parser struct {
+	regex string
+	// compiled is the compiled regex, or nil along with regexErr if it's invalid
+	compiled *regexp.Regexp
+	regexErr error
+	cache    store.RefCacher
+	timeout  time.Duration
+	// logger logs the cache lookups at debug level, if set
+	logger *slog.Logger
+}
+
+// New creates a new Parser
+func New() *Parser {
+	return &Parser{
+		regex:    RevRegex,
+		compiled: defaultRegex,
+		cache:    store.NewRefCacher(),
+	}
+}
+
+// SetCache sets the cache to store the resolved checksums
+func (p *Parser) SetCache(cache store.RefCacher) {
+	p.cache = cache
+}
+
+// SetLogger sets the logger the cache hits and misses are logged to at debug level
+func (p *Parser) SetLogger(logger *slog.Logger) {
+	p.logger = logger
+}
+
+// SetResolveTimeout sets the time limit to resolve each rev, zero means no timeout
+func (p *Parser) SetResolveTimeout(d time.Duration) {
+	p.timeout = d
+}
+
+// SetRegex sets the regular expression pattern to match revs
+func (p *Parser) SetRegex(regex string) {
+	p.regex = regex
+	p.compiled, p.regexErr = regexp.Compile(regex)
+}
+
+// GetRegex returns the regular expression pattern to match revs
+func (p *Parser) GetRegex() string {
+	return p.regex
+}
+
+// CompiledRegex returns the regular expression pattern to match revs, compiled
+// once when set rather than for every file, or the error compiling it if it's invalid
+func (p *Parser) CompiledRegex() (*regexp.Regexp, error) {
+	return p.compiled, p.regexErr
+}
+
+// TraverseFiles calls fn with each pre-commit configuration file of the given directory
+func (*Parser) TraverseFiles(bfs billy.Filesystem, base string, fn func(path string) error, opts ...traverse.Option) error {
+	return traverse.Files(bfs, base, func(info fs.FileInfo) bool {
+		return !info.IsDir() && info.Name() == ConfigFileName
+	}, fn, opts...)
+}
+
+// FormatReference rewrites the value of the matched rev
+func (*Parser) FormatReference(matchedLine, ref string) string {
+	loc := revValue.FindStringSubmatchIndex(matchedLine)
+	if loc == nil {
+		return matchedLine
+	}
+	return matchedLine[:loc[4]] + ref + matchedLine[loc[5]:]
+}
+
+// Replace skips the matched rev, it can't be resolved without the repo of the same
+// hook repository. The revs are pinned through ReplaceInDocument instead.
+func (*Parser) Replace(
+	_ context.Context,
+	matchedLine string,
+	_ interfaces.REST,
+	_ config.Config,
+) (*interfaces.EntityRef, error) {
+	return nil, fmt.Errorf("%w: %w: %s", interfaces.ErrReferenceSkipped, interfaces.ErrReplacedInDocument, matchedLine)
+}
+
+// ReplaceInDocument pins the revs of the hook repositories hosted on GitHub to the
+// checksum of the commit they point at, recording the rev in a trailing comment, i.e.
+//
+//	repos:
+//	  - repo: https://github.com/psf/black
+//	    rev: 2a1c67e0b2f81df602ec1f6e7aeb030b9709dc7c # 23.1.0
+//
+// The rest of the document is left untouched. Content that isn't valid YAML is returned as is.
+// The revs found are returned along with the outcome of pinning them.
+func (p *Parser) ReplaceInDocument(
+	ctx context.Context,
+	content string,
+	restIf interfaces.REST,
+	cfg config.Config,
+) (string, bool, []interfaces.DocumentReference) {
+	var hooks []hookRepository
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return content, false, nil
+			}
+			break
+		}
+		hooks = appendHookRepositories(hooks, &doc)
+	}
+
+	lines := strings.Split(content, "\n")
+	var edits []revEdit
+	var refs []interfaces.DocumentReference
+	for _, h := range hooks {
+		ref := interfaces.DocumentReference{Line: h.rev.Line, Reference: keyRev + ": " + h.rev.Value}
+		repo, ok := githubRepository(h.repo)
+		if !ok || !isIncluded(&cfg.PreCommit, repo) || config.MatchAny(cfg.PreCommit.Exclude, repo) ||
+			actions.IsChecksum(h.rev.Value) {
+			ref.Err = fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, ref.Reference)
+			refs = append(refs, ref)
+			continue
+		}
+
+		sum, err := p.resolve(ctx, restIf, cfg, repo, h.rev.Value)
+		if err != nil {
+			ref.Err = err
+			refs = append(refs, ref)
+			continue
+		}
+
+		edit, ok := pinRev(lines, h.rev, sum)
+		if !ok {
+			// The rev can't be rewritten in place, e.g. in a flow mapping
+			ref.Err = fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, ref.Reference)
+			refs = append(refs, ref)
+			continue
+		}
+		edits = append(edits, edit)
+		ref.Ref = &interfaces.EntityRef{Name: repo, Ref: sum, Tag: h.rev.Value, Type: ReferenceType, Prefix: keyRev + ": "}
+		ref.Pinned = keyRev + ": " + sum + " # " + h.rev.Value
+		refs = append(refs, ref)
+	}
+	if len(edits) == 0 {
+		return content, false, refs
+	}
+
+	// Apply the edits from the end so they don't shift the positions of the remaining ones
+	sort.Slice(edits, func(i, j int) bool {
+		if edits[i].line != edits[j].line {
+			return edits[i].line > edits[j].line
+		}
+		return edits[i].start > edits[j].start
+	})
+	for _, e := range edits {
+		line := []rune(lines[e.line])
+		lines[e.line] = string(line[:e.start]) + e.text + string(line[e.end:])
+	}
+	return strings.Join(lines, "\n"), true, refs
+}
+
+// Unpin reverts a rev pinned by its checksum back to the given tag
+func (*Parser) Unpin(matchedLine, tag string) (*interfaces.EntityRef, error) {
+	ref, err := parseRev(matchedLine)
+	if err != nil {
+		return nil, err
+	}
+	// Only revs pinned by a checksum with a known tag can be unpinned
+	if !actions.IsChecksum(ref.Ref) || tag == "" {
+		return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+	}
+	ref.Tag = tag
+	return ref, nil
+}
+
+// ConvertToEntityRef converts a rev to an EntityRef. The name is unknown as the repo
+// is held by a sibling key.
+func (*Parser) ConvertToEntityRef(reference string) (*interfaces.EntityRef, error) {
+	return parseRev(reference)
+}
+
+// resolve returns the checksum of the commit the rev of the repository points at,
+// within the timeout set through SetResolveTimeout, if any
+func (p *Parser) resolve(ctx context.Context, restIf interfaces.REST, cfg config.Config, repo, rev string) (string, error) {
+	key := repo + "@" + rev
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	store.LogLookup(ctx, p.logger, p.cache, key, key)
+	sum, err := actions.GetChecksumCached(ctx, config.GHActions{Filter: cfg.PreCommit.Filter}, restIf, p.cache, key, repo, rev)
+	if err != nil {
+		return "", fmt.Errorf("failed to get checksum for hook repository '%s': %w", key, err)
+	}
+	return sum, nil
+}
+
+// hookRepository is a hook repository of the pre-commit configuration
+type hookRepository struct {
+	repo string
+	rev  *yaml.Node
+}
+
+// appendHookRepositories appends the hook repositories listed under the repos key of the document
+func appendHookRepositories(hooks []hookRepository, doc *yaml.Node) []hookRepository {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return hooks
+	}
+	repos := mappingValue(doc.Content[0], keyRepos)
+	if repos == nil || repos.Kind != yaml.SequenceNode {
+		return hooks
+	}
+	for _, item := range repos.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		// The local and meta repositories don't have a rev
+		repo, rev := mappingValue(item, keyRepo), mappingValue(item, keyRev)
+		if repo == nil || rev == nil || repo.Kind != yaml.ScalarNode || rev.Kind != yaml.ScalarNode || rev.Value == "" {
+			continue
+		}
+		hooks = append(hooks, hookRepository{repo: repo.Value, rev: rev})
+	}
+	return hooks
+}
+
+// mappingValue returns the value of the given key of the mapping node, if any
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// revEdit replaces the runes of a line between start and end
+type revEdit struct {
+	line       int // 0-based
+	start, end int // 0-based, in runes
+	text       string
+}
+
+// pinRev returns the edit replacing the value of the rev node with the given checksum,
+// followed by the original rev in a comment. It's false if the rev doesn't end its line,
+// e.g. in a flow mapping, or doesn't match the content.
+func pinRev(lines []string, rev *yaml.Node, sum string) (revEdit, bool) {
+	if rev.Line < 1 || rev.Line > len(lines) {
+		return revEdit{}, false
+	}
+	line := []rune(lines[rev.Line-1])
+	start := rev.Column - 1
+	value := []rune(rev.Value)
+
+	quote := ""
+	if rev.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 {
+		quote = string(line[start])
+		start++
+	}
+	end := start + len(value)
+	if start < 0 || end+len(quote) > len(line) || string(line[start:end]) != rev.Value {
+		return revEdit{}, false
+	}
+	end += len(quote)
+
+	// Only a comment may follow the rev, it's kept after the rev comment
+	rest := strings.TrimSpace(string(line[end:]))
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return revEdit{}, false
+	}
+
+	text := sum + quote + " # " + rev.Value
+	if rest != "" {
+		text += " " + rest
+	}
+	return revEdit{line: rev.Line - 1, start: start, end: len(line), text: text}, true
+}
+
+// parseRev parses the matched rev into an EntityRef
+func parseRev(reference string) (*interfaces.EntityRef, error) {
+	m := revValue.FindStringSubmatch(strings.TrimSpace(reference))
+	if m == nil {
+		return nil, fmt.Errorf("invalid rev: %s %w", reference, interfaces.ErrInvalidReference)
+	}
+	return &interfaces.EntityRef{
+		Ref:    m[2],
+		Type:   ReferenceType,
+		Prefix: m[1],
+	}, nil
+}
+
+// githubRepository returns the owner/repo of a hook repository hosted on GitHub, either
+// through an HTTPS or SSH URL, e.g. https://github.com/psf/black or git@github.com:psf/black.git
+func githubRepository(url string) (string, bool) {
+	address := url
+	if _, rest, ok := strings.Cut(address, "://"); ok {
+		address = rest
+	}
+	if at := strings.Index(address, "@"); at >= 0 && !strings.ContainsAny(address[:at], "/:") {
+		address = address[at+1:]
+	}
+
+	// The host is followed by a / or, in the scp-like syntax, by a :
+	sep := strings.IndexAny(address, "/:")
+	if sep < 0 || address[:sep] != githubHost {
+		return "", false
+	}
+	frags := strings.Split(strings.TrimSuffix(address[sep+1:], "/"), "/")
+	if len(frags) != 2 || frags[0] == "" || frags[1] == "" {
+		return "", false
+	}
+
+	return frags[0] + "/" + strings.TrimSuffix(frags[1], ".git"), true
+}
+
+// isIncluded returns true if the repository matches the include list, or if there's none
+func isIncluded(cfg *config.PreCommit, repo string) bool {
+	return len(cfg.Include) == 0 || config.MatchAny(cfg.Include, repo)
+}
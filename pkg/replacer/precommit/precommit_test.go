@@ -0,0 +1,204 @@
+package precommit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/ghrest"
+)
+
+const testSHA = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+
+func TestReplaceInDocument(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/psf/black/git/refs/tags/23.1.0",
+			"/api/v3/repos/pre-commit/pre-commit-hooks/git/refs/tags/v4.5.0":
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + testSHA + `", "type": "commit"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		content string
+		cfg     config.Config
+		want    string
+		// wantErr is the rev failing to resolve, if any
+		wantErr *interfaces.DocumentReference
+		// wantSkipped is true if the rev is left untouched on purpose
+		wantSkipped bool
+	}{
+		{
+			name: "block mapping",
+			content: `repos:
+  - repo: https://github.com/psf/black
+    rev: 23.1.0
+    hooks:
+      - id: black
+`,
+			want: `repos:
+  - repo: https://github.com/psf/black
+    rev: ` + testSHA + ` # 23.1.0
+    hooks:
+      - id: black
+`,
+		},
+		{
+			name: "rev before repo",
+			content: `repos:
+- rev: "v4.5.0"
+  repo: https://github.com/pre-commit/pre-commit-hooks.git
+`,
+			want: `repos:
+- rev: "` + testSHA + `" # v4.5.0
+  repo: https://github.com/pre-commit/pre-commit-hooks.git
+`,
+		},
+		{
+			name: "flow mapping",
+			content: `repos:
+  - {repo: https://github.com/psf/black, rev: 23.1.0}
+`,
+			wantSkipped: true,
+		},
+		{
+			name: "excluded",
+			content: `repos:
+  - repo: https://github.com/psf/black
+    rev: 23.1.0
+`,
+			cfg:         config.Config{PreCommit: config.PreCommit{Filter: config.Filter{Exclude: []string{"psf/*"}}}},
+			wantSkipped: true,
+		},
+		{
+			name: "unknown tag",
+			content: `repos:
+  - repo: https://github.com/psf/black
+    rev: 99.0.0
+`,
+			wantErr: &interfaces.DocumentReference{Line: 3, Reference: "rev: 99.0.0"},
+		},
+		{
+			name: "not a pre-commit configuration",
+			content: `repo: https://github.com/psf/black
+rev: 23.1.0
+`,
+		},
+		{
+			name:    "not YAML",
+			content: "FROM golang:1.23\n",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, modified, refs := New().ReplaceInDocument(context.Background(), tt.content, client, tt.cfg)
+			switch {
+			case tt.wantErr != nil:
+				require.Len(t, refs, 1)
+				require.Equal(t, tt.wantErr.Line, refs[0].Line)
+				require.Equal(t, tt.wantErr.Reference, refs[0].Reference)
+				require.Error(t, refs[0].Err)
+				require.NotErrorIs(t, refs[0].Err, interfaces.ErrReferenceSkipped)
+			case tt.wantSkipped:
+				require.Len(t, refs, 1)
+				require.ErrorIs(t, refs[0].Err, interfaces.ErrReferenceSkipped)
+			case tt.want != "":
+				require.Len(t, refs, 1)
+				require.NoError(t, refs[0].Err)
+				require.Equal(t, testSHA, refs[0].Ref.Ref)
+			default:
+				require.Empty(t, refs)
+			}
+			if tt.want == "" {
+				require.False(t, modified)
+				require.Equal(t, tt.content, got)
+				return
+			}
+			require.True(t, modified)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGitHubRepository(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://github.com/psf/black", want: "psf/black"},
+		{url: "https://github.com/psf/black/", want: "psf/black"},
+		{url: "https://github.com/pre-commit/pre-commit-hooks.git", want: "pre-commit/pre-commit-hooks"},
+		{url: "ssh://git@github.com/psf/black.git", want: "psf/black"},
+		{url: "git@github.com:psf/black.git", want: "psf/black"},
+		{url: "https://gitlab.com/pycqa/flake8"},
+		{url: "https://github.com.evil.example/psf/black"},
+		{url: "https://github.com/psf"},
+		{url: "local"},
+		{url: "meta"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.url, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := githubRepository(tt.url)
+			require.Equal(t, tt.want != "", ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUnpin(t *testing.T) {
+	t.Parallel()
+
+	got, err := New().Unpin(`rev: "`+testSHA+`"`, "v4.5.0")
+	require.NoError(t, err)
+	require.Equal(t, &interfaces.EntityRef{Ref: testSHA, Type: ReferenceType, Tag: "v4.5.0", Prefix: `rev: "`}, got)
+	require.Equal(t, `rev: "v4.5.0"`, New().FormatReference(`rev: "`+testSHA+`"`, got.Tag))
+
+	_, err = New().Unpin("rev: "+testSHA, "")
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+	_, err = New().Unpin("rev: v4.5.0", "v4.5.0")
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+}
+
+func TestRevRegex(t *testing.T) {
+	t.Parallel()
+
+	re := regexp.MustCompile(RevRegex)
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "    rev: 23.1.0", want: "rev: 23.1.0"},
+		{line: `    rev: "v4.5.0" # comment`, want: `rev: "v4.5.0"`},
+		{line: "    rev: '" + testSHA + "' # v0.1.6", want: "rev: '" + testSHA + "'"},
+		{line: "  - repo: https://github.com/psf/black"},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, strings.TrimSpace(re.FindString(tt.line)), tt.line)
+	}
+}
@@ -18,73 +18,709 @@ package replacer
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/stacklok/frizbee/internal/traverse"
 	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/replacer/circleci"
 	"github.com/stacklok/frizbee/pkg/replacer/image"
+	"github.com/stacklok/frizbee/pkg/replacer/precommit"
+	"github.com/stacklok/frizbee/pkg/replacer/terraform"
 	"github.com/stacklok/frizbee/pkg/utils/config"
 	"github.com/stacklok/frizbee/pkg/utils/ghrest"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+	"github.com/stacklok/frizbee/pkg/utils/store"
 )
 
+// maxLineSize is the longest line the file scanners accept, generated manifests
+// may well exceed the bufio.Scanner default of 64KB
+const maxLineSize = 1024 * 1024
+
 // ReplaceResult holds a slice of all processed files along with a map of their modified content
 type ReplaceResult struct {
 	Processed []string
 	Modified  map[string]string
+	// Errors holds the references which looked pinnable but failed to resolve and were left untouched
+	Errors []ReferenceError
+	// Stats holds the reference counts of each processed file, modified or not
+	Stats map[string]FileStats
+	// Skipped holds the references skipped for a known reason, e.g. because of an excluded
+	// tag such as latest. The ones skipped for other reasons, e.g. already pinned, aren't held.
+	Skipped []interfaces.SkippedReference
+	// Changes holds the references pinned, or unpinned, including the ones pinned across
+	// the whole document, e.g. in Helm chart values
+	Changes []interfaces.ReferenceChange
+}
+
+// FileStats counts the references matched in a file by what happened to them
+type FileStats struct {
+	// Matched is the number of references matched in the file
+	Matched int `json:"matched"`
+	// Modified is the number of references pinned, or unpinned
+	Modified int `json:"modified"`
+	// Skipped is the number of references left untouched on purpose, e.g. excluded or already pinned
+	Skipped int `json:"skipped"`
+	// Errored is the number of references which failed to resolve
+	Errored int `json:"errored"`
+}
+
+// Totals returns the sum of the references counted in every file
+func (r *ReplaceResult) Totals() FileStats {
+	var totals FileStats
+	for _, stats := range r.Stats {
+		totals.Matched += stats.Matched
+		totals.Modified += stats.Modified
+		totals.Skipped += stats.Skipped
+		totals.Errored += stats.Errored
+	}
+	return totals
 }
 
 // ListResult holds the result of the list methods
 type ListResult struct {
 	Processed []string
 	Entities  []interfaces.EntityRef
+	// Locations holds every occurrence of the listed entities
+	Locations []EntityLocation
+}
+
+// EntityLocation is an entity reference along with the place it was found at
+type EntityLocation struct {
+	interfaces.EntityRef
+	// Path is the path of the file, empty when listing a single file
+	Path string `json:"path,omitempty"`
+	// Line is the 1-based line number
+	Line int `json:"line"`
+	// SkipReason is the reason the entity would be skipped for when pinning it, if known
+	SkipReason interfaces.SkipReason `json:"skip_reason,omitempty"`
+}
+
+// Unpinned returns the locations of the entities referenced by a mutable tag or
+// branch rather than by a checksum or digest
+func (l *ListResult) Unpinned() []EntityLocation {
+	unpinned := make([]EntityLocation, 0)
+	for _, loc := range l.Locations {
+		if !IsPinned(loc.EntityRef) {
+			unpinned = append(unpinned, loc)
+		}
+	}
+	return unpinned
+}
+
+// WithoutPinned returns a copy of the result keeping only the entities referenced by a
+// mutable tag or branch, along with their locations
+func (l *ListResult) WithoutPinned() *ListResult {
+	res := &ListResult{
+		Processed: l.Processed,
+		Entities:  make([]interfaces.EntityRef, 0, len(l.Entities)),
+		Locations: l.Unpinned(),
+	}
+	for _, e := range l.Entities {
+		if !IsPinned(e) {
+			res.Entities = append(res.Entities, e)
+		}
+	}
+	return res
+}
+
+// SkipReasons returns the reason each entity would be skipped for when pinning it, for
+// the entities whose reason is known
+func (l *ListResult) SkipReasons() map[interfaces.EntityRef]interfaces.SkipReason {
+	reasons := make(map[interfaces.EntityRef]interfaces.SkipReason)
+	for _, loc := range l.Locations {
+		if loc.SkipReason != "" {
+			reasons[loc.EntityRef] = loc.SkipReason
+		}
+	}
+	return reasons
+}
+
+// IsPinned returns true if the entity is referenced by a checksum or digest, or by
+// the full version of an orb, rather than by a mutable tag, branch or version range
+func IsPinned(e interfaces.EntityRef) bool {
+	switch e.Type {
+	case image.ReferenceType:
+		_, err := v1.NewHash(e.Ref)
+		return err == nil
+	case circleci.ReferenceType:
+		return circleci.IsPinnedVersion(e.Ref)
+	default:
+		return actions.IsChecksum(e.Ref)
+	}
+}
+
+var (
+	// ErrUnresolved is matched by the errors returned when references fail to resolve
+	// and the replacer is set to fail on unresolved references
+	ErrUnresolved = errors.New("failed to resolve references")
+	// ErrRefTimeout is matched by the errors of references which failed to resolve
+	// within the timeout set through WithPerRefTimeout
+	ErrRefTimeout = errors.New("reference resolution timed out")
+	// ErrInvalidRegex is matched by the errors of regular expression patterns which don't
+	// compile, e.g. the one set through WithUserRegex
+	ErrInvalidRegex = errors.New("invalid regex")
+)
+
+// ReferenceError is a reference that looks pinnable, i.e. it's neither skipped nor
+// invalid, but failed to resolve, e.g. because of a typo in its name or tag
+type ReferenceError struct {
+	// Path is the path of the file, empty when parsing a single file
+	Path string
+	// Line is the 1-based line number
+	Line      int
+	Reference string
+	Err       error
+}
+
+// Error implements the error interface
+func (e *ReferenceError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d: %s: %v", e.Line, e.Reference, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s: %v", e.Path, e.Line, e.Reference, e.Err)
+}
+
+// Unwrap returns the cause of the error
+func (e *ReferenceError) Unwrap() error {
+	return e.Err
+}
+
+// UnresolvedError lists the references which failed to resolve
+type UnresolvedError struct {
+	References []ReferenceError
+}
+
+// Error implements the error interface
+func (e *UnresolvedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "failed to resolve %d reference(s):", len(e.References))
+	for _, u := range e.References {
+		b.WriteString("\n  " + u.Error())
+	}
+	return b.String()
+}
+
+// Is makes the error match ErrUnresolved
+func (*UnresolvedError) Is(target error) bool {
+	return target == ErrUnresolved
 }
 
 // Replacer is an object with methods to replace references with digests
 type Replacer struct {
-	parser interfaces.Parser
-	rest   interfaces.REST
-	cfg    config.Config
+	parser           interfaces.Parser
+	rest             interfaces.REST
+	cfg              config.Config
+	maxConcurrency   int
+	remoteOpts       []remote.Option
+	retry            *retry.Policy
+	failOnUnresolved bool
+	timeouts         refTimeouts
+	// configName is the name of the configuration files discovered next to the processed
+	// files, see WithConfigDiscovery
+	configName string
+	// insecureRegistries and registryCAs configure the transport talking to container
+	// registries, see WithInsecureRegistries and WithRegistryCACert
+	insecureRegistries []string
+	registryCAs        *x509.CertPool
+	// logger logs the resolution of each reference at debug level, if set, see WithLogger
+	logger *slog.Logger
+	// onReplace is called for each reference replaced or skipped, if set, see WithOnReplace
+	onReplace OnReplaceFunc
+	// progress is called as the files are found and processed, if set, see WithProgress
+	progress ProgressFunc
+	// onlyFiles restricts the files parsed or unpinned to these ones, relative to the
+	// processed directory, if set, see WithOnlyFiles
+	onlyFiles map[string]bool
+	// anyFileName processes a file given as the path whatever its name, see WithAnyFileName
+	anyFileName bool
+	// regexErr is the error compiling the regex set through WithUserRegex, returned by the
+	// listings and replacements rather than failing on the first file
+	regexErr error
 }
 
-// NewGitHubActionsReplacer creates a new replacer for GitHub actions
-func NewGitHubActionsReplacer(cfg *config.Config) *Replacer {
-	cfg = config.MergeUserConfig(cfg)
+// OnReplaceFunc is called for each reference matched in a file with the reference it
+// resolved to, the matched reference, the one replacing it, the file and the 1-based line.
+// For skipped references the ref is nil and after holds the reason they were skipped,
+// e.g. excluded-image, which is empty if there's no specific one, e.g. already pinned.
+type OnReplaceFunc func(ref *interfaces.EntityRef, before, after string, file string, line int)
 
-	return &Replacer{
-		cfg:    *cfg,
-		parser: actions.New(),
-		rest:   ghrest.NewClient(""),
+// ProgressFunc is called each time a file to process is found or processed with the
+// number of files processed and found so far. The files are processed while the
+// directory is still being traversed, so the total grows until the traversal is over.
+type ProgressFunc func(done, total int)
+
+// refTimeouts bounds the time spent resolving a single reference
+type refTimeouts struct {
+	// perRef is the timeout of a single resolution, zero means none
+	perRef time.Duration
+	// skip leaves the references timing out untouched without reporting them
+	skip bool
+}
+
+// matchFilter is implemented by parsers whose regular expression pattern may match
+// within a longer key, which RE2 can't rule out without a lookbehind, e.g. the container
+// key of the image parser at the end of an init-container key
+type matchFilter interface {
+	// KeepMatch returns false if the match starting at the offset of the line isn't a reference
+	KeepMatch(line string, start int) bool
+}
+
+// findMatches returns the index pairs of the matches of the regex in the line, leaving out
+// the ones rejected by the parser if it's a matchFilter
+func findMatches(re *regexp.Regexp, parser interfaces.Parser, line string) [][]int {
+	matches := re.FindAllStringIndex(line, -1)
+	f, ok := parser.(matchFilter)
+	if !ok {
+		return matches
+	}
+	kept := matches[:0]
+	for _, match := range matches {
+		if f.KeepMatch(line, match[0]) {
+			kept = append(kept, match)
+		}
+	}
+	return kept
+}
+
+// replaceMatches returns the line with the matches found by findMatches replaced by the
+// return value of repl, like ReplaceAllStringFunc
+func replaceMatches(re *regexp.Regexp, parser interfaces.Parser, line string, repl func(string) string) string {
+	matches := findMatches(re, parser, line)
+	if len(matches) == 0 {
+		return line
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		b.WriteString(line[last:match[0]])
+		b.WriteString(repl(line[match[0]:match[1]]))
+		last = match[1]
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// regexCompiler is implemented by parsers compiling their regular expression pattern once
+// when it's set rather than for every file
+type regexCompiler interface {
+	CompiledRegex() (*regexp.Regexp, error)
+}
+
+// compileRegex returns the compiled regular expression pattern of the parser
+func compileRegex(parser interfaces.Parser) (*regexp.Regexp, error) {
+	var re *regexp.Regexp
+	var err error
+	if c, ok := parser.(regexCompiler); ok {
+		re, err = c.CompiledRegex()
+	} else {
+		re, err = regexp.Compile(parser.GetRegex())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidRegex, parser.GetRegex(), err)
+	}
+	return re, nil
+}
+
+// remoteOptionsSetter is implemented by parsers resolving container images
+type remoteOptionsSetter interface {
+	SetRemoteOptions(opts ...remote.Option)
+}
+
+// imageKeysSetter is implemented by parsers matching container images by their YAML key
+type imageKeysSetter interface {
+	SetImageKeys(keys []string)
+}
+
+// buildArgKeysSetter is implemented by parsers matching the base images passed as build args
+type buildArgKeysSetter interface {
+	SetBuildArgKeys(keys []string)
+}
+
+// platformsSetter is implemented by parsers resolving container images
+type platformsSetter interface {
+	SetPlatforms(platforms []v1.Platform)
+}
+
+// retryPolicySetter is implemented by parsers resolving container images
+type retryPolicySetter interface {
+	SetRetryPolicy(policy retry.Policy)
+}
+
+// envLookupSetter is implemented by parsers expanding variables in the references
+type envLookupSetter interface {
+	SetEnvLookup(lookup func(string) (string, bool))
+}
+
+// commitVerifierSetter is implemented by parsers resolving GitHub Actions
+type commitVerifierSetter interface {
+	SetVerifyCommits(verify bool)
+}
+
+// mediaTyper is implemented by parsers telling the media type of the manifest the listed
+// container images refer to
+type mediaTyper interface {
+	MediaType(ctx context.Context, e interfaces.EntityRef) (string, error)
+}
+
+// resolverSetter is implemented by parsers resolving GitHub Actions or container images
+type resolverSetter interface {
+	SetResolver(resolver interfaces.Resolver)
+}
+
+// resolveTimeoutSetter is implemented by parsers resolving references outside of Replace
+type resolveTimeoutSetter interface {
+	SetResolveTimeout(d time.Duration)
+}
+
+// loggerSetter is implemented by parsers logging their cache lookups
+type loggerSetter interface {
+	SetLogger(logger *slog.Logger)
+}
+
+// circleCIAPISetter is implemented by parsers resolving CircleCI orbs
+type circleCIAPISetter interface {
+	SetHost(host string)
+	SetToken(token string)
+}
+
+// fileTraverser is implemented by parsers processing files other than YAML files and Dockerfiles
+type fileTraverser interface {
+	TraverseFiles(bfs billy.Filesystem, base string, fn func(path string) error, opts ...traverse.Option) error
+}
+
+// referenceFormatter is implemented by parsers whose references don't follow the
+// name@ref layout, e.g. Terraform module sources holding the ref in their query
+type referenceFormatter interface {
+	// FormatReference rewrites the matched reference to point at the given ref or tag
+	FormatReference(matchedLine, ref string) string
+}
+
+// skipClassifier is implemented by parsers able to tell why a reference would be
+// skipped without resolving it, e.g. because of an excluded tag
+type skipClassifier interface {
+	SkipReason(matchedLine string, cfg config.Config) interfaces.SkipReason
+}
+
+// stageTracker is implemented by parsers whose references may name a build stage defined
+// earlier in the same file rather than a remote entity, e.g. FROM builder after
+// FROM golang AS builder in multi-stage Dockerfiles
+type stageTracker interface {
+	// DefinedStage returns the name of the stage defined by the line, if any
+	DefinedStage(line string) string
+	// UsedStage returns the name of the stage the matched reference would build upon
+	UsedStage(matchedLine string) string
+}
+
+// fileStages holds the stages defined so far in a file processed by a stageTracker
+type fileStages struct {
+	tracker stageTracker
+	names   map[string]bool
+}
+
+// newFileStages returns the stages of a file processed by the parser, which are never
+// recorded if the parser isn't a stageTracker
+func newFileStages(parser interfaces.Parser) *fileStages {
+	tracker, _ := parser.(stageTracker)
+	return &fileStages{tracker: tracker, names: make(map[string]bool)}
+}
+
+// record records the stage defined by the line, if any
+func (s *fileStages) record(line string) {
+	if s.tracker == nil {
+		return
+	}
+	if name := s.tracker.DefinedStage(line); name != "" {
+		s.names[name] = true
+	}
+}
+
+// uses returns true if the matched reference names a stage recorded earlier
+func (s *fileStages) uses(matchedLine string) bool {
+	if s.tracker == nil || len(s.names) == 0 {
+		return false
 	}
+	return s.names[s.tracker.UsedStage(matchedLine)]
+}
+
+// parentKeyMatcher is implemented by parsers whose matches only reference an entity when
+// nested under some YAML keys, e.g. the name key of GitLab CI images, which must be under
+// an image or services key
+type parentKeyMatcher interface {
+	// ParentKeys returns the keys the matched reference must be nested under, nil if it
+	// references an entity wherever it is
+	ParentKeys(matchedLine string) []string
+}
+
+// yamlKeyRegex matches the key of a YAML mapping entry, e.g. image: in "image: nginx"
+var yamlKeyRegex = regexp.MustCompile(`^([^\s:#"'{}\[\],]+)\s*:(\s|$)`)
+
+// yamlKey is a key of a YAML mapping along with its indentation
+type yamlKey struct {
+	indent int
+	name   string
+}
+
+// fileKeys holds the YAML keys enclosing the line of a file processed by a parentKeyMatcher,
+// which are never tracked if the parser isn't a parentKeyMatcher
+type fileKeys struct {
+	matcher parentKeyMatcher
+	keys    []yamlKey
+	parent  string
+}
+
+// newFileKeys returns the keys enclosing the lines of a file processed by the parser
+func newFileKeys(parser interfaces.Parser) *fileKeys {
+	matcher, _ := parser.(parentKeyMatcher)
+	return &fileKeys{matcher: matcher}
+}
+
+// enter moves to the line, which must be called before matching the references of the line
+func (k *fileKeys) enter(line string) {
+	if k.matcher == nil || strings.TrimSpace(line) == "" {
+		return
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	indent := len(line) - len(trimmed)
+	item := strings.HasPrefix(trimmed, "- ")
+	// A sequence may be indented like the key holding it
+	for len(k.keys) > 0 {
+		top := k.keys[len(k.keys)-1]
+		if top.indent < indent || (item && top.indent == indent) {
+			break
+		}
+		k.keys = k.keys[:len(k.keys)-1]
+	}
+	k.parent = ""
+	if len(k.keys) > 0 {
+		k.parent = k.keys[len(k.keys)-1].name
+	}
+	// A sequence item holding a mapping opens it at the column of its first key
+	for strings.HasPrefix(trimmed, "- ") {
+		rest := strings.TrimLeft(trimmed[1:], " ")
+		indent += len(trimmed) - len(rest)
+		trimmed = rest
+	}
+	if m := yamlKeyRegex.FindStringSubmatch(trimmed); m != nil {
+		k.keys = append(k.keys, yamlKey{indent: indent, name: m[1]})
+	}
+}
+
+// allows returns false if the matched reference isn't nested under the keys the parser requires
+func (k *fileKeys) allows(matchedLine string) bool {
+	if k.matcher == nil {
+		return true
+	}
+	parents := k.matcher.ParentKeys(matchedLine)
+	return parents == nil || slices.Contains(parents, k.parent)
+}
+
+// documentReplacer is implemented by parsers pinning references which can't be matched
+// line by line, e.g. container images split across several keys of Helm chart values
+// or the repo and rev keys of pre-commit hook repositories. The references which failed
+// to resolve are returned along with their error, to be reported like the ones matched
+// line by line.
+type documentReplacer interface {
+	ReplaceInDocument(
+		ctx context.Context,
+		content string,
+		restIf interfaces.REST,
+		cfg config.Config,
+	) (string, bool, []interfaces.DocumentReference)
+}
+
+// DefaultMaxConcurrency returns the default limit of files processed concurrently
+func DefaultMaxConcurrency() int {
+	return runtime.NumCPU() * 4
+}
+
+// NewGitHubActionsReplacer creates a new replacer for GitHub actions
+func NewGitHubActionsReplacer(cfg *config.Config) *Replacer {
+	return newReplacer(actions.New(), cfg)
 }
 
 // NewContainerImagesReplacer creates a new replacer for container images
 func NewContainerImagesReplacer(cfg *config.Config) *Replacer {
+	return newReplacer(image.New(), cfg)
+}
+
+// NewCircleCIOrbsReplacer creates a new replacer for CircleCI orbs
+func NewCircleCIOrbsReplacer(cfg *config.Config) *Replacer {
+	return newReplacer(circleci.New(), cfg)
+}
+
+// NewTerraformModulesReplacer creates a new replacer for Terraform and OpenTofu module sources
+func NewTerraformModulesReplacer(cfg *config.Config) *Replacer {
+	return newReplacer(terraform.New(), cfg)
+}
+
+// NewPreCommitHooksReplacer creates a new replacer for the revs of pre-commit hook repositories
+func NewPreCommitHooksReplacer(cfg *config.Config) *Replacer {
+	return newReplacer(precommit.New(), cfg)
+}
+
+// newReplacer creates a new replacer using the given parser
+func newReplacer(parser interfaces.Parser, cfg *config.Config) *Replacer {
 	cfg = config.MergeUserConfig(cfg)
+	if p, ok := parser.(imageKeysSetter); ok && len(cfg.Images.ImageKeys) > 0 {
+		p.SetImageKeys(cfg.Images.ImageKeys)
+	}
+	if p, ok := parser.(buildArgKeysSetter); ok && len(cfg.Images.BuildArgKeys) > 0 {
+		p.SetBuildArgKeys(cfg.Images.BuildArgKeys)
+	}
 
 	return &Replacer{
-		cfg:    *cfg,
-		parser: image.New(),
-		rest:   ghrest.NewClient(""),
+		cfg:            *cfg,
+		parser:         parser,
+		rest:           ghrest.NewClient(""),
+		maxConcurrency: DefaultMaxConcurrency(),
 	}
 }
 
 // WithGitHubClientFromToken creates an authenticated GitHub client from a token
 func (r *Replacer) WithGitHubClientFromToken(token string) *Replacer {
 	client := ghrest.NewClient(token)
+	if r.retry != nil {
+		client = client.WithRetry(*r.retry)
+	}
+	r.rest = client
+	return r
+}
+
+// WithGitHubAppAuth authenticates the GitHub API requests as the given installation of
+// a GitHub App, and so with the rate limits of the app, rather than with a static token.
+// The private key is the PEM encoded key generated for the app.
+func (r *Replacer) WithGitHubAppAuth(appID, installationID int64, privateKeyPEM []byte) (*Replacer, error) {
+	client, err := ghrest.NewAppClient(appID, installationID, privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if r.retry != nil {
+		client = client.WithRetry(*r.retry)
+	}
+	r.rest = client
+	return r, nil
+}
+
+// WithRetry retries GitHub API requests and container image resolutions failing
+// with transient errors, i.e. network or server errors and rate limiting, according
+// to the given policy. It has no effect on GitHub clients set through WithGitHubClient.
+func (r *Replacer) WithRetry(policy retry.Policy) *Replacer {
+	r.retry = &policy
+	if client, ok := r.rest.(*ghrest.Client); ok {
+		r.rest = client.WithRetry(policy)
+	}
+	if p, ok := r.parser.(retryPolicySetter); ok {
+		p.SetRetryPolicy(policy)
+	}
+	// Don't let the registry client retry server errors on its own as well
+	return r.withRemoteOptions(remote.WithRetryStatusCodes())
+}
+
+// WithVerifyResolvedCommits checks that the commit each action reference resolved to
+// exists before pinning it, e.g. in case the tag was force-updated in the meantime.
+// It costs an additional GitHub API request per reference, so it's opt-in. It has no
+// effect on the replacers not resolving GitHub Actions.
+func (r *Replacer) WithVerifyResolvedCommits() *Replacer {
+	if p, ok := r.parser.(commitVerifierSetter); ok {
+		p.SetVerifyCommits(true)
+	}
+	return r
+}
+
+// WithResolver resolves the checksums of the GitHub Actions and the digests of the
+// container images through the given resolver rather than through the GitHub API and the
+// registries, e.g. to go through an artifact proxy. The resolved references are cached
+// like the ones resolved by default, see DefaultResolver to fall back to them. As the
+// resolver doesn't tell the tags of the actions from their branches, the branch filters
+// of the configuration apply to all of their references. It has no effect on the
+// replacers not resolving GitHub Actions or container images.
+func (r *Replacer) WithResolver(resolver interfaces.Resolver) *Replacer {
+	if p, ok := r.parser.(resolverSetter); ok {
+		p.SetResolver(resolver)
+	}
+	return r
+}
+
+// DefaultResolver returns the resolver going through the GitHub client, the
+// configuration and the registry options of the replacer, i.e. resolving the references
+// as the replacer does unless given another resolver through WithResolver
+func (r *Replacer) DefaultResolver() *DefaultResolver {
+	return NewDefaultResolver(r.rest, r.cfg.GHActions, r.remoteOpts...)
+}
+
+// WithGitHubBaseURL points the GitHub client at the given API base URL, e.g. of a
+// GitHub Enterprise Server. It's only supported by the clients created by frizbee,
+// i.e. not by the ones set through WithGitHubClient.
+func (r *Replacer) WithGitHubBaseURL(baseURL string) (*Replacer, error) {
+	client, ok := r.rest.(*ghrest.Client)
+	if !ok {
+		return nil, fmt.Errorf("cannot set the base URL %s of a custom GitHub client", baseURL)
+	}
+	client, err := client.WithBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	r.rest = client
+	return r, nil
+}
+
+// WithGitHubHostTokens authenticates the GitHub API requests with the token of each host,
+// e.g. for workflows using both public actions and actions of a GitHub Enterprise Server.
+// The github.com token is used for the actions without a host, the actions prefixed by
+// another host, e.g. ghe.example-corp.com/team/action, are resolved through its API.
+// It's only supported by the clients created by frizbee, and so must be called after
+// WithGitHubClientFromToken or WithGitHubAppAuth, if at all.
+func (r *Replacer) WithGitHubHostTokens(tokens map[string]string) (*Replacer, error) {
+	client, ok := r.rest.(*ghrest.Client)
+	if !ok {
+		return nil, errors.New("cannot set the host tokens of a custom GitHub client")
+	}
+	client, err := client.WithHostTokens(tokens)
+	if err != nil {
+		return nil, err
+	}
 	r.rest = client
+	return r, nil
+}
+
+// WithCircleCIToken sets the CircleCI personal API token used to resolve private orbs
+func (r *Replacer) WithCircleCIToken(token string) *Replacer {
+	if p, ok := r.parser.(circleCIAPISetter); ok {
+		p.SetToken(token)
+	}
+	return r
+}
+
+// WithCircleCIHost points the orb resolution at the given CircleCI host, e.g. of a
+// CircleCI server installation, rather than circleci.com
+func (r *Replacer) WithCircleCIHost(host string) *Replacer {
+	if p, ok := r.parser.(circleCIAPISetter); ok && host != "" {
+		p.SetHost(host)
+	}
 	return r
 }
 
@@ -94,10 +730,13 @@ func (r *Replacer) WithGitHubClient(client interfaces.REST) *Replacer {
 	return r
 }
 
-// WithUserRegex sets a user-provided regex for the parser
+// WithUserRegex sets a user-provided regex for the parser. The regex is validated right
+// away, if it's invalid the listings and replacements fail with ErrInvalidRegex before
+// processing any file.
 func (r *Replacer) WithUserRegex(regex string) *Replacer {
 	if r.parser != nil && regex != "" {
 		r.parser.SetRegex(regex)
+		_, r.regexErr = compileRegex(r.parser)
 	}
 	return r
 }
@@ -108,43 +747,388 @@ func (r *Replacer) WithCacheDisabled() *Replacer {
 	return r
 }
 
+// WithCache sets the cache used to store resolved references, e.g. a
+// store.FileCacher persisting them across runs
+func (r *Replacer) WithCache(cache store.RefCacher) *Replacer {
+	r.parser.SetCache(cache)
+	return r
+}
+
+// WithFailOnUnresolved makes parsing fail with an UnresolvedError listing every reference
+// which looks pinnable but failed to resolve, rather than leaving them untouched
+func (r *Replacer) WithFailOnUnresolved() *Replacer {
+	r.failOnUnresolved = true
+	return r
+}
+
+// WithEnvExpansion expands the $VAR and ${VAR} variables of the references before
+// resolving them, e.g. image: ${REGISTRY}/app:1.2.3, looking them up in the given map
+// or in the environment of the process if it's nil. The unknown variables are left as
+// is, so their references are skipped rather than resolved to something else. The
+// pinned references are written expanded, as that's what their digests were resolved
+// for, except for the ones pinned in place, e.g. in Helm chart values. It has no
+// effect on the replacers not resolving GitHub Actions or container images.
+func (r *Replacer) WithEnvExpansion(env map[string]string) *Replacer {
+	if p, ok := r.parser.(envLookupSetter); ok {
+		lookup := os.LookupEnv
+		if env != nil {
+			lookup = func(key string) (string, bool) {
+				value, ok := env[key]
+				return value, ok
+			}
+		}
+		p.SetEnvLookup(lookup)
+	}
+	return r
+}
+
+// WithLogger sets the logger the resolution of each reference is logged to at debug
+// level, along with its outcome, i.e. the pinned reference, the reason it was skipped or
+// the error it failed with, and whether it was served from the cache. Nothing is logged
+// by default.
+func (r *Replacer) WithLogger(logger *slog.Logger) *Replacer {
+	r.logger = logger
+	if p, ok := r.parser.(loggerSetter); ok {
+		p.SetLogger(logger)
+	}
+	return r
+}
+
+// WithOnReplace sets the function called for each reference replaced or skipped while
+// parsing files, e.g. to build a custom report, including the ones pinned across the whole
+// document such as Helm chart values. The file is empty for ParseFile. As files are
+// processed concurrently, the function may be called from several goroutines at once.
+func (r *Replacer) WithOnReplace(fn OnReplaceFunc) *Replacer {
+	r.onReplace = fn
+	return r
+}
+
+// WithProgress sets the function called as the files ParsePath and UnpinPath, and their
+// InFS variants, process are found and processed, e.g. to print a progress indicator.
+// The calls are serialized, so the function doesn't need to be safe for concurrent use,
+// but it holds up the processing of the files until it returns.
+func (r *Replacer) WithProgress(fn ProgressFunc) *Replacer {
+	r.progress = fn
+	return r
+}
+
+// WithOnlyFiles restricts the files ParsePath and UnpinPath, and their InFS variants,
+// process to the given ones, relative to the processed directory, e.g. the files changed
+// by a pull request. The files which wouldn't be processed otherwise still aren't.
+func (r *Replacer) WithOnlyFiles(paths []string) *Replacer {
+	r.onlyFiles = make(map[string]bool, len(paths))
+	for _, path := range paths {
+		r.onlyFiles[filepath.ToSlash(filepath.Clean(path))] = true
+	}
+	return r
+}
+
+// WithAnyFileName makes ParsePath and UnpinPath, and their InFS variants, process a file
+// given as the path whatever its name, e.g. a file of a custom format matched through
+// WithUserRegex, rather than only the files they would process in a directory
+func (r *Replacer) WithAnyFileName() *Replacer {
+	r.anyFileName = true
+	return r
+}
+
+// walkFiles returns the function calling fn with each file of the given directory processed
+// by the parser, see traverseFiles, or with the base itself if it's a file processed
+// whatever its name according to WithAnyFileName
+func (r *Replacer) walkFiles(bfs billy.Filesystem, base string) func(fn func(path string) error) error {
+	if r.anyFileName {
+		if info, err := bfs.Stat(base); err == nil && info.Mode().IsRegular() {
+			return func(fn func(path string) error) error {
+				return fn(base)
+			}
+		}
+	}
+	return walkFiles(r.parser, bfs, base, &r.cfg, r.logger)
+}
+
+// includeFile returns the function telling whether a file of the given directory is to be
+// processed according to WithOnlyFiles, nil if every file is
+func (r *Replacer) includeFile(base string) func(path string) bool {
+	if r.onlyFiles == nil {
+		return nil
+	}
+	return func(path string) bool {
+		rel, err := filepath.Rel(base, path)
+		return err == nil && r.onlyFiles[filepath.ToSlash(rel)]
+	}
+}
+
+// onReplaceIn returns the function set through WithOnReplace bound to the given file,
+// nil if there's none
+func (r *Replacer) onReplaceIn(file string) func(ref *interfaces.EntityRef, before, after string, line int) {
+	if r.onReplace == nil {
+		return nil
+	}
+	return func(ref *interfaces.EntityRef, before, after string, line int) {
+		r.onReplace(ref, before, after, file, line)
+	}
+}
+
+// WithConfigDiscovery makes parsing a directory use the nearest configuration file with the
+// given name, e.g. .frizbee.yml, found walking up from each processed file to the processed
+// directory, rather than the replacer's configuration, like .editorconfig files. The files
+// without a configuration file above them keep using the replacer's configuration, as do
+// the traversal, i.e. the exclude_paths and include_extensions, and the image_keys.
+func (r *Replacer) WithConfigDiscovery(name string) *Replacer {
+	r.configName = name
+	return r
+}
+
+// WithPerRefTimeout bounds the time spent resolving a single reference, including
+// retries, so a hung registry or API doesn't stall the remaining ones. References
+// timing out are reported like the ones failing to resolve, see WithSkipOnTimeout.
+// A value of zero or less means no timeout.
+func (r *Replacer) WithPerRefTimeout(d time.Duration) *Replacer {
+	r.timeouts.perRef = max(d, 0)
+	if p, ok := r.parser.(resolveTimeoutSetter); ok {
+		p.SetResolveTimeout(r.timeouts.perRef)
+	}
+	return r
+}
+
+// WithSkipOnTimeout leaves the references timing out untouched without reporting them
+// as unresolved, i.e. they don't show up in ReplaceResult.Errors nor fail parsing with
+// WithFailOnUnresolved
+func (r *Replacer) WithSkipOnTimeout() *Replacer {
+	r.timeouts.skip = true
+	return r
+}
+
+// WithPlatforms requires every pinned container image to be available for all the given
+// platforms, e.g. linux/amd64 and linux/arm64, i.e. the digest must point at an index
+// covering them or at an image of the only given platform. Images which aren't are
+// reported like the ones failing to resolve, wrapping image.ErrPlatformNotFound.
+func (r *Replacer) WithPlatforms(platforms []string) (*Replacer, error) {
+	parsed := make([]v1.Platform, 0, len(platforms))
+	for _, platform := range platforms {
+		p, err := config.ParsePlatform(platform)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, *p)
+	}
+	if p, ok := r.parser.(platformsSetter); ok {
+		p.SetPlatforms(parsed)
+	}
+	return r, nil
+}
+
+// WithMaxConcurrency limits the number of files processed concurrently when
+// parsing or listing a path. A value of zero or less means unbounded.
+func (r *Replacer) WithMaxConcurrency(n int) *Replacer {
+	r.maxConcurrency = n
+	return r
+}
+
+// WithKeychain sets the keychain used to authenticate against container registries,
+// e.g. an authn.NewMultiKeychain composing cloud provider credential helpers
+func (r *Replacer) WithKeychain(keychain authn.Keychain) *Replacer {
+	return r.withRemoteOptions(remote.WithAuthFromKeychain(keychain))
+}
+
+// WithDockerConfig authenticates against container registries with the credentials of
+// the docker config.json at the given path, e.g. to resolve private quay.io images with
+// a config kept at a custom location in CI, instead of the default docker config
+func (r *Replacer) WithDockerConfig(path string) (*Replacer, error) {
+	keychain, err := image.NewDockerConfigKeychain(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.WithKeychain(keychain), nil
+}
+
+// WithInsecureRegistries talks to the given container registries, as host or host:port,
+// without verifying their TLS certificates and falling back to plain HTTP if they don't
+// serve HTTPS. The other registries are still verified strictly.
+func (r *Replacer) WithInsecureRegistries(hosts []string) *Replacer {
+	r.insecureRegistries = hosts
+	return r.withRegistryTransport()
+}
+
+// WithRegistryCACert verifies the TLS certificates of container registries against the
+// PEM encoded certificate authorities of the given file, e.g. the private CA of an internal
+// registry, on top of the system ones
+func (r *Replacer) WithRegistryCACert(path string) (*Replacer, error) {
+	pem, err := os.ReadFile(path) // nolint:gosec // The file is chosen by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM encoded certificate found in %s", path)
+	}
+	r.registryCAs = pool
+	return r.withRegistryTransport(), nil
+}
+
+// withRegistryTransport sets the transport talking to container registries according to
+// the insecure registries and certificate authorities configured
+func (r *Replacer) withRegistryTransport() *Replacer {
+	return r.withRemoteOptions(remote.WithTransport(image.NewRegistryTransport(r.insecureRegistries, r.registryCAs)))
+}
+
+// withRemoteOptions adds options used when talking to container registries
+func (r *Replacer) withRemoteOptions(opts ...remote.Option) *Replacer {
+	r.remoteOpts = append(r.remoteOpts, opts...)
+	if p, ok := r.parser.(remoteOptionsSetter); ok {
+		p.SetRemoteOptions(r.remoteOpts...)
+	}
+	return r
+}
+
 // ParseString parses and returns the referenced entity pinned by its digest
 func (r *Replacer) ParseString(ctx context.Context, entityRef string) (*interfaces.EntityRef, error) {
-	return r.parser.Replace(ctx, entityRef, r.rest, r.cfg)
+	ret, err := r.timeouts.replace(ctx, r.parser, entityRef, r.rest, r.cfg)
+	logResolution(ctx, r.logger, entityRef, ret, err)
+	return ret, err
+}
+
+// ResolveMediaType returns the media type of the manifest the listed image reference refers
+// to, e.g. an OCI image index for multi-platform images, asking the registry for it as
+// listing doesn't resolve the references. An error matching ErrReferenceSkipped is returned
+// if the parser doesn't resolve container images.
+func (r *Replacer) ResolveMediaType(ctx context.Context, e interfaces.EntityRef) (string, error) {
+	typer, ok := r.parser.(mediaTyper)
+	if !ok || e.Type != image.ReferenceType {
+		return "", fmt.Errorf("%s %w", e.Name, interfaces.ErrReferenceSkipped)
+	}
+	return typer.MediaType(ctx, e)
+}
+
+// osFS returns the file system of the OS holding the given directory along with the
+// base of the directory in it. The directory is made absolute first as the bound file
+// system of a relative directory, e.g. the parent of ., rejects the paths under it, and
+// resolved if it's a link as the bound file system rejects the links pointing outside it.
+func osFS(dir string) (billy.Filesystem, string) {
+	dir = traverse.RealPath(dir)
+	return osfs.New(filepath.Dir(dir), osfs.WithBoundOS()), filepath.Base(dir)
 }
 
 // ParsePath parses and replaces all entity references in the provided directory
 func (r *Replacer) ParsePath(ctx context.Context, dir string) (*ReplaceResult, error) {
-	return parsePathInFS(ctx, r.parser, r.rest, r.cfg, osfs.New(filepath.Dir(dir), osfs.WithBoundOS()), filepath.Base(dir))
+	bfs, base := osFS(dir)
+	return r.ParsePathInFS(ctx, bfs, base)
 }
 
 // ParsePathInFS parses and replaces all entity references in the provided file system
 func (r *Replacer) ParsePathInFS(ctx context.Context, bfs billy.Filesystem, base string) (*ReplaceResult, error) {
-	return parsePathInFS(ctx, r.parser, r.rest, r.cfg, bfs, base)
+	if r.regexErr != nil {
+		return nil, r.regexErr
+	}
+	return replaceInFS(bfs, r.walkFiles(bfs, base), r.maxConcurrency, r.failOnUnresolved,
+		r.includeFile(base), r.progress, r.parseFileFunc(ctx, bfs, base))
+}
+
+// parseFileFunc returns the function parsing and replacing the references of a file of
+// the given directory, with the configuration discovered for it if any
+func (r *Replacer) parseFileFunc(ctx context.Context, bfs billy.Filesystem, base string) fileReplaceFunc {
+	configs := newConfigResolver(bfs, base, r.configName, r.cfg)
+	return func(path string, f io.Reader) (fileResult, error) {
+		cfg, err := configs.forFile(path)
+		if err != nil {
+			return fileResult{}, err
+		}
+		logger := r.logger
+		if logger != nil {
+			logger = logger.With("file", path)
+		}
+		return parseAndReplaceReferencesInFile(ctx, f, r.parser, r.rest, cfg, r.timeouts, logger, r.onReplaceIn(path))
+	}
 }
 
 // ParseFile parses and replaces all entity references in the provided file
 func (r *Replacer) ParseFile(ctx context.Context, f io.Reader) (bool, string, error) {
-	return parseAndReplaceReferencesInFile(ctx, f, r.parser, r.rest, r.cfg)
+	if r.regexErr != nil {
+		return false, "", r.regexErr
+	}
+	res, err := parseAndReplaceReferencesInFile(ctx, f, r.parser, r.rest, r.cfg, r.timeouts, r.logger, r.onReplaceIn(""))
+	if err != nil {
+		return false, "", err
+	}
+	if r.failOnUnresolved && len(res.errors) > 0 {
+		return false, "", &UnresolvedError{References: res.errors}
+	}
+	return res.modified, res.content, nil
+}
+
+// UnpinPath reverts all entity references pinned by their digest in the provided directory back to their tags
+func (r *Replacer) UnpinPath(ctx context.Context, dir string) (*ReplaceResult, error) {
+	bfs, base := osFS(dir)
+	return r.UnpinPathInFS(ctx, bfs, base)
+}
+
+// UnpinPathInFS reverts all entity references pinned by their digest in the provided file system back to their tags
+func (r *Replacer) UnpinPathInFS(ctx context.Context, bfs billy.Filesystem, base string) (*ReplaceResult, error) {
+	if r.regexErr != nil {
+		return nil, r.regexErr
+	}
+	return unpinPathInFS(ctx, r.parser, bfs, r.walkFiles(bfs, base), r.maxConcurrency, r.includeFile(base), r.progress)
+}
+
+// UnpinFile reverts all entity references pinned by their digest in the provided file back to their tags
+func (r *Replacer) UnpinFile(ctx context.Context, f io.Reader) (bool, string, error) {
+	if r.regexErr != nil {
+		return false, "", r.regexErr
+	}
+	res, err := unpinReferencesInFile(ctx, f, r.parser)
+	if err != nil {
+		return false, "", err
+	}
+	return res.modified, res.content, nil
 }
 
 // ListPath lists all entity references in the provided directory
 func (r *Replacer) ListPath(dir string) (*ListResult, error) {
-	return listReferencesInFS(r.parser, osfs.New(filepath.Dir(dir), osfs.WithBoundOS()), filepath.Base(dir))
+	bfs, base := osFS(dir)
+	return r.ListPathInFS(bfs, base)
 }
 
 // ListPathInFS lists all entity references in the provided file system
 func (r *Replacer) ListPathInFS(bfs billy.Filesystem, base string) (*ListResult, error) {
-	return listReferencesInFS(r.parser, bfs, base)
+	if r.regexErr != nil {
+		return nil, r.regexErr
+	}
+	return listReferencesInFS(r.parser, bfs, base, &r.cfg, r.logger, r.maxConcurrency)
+}
+
+// ListPathFunc lists all entity references in the provided directory, calling fn with every
+// distinct entity as soon as it's found rather than collecting them, e.g. to stream the
+// results of huge scans. The entities are passed in no particular order, never concurrently.
+// An error returned by fn stops the listing.
+func (r *Replacer) ListPathFunc(dir string, fn func(interfaces.EntityRef) error) error {
+	bfs, base := osFS(dir)
+	return r.ListPathInFSFunc(bfs, base, fn)
+}
+
+// ListPathInFSFunc is like ListPathFunc for the provided file system
+func (r *Replacer) ListPathInFSFunc(bfs billy.Filesystem, base string, fn func(interfaces.EntityRef) error) error {
+	if r.regexErr != nil {
+		return r.regexErr
+	}
+	return streamReferencesInFS(r.parser, bfs, base, &r.cfg, r.logger, r.maxConcurrency, fn)
 }
 
 // ListInFile lists all entities in the provided file
 func (r *Replacer) ListInFile(f io.Reader) (*ListResult, error) {
-	found, err := listReferencesInFile(f, r.parser)
+	if r.regexErr != nil {
+		return nil, r.regexErr
+	}
+	locations, err := listReferencesInFile(f, r.parser, r.cfg)
 	if err != nil {
 		return nil, err
 	}
-	res := &ListResult{}
+	found := mapset.NewSet[interfaces.EntityRef]()
+	for _, loc := range locations {
+		found.Add(loc.EntityRef)
+	}
+	res := &ListResult{Locations: locations}
 	res.Entities = found.ToSlice()
 
 	// Sort the slice
@@ -152,28 +1136,80 @@ func (r *Replacer) ListInFile(f io.Reader) (*ListResult, error) {
 		return res.Entities[i].Name < res.Entities[j].Name
 	})
 
-	// All good
-	return res, nil
+	// All good
+	return res, nil
+}
+
+func unpinPathInFS(
+	ctx context.Context,
+	parser interfaces.Parser,
+	bfs billy.Filesystem,
+	walk func(fn func(path string) error) error,
+	maxConcurrency int,
+	include func(path string) bool,
+	progress ProgressFunc,
+) (*ReplaceResult, error) {
+	return replaceInFS(bfs, walk, maxConcurrency, false, include, progress,
+		func(_ string, f io.Reader) (fileResult, error) {
+			return unpinReferencesInFile(ctx, f, parser)
+		})
+}
+
+// fileReplaceFunc replaces the references in the content of the file at the given path
+type fileReplaceFunc func(path string, f io.Reader) (fileResult, error)
+
+// fileResult is the result of replacing the references in a file
+type fileResult struct {
+	modified bool
+	content  string
+	stats    FileStats
+	// errors holds the references which failed to resolve
+	errors []ReferenceError
+	// skipped holds the references skipped for a known reason
+	skipped []interfaces.SkippedReference
+	// changes holds the references rewritten line by line
+	changes []interfaces.ReferenceChange
 }
 
-func parsePathInFS(
-	ctx context.Context,
-	parser interfaces.Parser,
-	rest interfaces.REST,
-	cfg config.Config,
+// replaceInFS traverses the given file system with walk and applies replaceFn to the content of each
+// file walked, and accepted by include if set. The references failing to resolve are reported through
+// an UnresolvedError if failOnUnresolved is set.
+func replaceInFS(
 	bfs billy.Filesystem,
-	base string,
+	walk func(fn func(path string) error) error,
+	maxConcurrency int,
+	failOnUnresolved bool,
+	include func(path string) bool,
+	progress ProgressFunc,
+	replaceFn fileReplaceFunc,
 ) (*ReplaceResult, error) {
 	var eg errgroup.Group
 	var mu sync.Mutex
+	// done and total count the files processed and found, reported to progress if set
+	var done, total int
+
+	setConcurrencyLimit(&eg, maxConcurrency)
 
 	res := ReplaceResult{
 		Processed: make([]string, 0),
 		Modified:  make(map[string]string),
+		Errors:    make([]ReferenceError, 0),
+		Stats:     make(map[string]FileStats),
+		Skipped:   make([]interfaces.SkippedReference, 0),
+		Changes:   make([]interfaces.ReferenceChange, 0),
 	}
 
-	// Traverse all YAML/YML files in dir
-	err := traverse.YamlDockerfiles(bfs, base, func(path string) error {
+	// Traverse all related files
+	err := walk(func(path string) error {
+		if include != nil && !include(path) {
+			return nil
+		}
+		if progress != nil {
+			mu.Lock()
+			total++
+			progress(done, total)
+			mu.Unlock()
+		}
 		eg.Go(func() error {
 			file, err := bfs.Open(path)
 			if err != nil {
@@ -183,7 +1219,7 @@ func parsePathInFS(
 			defer file.Close()
 
 			// Parse the content of the file and update the matching references
-			modified, updatedFile, err := parseAndReplaceReferencesInFile(ctx, file, parser, rest, cfg)
+			fileRes, err := replaceFn(path, file)
 			if err != nil {
 				return fmt.Errorf("failed to modify references in %s: %w", path, err)
 			}
@@ -191,9 +1227,26 @@ func parsePathInFS(
 			mu.Lock()
 			// Store the file name to the processed batch
 			res.Processed = append(res.Processed, path)
+			res.Stats[path] = fileRes.stats
 			// Store the updated file content if it was modified
-			if modified {
-				res.Modified[path] = updatedFile
+			if fileRes.modified {
+				res.Modified[path] = fileRes.content
+			}
+			for _, e := range fileRes.errors {
+				e.Path = path
+				res.Errors = append(res.Errors, e)
+			}
+			for _, sk := range fileRes.skipped {
+				sk.Path = path
+				res.Skipped = append(res.Skipped, sk)
+			}
+			for _, c := range fileRes.changes {
+				c.Path = path
+				res.Changes = append(res.Changes, c)
+			}
+			if progress != nil {
+				done++
+				progress(done, total)
 			}
 			mu.Unlock()
 
@@ -210,23 +1263,125 @@ func parsePathInFS(
 		return nil, err
 	}
 
+	sort.Slice(res.Errors, func(i, j int) bool {
+		if res.Errors[i].Path != res.Errors[j].Path {
+			return res.Errors[i].Path < res.Errors[j].Path
+		}
+		return res.Errors[i].Line < res.Errors[j].Line
+	})
+	sort.Slice(res.Skipped, func(i, j int) bool {
+		if res.Skipped[i].Path != res.Skipped[j].Path {
+			return res.Skipped[i].Path < res.Skipped[j].Path
+		}
+		return res.Skipped[i].Line < res.Skipped[j].Line
+	})
+	// Keep references changed on the same line in the order they appear in
+	sort.SliceStable(res.Changes, func(i, j int) bool {
+		if res.Changes[i].Path != res.Changes[j].Path {
+			return res.Changes[i].Path < res.Changes[j].Path
+		}
+		return res.Changes[i].Line < res.Changes[j].Line
+	})
+	if failOnUnresolved && len(res.Errors) > 0 {
+		return nil, &UnresolvedError{References: res.Errors}
+	}
+
 	// All good
 	return &res, nil
 }
 
-func listReferencesInFS(parser interfaces.Parser, bfs billy.Filesystem, base string) (*ListResult, error) {
-	var eg errgroup.Group
-	var mu sync.Mutex
-
+func listReferencesInFS(
+	parser interfaces.Parser,
+	bfs billy.Filesystem,
+	base string,
+	cfg *config.Config,
+	logger *slog.Logger,
+	maxConcurrency int,
+) (*ListResult, error) {
 	res := ListResult{
 		Processed: make([]string, 0),
 		Entities:  make([]interfaces.EntityRef, 0),
+		Locations: make([]EntityLocation, 0),
 	}
 
-	found := mapset.NewSet[interfaces.EntityRef]()
+	found := mapset.NewThreadUnsafeSet[interfaces.EntityRef]()
+
+	err := walkReferencesInFS(parser, bfs, base, cfg, logger, maxConcurrency, func(path string, locations []EntityLocation) error {
+		// Store the file name to the processed batch
+		res.Processed = append(res.Processed, path)
+		for _, loc := range locations {
+			found.Add(loc.EntityRef)
+			res.Locations = append(res.Locations, loc)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Entities = found.ToSlice()
+
+	// Sort the slices
+	sort.Slice(res.Entities, func(i, j int) bool {
+		return res.Entities[i].Name < res.Entities[j].Name
+	})
+	// Keep references found on the same line in the order they appear in
+	sort.SliceStable(res.Locations, func(i, j int) bool {
+		if res.Locations[i].Path != res.Locations[j].Path {
+			return res.Locations[i].Path < res.Locations[j].Path
+		}
+		return res.Locations[i].Line < res.Locations[j].Line
+	})
+
+	// All good
+	return &res, nil
+}
+
+// streamReferencesInFS calls fn with every distinct entity referenced in the given file system
+// as soon as the file referencing it first is processed
+func streamReferencesInFS(
+	parser interfaces.Parser,
+	bfs billy.Filesystem,
+	base string,
+	cfg *config.Config,
+	logger *slog.Logger,
+	maxConcurrency int,
+	fn func(interfaces.EntityRef) error,
+) error {
+	found := mapset.NewThreadUnsafeSet[interfaces.EntityRef]()
+
+	return walkReferencesInFS(parser, bfs, base, cfg, logger, maxConcurrency, func(_ string, locations []EntityLocation) error {
+		for _, loc := range locations {
+			if !found.Add(loc.EntityRef) {
+				continue
+			}
+			if err := fn(loc.EntityRef); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// walkReferencesInFS traverses the given file system and calls fn with the references found in each
+// relevant file. The files are processed concurrently but fn is never called concurrently.
+func walkReferencesInFS(
+	parser interfaces.Parser,
+	bfs billy.Filesystem,
+	base string,
+	cfg *config.Config,
+	logger *slog.Logger,
+	maxConcurrency int,
+	fn func(path string, locations []EntityLocation) error,
+) error {
+	var eg errgroup.Group
+	var mu sync.Mutex
+	// stopped is set once fn fails, it must not be called again
+	stopped := false
+
+	setConcurrencyLimit(&eg, maxConcurrency)
 
 	// Traverse all related files
-	err := traverse.YamlDockerfiles(bfs, base, func(path string) error {
+	err := traverseFiles(parser, bfs, base, cfg, logger, func(path string) error {
 		eg.Go(func() error {
 			file, err := bfs.Open(path)
 			if err != nil {
@@ -235,38 +1390,163 @@ func listReferencesInFS(parser interfaces.Parser, bfs billy.Filesystem, base str
 			defer file.Close() // nolint:errcheck
 
 			// Parse the content of the file and list the matching references
-			foundRefs, err := listReferencesInFile(file, parser)
+			locations, err := listReferencesInFile(file, parser, *cfg)
 			if err != nil {
 				return fmt.Errorf("failed to list references in %s: %w", path, err)
 			}
+			for i := range locations {
+				locations[i].Path = path
+			}
 
-			// Store the file name to the processed batch
 			mu.Lock()
-			res.Processed = append(res.Processed, path)
-			found = found.Union(foundRefs)
-			mu.Unlock()
-
-			// All good
+			defer mu.Unlock()
+			if stopped {
+				return nil
+			}
+			if err := fn(path, locations); err != nil {
+				stopped = true
+				return err
+			}
 			return nil
 		})
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if err := eg.Wait(); err != nil {
-		return nil, err
+	return eg.Wait()
+}
+
+// traverseFiles calls fn with each file of the given directory processed by the parser,
+// i.e. YAML files, Dockerfiles, files with any of the configured extensions and JSON
+// files if JSON manifests are enabled, unless the parser says otherwise. The excluded
+// paths, the files ignored by git and those below the maximum depth if configured, are
+// skipped, and symbolic links to directories are followed if configured.
+func traverseFiles(
+	parser interfaces.Parser,
+	bfs billy.Filesystem,
+	base string,
+	cfg *config.Config,
+	logger *slog.Logger,
+	fn func(path string) error,
+) error {
+	opts := traverseOptions(cfg, logger)
+	if len(cfg.ExcludePaths) > 0 {
+		process := fn
+		fn = func(path string) error {
+			if isExcluded(cfg, base, path) {
+				return nil
+			}
+			return process(path)
+		}
 	}
-	res.Entities = found.ToSlice()
+	if t, ok := parser.(fileTraverser); ok {
+		return t.TraverseFiles(bfs, base, fn, opts...)
+	}
+	return traverse.YamlDockerfiles(bfs, base, traversedExtensions(cfg), fn, opts...)
+}
 
-	// Sort the slice
-	sort.Slice(res.Entities, func(i, j int) bool {
-		return res.Entities[i].Name < res.Entities[j].Name
+// walkFiles returns the function calling fn with each file of the given directory
+// processed by the parser, see traverseFiles
+func walkFiles(
+	parser interfaces.Parser,
+	bfs billy.Filesystem,
+	base string,
+	cfg *config.Config,
+	logger *slog.Logger,
+) func(fn func(path string) error) error {
+	return func(fn func(path string) error) error {
+		return traverseFiles(parser, bfs, base, cfg, logger, fn)
+	}
+}
+
+// traverseOptions returns the options of the traversal of a directory set in the given
+// configuration, e.g. the maximum depth, logging the links not followed to the logger if set
+func traverseOptions(cfg *config.Config, logger *slog.Logger) []traverse.Option {
+	var opts []traverse.Option
+	if cfg.RespectGitignore {
+		opts = append(opts, traverse.WithGitignore())
+	}
+	if cfg.FollowSymlinks {
+		opts = append(opts, traverse.WithFollowSymlinks())
+	}
+	if cfg.MaxDepth > 0 {
+		opts = append(opts, traverse.WithMaxDepth(cfg.MaxDepth))
+	}
+	if logger != nil {
+		opts = append(opts, traverse.WithLogger(logger))
+	}
+	return opts
+}
+
+// traversedExtensions returns the extensions of the files traversed on top of the YAML
+// files and Dockerfiles according to the given configuration
+func traversedExtensions(cfg *config.Config) []string {
+	extensions := cfg.IncludeExtensions
+	if cfg.Images.JSONManifests {
+		extensions = append(slices.Clone(extensions), traverse.JSONExtension)
+	}
+	if cfg.Images.NixImages {
+		extensions = append(slices.Clone(extensions), traverse.NixExtension)
+	}
+	return extensions
+}
+
+// isExcluded returns true if the given file of the base directory matches any of the
+// excluded paths of the given configuration
+func isExcluded(cfg *config.Config, base, path string) bool {
+	rel, err := filepath.Rel(base, path)
+	return err == nil && config.MatchAnyPath(cfg.ExcludePaths, filepath.ToSlash(rel))
+}
+
+// lineScanner reads a file line by line, stripping the line endings like bufio.ScanLines
+// while keeping track of them so they can be written back as they were
+type lineScanner struct {
+	*bufio.Scanner
+	// eol is the line ending of the last line read, i.e. \n or \r\n for files authored
+	// on Windows. A last line without one keeps the ending of the previous line.
+	eol string
+	// unterminated is true if the last line read is the last line of a file which
+	// doesn't end with a newline
+	unterminated bool
+}
+
+// lineEnd returns the line ending to write after the last line read, i.e. none if the
+// file doesn't end with a newline
+func (s *lineScanner) lineEnd() string {
+	if s.unterminated {
+		return ""
+	}
+	return s.eol
+}
+
+// newLineScanner returns a scanner reading the given file line by line
+func newLineScanner(f io.Reader) *lineScanner {
+	s := &lineScanner{Scanner: bufio.NewScanner(f), eol: "\n"}
+	s.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token == nil {
+			return advance, token, err
+		}
+		s.unterminated = !bytes.HasSuffix(data[:advance], []byte("\n"))
+		if !s.unterminated {
+			s.eol = "\n"
+			if bytes.HasSuffix(data[:advance], []byte("\r\n")) {
+				s.eol = "\r\n"
+			}
+		}
+		return advance, token, err
 	})
+	return s
+}
 
-	// All good
-	return &res, nil
+// setConcurrencyLimit limits the number of active goroutines in the group, zero or less means unbounded
+func setConcurrencyLimit(eg *errgroup.Group, maxConcurrency int) {
+	if maxConcurrency > 0 {
+		eg.SetLimit(maxConcurrency)
+	}
 }
 
 func parseAndReplaceReferencesInFile(
@@ -275,80 +1555,472 @@ func parseAndReplaceReferencesInFile(
 	parser interfaces.Parser,
 	rest interfaces.REST,
 	cfg config.Config,
-) (bool, string, error) {
+	timeouts refTimeouts,
+	logger *slog.Logger,
+	onReplace func(ref *interfaces.EntityRef, before, after string, line int),
+) (fileResult, error) {
+	if onReplace == nil {
+		onReplace = func(*interfaces.EntityRef, string, string, int) {}
+	}
 	var contentBuilder strings.Builder
-	var ret *interfaces.EntityRef
+	var rateLimitErr error
+	var refErrs []ReferenceError
+	var skipped []interfaces.SkippedReference
+	var changes []interfaces.ReferenceChange
+	var stats FileStats
+	stages := newFileStages(parser)
+	keys := newFileKeys(parser)
 
 	modified := false
 
-	// Compile the regular expression
-	re, err := regexp.Compile(parser.GetRegex())
+	re, err := compileRegex(parser)
 	if err != nil {
-		return false, "", err
+		return fileResult{}, err
+	}
+
+	// recordErr records the reference which failed to resolve or was skipped
+	recordErr := func(lineNumber int, matchedLine string, err error) {
+		// Remember hitting the rate limit, the remaining references can't be resolved either
+		if errors.Is(err, ghrest.ErrRateLimited) && rateLimitErr == nil {
+			rateLimitErr = err
+		} else if isUnresolved(err) && !(timeouts.skip && errors.Is(err, ErrRefTimeout)) {
+			refErrs = append(refErrs, ReferenceError{Line: lineNumber, Reference: matchedLine, Err: err})
+			stats.Errored++
+		} else {
+			stats.Skipped++
+			reason := interfaces.GetSkipReason(err)
+			if reason != "" {
+				skipped = append(skipped, interfaces.SkippedReference{Line: lineNumber, Reference: matchedLine, Reason: reason})
+			}
+			onReplace(nil, matchedLine, string(reason), lineNumber)
+		}
 	}
 
 	// Read the file line by line
-	scanner := bufio.NewScanner(f)
+	scanner := newLineScanner(f)
+	lineNumber := 0
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNumber++
 
 		// Skip commented lines
 		if strings.HasPrefix(strings.TrimLeft(line, " \t\n\r"), "#") {
 			// Write the line to the content builder buffer
-			contentBuilder.WriteString(line + "\n")
+			contentBuilder.WriteString(line + scanner.lineEnd())
 			continue
 		}
 
+		// Tag comments to write above the line
+		var tagComments []string
+		keys.enter(line)
+
 		// See if we can match an entity reference in the line
-		newLine := re.ReplaceAllStringFunc(line, func(matchedLine string) string {
+		newLine := replaceMatches(re, parser, line, func(matchedLine string) string {
+			if rateLimitErr != nil || !keys.allows(matchedLine) {
+				return matchedLine
+			}
+			stats.Matched++
+			// References to an earlier stage of the file don't name anything to resolve
+			if stages.uses(matchedLine) {
+				stats.Skipped++
+				skipped = append(skipped, interfaces.SkippedReference{Line: lineNumber, Reference: matchedLine, Reason: interfaces.SkipStage})
+				stageErr := &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipStage}
+				logResolution(ctx, logger, matchedLine, nil, stageErr, "line", lineNumber)
+				onReplace(nil, matchedLine, string(interfaces.SkipStage), lineNumber)
+				return matchedLine
+			}
 			// Modify the reference in the line
-			ret, err = parser.Replace(ctx, matchedLine, rest, cfg)
+			// Keep the result local to the match, a line may hold several references
+			ret, err := timeouts.replace(ctx, parser, matchedLine, rest, cfg)
+			if errors.Is(err, interfaces.ErrReplacedInDocument) {
+				// Counted along with the references replaced across the whole document
+				stats.Matched--
+				return matchedLine
+			}
+			logResolution(ctx, logger, matchedLine, ret, err, "line", lineNumber)
 			if err != nil {
+				recordErr(lineNumber, matchedLine, err)
 				// Return the original line as we don't want to update it in case something errored out
 				return matchedLine
 			}
-			// Construct the new line, comments in dockerfiles are handled differently than yml files
-			if strings.Contains(matchedLine, "FROM") {
-				return fmt.Sprintf("%s%s:%s@%s", ret.Prefix, ret.Name, ret.Tag, ret.Ref)
+			stats.Modified++
+			pinned, tagComment := formatPinned(parser, matchedLine, ret, cfg)
+			if tagComment != "" {
+				tagComments = append(tagComments, tagComment)
 			}
-			return fmt.Sprintf("%s%s@%s # %s", ret.Prefix, ret.Name, ret.Ref, ret.Tag)
+			changes = append(changes, interfaces.ReferenceChange{Line: lineNumber, Before: matchedLine, After: pinned, Type: ret.Type})
+			onReplace(ret, matchedLine, pinned, lineNumber)
+			return pinned
 		})
+		// A stage can only be used by the lines following its definition
+		stages.record(line)
 
 		// Check if the line was modified and set the modified flag to true if it was
 		if newLine != line {
 			modified = true
 		}
 
+		// Dockerfiles only support comments on their own line, indented like the instruction
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		for _, c := range tagComments {
+			contentBuilder.WriteString(indent + c + scanner.eol)
+		}
+
 		// Write the line to the content builder buffer
-		contentBuilder.WriteString(newLine + "\n")
+		contentBuilder.WriteString(newLine + scanner.lineEnd())
 	}
 
 	// Check for errors during the scan
 	if err := scanner.Err(); err != nil {
-		return false, "", err
+		return fileResult{}, err
+	}
+	if rateLimitErr != nil {
+		return fileResult{}, rateLimitErr
+	}
+
+	// The references replaced across the whole document, e.g. the revs of pre-commit
+	// configurations, are counted like the ones matched line by line
+	content := contentBuilder.String()
+	if p, ok := parser.(documentReplacer); ok {
+		var replaced bool
+		var refs []interfaces.DocumentReference
+		if content, replaced, refs = p.ReplaceInDocument(ctx, content, rest, cfg); replaced {
+			modified = true
+		}
+		for _, ref := range refs {
+			stats.Matched++
+			err := timeouts.wrap(ctx, ref.Err)
+			logResolution(ctx, logger, ref.Reference, ref.Ref, err, "line", ref.Line)
+			if err != nil {
+				recordErr(ref.Line, ref.Reference, err)
+				continue
+			}
+			stats.Modified++
+			changes = append(changes, interfaces.ReferenceChange{
+				Line: ref.Line, Before: ref.Reference, After: ref.Pinned, Type: ref.Ref.Type,
+			})
+			onReplace(ref.Ref, ref.Reference, ref.Pinned, ref.Line)
+		}
+		if rateLimitErr != nil {
+			return fileResult{}, rateLimitErr
+		}
+	}
+
+	// Return the workflow content
+	return fileResult{
+		modified: modified,
+		content:  content,
+		stats:    stats,
+		errors:   refErrs,
+		skipped:  skipped,
+		changes:  changes,
+	}, nil
+}
+
+// logResolution logs the outcome of resolving the matched reference at debug level,
+// along with the given attributes, e.g. its line. Nothing is logged if the logger is nil.
+func logResolution(
+	ctx context.Context,
+	logger *slog.Logger,
+	matchedLine string,
+	ret *interfaces.EntityRef,
+	err error,
+	attrs ...any,
+) {
+	if logger == nil {
+		return
+	}
+	attrs = append(attrs, "reference", matchedLine)
+	switch {
+	case err == nil:
+		logger.DebugContext(ctx, "resolved reference", append(attrs, "ref", ret.Ref, "tag", ret.Tag)...)
+	case errors.Is(err, interfaces.ErrReferenceSkipped):
+		logger.DebugContext(ctx, "skipped reference", append(attrs, "reason", interfaces.GetSkipReason(err), "error", err)...)
+	default:
+		logger.DebugContext(ctx, "failed to resolve reference", append(attrs, "error", err)...)
+	}
+}
+
+// formatPinned returns the pinned reference replacing the matched one, along with the tag
+// comment to write on its own line above it, if any. Comments in Dockerfiles and quadlet
+// units are handled differently than in YAML files.
+func formatPinned(
+	parser interfaces.Parser,
+	matchedLine string,
+	ret *interfaces.EntityRef,
+	cfg config.Config,
+) (string, string) {
+	if f, ok := parser.(referenceFormatter); ok {
+		return fmt.Sprintf("%s # %s", f.FormatReference(matchedLine, ret.Ref), tagComment(ret)), ""
+	}
+	if image.IsDockerfileRef(ret) {
+		var tagComment string
+		if cfg.Images.DockerfileTagComment && ret.Tag != "" {
+			tagComment = "# " + ret.Tag
+		}
+		return fmt.Sprintf("%s%s:%s@%s", ret.Prefix, ret.Name, ret.Tag, ret.Ref), tagComment
+	}
+	if image.IsQuadletRef(ret) {
+		// systemd units don't support trailing comments either, the tag is kept in the reference
+		return fmt.Sprintf("%s%s:%s@%s", ret.Prefix, ret.Name, ret.Tag, ret.Ref), ""
+	}
+	// The tag comment goes right after the reference, ahead of any comment already on the line
+	pinned := requote(matchedLine, fmt.Sprintf("%s%s@%s", ret.Prefix, ret.Name, ret.Ref))
+	return fmt.Sprintf("%s # %s", pinned, tagComment(ret)), ""
+}
+
+// tagComment returns the tag comment of the pinned reference, i.e. its tag followed by
+// the release it points at, if looked up, e.g. v4 (v4.1.1), so it can be unpinned back to
+// the tag as written
+func tagComment(ret *interfaces.EntityRef) string {
+	if ret.Version == "" {
+		return ret.Tag
+	}
+	return fmt.Sprintf("%s (%s)", ret.Tag, ret.Version)
+}
+
+// requote wraps the value of the given reference in the quotes the matched one is wrapped
+// in, if any, e.g. uses: "actions/checkout@v4", unless the parser already kept them
+func requote(matched, ref string) string {
+	if len(matched) < 2 {
+		return ref
+	}
+	quote := matched[len(matched)-1:]
+	if quote != `"` && quote != "'" {
+		return ref
+	}
+	key, _, found := strings.Cut(matched, quote)
+	if !found || !strings.HasPrefix(ref, key) || strings.Contains(ref, quote) {
+		return ref
+	}
+	return key + quote + ref[len(key):] + quote
+}
+
+// replace resolves the matched reference through the parser, giving up with ErrRefTimeout
+// once the per reference timeout expires
+func (t refTimeouts) replace(
+	ctx context.Context,
+	parser interfaces.Parser,
+	matchedLine string,
+	rest interfaces.REST,
+	cfg config.Config,
+) (*interfaces.EntityRef, error) {
+	if t.perRef <= 0 {
+		return parser.Replace(ctx, matchedLine, rest, cfg)
+	}
+
+	refCtx, cancel := context.WithTimeout(ctx, t.perRef)
+	defer cancel()
+	ret, err := parser.Replace(refCtx, matchedLine, rest, cfg)
+	// Only blame the per reference timeout, not the caller cancelling the parent context
+	if err != nil && ctx.Err() == nil && errors.Is(refCtx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w after %s: %w", ErrRefTimeout, t.perRef, err)
+	}
+	return ret, err
+}
+
+// wrap returns the error of a reference resolved by a documentReplacer, which applies the
+// per reference timeout itself, matching ErrRefTimeout if it timed out
+func (t refTimeouts) wrap(ctx context.Context, err error) error {
+	if err != nil && t.perRef > 0 && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %w", ErrRefTimeout, t.perRef, err)
+	}
+	return err
+}
+
+// isUnresolved returns true if the error returned by a parser means the reference looks
+// pinnable but failed to resolve, rather than being skipped or not being a reference at all
+func isUnresolved(err error) bool {
+	return !errors.Is(err, interfaces.ErrReferenceSkipped) && !errors.Is(err, interfaces.ErrInvalidReference)
+}
+
+// unpinReferencesInFile reverts all references pinned by their digest in the given file back to
+// the tag recorded alongside them, i.e. in a trailing "# tag" comment
+func unpinReferencesInFile(
+	ctx context.Context,
+	f io.Reader,
+	parser interfaces.Parser,
+) (fileResult, error) {
+	var contentBuilder strings.Builder
+	var stats FileStats
+	var changes []interfaces.ReferenceChange
+
+	modified := false
+	// The comment lines preceding the current one
+	var comments []string
+	keys := newFileKeys(parser)
+
+	re, err := compileRegex(parser)
+	if err != nil {
+		return fileResult{}, err
+	}
+
+	// Read the file line by line
+	scanner := newLineScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return fileResult{}, err
+		}
+		line := scanner.Text()
+		lineNumber++
+
+		// Skip commented lines, which are held back until the next line as they may be the
+		// tag comments of its Dockerfile FROM instructions
+		if strings.HasPrefix(strings.TrimLeft(line, " \t\n\r"), "#") {
+			comments = append(comments, line+scanner.lineEnd())
+			continue
+		}
+
+		keys.enter(line)
+		newLine, lineChanges, tagComments := unpinReferencesInLine(line, re, tagCommentRegex, parser, keys, &stats)
+		for _, c := range lineChanges {
+			c.Line = lineNumber
+			changes = append(changes, c)
+		}
+
+		// The tag comments written above the line when pinning are redundant now that the
+		// tags are part of the references, so pinning again doesn't stack them
+		for i := len(tagComments) - 1; i >= 0 && len(comments) > 0; i-- {
+			if strings.TrimSpace(comments[len(comments)-1]) != tagComments[i] {
+				break
+			}
+			comments = comments[:len(comments)-1]
+			modified = true
+		}
+		for _, c := range comments {
+			contentBuilder.WriteString(c)
+		}
+		comments = comments[:0]
+
+		// Check if the line was modified and set the modified flag to true if it was
+		if newLine != line {
+			modified = true
+		}
+
+		// Write the line to the content builder buffer
+		contentBuilder.WriteString(newLine + scanner.lineEnd())
+	}
+	for _, c := range comments {
+		contentBuilder.WriteString(c)
+	}
+
+	// Check for errors during the scan
+	if err := scanner.Err(); err != nil {
+		return fileResult{}, err
 	}
 
 	// Return the workflow content
-	return modified, contentBuilder.String(), nil
+	return fileResult{modified: modified, content: contentBuilder.String(), stats: stats, changes: changes}, nil
+}
+
+// tagCommentRegex matches the tag comment of a pinned reference, along with the release the
+// tag pointed at, if any, which may be followed by a comment which was already on the line
+// when pinning
+var tagCommentRegex = regexp.MustCompile(`^\s+#\s*(\S+)(?:\s+\(\S+\))?(\s+#.*?)?(\s*)$`)
+
+// unpinReferencesInLine reverts the references of the line pinned by their digest, counting
+// them in stats, and returns the line along with the references it changed and the tag
+// comments pinning them writes above the line, i.e. the ones of Dockerfile FROM instructions
+func unpinReferencesInLine(
+	line string,
+	re, tagComment *regexp.Regexp,
+	parser interfaces.Parser,
+	keys *fileKeys,
+	stats *FileStats,
+) (string, []interfaces.ReferenceChange, []string) {
+	var lineBuilder strings.Builder
+	var changes []interfaces.ReferenceChange
+	var tagComments []string
+
+	matches := findMatches(re, parser, line)
+	last := 0
+	for i, match := range matches {
+		// The tag comment, if any, sits between this match and the next one
+		end := len(line)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		var tag, comment string
+		if c := tagComment.FindStringSubmatch(line[match[1]:end]); c != nil {
+			// Trailing whitespace is kept as it is
+			tag, comment = c[1], c[2]+c[3]
+		}
+
+		lineBuilder.WriteString(line[last:match[0]])
+		last = match[1]
+
+		// Like when pinning, the matches which aren't nested under the keys the parser
+		// requires aren't references
+		if !keys.allows(line[match[0]:match[1]]) {
+			lineBuilder.WriteString(line[match[0]:match[1]])
+			continue
+		}
+		stats.Matched++
+		ret, err := parser.Unpin(line[match[0]:match[1]], tag)
+		if err != nil {
+			// Keep the original reference as we don't know which tag to use
+			lineBuilder.WriteString(line[match[0]:match[1]])
+			stats.Skipped++
+			continue
+		}
+		stats.Modified++
+		if image.IsDockerfileRef(ret) {
+			tagComments = append(tagComments, "# "+ret.Tag)
+		}
+
+		unpinned := formatUnpinned(parser, line[match[0]:match[1]], ret)
+		lineBuilder.WriteString(unpinned)
+		changes = append(changes, interfaces.ReferenceChange{Before: line[match[0]:match[1]], After: unpinned, Type: ret.Type})
+
+		// The tag comment is redundant now that the tag is part of the reference, unlike the
+		// comment following it
+		if tag != "" && tag == ret.Tag {
+			lineBuilder.WriteString(comment)
+			last = end
+		}
+	}
+	lineBuilder.WriteString(line[last:])
+
+	return lineBuilder.String(), changes, tagComments
+}
+
+// formatUnpinned returns the matched reference reverted to the tag of the reference
+// returned by the parser's Unpin
+func formatUnpinned(parser interfaces.Parser, matchedLine string, ret *interfaces.EntityRef) string {
+	if f, ok := parser.(referenceFormatter); ok {
+		return f.FormatReference(matchedLine, ret.Tag)
+	}
+	// Actions and orbs use @ to separate the tag while images use :
+	sep := ":"
+	if ret.Type == actions.ReferenceType || ret.Type == circleci.ReferenceType {
+		sep = "@"
+	}
+	return requote(matchedLine, fmt.Sprintf("%s%s%s%s", ret.Prefix, ret.Name, sep, ret.Tag))
 }
 
-// listReferencesInFile takes the given file reader and returns a map of all references, action or images it finds
+// listReferencesInFile takes the given file reader and returns all references, action or images, it finds
+// along with the line they were found at
 func listReferencesInFile(
 	f io.Reader,
 	parser interfaces.Parser,
-) (mapset.Set[interfaces.EntityRef], error) {
-	found := mapset.NewSet[interfaces.EntityRef]()
+	cfg config.Config,
+) ([]EntityLocation, error) {
+	var found []EntityLocation
+	stages := newFileStages(parser)
+	keys := newFileKeys(parser)
 
-	// Compile the regular expression
-	re, err := regexp.Compile(parser.GetRegex())
+	re, err := compileRegex(parser)
 	if err != nil {
 		return nil, err
 	}
 
 	// Read the file line by line
-	scanner := bufio.NewScanner(f)
+	scanner := newLineScanner(f)
+	lineNumber := 0
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNumber++
 
 		// Skip commented lines
 		if strings.HasPrefix(strings.TrimLeft(line, " \t\n\r"), "#") {
@@ -356,17 +2028,24 @@ func listReferencesInFile(
 		}
 
 		// See if we can match an entity reference in the line
-		foundEntries := re.FindAllString(line, -1)
-		// nolint:gosimple
-		if foundEntries != nil {
-			for _, entry := range foundEntries {
-				e, err := parser.ConvertToEntityRef(entry)
-				if err != nil {
-					continue
-				}
-				found.Add(*e)
+		keys.enter(line)
+		for _, match := range findMatches(re, parser, line) {
+			entry := line[match[0]:match[1]]
+			// References to an earlier stage of the file aren't entities
+			if stages.uses(entry) || !keys.allows(entry) {
+				continue
+			}
+			e, err := parser.ConvertToEntityRef(entry)
+			if err != nil {
+				continue
+			}
+			loc := EntityLocation{EntityRef: *e, Line: lineNumber}
+			if c, ok := parser.(skipClassifier); ok {
+				loc.SkipReason = c.SkipReason(entry, cfg)
 			}
+			found = append(found, loc)
 		}
+		stages.record(line)
 	}
 
 	// Check for errors during the scan
@@ -16,20 +16,48 @@
 package replacer
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
 
 	"github.com/stacklok/frizbee/internal/cli"
 	"github.com/stacklok/frizbee/pkg/interfaces"
 	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/replacer/circleci"
 	"github.com/stacklok/frizbee/pkg/replacer/image"
 	"github.com/stacklok/frizbee/pkg/utils/config"
 	"github.com/stacklok/frizbee/pkg/utils/ghrest"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
+	"github.com/stacklok/frizbee/pkg/utils/store"
 )
 
 func TestReplacer_ParseContainerImageString(t *testing.T) {
@@ -544,6 +572,34 @@ spec:
 `,
 			modified: false,
 		},
+		{
+			name: "GitLab CI image mapping and services",
+			before: `
+build:
+  image:
+    name: registry.k8s.io/kube-apiserver:v1.20.0
+    entrypoint: [""]
+  services:
+    - name: registry.k8s.io/kube-controller-manager:v1.15.0
+      alias: controller
+    - name: minder:latest
+  script:
+    - name: not-an-image
+`,
+			expected: `
+build:
+  image:
+    name: registry.k8s.io/kube-apiserver@sha256:8b8125d7a6e4225b08f04f65ca947b27d0cc86380bf09fab890cc80408230114 # v1.20.0
+    entrypoint: [""]
+  services:
+    - name: registry.k8s.io/kube-controller-manager@sha256:835f32a5cdb30e86f35675dd91f9c7df01d48359ab8b51c1df866a2c7ea2e870 # v1.15.0
+      alias: controller
+    - name: minder:latest
+  script:
+    - name: not-an-image
+`,
+			modified: true,
+		},
 		{
 			name: "A complex dockerfile",
 			before: `
@@ -982,6 +1038,190 @@ jobs:
 	}
 }
 
+func TestReplacer_UnpinGitHubActionsInFile(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		before   string
+		expected string
+		modified bool
+	}{
+		{
+			name: "Unpin action and docker references",
+			before: `
+name: Linter
+on: pull_request
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: ./minder/server.yml # this should not be replaced
+      - uses: actions/checkout@ee0669bd1cc54295c223e0bb666b733df41de1c5 # v2
+      - uses: xt0rted/markdownlint-problem-matcher@b643b0751c371f357690337d4549221347c0e1bc # v1
+      - name: "Run Markdown linter"
+        uses: docker://index.docker.io/avtodev/markdown-lint@sha256:6aeedc2f49138ce7a1cd0adffc1b1c0321b841dc2102408967d9301c031949ee # v1
+        with:
+          args: src/*.md
+`,
+			expected: `
+name: Linter
+on: pull_request
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: ./minder/server.yml # this should not be replaced
+      - uses: actions/checkout@v2
+      - uses: xt0rted/markdownlint-problem-matcher@v1
+      - name: "Run Markdown linter"
+        uses: docker://index.docker.io/avtodev/markdown-lint:v1
+        with:
+          args: src/*.md
+`,
+			modified: true,
+		},
+		{
+			name: "Pinned references without a tag comment are kept",
+			before: `
+jobs:
+  build:
+    steps:
+      - uses: actions/checkout@ee0669bd1cc54295c223e0bb666b733df41de1c5
+      - uses: actions/setup-go@v5
+      # - uses: actions/checkout@ee0669bd1cc54295c223e0bb666b733df41de1c5 # v2
+`,
+			modified: false,
+		},
+	}
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			r := NewGitHubActionsReplacer(&config.Config{})
+			modified, newContent, err := r.UnpinFile(ctx, strings.NewReader(tt.before))
+			require.NoError(t, err)
+			require.Equal(t, tt.modified, modified)
+			if tt.modified {
+				require.Equal(t, tt.expected, newContent)
+			} else {
+				require.Equal(t, tt.before, newContent)
+			}
+		})
+	}
+}
+
+func TestReplacer_UnpinContainerImagesInFile(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		before   string
+		expected string
+		modified bool
+	}{
+		{
+			name: "Unpin yaml image references",
+			before: `
+version: v1
+services:
+  - name: kube-apiserver
+    image: registry.k8s.io/kube-apiserver@sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec # v1.20.0
+  - name: minder
+    image: ghcr.io/stacklok/minder/server@sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec
+  - name: postgres
+    image: postgres:15
+`,
+			expected: `
+version: v1
+services:
+  - name: kube-apiserver
+    image: registry.k8s.io/kube-apiserver:v1.20.0
+  - name: minder
+    image: ghcr.io/stacklok/minder/server@sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec
+  - name: postgres
+    image: postgres:15
+`,
+			modified: true,
+		},
+		{
+			name: "Keep the comment following the tag comment",
+			before: `
+services:
+  - name: kube-apiserver
+    image: registry.k8s.io/kube-apiserver@sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec # v1.20.0 # managed by team X
+`,
+			expected: `
+services:
+  - name: kube-apiserver
+    image: registry.k8s.io/kube-apiserver:v1.20.0 # managed by team X
+`,
+			modified: true,
+		},
+		{
+			name: "Unpin dockerfile references",
+			before: `
+FROM --platform=linux/amd64 index.docker.io/library/golang:1.22.2@sha256:d5302d40dc5fbbf38ec472d1848a9d2391a13f93293a6a5b0b87c99dc0eaa6ae AS builder
+FROM localhost:5000/distroless/static-debian12@sha256:d5302d40dc5fbbf38ec472d1848a9d2391a13f93293a6a5b0b87c99dc0eaa6ae
+FROM scratch
+`,
+			expected: `
+FROM --platform=linux/amd64 index.docker.io/library/golang:1.22.2 AS builder
+FROM localhost:5000/distroless/static-debian12@sha256:d5302d40dc5fbbf38ec472d1848a9d2391a13f93293a6a5b0b87c99dc0eaa6ae
+FROM scratch
+`,
+			modified: true,
+		},
+	}
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			r := NewContainerImagesReplacer(&config.Config{})
+			modified, newContent, err := r.UnpinFile(ctx, strings.NewReader(tt.before))
+			require.NoError(t, err)
+			require.Equal(t, tt.modified, modified)
+			if tt.modified {
+				require.Equal(t, tt.expected, newContent)
+			} else {
+				require.Equal(t, tt.before, newContent)
+			}
+		})
+	}
+}
+
+func TestReplacer_LongLines(t *testing.T) {
+	t.Parallel()
+
+	// A single line well beyond the default bufio.Scanner limit of 64KB
+	longLine := "    image: nginx:1.25 # " + strings.Repeat("x", 128*1024)
+	content := "spec:\n  containers:\n  - name: web\n" + longLine + "\n" +
+		"  - name: skipped\n    image: nginx:latest\n"
+
+	r := NewContainerImagesReplacer(&config.Config{
+		Images: config.Images{
+			ImageFilter: config.ImageFilter{
+				ExcludeTags: []string{"latest"},
+			},
+		},
+	})
+
+	listRes, err := r.ListInFile(strings.NewReader(content))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []interfaces.EntityRef{
+		{Name: "nginx", Ref: "1.25", Type: image.ReferenceType},
+		{Name: "nginx", Ref: "latest", Type: image.ReferenceType},
+	}, listRes.Entities)
+
+	r = r.WithUserRegex(`image:\s*nginx:latest`)
+	modified, newContent, err := r.ParseFile(context.Background(), strings.NewReader(content))
+	require.NoError(t, err)
+	require.False(t, modified)
+	require.Equal(t, content, newContent)
+}
+
 func TestReplacer_NewGitHubActionsReplacer(t *testing.T) {
 	t.Parallel()
 
@@ -1050,6 +1290,22 @@ func TestReplacer_WithGitHubClient(t *testing.T) {
 	}
 }
 
+func TestReplacer_WithGitHubBaseURL(t *testing.T) {
+	t.Parallel()
+
+	r, err := NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubBaseURL("https://github.example-corp.com")
+	require.NoError(t, err)
+	req, err := r.rest.NewRequest("GET", "repos/owner/repo", nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://github.example-corp.com/api/v3/repos/owner/repo", req.URL.String())
+
+	_, err = NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubBaseURL("not a url")
+	require.Error(t, err)
+
+	_, err = (&Replacer{}).WithGitHubBaseURL("https://github.example-corp.com")
+	require.Error(t, err, "only clients created by frizbee can be pointed at a different host")
+}
+
 func TestReplacer_WithUserRegex(t *testing.T) {
 	t.Parallel()
 
@@ -1071,6 +1327,40 @@ func TestReplacer_WithUserRegex(t *testing.T) {
 	}
 }
 
+func TestReplacer_WithUserRegexInvalid(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/workflow.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("steps:\n  - uses: actions/checkout@v4\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	r := NewGitHubActionsReplacer(config.DefaultConfig()).WithUserRegex(`uses:\s*(`)
+	ctx := context.Background()
+
+	// Every listing and replacement fails up front rather than for each file
+	_, err = r.ParsePathInFS(ctx, fs, "repo")
+	require.ErrorIs(t, err, ErrInvalidRegex)
+	require.ErrorContains(t, err, `uses:\s*(`)
+	_, _, err = r.ParseFile(ctx, strings.NewReader("uses: actions/checkout@v4\n"))
+	require.ErrorIs(t, err, ErrInvalidRegex)
+	_, err = r.UnpinPathInFS(ctx, fs, "repo")
+	require.ErrorIs(t, err, ErrInvalidRegex)
+	_, err = r.ListPathInFS(fs, "repo")
+	require.ErrorIs(t, err, ErrInvalidRegex)
+	_, err = r.ListInFile(strings.NewReader("uses: actions/checkout@v4\n"))
+	require.ErrorIs(t, err, ErrInvalidRegex)
+	err = r.ListPathInFSFunc(fs, "repo", func(interfaces.EntityRef) error { return nil })
+	require.ErrorIs(t, err, ErrInvalidRegex)
+
+	// A valid regex set afterwards replaces the invalid one
+	res, err := r.WithUserRegex(`uses:\s*\S+`).ListPathInFS(fs, "repo")
+	require.NoError(t, err)
+	require.Len(t, res.Entities, 1)
+}
+
 func TestReplacer_WithCacheDisabled(t *testing.T) {
 	t.Parallel()
 
@@ -1092,118 +1382,2686 @@ func TestReplacer_WithCacheDisabled(t *testing.T) {
 	}
 }
 
-func TestReplacer_ParsePathInFS(t *testing.T) {
+func TestReplacer_WithMaxConcurrency(t *testing.T) {
 	t.Parallel()
 
-	r := &Replacer{parser: actions.New(), cfg: config.Config{}}
-	fs := memfs.New()
 	tests := []struct {
-		name    string
-		base    string
-		wantErr bool
+		name           string
+		maxConcurrency int
+		files          int
+		wantPeak       int32
 	}{
-		{name: "valid base", base: "some-base", wantErr: false},
+		{name: "limited to one", maxConcurrency: 1, files: 8, wantPeak: 1},
+		{name: "limited to three", maxConcurrency: 3, files: 8, wantPeak: 3},
+		{name: "unbounded", maxConcurrency: 0, files: 8, wantPeak: 8},
 	}
 
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			_, err := r.ParsePathInFS(context.Background(), fs, tt.base)
-			if tt.wantErr {
-				require.Error(t, err)
-			} else {
+
+			fs := memfs.New()
+			for i := 0; i < tt.files; i++ {
+				f, err := fs.Create(fmt.Sprintf("base/workflow-%d.yml", i))
 				require.NoError(t, err)
+				_, err = f.Write([]byte("steps:\n  - uses: actions/checkout@v4\n"))
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
 			}
+
+			parser := &concurrencyTrackingParser{Parser: actions.New()}
+			r := (&Replacer{parser: parser}).WithMaxConcurrency(tt.maxConcurrency)
+			res, err := r.ParsePathInFS(context.Background(), fs, "base")
+			require.NoError(t, err)
+			require.Len(t, res.Processed, tt.files)
+			require.LessOrEqual(t, parser.peak.Load(), tt.wantPeak)
 		})
 	}
 }
 
-func TestReplacer_ListPathInFS(t *testing.T) {
+// concurrencyTrackingParser records the peak number of concurrent calls to Replace
+type concurrencyTrackingParser struct {
+	*actions.Parser
+	active atomic.Int32
+	peak   atomic.Int32
+}
+
+func (p *concurrencyTrackingParser) Replace(
+	_ context.Context,
+	matchedLine string,
+	_ interfaces.REST,
+	_ config.Config,
+) (*interfaces.EntityRef, error) {
+	active := p.active.Add(1)
+	defer p.active.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if active <= peak || p.peak.CompareAndSwap(peak, active) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+}
+
+func TestReplacer_WithPerRefTimeout(t *testing.T) {
 	t.Parallel()
 
-	r := &Replacer{parser: actions.New(), cfg: config.Config{}}
-	fs := memfs.New()
+	content := `steps:
+  - uses: hung/action@v1
+  - uses: fast/action@v1
+`
+
 	tests := []struct {
-		name    string
-		base    string
-		wantErr bool
+		name       string
+		skip       bool
+		wantErrors int
 	}{
-		{name: "valid base", base: "some-base", wantErr: false},
+		{name: "timeouts reported"},
+		{name: "timeouts skipped", skip: true},
 	}
 
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			_, err := r.ListPathInFS(fs, tt.base)
-			if tt.wantErr {
-				require.Error(t, err)
-			} else {
-				require.NoError(t, err)
+
+			fs := memfs.New()
+			f, err := fs.Create(".github/workflows/ci.yml")
+			require.NoError(t, err)
+			_, err = f.Write([]byte(content))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			r := NewGitHubActionsReplacer(config.DefaultConfig()).WithPerRefTimeout(50 * time.Millisecond)
+			r.parser = &hangingParser{Parser: actions.New()}
+			if tt.skip {
+				r = r.WithSkipOnTimeout()
+			}
+
+			start := time.Now()
+			res, err := r.ParsePathInFS(context.Background(), fs, ".github")
+			require.NoError(t, err)
+			require.Less(t, time.Since(start), 5*time.Second, "the hung reference stalled the others")
+
+			require.Equal(t, `steps:
+  - uses: hung/action@v1
+  - uses: fast/action@0123456789abcdef0123456789abcdef01234567 # v1
+`, res.Modified[".github/workflows/ci.yml"])
+			if tt.skip {
+				require.Empty(t, res.Errors)
+				return
 			}
+			require.Len(t, res.Errors, 1)
+			require.Equal(t, 2, res.Errors[0].Line)
+			require.ErrorIs(t, res.Errors[0].Err, ErrRefTimeout)
+			require.ErrorIs(t, res.Errors[0].Err, context.DeadlineExceeded)
 		})
 	}
+
+	// Cancelling the parent context isn't reported as a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := NewGitHubActionsReplacer(config.DefaultConfig()).WithPerRefTimeout(time.Minute)
+	r.parser = &hangingParser{Parser: actions.New()}
+	_, err := r.ParseString(ctx, "hung/action@v1")
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotErrorIs(t, err, ErrRefTimeout)
 }
 
-func TestReplacer_ListContainerImagesInFile(t *testing.T) {
+// hangingParser blocks resolving the references to hung/ actions until the context is done,
+// like a hung registry or API would, and pins the other ones to a fixed checksum
+type hangingParser struct {
+	*actions.Parser
+}
+
+func (*hangingParser) Replace(
+	ctx context.Context,
+	matchedLine string,
+	_ interfaces.REST,
+	_ config.Config,
+) (*interfaces.EntityRef, error) {
+	prefix, ref, _ := strings.Cut(matchedLine, "uses: ")
+	if ref == "" {
+		ref, prefix = prefix, ""
+	} else {
+		prefix += "uses: "
+	}
+	if strings.HasPrefix(ref, "hung/") {
+		<-ctx.Done()
+		return nil, fmt.Errorf("failed to get checksum for %s: %w", ref, ctx.Err())
+	}
+	name, tag, _ := strings.Cut(ref, "@")
+	return &interfaces.EntityRef{
+		Name:   name,
+		Ref:    "0123456789abcdef0123456789abcdef01234567",
+		Type:   actions.ReferenceType,
+		Tag:    tag,
+		Prefix: prefix,
+	}, nil
+}
+
+func TestReplacer_WithKeychain(t *testing.T) {
 	t.Parallel()
 
-	testCases := []struct {
-		name           string
-		before         string
-		expected       *ListResult
-		regex          string
-		wantErr        bool
-		useCustomRegex bool
+	creds := &authn.Basic{Username: "frizbee", Password: "s3cr3t"}
+	srv := httptest.NewServer(requireBasicAuth(creds, registry.New(registry.Logger(log.New(io.Discard, "", 0)))))
+	t.Cleanup(srv.Close)
+	imageRef := strings.TrimPrefix(srv.URL, "http://") + "/private/app:v1.0.0"
+
+	digest := pushRandomImage(t, imageRef, remote.WithAuth(creds))
+
+	tests := []struct {
+		name     string
+		replacer func() *Replacer
+		input    string
+		prefix   string
 	}{
 		{
-			name: "Lust image reference",
-			before: `
-version: v1
-services:
- - name: kube-apiserver
-   image: registry.k8s.io/kube-apiserver:v1.20.0
- - name: kube-controller-manager
-   image: registry.k8s.io/kube-controller-manager:v1.15.0
- - name: minder-app
-   image: minder:latest
-`,
-			expected: &ListResult{
-				Entities: []interfaces.EntityRef{
-					{
-						Name: "registry.k8s.io/kube-apiserver",
-						Ref:  "v1.20.0",
-						Type: image.ReferenceType,
-					},
-					{
-						Name: "registry.k8s.io/kube-controller-manager",
-						Ref:  "v1.15.0",
-						Type: image.ReferenceType,
-					},
-					{
-						Name: "minder",
-						Ref:  "latest",
-						Type: image.ReferenceType,
-					},
-				},
-			},
-			wantErr: false,
+			name:     "container image",
+			replacer: func() *Replacer { return NewContainerImagesReplacer(config.DefaultConfig()) },
+			input:    imageRef,
 		},
 		{
-			name: "No image reference modification",
-			before: `
-		version: v1
-		services:
-		- name: minder-app
-		  # image: minder:latest
-		`,
-			expected: &ListResult{
-				Entities: []interfaces.EntityRef{},
-			},
-			wantErr: false,
+			name:     "docker action",
+			replacer: func() *Replacer { return NewGitHubActionsReplacer(config.DefaultConfig()) },
+			input:    "docker://" + imageRef,
+			prefix:   "docker://",
 		},
-		{
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tt.replacer().WithCacheDisabled().ParseString(context.Background(), tt.input)
+			require.Error(t, err)
+
+			r := tt.replacer().WithCacheDisabled().WithKeychain(staticKeychain{creds})
+			got, err := r.ParseString(context.Background(), tt.input)
+			require.NoError(t, err)
+			require.Equal(t, digest, got.Ref)
+			require.Equal(t, "v1.0.0", got.Tag)
+			require.Equal(t, tt.prefix, got.Prefix)
+		})
+	}
+}
+
+func TestReplacer_WithDockerConfig(t *testing.T) {
+	t.Parallel()
+
+	creds := &authn.Basic{Username: "frizbee", Password: "s3cr3t"}
+	srv := httptest.NewServer(requireBasicAuth(creds, registry.New(registry.Logger(log.New(io.Discard, "", 0)))))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	imageRef := host + "/private/app:v1.0.0"
+
+	digest := pushRandomImage(t, imageRef, remote.WithAuth(creds))
+
+	writeConfig := func(registryHost string) string {
+		path := filepath.Join(t.TempDir(), "config.json")
+		auth := base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+		content := fmt.Sprintf(`{"auths": {%q: {"auth": %q}}}`, registryHost, auth)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	// The credentials of the chosen config are used
+	r, err := NewContainerImagesReplacer(config.DefaultConfig()).WithCacheDisabled().WithDockerConfig(writeConfig(host))
+	require.NoError(t, err)
+	got, err := r.ParseString(context.Background(), imageRef)
+	require.NoError(t, err)
+	require.Equal(t, digest, got.Ref)
+
+	// A config without credentials for the registry resolves anonymously
+	r, err = NewContainerImagesReplacer(config.DefaultConfig()).WithCacheDisabled().WithDockerConfig(writeConfig("quay.io"))
+	require.NoError(t, err)
+	_, err = r.ParseString(context.Background(), imageRef)
+	require.Error(t, err)
+
+	_, err = NewContainerImagesReplacer(config.DefaultConfig()).WithDockerConfig(filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+}
+
+func TestReplacer_RegistryTLS(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "https://")
+	imageRef := host + "/internal/app:v1.0.0"
+
+	digest := pushRandomImage(t, imageRef, remote.WithTransport(srv.Client().Transport))
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caPath, caPEM, 0600))
+
+	newReplacer := func() *Replacer {
+		return NewContainerImagesReplacer(config.DefaultConfig()).WithCacheDisabled()
+	}
+
+	// The certificate of the registry isn't trusted by default
+	_, err := newReplacer().ParseString(context.Background(), imageRef)
+	require.Error(t, err)
+
+	// Other registries listed as insecure don't make this one insecure too
+	_, err = newReplacer().WithInsecureRegistries([]string{"registry.internal"}).ParseString(context.Background(), imageRef)
+	require.Error(t, err)
+
+	got, err := newReplacer().WithInsecureRegistries([]string{host}).ParseString(context.Background(), imageRef)
+	require.NoError(t, err)
+	require.Equal(t, digest, got.Ref)
+
+	r, err := newReplacer().WithRegistryCACert(caPath)
+	require.NoError(t, err)
+	got, err = r.ParseString(context.Background(), imageRef)
+	require.NoError(t, err)
+	require.Equal(t, digest, got.Ref)
+
+	_, err = newReplacer().WithRegistryCACert(filepath.Join(t.TempDir(), "missing.pem"))
+	require.Error(t, err)
+	_, err = newReplacer().WithRegistryCACert(filepath.Join("..", "..", "go.mod"))
+	require.Error(t, err)
+}
+
+func TestReplacer_ParseHelmValues(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1.0.0")
+	digest := digests["app:v1.0.0"]
+
+	values := fmt.Sprintf(`image:
+  repository: %[1]s/app
+  tag: v1.0.0
+job:
+  image: %[1]s/app:v1.0.0
+`, host)
+	want := fmt.Sprintf(`image:
+  repository: %[1]s/app
+  tag: v1.0.0@%[2]s
+job:
+  image: %[1]s/app@%[2]s # v1.0.0
+`, host, digest)
+
+	modified, got, err := NewContainerImagesReplacer(config.DefaultConfig()).ParseFile(context.Background(), strings.NewReader(values))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, want, got)
+
+	// The Helm image failing to resolve is reported, the others are still pinned
+	fs := memfs.New()
+	f, err := fs.Create("chart/values.yaml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(values + fmt.Sprintf("missing:\n  image:\n    repository: %s/missing\n    tag: v1.0.0\n", host)))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	res, err := NewContainerImagesReplacer(config.DefaultConfig()).ParsePathInFS(context.Background(), fs, "chart")
+	require.NoError(t, err)
+	require.Contains(t, res.Modified, "chart/values.yaml")
+	require.Len(t, res.Errors, 1)
+	require.Equal(t, 9, res.Errors[0].Line)
+	require.Equal(t, host+"/missing:v1.0.0", res.Errors[0].Reference)
+	// The Helm images are counted along with the ones matched line by line
+	require.Equal(t, FileStats{Matched: 3, Modified: 2, Errored: 1}, res.Stats["chart/values.yaml"])
+	// The Helm image is listed among the changes along with the one matched line by line
+	require.Len(t, res.Changes, 2)
+	require.Equal(t, 3, res.Changes[0].Line)
+	require.Equal(t, host+"/app:v1.0.0", res.Changes[0].Before)
+	require.Equal(t, host+"/app:v1.0.0@"+digest, res.Changes[0].After)
+}
+
+func TestReplacer_DockerfileTagComment(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1.0.0")
+	digest := digests["app:v1.0.0"]
+
+	dockerfile := fmt.Sprintf(`# The build stage
+FROM %[1]s/app:v1.0.0 AS builder
+RUN make
+  FROM --platform=linux/amd64 %[1]s/app:v1.0.0
+`, host)
+
+	tests := []struct {
+		name    string
+		comment bool
+		want    string
+	}{
+		{
+			name: "no comment",
+			want: fmt.Sprintf(`# The build stage
+FROM %[1]s/app:v1.0.0@%[2]s AS builder
+RUN make
+  FROM --platform=linux/amd64 %[1]s/app:v1.0.0@%[2]s
+`, host, digest),
+		},
+		{
+			name:    "comment above the instruction",
+			comment: true,
+			want: fmt.Sprintf(`# The build stage
+# v1.0.0
+FROM %[1]s/app:v1.0.0@%[2]s AS builder
+RUN make
+  # v1.0.0
+  FROM --platform=linux/amd64 %[1]s/app:v1.0.0@%[2]s
+`, host, digest),
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.DefaultConfig()
+			cfg.Images.DockerfileTagComment = tt.comment
+			r := NewContainerImagesReplacer(cfg)
+			modified, got, err := r.ParseFile(context.Background(), strings.NewReader(dockerfile))
+			require.NoError(t, err)
+			require.True(t, modified)
+			require.Equal(t, tt.want, got)
+
+			// Unpinning drops the tag comments, so pinning again doesn't stack them
+			modified, got, err = r.UnpinFile(context.Background(), strings.NewReader(got))
+			require.NoError(t, err)
+			require.True(t, modified)
+			require.Equal(t, dockerfile, got)
+			_, got, err = r.ParseFile(context.Background(), strings.NewReader(got))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReplacer_PreserveShortNames(t *testing.T) {
+	t.Parallel()
+
+	// Docker Hub is mirrored by the local registry to resolve golang:1.22
+	mirror, digests := newTestRegistry(t, "library/golang:1.22")
+	digest := digests["library/golang:1.22"]
+
+	const manifest = `containers:
+  - image: golang:1.22
+FROM golang:1.22 AS builder
+`
+
+	tests := []struct {
+		name     string
+		preserve bool
+		want     string
+	}{
+		{
+			name: "expanded",
+			want: fmt.Sprintf(`containers:
+  - image: index.docker.io/library/golang@%[1]s # 1.22
+FROM index.docker.io/library/golang:1.22@%[1]s AS builder
+`, digest),
+		},
+		{
+			name:     "preserved",
+			preserve: true,
+			want: fmt.Sprintf(`containers:
+  - image: golang@%[1]s # 1.22
+FROM golang:1.22@%[1]s AS builder
+`, digest),
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.DefaultConfig()
+			cfg.Images.RegistryMirrors = map[string]string{"docker.io": mirror}
+			cfg.Images.PreserveShortNames = tt.preserve
+			modified, got, err := NewContainerImagesReplacer(cfg).ParseFile(context.Background(), strings.NewReader(manifest))
+			require.NoError(t, err)
+			require.True(t, modified)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReplacer_InlineComment(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1.0.0")
+	digest := digests["app:v1.0.0"]
+
+	content := fmt.Sprintf(`services:
+  web:
+    image: %[1]s/app:v1.0.0 # managed by team X
+  worker:
+    image: %[1]s/app:v1.0.0   #  keep in sync with web
+`, host)
+	want := fmt.Sprintf(`services:
+  web:
+    image: %[1]s/app@%[2]s # v1.0.0 # managed by team X
+  worker:
+    image: %[1]s/app@%[2]s # v1.0.0   #  keep in sync with web
+`, host, digest)
+
+	r := NewContainerImagesReplacer(config.DefaultConfig())
+	modified, got, err := r.ParseFile(context.Background(), strings.NewReader(content))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, want, got)
+
+	// Unpinning restores the original lines, comments included
+	modified, got, err = r.UnpinFile(context.Background(), strings.NewReader(want))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, content, got)
+}
+
+func TestReplacer_WithPlatforms(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newTestRegistry(t)
+
+	// A multi-platform index and a single amd64 image
+	idx := v1.ImageIndex(empty.Index)
+	for _, platform := range []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}} {
+		img, err := random.Image(64, 1)
+		require.NoError(t, err)
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &platform}})
+	}
+	ref, err := name.ParseReference(host + "/multi:v1")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(ref, idx))
+	idxDigest, err := idx.Digest()
+	require.NoError(t, err)
+
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	img, err = mutate.ConfigFile(img, &v1.ConfigFile{OS: "linux", Architecture: "amd64"})
+	require.NoError(t, err)
+	ref, err = name.ParseReference(host + "/single:v1")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+
+	content := fmt.Sprintf(`services:
+  multi:
+    image: %[1]s/multi:v1
+  single:
+    image: %[1]s/single:v1
+`, host)
+
+	r, err := NewContainerImagesReplacer(config.DefaultConfig()).WithPlatforms([]string{"linux/amd64", "linux/arm64"})
+	require.NoError(t, err)
+
+	fs := memfs.New()
+	f, err := fs.Create("compose.yaml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	res, err := r.ParsePathInFS(context.Background(), fs, ".")
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf(`services:
+  multi:
+    image: %[1]s/multi@%[2]s # v1
+  single:
+    image: %[1]s/single:v1
+`, host, idxDigest), res.Modified["compose.yaml"])
+	require.Len(t, res.Errors, 1)
+	require.Equal(t, 5, res.Errors[0].Line)
+	require.ErrorIs(t, &res.Errors[0], image.ErrPlatformNotFound)
+	require.ErrorContains(t, &res.Errors[0], "linux/arm64")
+
+	_, err = NewContainerImagesReplacer(config.DefaultConfig()).WithPlatforms([]string{"linux/amd64", ""})
+	require.Error(t, err)
+}
+
+func TestReplacer_PinnedImagesIdempotent(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	digest := pushRandomImage(t, host+"/app:v1.0.0")
+
+	tests := []struct {
+		name     string
+		content  string
+		replacer func() *Replacer
+	}{
+		{
+			name: "dockerfile",
+			content: `FROM %[1]s/app:v1.0.0 AS builder
+FROM --platform=linux/amd64 %[1]s/app:v1.0.0
+`,
+			replacer: func() *Replacer { return NewContainerImagesReplacer(config.DefaultConfig()) },
+		},
+		{
+			name: "yaml",
+			content: `services:
+  web:
+    image: %[1]s/app:v1.0.0
+  worker:
+    image: %[1]s/app:v1.0.0@%[2]s
+`,
+			replacer: func() *Replacer { return NewContainerImagesReplacer(config.DefaultConfig()) },
+		},
+		{
+			name: "docker action",
+			content: `steps:
+  - uses: docker://%[1]s/app:v1.0.0
+`,
+			replacer: func() *Replacer { return NewGitHubActionsReplacer(config.DefaultConfig()) },
+		},
+	}
+
+	// The subtests share the request counter of the registry, so they can't run in parallel
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.replacer().WithCacheDisabled()
+			content := fmt.Sprintf(tt.content, host, digest)
+			modified, pinned, err := r.ParseFile(context.Background(), strings.NewReader(content))
+			require.NoError(t, err)
+			require.True(t, modified)
+
+			// Pinning again neither changes the content nor asks the registry
+			before := requests.Load()
+			modified, repinned, err := r.ParseFile(context.Background(), strings.NewReader(pinned))
+			require.NoError(t, err)
+			require.False(t, modified)
+			require.Equal(t, pinned, repinned)
+			require.Equal(t, before, requests.Load())
+		})
+	}
+}
+
+func TestReplacer_FROMInImageName(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "team/from-service:1.0", "app:BUILT-FROM-main")
+
+	manifest := fmt.Sprintf(`containers:
+  - image: %[1]s/team/from-service:1.0
+  - image: %[1]s/app:BUILT-FROM-main
+`, host)
+	want := fmt.Sprintf(`containers:
+  - image: %[1]s/team/from-service@%[2]s # 1.0
+  - image: %[1]s/app@%[3]s # BUILT-FROM-main
+`, host, digests["team/from-service:1.0"], digests["app:BUILT-FROM-main"])
+
+	modified, got, err := NewContainerImagesReplacer(config.DefaultConfig()).ParseFile(context.Background(), strings.NewReader(manifest))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, want, got)
+}
+
+func TestReplacer_MultipleReferencesOnALine(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "init:1.0", "app:2.0")
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "both references resolve",
+			input: "init_image: %[1]s/init:1.0 image: %[1]s/app:2.0\n",
+			want:  "init_image: %[1]s/init@%[2]s # 1.0 image: %[1]s/app@%[3]s # 2.0\n",
+		},
+		{
+			name:  "first reference fails to resolve",
+			input: "init_image: %[1]s/missing:1.0 image: %[1]s/app:2.0\n",
+			want:  "init_image: %[1]s/missing:1.0 image: %[1]s/app@%[3]s # 2.0\n",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			input := fmt.Sprintf(tt.input, host)
+			want := fmt.Sprintf(tt.want, host, digests["init:1.0"], digests["app:2.0"])
+			modified, got, err := NewContainerImagesReplacer(config.DefaultConfig()).ParseFile(context.Background(), strings.NewReader(input))
+			require.NoError(t, err)
+			require.True(t, modified)
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+func TestReplacer_ImageKeys(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "pause:3.9")
+	digest := digests["pause:3.9"]
+
+	input := fmt.Sprintf(`plugins:
+  cri:
+    sandbox_image: %[1]s/pause:3.9
+    initImage: %[1]s/pause:3.9
+`, host)
+	want := fmt.Sprintf(`plugins:
+  cri:
+    sandbox_image: %[1]s/pause@%[2]s # 3.9
+    initImage: %[1]s/pause@%[2]s # 3.9
+`, host, digest)
+
+	cfg := config.DefaultConfig()
+	cfg.Images.ImageKeys = []string{"sandbox_image", "initImage"}
+	modified, got, err := NewContainerImagesReplacer(cfg).ParseFile(context.Background(), strings.NewReader(input))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, want, got)
+
+	// The keys aren't matched unless configured
+	_, got, err = NewContainerImagesReplacer(config.DefaultConfig()).ParseFile(context.Background(), strings.NewReader(input))
+	require.NoError(t, err)
+	require.Contains(t, got, "initImage: "+host+"/pause:3.9\n")
+}
+
+func TestReplacer_WithFailOnUnresolved(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newTestRegistry(t, "app:v1.0.0")
+
+	fs := memfs.New()
+	files := map[string]string{
+		"base/deployment.yaml": fmt.Sprintf(`containers:
+  - image: %[1]s/app:v1.0.0
+  - image: %[1]s/app:v1.0.1
+  - image: ubuntu:latest
+  - image: {{ .Values.image }}
+`, host),
+		"base/Dockerfile": fmt.Sprintf(`FROM ${BASE_IMAGE}
+FROM %[1]s/typo:v1.0.0
+`, host),
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// Unresolved references are left untouched by default
+	res, err := NewContainerImagesReplacer(config.DefaultConfig()).ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+	require.Len(t, res.Modified, 1)
+
+	_, err = NewContainerImagesReplacer(config.DefaultConfig()).WithFailOnUnresolved().
+		ParsePathInFS(context.Background(), fs, "base")
+	require.ErrorIs(t, err, ErrUnresolved)
+	var unresolvedErr *UnresolvedError
+	require.ErrorAs(t, err, &unresolvedErr)
+	require.Len(t, unresolvedErr.References, 2, "skipped and templated references aren't reported")
+	require.Equal(t, "base/Dockerfile", unresolvedErr.References[0].Path)
+	require.Equal(t, 2, unresolvedErr.References[0].Line)
+	require.Equal(t, "FROM "+host+"/typo:v1.0.0", unresolvedErr.References[0].Reference)
+	require.Equal(t, "base/deployment.yaml", unresolvedErr.References[1].Path)
+	require.Equal(t, 3, unresolvedErr.References[1].Line)
+	require.Equal(t, "image: "+host+"/app:v1.0.1", unresolvedErr.References[1].Reference)
+	require.Contains(t, err.Error(), "base/deployment.yaml:3: image: "+host+"/app:v1.0.1: ")
+
+	_, _, err = NewContainerImagesReplacer(config.DefaultConfig()).WithFailOnUnresolved().
+		ParseFile(context.Background(), strings.NewReader(files["base/deployment.yaml"]))
+	require.ErrorIs(t, err, ErrUnresolved)
+	require.Contains(t, err.Error(), "line 3: image: "+host+"/app:v1.0.1: ")
+}
+
+func TestReplacer_ReferenceErrors(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1.0.0")
+	digest := digests["app:v1.0.0"]
+
+	fs := memfs.New()
+	f, err := fs.Create("base/deployment.yaml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(fmt.Sprintf(`containers:
+  - image: %[1]s/missing:v1.0.0
+  - image: %[1]s/app:v1.0.0
+`, host)))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	res, err := NewContainerImagesReplacer(config.DefaultConfig()).ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+
+	// The good reference is pinned regardless of the bad one
+	require.Equal(t, fmt.Sprintf(`containers:
+  - image: %[1]s/missing:v1.0.0
+  - image: %[1]s/app@%[2]s # v1.0.0
+`, host, digest), res.Modified["base/deployment.yaml"])
+
+	require.Len(t, res.Errors, 1)
+	require.Equal(t, "base/deployment.yaml", res.Errors[0].Path)
+	require.Equal(t, 2, res.Errors[0].Line)
+	require.Equal(t, "image: "+host+"/missing:v1.0.0", res.Errors[0].Reference)
+	require.Error(t, res.Errors[0].Err)
+	require.Contains(t, res.Errors[0].Error(), "base/deployment.yaml:2: image: "+host+"/missing:v1.0.0: ")
+}
+
+func TestIsUnresolved(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"skipped", fmt.Errorf("excluded: %w", interfaces.ErrReferenceSkipped), false},
+		{"invalid", fmt.Errorf("invalid action reference: foo %w", interfaces.ErrInvalidReference), false},
+		{"tag not found", fmt.Errorf("failed to get checksum: %w", actions.ErrInvalidActionReference), true},
+		{"network error", errors.New("connection refused"), true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, isUnresolved(tt.err))
+		})
+	}
+}
+
+func TestReplacer_WithRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		failures    int32
+		failStatus  int
+		maxAttempts int
+		wantErr     bool
+		wantFetches int32
+	}{
+		{name: "server errors retried", failures: 2, failStatus: http.StatusServiceUnavailable, maxAttempts: 3, wantFetches: 3},
+		{name: "attempts exhausted", failures: 3, failStatus: http.StatusBadGateway, maxAttempts: 3, wantErr: true, wantFetches: 3},
+		{name: "client errors not retried", failures: 1, failStatus: http.StatusNotFound, maxAttempts: 3, wantErr: true, wantFetches: 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Fail the first manifest lookups served by the registry once the image is pushed
+			var armed atomic.Bool
+			var fetches atomic.Int32
+			reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if armed.Load() && strings.Contains(r.URL.Path, "/manifests/") &&
+					fetches.Add(1) <= tt.failures {
+					w.WriteHeader(tt.failStatus)
+					return
+				}
+				reg.ServeHTTP(w, r)
+			}))
+			t.Cleanup(srv.Close)
+			imageRef := strings.TrimPrefix(srv.URL, "http://") + "/flaky/app:v1.0.0"
+
+			digest := pushRandomImage(t, imageRef)
+			armed.Store(true)
+
+			r := NewContainerImagesReplacer(config.DefaultConfig()).
+				WithCacheDisabled().
+				WithRetry(retry.Policy{MaxAttempts: tt.maxAttempts, BaseDelay: time.Millisecond, MaxDelay: time.Second})
+			got, err := r.ParseString(context.Background(), imageRef)
+			require.Equal(t, tt.wantFetches, fetches.Load())
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, digest, got.Ref)
+		})
+	}
+}
+
+func TestReplacer_RateLimited(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	fs := memfs.New()
+	f, err := fs.Create("base/workflow.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("steps:\n  - uses: actions/checkout@v4\n  - uses: actions/setup-go@v5\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	r := NewGitHubActionsReplacer(config.DefaultConfig()).WithCacheDisabled().WithGitHubClient(client)
+	_, err = r.ParsePathInFS(context.Background(), fs, "base")
+	require.ErrorIs(t, err, ghrest.ErrRateLimited)
+	require.Equal(t, int32(1), calls.Load(), "the remaining references shouldn't be resolved")
+}
+
+func TestReplacer_ResolveVersion(t *testing.T) {
+	t.Parallel()
+
+	const sum = "11bd71901bbe5b1630ceea73d27597364c9af683"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/actions/checkout/git/refs/tags/v4":
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + sum + `", "type": "commit"}}`))
+		case "/api/v3/repos/actions/checkout/git/matching-refs/tags/v4":
+			_, _ = w.Write([]byte(`[
+				{"ref": "refs/tags/v4", "object": {"sha": "` + sum + `", "type": "commit"}},
+				{"ref": "refs/tags/v4.1.1", "object": {"sha": "` + sum + `", "type": "commit"}}
+			]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.GHActions.ResolveVersion = true
+	r := NewGitHubActionsReplacer(cfg).WithGitHubClient(client)
+
+	modified, res, err := r.ParseFile(context.Background(), strings.NewReader("steps:\n  - uses: actions/checkout@v4\n"))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, "steps:\n  - uses: actions/checkout@"+sum+" # v4 (v4.1.1)\n", res)
+
+	// Unpinning restores the moving tag as written
+	modified, res, err = r.UnpinFile(context.Background(), strings.NewReader(res))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, "steps:\n  - uses: actions/checkout@v4\n", res)
+}
+
+func TestReplacer_Idempotent(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newTestRegistry(t,
+		"golang:1.22", "distroless/static:nonroot", "plugins/docker:20", "postgres:16", "drone/git:1",
+		"plugins/git:next", "plugins/slack:1", "nginx:1.25", "nginx:1.25.3", "redis:7.2", "aquasec/trivy:0.50",
+	)
+
+	fs := memfs.New()
+	fixtures := []string{"deployment.yaml", "drone.yml", "Dockerfile.multistage", "web.container", "pod.json"}
+	for _, fixture := range fixtures {
+		content, err := os.ReadFile(filepath.Join("image", "testdata", fixture))
+		require.NoError(t, err)
+		f, err := fs.Create("repo/" + fixture)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(strings.ReplaceAll(string(content), "REGISTRY", host)))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Images.JSONManifests = true
+	cfg.Images.DockerfileTagComment = true
+	r := NewContainerImagesReplacer(cfg).WithFailOnUnresolved()
+
+	// The first pass pins every fixture
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Len(t, res.Modified, len(fixtures))
+	for path, content := range res.Modified {
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// Running the replacer on its own output is a no-op, i.e. the name@digest # tag of YAML
+	// files, the name:tag@digest of Dockerfiles and quadlet units along with the tag comments
+	// written above them, and the name:tag@digest of JSON manifests are left as they are
+	res, err = r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Empty(t, res.Modified)
+	require.Empty(t, res.Errors)
+
+	// So is the sha # tag of actions
+	const sum = "11bd71901bbe5b1630ceea73d27597364c9af683"
+	gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/actions/checkout/git/refs/tags/v4" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + sum + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(gh.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(gh.URL)
+	require.NoError(t, err)
+
+	actions := NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubClient(client).WithFailOnUnresolved()
+	modified, pinned, err := actions.ParseFile(context.Background(), strings.NewReader("steps:\n  - uses: actions/checkout@v4\n"))
+	require.NoError(t, err)
+	require.True(t, modified)
+	modified, repinned, err := actions.ParseFile(context.Background(), strings.NewReader(pinned))
+	require.NoError(t, err)
+	require.False(t, modified)
+	require.Equal(t, pinned, repinned)
+}
+
+func TestReplacer_CRLF(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "nginx:1.25.3", "redis:7.2", "golang:1.22", "distroless/static:nonroot")
+
+	compose, err := os.ReadFile(filepath.Join("image", "testdata", "compose.crlf.yaml"))
+	require.NoError(t, err)
+	dockerfile, err := os.ReadFile(filepath.Join("image", "testdata", "Dockerfile.multistage"))
+	require.NoError(t, err)
+
+	fs := memfs.New()
+	files := map[string]string{
+		"repo/compose.yaml": string(compose),
+		// The tag comments written above the FROM instructions get the same line endings
+		"repo/Dockerfile": strings.ReplaceAll(string(dockerfile), "\n", "\r\n"),
+		// As do the documents edited as a whole, e.g. Helm chart values
+		"repo/values.yaml": "image:\r\n  repository: REGISTRY/nginx\r\n  tag: 1.25.3\r\n",
+	}
+	for path, content := range files {
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(strings.ReplaceAll(content, "REGISTRY", host)))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Images.DockerfileTagComment = true
+	r := NewContainerImagesReplacer(cfg).WithFailOnUnresolved()
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{
+		"repo/compose.yaml": "# Compose file authored on Windows\r\nservices:\r\n  web:\r\n" +
+			"    image: " + host + "/nginx@" + digests["nginx:1.25.3"] + " # 1.25.3\r\n  cache:\r\n" +
+			"    image: " + host + "/redis@" + digests["redis:7.2"] + " # 7.2 # LRU cache\r\n" +
+			"    command: [\"redis-server\", \"--maxmemory\", \"64mb\"]\r\n",
+		"repo/Dockerfile": "# syntax=docker/dockerfile:1\r\n# 1.22\r\n" +
+			"FROM --platform=$BUILDPLATFORM " + host + "/golang:1.22@" + digests["golang:1.22"] + " AS builder\r\n" +
+			"WORKDIR /src\r\nCOPY . .\r\nRUN go build -o /out/app ./cmd/app\r\n\r\n" +
+			"FROM builder as Tester\r\nRUN go test ./...\r\n\r\n# nonroot\r\n" +
+			"FROM " + host + "/distroless/static:nonroot@" + digests["distroless/static:nonroot"] + "\r\n" +
+			"COPY --from=builder /out/app /app\r\nCOPY --from=tester /src/report.xml /report.xml\r\n" +
+			"ENTRYPOINT [\"/app\"]\r\n",
+		"repo/values.yaml": "image:\r\n  repository: " + host + "/nginx\r\n  tag: 1.25.3@" + digests["nginx:1.25.3"] + "\r\n",
+	}, res.Modified)
+}
+
+func TestReplacer_FinalNewline(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1")
+	digest := digests["app:v1"]
+
+	pinnedYAML := "image: " + host + "/app@" + digest + " # v1"
+	pinnedFROM := "FROM " + host + "/app:v1@" + digest
+
+	testCases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "final newline",
+			content: "services:\n  app:\n    image: " + host + "/app:v1\n",
+			want:    "services:\n  app:\n    " + pinnedYAML + "\n",
+		},
+		{
+			name:    "no final newline",
+			content: "services:\n  app:\n    image: " + host + "/app:v1",
+			want:    "services:\n  app:\n    " + pinnedYAML,
+		},
+		{
+			name:    "no final newline after an untouched line",
+			content: "services:\n  app:\n    image: " + host + "/app:v1\n    restart: always",
+			want:    "services:\n  app:\n    " + pinnedYAML + "\n    restart: always",
+		},
+		{
+			name:    "CRLF without a final newline",
+			content: "services:\r\n  app:\r\n    image: " + host + "/app:v1",
+			want:    "services:\r\n  app:\r\n    " + pinnedYAML,
+		},
+		{
+			name:    "Dockerfile tag comment without a final newline",
+			content: "FROM " + host + "/app:v1",
+			want:    "# v1\n" + pinnedFROM,
+		},
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Images.DockerfileTagComment = true
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewContainerImagesReplacer(cfg).WithFailOnUnresolved()
+			modified, got, err := r.ParseFile(context.Background(), strings.NewReader(tt.content))
+			require.NoError(t, err)
+			require.True(t, modified)
+			require.Equal(t, tt.want, got)
+
+			// Unpinning keeps the final newline, or its absence, as well
+			_, unpinned, err := r.UnpinFile(context.Background(), strings.NewReader(got))
+			require.NoError(t, err)
+			require.Equal(t, strings.HasSuffix(tt.content, "\n"), strings.HasSuffix(unpinned, "\n"))
+		})
+	}
+}
+
+func TestReplacer_UserRegexCustomFormat(t *testing.T) {
+	t.Parallel()
+
+	const sum = "11bd71901bbe5b1630ceea73d27597364c9af683"
+	gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/actions/checkout/git/refs/tags/v4" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + sum + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(gh.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(gh.URL)
+	require.NoError(t, err)
+
+	host, digests := newTestRegistry(t, "app:v1")
+	digest := digests["app:v1"]
+
+	// A bespoke pipeline format, neither YAML nor a Dockerfile, whose extension isn't traversed
+	fs := memfs.New()
+	f, err := fs.Create("repo/build.pipeline")
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(f, `[stage checkout]
+run-action  => actions/checkout@v4
+[stage build]
+container   => %s/app:v1
+run-action  => ./local/action
+`, host)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	testCases := []struct {
+		name     string
+		replacer *Replacer
+		regex    string
+		want     string
+	}{
+		{
+			name:     "actions",
+			replacer: NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubClient(client),
+			regex:    `[\w./-]+@v[\w.]+`,
+			want: fmt.Sprintf(`[stage checkout]
+run-action  => actions/checkout@%s # v4
+[stage build]
+container   => %s/app:v1
+run-action  => ./local/action
+`, sum, host),
+		},
+		{
+			name:     "images",
+			replacer: NewContainerImagesReplacer(config.DefaultConfig()),
+			regex:    `\S+/app:v1`,
+			want: fmt.Sprintf(`[stage checkout]
+run-action  => actions/checkout@v4
+[stage build]
+container   => %s/app@%s # v1
+run-action  => ./local/action
+`, host, digest),
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// A file given explicitly is only processed like in its directory by default
+			r := tt.replacer.WithUserRegex(tt.regex).WithFailOnUnresolved()
+			res, err := r.ParsePathInFS(context.Background(), fs, "repo/build.pipeline")
+			require.NoError(t, err)
+			require.Empty(t, res.Processed)
+
+			// Or whatever its extension if asked to
+			r = r.WithAnyFileName()
+			res, err = r.ParsePathInFS(context.Background(), fs, "repo/build.pipeline")
+			require.NoError(t, err)
+			require.Equal(t, []string{"repo/build.pipeline"}, res.Processed)
+			require.Equal(t, map[string]string{"repo/build.pipeline": tt.want}, res.Modified)
+
+			// But not when traversing its directory
+			res, err = r.ParsePathInFS(context.Background(), fs, "repo")
+			require.NoError(t, err)
+			require.Empty(t, res.Processed)
+		})
+	}
+}
+
+// staticKeychain resolves the same credentials for every registry
+type staticKeychain struct {
+	authn.Authenticator
+}
+
+func (k staticKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return k.Authenticator, nil
+}
+
+// newTestRegistry starts a registry serving a random image for each of the given
+// references, e.g. app:v1.0.0, and returns its host along with the digests of the images
+// by reference
+func newTestRegistry(t *testing.T, refs ...string) (string, map[string]string) {
+	t.Helper()
+
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	digests := make(map[string]string, len(refs))
+	for _, r := range refs {
+		digests[r] = pushRandomImage(t, host+"/"+r)
+	}
+
+	return host, digests
+}
+
+// pushRandomImage pushes a random image under the given reference and returns its digest
+func pushRandomImage(t *testing.T, refstr string, opts ...remote.Option) string {
+	t.Helper()
+
+	ref, err := name.ParseReference(refstr)
+	require.NoError(t, err)
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img, opts...))
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	return digest.String()
+}
+
+// requireBasicAuth rejects requests not carrying the given credentials
+func requireBasicAuth(creds *authn.Basic, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != creds.Username || pass != creds.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="frizbee"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestIsPinned(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		ref  interfaces.EntityRef
+		want bool
+	}{
+		{
+			name: "action pinned by checksum",
+			ref:  interfaces.EntityRef{Name: "actions/checkout", Ref: "b4ffde65f46336ab88eb53be808477a3936bae11", Type: actions.ReferenceType},
+			want: true,
+		},
+		{
+			name: "action referenced by tag",
+			ref:  interfaces.EntityRef{Name: "actions/checkout", Ref: "v4", Type: actions.ReferenceType},
+		},
+		{
+			name: "action referenced by branch",
+			ref:  interfaces.EntityRef{Name: "actions/checkout", Ref: "main", Type: actions.ReferenceType},
+		},
+		{
+			name: "image pinned by digest",
+			ref: interfaces.EntityRef{
+				Name: "nginx",
+				Ref:  "sha256:a29f8a8d28f0af7f70a4b3dd3e33c8c8cc5cf9e88e802e2700cf272a0b6140ec",
+				Type: image.ReferenceType,
+			},
+			want: true,
+		},
+		{
+			name: "image referenced by tag",
+			ref:  interfaces.EntityRef{Name: "nginx", Ref: "1.25", Type: image.ReferenceType},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, IsPinned(tt.ref))
+		})
+	}
+}
+
+func TestListResult_Unpinned(t *testing.T) {
+	t.Parallel()
+
+	pinned := EntityLocation{
+		EntityRef: interfaces.EntityRef{
+			Name: "actions/checkout",
+			Ref:  "b4ffde65f46336ab88eb53be808477a3936bae11",
+			Type: actions.ReferenceType,
+		},
+		Path: "workflows/ci.yml",
+		Line: 5,
+	}
+	unpinned := EntityLocation{
+		EntityRef: interfaces.EntityRef{Name: "actions/setup-go", Ref: "v5", Type: actions.ReferenceType},
+		Path:      "workflows/ci.yml",
+		Line:      6,
+	}
+
+	res := &ListResult{Locations: []EntityLocation{pinned, unpinned}}
+	require.Equal(t, []EntityLocation{unpinned}, res.Unpinned())
+	require.Empty(t, (&ListResult{Locations: []EntityLocation{pinned}}).Unpinned())
+
+	res.Processed = []string{"workflows/ci.yml"}
+	res.Entities = []interfaces.EntityRef{pinned.EntityRef, unpinned.EntityRef}
+	require.Equal(t, &ListResult{
+		Processed: []string{"workflows/ci.yml"},
+		Entities:  []interfaces.EntityRef{unpinned.EntityRef},
+		Locations: []EntityLocation{unpinned},
+	}, res.WithoutPinned())
+}
+
+func TestReplacer_ParsePathInFS(t *testing.T) {
+	t.Parallel()
+
+	r := &Replacer{parser: actions.New(), cfg: config.Config{}}
+	fs := memfs.New()
+	tests := []struct {
+		name    string
+		base    string
+		wantErr bool
+	}{
+		{name: "valid base", base: "some-base", wantErr: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := r.ParsePathInFS(context.Background(), fs, tt.base)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestReplacer_RelativePath runs from the directory of the tree, so it can't be parallel
+func TestReplacer_RelativePath(t *testing.T) {
+	host, digests := newTestRegistry(t, "app:1.0")
+	digest := digests["app:1.0"]
+
+	dir := t.TempDir()
+	files := map[string]string{
+		".github/workflows/ci.yml": "jobs:\n  test:\n    container: " + host + "/app:1.0\n",
+		"deploy/k8s/pod.yaml":      "spec:\n  containers:\n    - image: " + host + "/app:1.0\n",
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0750))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0600))
+	}
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { require.NoError(t, os.Chdir(wd)) })
+
+	base := filepath.Base(dir)
+	r := NewContainerImagesReplacer(config.DefaultConfig()).WithFailOnUnresolved()
+	list, err := r.ListPath(".")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{base + "/.github/workflows/ci.yml", base + "/deploy/k8s/pod.yaml"}, list.Processed)
+
+	res, err := r.ParsePath(context.Background(), ".")
+	require.NoError(t, err)
+	pinned := host + "/app@" + digest + " # 1.0"
+	require.Equal(t, map[string]string{
+		base + "/.github/workflows/ci.yml": strings.Replace(files[".github/workflows/ci.yml"], host+"/app:1.0", pinned, 1),
+		base + "/deploy/k8s/pod.yaml":      strings.Replace(files["deploy/k8s/pod.yaml"], host+"/app:1.0", pinned, 1),
+	}, res.Modified)
+
+	// A relative directory under the current one
+	res, err = r.ParsePath(context.Background(), "deploy")
+	require.NoError(t, err)
+	require.Equal(t, []string{"deploy/k8s/pod.yaml"}, res.Processed)
+}
+
+func TestReplacer_LinkedPath(t *testing.T) {
+	t.Parallel()
+
+	// The workflows are shared through a link pointing out of its parent directory
+	dir := t.TempDir()
+	content := "jobs:\n  build:\n    steps:\n      - uses: actions/checkout@v4\n"
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "ci", "workflows"), 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ci", "workflows", "ci.yml"), []byte(content), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "repo", ".github"), 0750))
+	require.NoError(t, os.Symlink("../../ci/workflows", filepath.Join(dir, "repo", ".github", "workflows")))
+
+	r := NewGitHubActionsReplacer(&config.Config{})
+	list, err := r.ListPath(filepath.Join(dir, "repo", ".github", "workflows"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"workflows/ci.yml"}, list.Processed)
+	require.Len(t, list.Entities, 1)
+	require.Equal(t, "actions/checkout", list.Entities[0].Name)
+}
+
+func TestReplacer_ResolveMediaType(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:1.0")
+	r := NewContainerImagesReplacer(config.DefaultConfig())
+
+	// As listed, by tag or by digest
+	for _, ref := range []string{"1.0", digests["app:1.0"]} {
+		listed := interfaces.EntityRef{Name: host + "/app", Ref: ref, Type: image.ReferenceType}
+		mediaType, err := r.ResolveMediaType(context.Background(), listed)
+		require.NoError(t, err)
+		require.Equal(t, string(types.DockerManifestSchema2), mediaType)
+	}
+
+	listed := interfaces.EntityRef{Name: host + "/app", Ref: "1.0", Type: image.ReferenceType}
+	_, err := NewGitHubActionsReplacer(config.DefaultConfig()).ResolveMediaType(context.Background(), listed)
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+}
+
+func TestReplacer_ListPathInFS(t *testing.T) {
+	t.Parallel()
+
+	r := &Replacer{parser: actions.New(), cfg: config.Config{}}
+	fs := memfs.New()
+	tests := []struct {
+		name    string
+		base    string
+		wantErr bool
+	}{
+		{name: "valid base", base: "some-base", wantErr: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := r.ListPathInFS(fs, tt.base)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestReplacer_ListPathInFSFunc(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	files := map[string]string{
+		"base/build.yml": `jobs:
+  build:
+    steps:
+      - uses: actions/checkout@v4
+      - uses: actions/setup-go@v5
+`,
+		"base/lint.yml": `jobs:
+  lint:
+    steps:
+      - uses: actions/checkout@v4
+`,
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	r := NewGitHubActionsReplacer(&config.Config{})
+
+	var streamed []interfaces.EntityRef
+	err := r.ListPathInFSFunc(fs, "base", func(e interfaces.EntityRef) error {
+		streamed = append(streamed, e)
+		return nil
+	})
+	require.NoError(t, err)
+
+	listed, err := r.ListPathInFS(fs, "base")
+	require.NoError(t, err)
+	require.ElementsMatch(t, listed.Entities, streamed, "every distinct entity is streamed once")
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = r.ListPathInFSFunc(fs, "base", func(interfaces.EntityRef) error {
+		calls++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, calls, "the callback isn't called again once it failed")
+}
+
+func TestReplacer_IncludeExtensions(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	files := map[string]string{
+		"base/templates/deployment.yaml.tpl": "image: nginx:1.25\n",
+		"base/helmfile.yaml.gotmpl":          "image: redis:7\n",
+		"base/compose.yaml.tmpl":             "image: postgres:16\n",
+		"base/notes.txt":                     "image: busybox:1.36\n",
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	res, err := NewContainerImagesReplacer(config.DefaultConfig()).ListPathInFS(fs, "base")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"base/templates/deployment.yaml.tpl", "base/helmfile.yaml.gotmpl"}, res.Processed)
+
+	cfg := config.DefaultConfig()
+	cfg.IncludeExtensions = []string{".yaml.tmpl"}
+	res, err = NewContainerImagesReplacer(cfg).ListPathInFS(fs, "base")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		"base/templates/deployment.yaml.tpl",
+		"base/helmfile.yaml.gotmpl",
+		"base/compose.yaml.tmpl",
+	}, res.Processed)
+	require.Len(t, res.Entities, 3)
+}
+
+func TestReplacer_RespectGitignore(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	files := map[string]string{
+		"repo/.gitignore":                      "testdata/\n",
+		"repo/.github/workflows/ci.yml":        "      - uses: actions/checkout@v4\n",
+		"repo/testdata/workflows/ci.yml":       "      - uses: actions/setup-go@v5\n",
+		"repo/vendor/example.com/mod/ci.yml":   "      - uses: actions/cache@v4\n",
+		"repo/node_modules/pkg/.github/ci.yml": "      - uses: actions/setup-node@v4\n",
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	res, err := NewGitHubActionsReplacer(&config.Config{}).ListPathInFS(fs, "repo")
+	require.NoError(t, err)
+	require.Len(t, res.Entities, 4)
+
+	res, err = NewGitHubActionsReplacer(&config.Config{RespectGitignore: true}).ListPathInFS(fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"repo/.github/workflows/ci.yml"}, res.Processed)
+	require.Len(t, res.Entities, 1)
+	require.Equal(t, "actions/checkout", res.Entities[0].Name)
+}
+
+func TestReplacer_MaxDepth(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	files := map[string]string{
+		"repo/compose.yaml":                  "    image: nginx:1.25\n",
+		"repo/deploy/app.yaml":               "    image: redis:7\n",
+		"repo/deploy/charts/app/values.yaml": "    image: postgres:16\n",
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	res, err := NewContainerImagesReplacer(&config.Config{}).ListPathInFS(fs, "repo")
+	require.NoError(t, err)
+	require.Len(t, res.Entities, 3)
+
+	res, err = NewContainerImagesReplacer(&config.Config{MaxDepth: 2}).ListPathInFS(fs, "repo")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"repo/compose.yaml", "repo/deploy/app.yaml"}, res.Processed)
+	require.Len(t, res.Entities, 2)
+}
+
+func TestReplacer_ExcludePaths(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	files := map[string]string{
+		"repo/.github/workflows/ci.yml":          "      - uses: actions/checkout@v4\n",
+		"repo/testdata/ci.yml":                   "      - uses: actions/setup-go@v5\n",
+		"repo/testdata/nested/ci.yml":            "      - uses: actions/cache@v4\n",
+		"repo/pkg/fixtures/testdata/release.yml": "      - uses: actions/setup-node@v4\n",
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	r := NewGitHubActionsReplacer(&config.Config{ExcludePaths: []string{"testdata/**"}})
+
+	listed, err := r.ListPathInFS(fs, "repo")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		"repo/.github/workflows/ci.yml",
+		"repo/pkg/fixtures/testdata/release.yml",
+	}, listed.Processed)
+	require.Len(t, listed.Entities, 2)
+
+	// Excluded files aren't parsed either, so nothing is resolved here
+	r = NewGitHubActionsReplacer(&config.Config{ExcludePaths: []string{"**/testdata", ".github/**"}})
+	parsed, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Empty(t, parsed.Processed)
+	require.Empty(t, parsed.Modified)
+}
+
+func TestReplacer_SkipDocker(t *testing.T) {
+	t.Parallel()
+
+	// The image can't be resolved, so it'd fail unless skipped
+	const workflow = `jobs:
+  build:
+    steps:
+      - uses: docker://127.0.0.1:1/dev/builder:latest
+`
+	fs := memfs.New()
+	f, err := fs.Create("repo/.github/workflows/ci.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(workflow))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	r := NewGitHubActionsReplacer(&config.Config{GHActions: config.GHActions{SkipDocker: true}}).
+		WithFailOnUnresolved()
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Empty(t, res.Modified)
+
+	// The step is resolved, and fails, without the option
+	r = NewGitHubActionsReplacer(&config.Config{}).WithFailOnUnresolved()
+	_, err = r.ParsePathInFS(context.Background(), fs, "repo")
+	require.Error(t, err)
+}
+
+func TestReplacer_GitLabNameKeys(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "ruby:3.3", "postgres:16", "redis:7")
+
+	// The name keys of the image mapping and the services list are images, while the
+	// names of the workflow steps and jobs merely contain a colon
+	workflow := `on: push
+jobs:
+  lint:
+    name: lint:go
+    runs-on: ubuntu-latest
+    steps:
+      - name: lint:go
+        run: make lint
+      - uses: actions/checkout@v4
+        name: checkout:sources
+`
+	gitlab := `test:
+  image:
+    name: %[1]s/ruby:3.3
+    entrypoint: [""]
+  services:
+  - name: %[1]s/postgres:16
+    alias: db
+  - alias: cache
+    name: %[1]s/redis:7
+  variables:
+    name: not:an-image
+`
+	newFS := func(host string) billy.Filesystem {
+		fs := memfs.New()
+		for path, content := range map[string]string{
+			"repo/.github/workflows/lint.yml": workflow,
+			"repo/.gitlab-ci.yml":             fmt.Sprintf(gitlab, host),
+		} {
+			f, err := fs.Create(path)
+			require.NoError(t, err)
+			_, err = f.Write([]byte(content))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+		}
+		return fs
+	}
+
+	r := NewContainerImagesReplacer(config.DefaultConfig()).WithFailOnUnresolved()
+	// The port of the local registry can't be told apart from a tag when listing
+	list, err := r.ListPathInFS(newFS("registry.example.com"), "repo")
+	require.NoError(t, err)
+	var names []string
+	for _, e := range list.Entities {
+		names = append(names, e.Name+":"+e.Ref)
+	}
+	require.ElementsMatch(t, []string{
+		"registry.example.com/ruby:3.3", "registry.example.com/postgres:16", "registry.example.com/redis:7",
+	}, names)
+
+	res, err := r.ParsePathInFS(context.Background(), newFS(host), "repo")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"repo/.gitlab-ci.yml": strings.NewReplacer(
+			host+"/ruby:3.3\n", host+"/ruby@"+digests["ruby:3.3"]+" # 3.3\n",
+			host+"/postgres:16\n", host+"/postgres@"+digests["postgres:16"]+" # 16\n",
+			host+"/redis:7\n", host+"/redis@"+digests["redis:7"]+" # 7\n",
+		).Replace(fmt.Sprintf(gitlab, host)),
+	}, res.Modified)
+
+	// Unpinning leaves the name keys elsewhere untouched as well, even if pinned-looking
+	other := "cache:\n  name: " + host + "/redis@" + digests["redis:7"] + " # 7\n"
+	modified, unpinned, err := r.UnpinFile(context.Background(), strings.NewReader(res.Modified["repo/.gitlab-ci.yml"]+other))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, fmt.Sprintf(gitlab, host)+other, unpinned)
+}
+
+func TestReplacer_AzurePipelines(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "sdk:8.0", "redis:7")
+
+	// The container key of the job references an image, while the one of the
+	// resources is the alias the other jobs reference it by. A variable named
+	// after a container isn't a container key.
+	pipeline := fmt.Sprintf(`trigger:
+  - main
+
+variables:
+  test-container: %[1]s/sdk:8.0
+
+resources:
+  containers:
+    - container: cache
+      image: %[1]s/redis:7
+
+jobs:
+  - job: Build
+    pool:
+      vmImage: ubuntu-latest
+    container: %[1]s/sdk:8.0
+    services:
+      redis: cache
+    steps:
+      - script: dotnet build
+  - job: Test
+    container: cache
+    steps:
+      - script: dotnet test
+`, host)
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/azure-pipelines.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(pipeline))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	r := NewContainerImagesReplacer(config.DefaultConfig()).WithFailOnUnresolved()
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, strings.NewReplacer(
+		"image: "+host+"/redis:7\n", "image: "+host+"/redis@"+digests["redis:7"]+" # 7\n",
+		"    container: "+host+"/sdk:8.0\n", "    container: "+host+"/sdk@"+digests["sdk:8.0"]+" # 8.0\n",
+	).Replace(pipeline), res.Modified["repo/azure-pipelines.yml"])
+}
+
+func TestReplacer_Drone(t *testing.T) {
+	t.Parallel()
+
+	tags := []string{
+		"golang:1.22", "plugins/docker:20", "postgres:16", "drone/git:1", "plugins/git:next", "plugins/slack:1",
+		"aquasec/trivy:0.50",
+	}
+	host, digests := newTestRegistry(t, tags...)
+
+	// The pinned form of each image pushed to the registry
+	var pinned []string
+	for _, tag := range tags {
+		repo, version, _ := strings.Cut(tag, ":")
+		pinned = append(pinned, "image: "+host+"/"+tag+"\n", "image: "+host+"/"+repo+"@"+digests[tag]+" # "+version+"\n")
+	}
+	// The image key of a plugin setting given by the configuration
+	pinned = append(pinned, "target: "+host+"/postgres:16\n", "target: "+host+"/postgres@"+digests["postgres:16"]+" # 16\n")
+
+	fs := memfs.New()
+	want := map[string]string{}
+	for _, fixture := range []string{"drone.yml", "drone-legacy.yml"} {
+		content, err := os.ReadFile(filepath.Join("image", "testdata", fixture))
+		require.NoError(t, err)
+		pipeline := strings.ReplaceAll(string(content), "REGISTRY", host)
+		want["repo/"+fixture] = strings.NewReplacer(pinned...).Replace(pipeline)
+
+		f, err := fs.Create("repo/" + fixture)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(pipeline))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// The steps, services and clone images are pinned, along with the plugin settings
+	// under an image key, while the other settings, the when and trigger blocks and the
+	// images interpolating matrix variables are untouched
+	cfg := config.DefaultConfig()
+	cfg.Images.ImageKeys = []string{"target"}
+	r := NewContainerImagesReplacer(cfg).WithFailOnUnresolved()
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, want, res.Modified)
+}
+
+func TestReplacer_BitbucketPipelines(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "node:20", "redis:7", "golang:1.22", "atlassian/aws-s3-deploy:1.1.0", "acme/notify-pipe:2.0")
+
+	content, err := os.ReadFile(filepath.Join("image", "testdata", "bitbucket-pipelines.yml"))
+	require.NoError(t, err)
+	pipeline := strings.ReplaceAll(string(content), "REGISTRY", host)
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/bitbucket-pipelines.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(pipeline))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The pipes are pinned like the build and service images, keeping their docker:// transport
+	r := NewContainerImagesReplacer(config.DefaultConfig()).WithFailOnUnresolved()
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, strings.NewReplacer(
+		"image: "+host+"/node:20\n", "image: "+host+"/node@"+digests["node:20"]+" # 20\n",
+		"image: "+host+"/redis:7\n", "image: "+host+"/redis@"+digests["redis:7"]+" # 7\n",
+		"name: "+host+"/golang:1.22\n", "name: "+host+"/golang@"+digests["golang:1.22"]+" # 1.22\n",
+		"pipe: "+host+"/atlassian/aws-s3-deploy:1.1.0\n",
+		"pipe: "+host+"/atlassian/aws-s3-deploy@"+digests["atlassian/aws-s3-deploy:1.1.0"]+" # 1.1.0\n",
+		"pipe: docker://"+host+"/acme/notify-pipe:2.0\n",
+		"pipe: docker://"+host+"/acme/notify-pipe@"+digests["acme/notify-pipe:2.0"]+" # 2.0\n",
+	).Replace(pipeline), res.Modified["repo/bitbucket-pipelines.yml"])
+}
+
+func TestReplacer_JSONManifests(t *testing.T) {
+	t.Parallel()
+
+	tags := []string{"postgres:16", "nginx:1.25.3", "redis:7.2"}
+	host, digests := newTestRegistry(t, tags...)
+
+	var pinned []string
+	for _, tag := range tags {
+		pinned = append(pinned, `"image": "`+host+"/"+tag+`"`, `"image": "`+host+"/"+tag+"@"+digests[tag]+`"`)
+	}
+
+	content, err := os.ReadFile(filepath.Join("image", "testdata", "pod.json"))
+	require.NoError(t, err)
+	pod := strings.ReplaceAll(string(content), "REGISTRY", host)
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/pod.json")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(pod))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// JSON files aren't processed by default
+	r := NewContainerImagesReplacer(config.DefaultConfig())
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Empty(t, res.Processed)
+
+	// The container images are pinned while the image label and the command are untouched
+	cfg := config.DefaultConfig()
+	cfg.Images.JSONManifests = true
+	r = NewContainerImagesReplacer(cfg).WithFailOnUnresolved()
+	res, err = r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"repo/pod.json": strings.NewReplacer(pinned...).Replace(pod)}, res.Modified)
+
+	// The image failing to resolve is reported and fails the run if asked to
+	f, err = fs.Create("repo/missing.json")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(`{"containers": [{"name": "app", "image": "` + host + `/missing:1.0"}]}`))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = r.ParsePathInFS(context.Background(), fs, "repo")
+	require.Error(t, err)
+	res, err = NewContainerImagesReplacer(cfg).ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Len(t, res.Errors, 1)
+	require.Equal(t, "repo/missing.json", res.Errors[0].Path)
+	require.Equal(t, 1, res.Errors[0].Line)
+	require.Equal(t, host+"/missing:1.0", res.Errors[0].Reference)
+}
+
+func TestReplacer_NixImages(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "nginx:1.25")
+	digest := digests["nginx:1.25"]
+
+	nix := `{ dockerTools, lib }:
+dockerTools.pullImage {
+  imageName = "` + host + `/nginx";
+  imageDigest = "";
+  finalImageTag = "1.25";
+  sha256 = lib.fakeHash;
+}
+`
+	fs := memfs.New()
+	f, err := fs.Create("repo/nix/nginx.nix")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(nix))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Nix files aren't processed by default
+	r := NewContainerImagesReplacer(config.DefaultConfig())
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Empty(t, res.Processed)
+
+	cfg := config.DefaultConfig()
+	cfg.Images.NixImages = true
+	res, err = NewContainerImagesReplacer(cfg).ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"repo/nix/nginx.nix": strings.Replace(nix, `imageDigest = ""`, `imageDigest = "`+digest+`"`, 1),
+	}, res.Modified)
+
+	// The image failing to resolve is reported on the line of its name
+	f, err = fs.Create("repo/nix/missing.nix")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(strings.ReplaceAll(nix, "/nginx", "/missing")))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	res, err = NewContainerImagesReplacer(cfg).ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Len(t, res.Errors, 1)
+	require.Equal(t, "repo/nix/missing.nix", res.Errors[0].Path)
+	require.Equal(t, 3, res.Errors[0].Line)
+	require.Equal(t, host+"/missing:1.25", res.Errors[0].Reference)
+}
+
+func TestReplacer_Quadlet(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "nginx:1.25")
+	digest := digests["nginx:1.25"]
+
+	content, err := os.ReadFile(filepath.Join("image", "testdata", "web.container"))
+	require.NoError(t, err)
+	unit := strings.ReplaceAll(string(content), "REGISTRY", host)
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/web.container")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(unit))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Only the Image key is pinned, keeping the tag as units don't support trailing comments,
+	// while the other keys, including the environment variable, are untouched
+	r := NewContainerImagesReplacer(config.DefaultConfig()).WithFailOnUnresolved()
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"repo/web.container": strings.Replace(unit,
+			"\nImage="+host+"/nginx:1.25\n", "\nImage="+host+"/nginx:1.25@"+digest+"\n", 1),
+	}, res.Modified)
+}
+
+func TestReplacer_ComposeBuildArgs(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "node:18", "golang:1.22", "distroless/static:nonroot", "postgres:16")
+
+	content, err := os.ReadFile(filepath.Join("image", "testdata", "compose-build-args.yml"))
+	require.NoError(t, err)
+	compose := strings.ReplaceAll(string(content), "REGISTRY", host)
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/compose.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(compose))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Only the image key is pinned by default
+	r := NewContainerImagesReplacer(config.DefaultConfig())
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	pinnedDB := strings.Replace(compose, "image: "+host+"/postgres:16",
+		"image: "+host+"/postgres@"+digests["postgres:16"]+" # 16", 1)
+	require.Equal(t, map[string]string{"repo/compose.yml": pinnedDB}, res.Modified)
+
+	// The configured build args are pinned in both the mapping and the list form, while the
+	// other args and the environment variables are untouched
+	cfg := config.DefaultConfig()
+	cfg.Images.BuildArgKeys = []string{"BASE_IMAGE", "BUILDER_IMAGE", "RUNTIME_IMAGE"}
+	r = NewContainerImagesReplacer(cfg).WithFailOnUnresolved()
+	res, err = r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"repo/compose.yml": strings.NewReplacer(
+		"BASE_IMAGE: "+host+"/node:18\n", "BASE_IMAGE: "+host+"/node@"+digests["node:18"]+" # 18\n",
+		"- BUILDER_IMAGE="+host+"/golang:1.22\n", "- BUILDER_IMAGE="+host+"/golang@"+digests["golang:1.22"]+" # 1.22\n",
+		"- RUNTIME_IMAGE="+host+"/distroless/static:nonroot\n",
+		"- RUNTIME_IMAGE="+host+"/distroless/static@"+digests["distroless/static:nonroot"]+" # nonroot\n",
+	).Replace(pinnedDB)}, res.Modified)
+}
+
+func TestReplacer_WithLogger(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1")
+	digest := digests["app:v1"]
+
+	fs := memfs.New()
+	f, err := fs.Create("base/compose.yaml")
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(f, `services:
+  app:
+    image: %[1]s/app:v1
+  worker:
+    image: %[1]s/app:v1
+  dev:
+    image: %[1]s/app:latest
+  missing:
+    image: %[1]s/missing:v1
+`, host)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	r := NewContainerImagesReplacer(config.DefaultConfig()).WithLogger(logger)
+	_, err = r.ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+
+	logs := buf.String()
+	appRef := host + "/app:v1"
+	require.Contains(t, logs, `msg="cache miss" reference=`+appRef)
+	require.Contains(t, logs, `msg="cache hit" reference=`+appRef, "the repeated reference is served from the cache")
+	require.Contains(t, logs, fmt.Sprintf(`msg="resolved reference" file=base/compose.yaml line=3 reference="image: %s" ref=%s tag=v1`,
+		appRef, digest))
+	require.Contains(t, logs, `msg="skipped reference" file=base/compose.yaml line=7`)
+	require.Contains(t, logs, "reason=excluded-tag")
+	require.Contains(t, logs, `msg="failed to resolve reference" file=base/compose.yaml line=9`)
+}
+
+func TestReplacer_WithOnReplace(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1")
+	digest := digests["app:v1"]
+
+	fs := memfs.New()
+	f, err := fs.Create("base/compose.yaml")
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(f, "services:\n  app:\n    image: %[1]s/app:v1\n  dev:\n    image: %[1]s/app:latest\n", host)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	// The Helm chart values are pinned across the whole document
+	f, err = fs.Create("base/values.yaml")
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(f, "image:\n  repository: %s/app\n  tag: v1\n", host)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	type call struct {
+		ref    *interfaces.EntityRef
+		before string
+		after  string
+		file   string
+		line   int
+	}
+	var calls []call
+	r := NewContainerImagesReplacer(config.DefaultConfig()).WithMaxConcurrency(1).WithOnReplace(
+		func(ref *interfaces.EntityRef, before, after string, file string, line int) {
+			calls = append(calls, call{ref, before, after, file, line})
+		})
+	_, err = r.ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+
+	require.Equal(t, []call{
+		{
+			ref: &interfaces.EntityRef{
+				Name:      host + "/app",
+				Ref:       digest,
+				Type:      image.ReferenceType,
+				Tag:       "v1",
+				Prefix:    "image: ",
+				MediaType: string(types.DockerManifestSchema2),
+			},
+			before: "image: " + host + "/app:v1",
+			after:  "image: " + host + "/app@" + digest + " # v1",
+			file:   "base/compose.yaml",
+			line:   3,
+		},
+		{
+			before: "image: " + host + "/app:latest",
+			after:  string(interfaces.SkipExcludedTag),
+			file:   "base/compose.yaml",
+			line:   5,
+		},
+		{
+			ref: &interfaces.EntityRef{
+				Name:      host + "/app",
+				Ref:       digest,
+				Type:      image.ReferenceType,
+				Tag:       "v1",
+				MediaType: string(types.DockerManifestSchema2),
+			},
+			before: host + "/app:v1",
+			after:  host + "/app:v1@" + digest,
+			file:   "base/values.yaml",
+			line:   3,
+		},
+	}, calls)
+}
+
+func TestReplacer_WithProgress(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	for i := 0; i < 20; i++ {
+		f, err := fs.Create(fmt.Sprintf("repo/.github/workflows/workflow-%d.yml", i))
+		require.NoError(t, err)
+		// References already pinned are skipped without reaching GitHub
+		_, err = f.Write([]byte("steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4\n"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	// Files the replacer doesn't process aren't counted
+	f, err := fs.Create("repo/README.md")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	type progress struct{ done, total int }
+	var calls []progress
+	r := NewGitHubActionsReplacer(config.DefaultConfig()).
+		WithMaxConcurrency(4).
+		WithProgress(func(done, total int) {
+			calls = append(calls, progress{done, total})
+		})
+
+	for _, run := range []func() (*ReplaceResult, error){
+		func() (*ReplaceResult, error) { return r.ParsePathInFS(context.Background(), fs, "repo") },
+		func() (*ReplaceResult, error) { return r.UnpinPathInFS(context.Background(), fs, "repo") },
+	} {
+		calls = nil
+		_, err := run()
+		require.NoError(t, err)
+
+		// Each file is reported once found and once processed, the counts never go back
+		require.Len(t, calls, 40)
+		for i, c := range calls {
+			require.LessOrEqual(t, c.done, c.total)
+			if i > 0 {
+				require.GreaterOrEqual(t, c.done, calls[i-1].done)
+				require.GreaterOrEqual(t, c.total, calls[i-1].total)
+			}
+		}
+		require.Equal(t, progress{20, 20}, calls[len(calls)-1])
+	}
+}
+
+func TestReplacer_VerboseCLI(t *testing.T) {
+	t.Parallel()
+
+	host, _ := newTestRegistry(t, "app:v1")
+
+	cmd := &cobra.Command{}
+	cli.DeclareFrizbeeFlags(cmd, false)
+	require.NoError(t, cmd.ParseFlags([]string{"--verbose"}))
+	stderr := &bytes.Buffer{}
+	cmd.SetErr(stderr)
+	helper, err := cli.NewHelper(cmd)
+	require.NoError(t, err)
+
+	r := NewContainerImagesReplacer(config.DefaultConfig()).WithLogger(helper.Logger())
+	content := fmt.Sprintf("services:\n  app:\n    image: %[1]s/app:v1\n  worker:\n    image: %[1]s/app:v1\n", host)
+	modified, _, err := r.ParseFile(context.Background(), strings.NewReader(content))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Contains(t, stderr.String(), `msg="cache miss" reference=`+host+"/app:v1")
+	require.Contains(t, stderr.String(), `msg="cache hit" reference=`+host+"/app:v1",
+		"the repeated reference is served from the cache")
+}
+
+func TestReplacer_FileStats(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1")
+	digest := digests["app:v1"]
+
+	fs := memfs.New()
+	files := map[string]string{
+		// Pinned, skipped as already pinned, skipped as excluded and failing to resolve
+		"base/compose.yaml": fmt.Sprintf(`services:
+  app:
+    image: %[1]s/app:v1
+  pinned:
+    image: %[1]s/app@%[2]s
+  dev:
+    image: %[1]s/app:latest
+  missing:
+    image: %[1]s/missing:v1
+`, host, digest),
+		"base/empty.yaml": "services: {}\n",
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	r := NewContainerImagesReplacer(config.DefaultConfig())
+	res, err := r.ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+	require.Equal(t, map[string]FileStats{
+		"base/compose.yaml": {Matched: 4, Modified: 1, Skipped: 2, Errored: 1},
+		"base/empty.yaml":   {},
+	}, res.Stats)
+	require.Equal(t, FileStats{Matched: 4, Modified: 1, Skipped: 2, Errored: 1}, res.Totals())
+	require.Len(t, res.Errors, 1)
+
+	// Write the pinned file back and unpin it
+	f, err := fs.Create("base/compose.yaml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(res.Modified["base/compose.yaml"]))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	res, err = r.UnpinPathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+	require.Equal(t, FileStats{Matched: 4, Modified: 1, Skipped: 3}, res.Stats["base/compose.yaml"])
+}
+
+func TestReplacer_SkippedReferences(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	files := map[string]string{
+		"base/compose.yaml": `services:
+  web:
+    image: nginx:latest
+  cache:
+    image: redis
+`,
+		"base/Dockerfile": "FROM scratch\nCOPY app /app\n",
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	r := NewContainerImagesReplacer(config.DefaultConfig())
+	res, err := r.ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+	require.Empty(t, res.Modified)
+	require.Equal(t, []interfaces.SkippedReference{
+		{Path: "base/Dockerfile", Line: 1, Reference: "FROM scratch", Reason: interfaces.SkipExcludedImage},
+		{Path: "base/compose.yaml", Line: 3, Reference: "image: nginx:latest", Reason: interfaces.SkipExcludedTag},
+		{Path: "base/compose.yaml", Line: 5, Reference: "image: redis", Reason: interfaces.SkipNoTag},
+	}, res.Skipped)
+
+	// Listing tells the same reasons without resolving anything
+	list, err := r.ListPathInFS(fs, "base")
+	require.NoError(t, err)
+	reasons := list.SkipReasons()
+	require.Equal(t, interfaces.SkipExcludedImage, reasons[interfaces.EntityRef{Name: "scratch", Ref: "latest", Type: image.ReferenceType}])
+	require.Equal(t, interfaces.SkipExcludedTag, reasons[interfaces.EntityRef{Name: "nginx", Ref: "latest", Type: image.ReferenceType}])
+	require.Equal(t, interfaces.SkipNoTag, reasons[interfaces.EntityRef{Name: "redis", Ref: "latest", Type: image.ReferenceType}])
+
+	// Excluded actions and branches are skipped with their reason too, the tags are looked
+	// up before the branches
+	gh := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(gh.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(gh.URL)
+	require.NoError(t, err)
+	f, err := fs.Create("base/.github/workflows/ci.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("steps:\n  - uses: actions/cache@v4\n  - uses: org/lint@main\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	cfg := config.DefaultConfig()
+	cfg.GHActions.Exclude = []string{"actions/cache"}
+	res, err = NewGitHubActionsReplacer(cfg).WithGitHubClient(client).ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+	require.Empty(t, res.Modified)
+	require.Equal(t, []interfaces.SkippedReference{
+		{Path: "base/.github/workflows/ci.yml", Line: 2, Reference: "uses: actions/cache@v4", Reason: interfaces.SkipExcludedAction},
+		{Path: "base/.github/workflows/ci.yml", Line: 3, Reference: "uses: org/lint@main", Reason: interfaces.SkipExcludedBranch},
+	}, res.Skipped)
+}
+
+func TestReplacer_DockerfileStages(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "golang:1.22", "distroless/static:nonroot")
+
+	content, err := os.ReadFile(filepath.Join("image", "testdata", "Dockerfile.multistage"))
+	require.NoError(t, err)
+	dockerfile := strings.ReplaceAll(string(content), "REGISTRY", host)
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/Dockerfile")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(dockerfile))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Nothing is excluded, FROM builder would be looked up as docker.io/library/builder
+	// and fail to resolve if it wasn't recognized as a stage
+	cfg := config.DefaultConfig()
+	cfg.Images.ExcludeTags = nil
+	r := NewContainerImagesReplacer(cfg).WithFailOnUnresolved()
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, strings.NewReplacer(
+		host+"/golang:1.22 ", host+"/golang:1.22@"+digests["golang:1.22"]+" ",
+		host+"/distroless/static:nonroot\n", host+"/distroless/static:nonroot@"+digests["distroless/static:nonroot"]+"\n",
+	).Replace(dockerfile), res.Modified["repo/Dockerfile"])
+	require.Equal(t, []interfaces.SkippedReference{
+		{Path: "repo/Dockerfile", Line: 7, Reference: "FROM builder", Reason: interfaces.SkipStage},
+	}, res.Skipped)
+
+	// The stage isn't listed as an image either
+	list, err := r.ListInFile(strings.NewReader("FROM golang:1.22 AS builder\nFROM builder\n"))
+	require.NoError(t, err)
+	require.Equal(t, []interfaces.EntityRef{{Name: "golang", Ref: "1.22", Type: image.ReferenceType}}, list.Entities)
+}
+
+func TestReplacer_DockerfileBuildArgs(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile(filepath.Join("image", "testdata", "Dockerfile.args"))
+	require.NoError(t, err)
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/Dockerfile")
+	require.NoError(t, err)
+	_, err = f.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The images interpolating build arguments are skipped rather than failing to resolve
+	r := NewContainerImagesReplacer(config.DefaultConfig()).WithFailOnUnresolved()
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Empty(t, res.Modified)
+	require.Equal(t, []interfaces.SkippedReference{
+		{Path: "repo/Dockerfile", Line: 5, Reference: "FROM $BASE_IMAGE", Reason: interfaces.SkipVariable},
+		{Path: "repo/Dockerfile", Line: 8, Reference: "FROM ${REGISTRY}/builder:${TAG}", Reason: interfaces.SkipVariable},
+		{Path: "repo/Dockerfile", Line: 12, Reference: "FROM --platform=$TARGETPLATFORM ${REGISTRY}/runtime:$TAG",
+			Reason: interfaces.SkipVariable},
+	}, res.Skipped)
+}
+
+func TestReplacer_EnvExpansion(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:1.2.3")
+	digest := digests["app:1.2.3"]
+
+	content := "services:\n" +
+		"  app:\n    image: ${REGISTRY}/app:$TAG\n" +
+		"  other:\n    image: ${UNSET_REGISTRY}/app:1.2.3\n"
+	env := map[string]string{"REGISTRY": host, "TAG": "1.2.3"}
+
+	testCases := []struct {
+		name            string
+		env             map[string]string
+		expectedContent string
+		expectedSkipped []interfaces.SkippedReference
+	}{
+		{
+			name: "set variables are expanded",
+			env:  env,
+			expectedContent: fmt.Sprintf("services:\n"+
+				"  app:\n    image: %s/app@%s # 1.2.3\n"+
+				"  other:\n    image: ${UNSET_REGISTRY}/app:1.2.3\n", host, digest),
+			expectedSkipped: []interfaces.SkippedReference{
+				{Path: "compose.yml", Line: 5, Reference: "image: ${UNSET_REGISTRY}/app:1.2.3", Reason: interfaces.SkipVariable},
+			},
+		},
+		{
+			name:            "unset variables are left as is",
+			env:             map[string]string{},
+			expectedContent: content,
+			expectedSkipped: []interfaces.SkippedReference{
+				{Path: "compose.yml", Line: 3, Reference: "image: ${REGISTRY}/app:$TAG", Reason: interfaces.SkipVariable},
+				{Path: "compose.yml", Line: 5, Reference: "image: ${UNSET_REGISTRY}/app:1.2.3", Reason: interfaces.SkipVariable},
+			},
+		},
+	}
+	for _, tt := range testCases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := memfs.New()
+			f, err := fs.Create("compose.yml")
+			require.NoError(t, err)
+			_, err = f.Write([]byte(content))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			r := NewContainerImagesReplacer(config.DefaultConfig()).WithEnvExpansion(tt.env).WithFailOnUnresolved()
+			res, err := r.ParsePathInFS(context.Background(), fs, ".")
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedSkipped, res.Skipped)
+			if tt.expectedContent == content {
+				require.Empty(t, res.Modified)
+			} else {
+				require.Equal(t, tt.expectedContent, res.Modified["compose.yml"])
+			}
+		})
+	}
+}
+
+func TestReplacer_Changes(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1")
+	digest := digests["app:v1"]
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/compose.yml")
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(f, "services:\n  app:\n    image: %s/app:v1\n  db:\n    image: %s/app:latest\n", host, host)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	r := NewContainerImagesReplacer(config.DefaultConfig())
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	pinned := fmt.Sprintf("image: %s/app@%s # v1", host, digest)
+	require.Equal(t, []interfaces.ReferenceChange{
+		{Path: "repo/compose.yml", Line: 3, Before: "image: " + host + "/app:v1", After: pinned, Type: image.ReferenceType},
+	}, res.Changes)
+
+	// Unpinning reports the reverse change
+	f, err = fs.Create("repo/compose.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(res.Modified["repo/compose.yml"]))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	res, err = r.UnpinPathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, []interfaces.ReferenceChange{
+		{Path: "repo/compose.yml", Line: 3, Before: fmt.Sprintf("image: %s/app@%s", host, digest),
+			After: "image: " + host + "/app:v1", Type: image.ReferenceType},
+	}, res.Changes)
+}
+
+func TestReplacer_ConfigDiscovery(t *testing.T) {
+	t.Parallel()
+
+	host, digests := newTestRegistry(t, "app:v1")
+	digest := digests["app:v1"]
+
+	compose := fmt.Sprintf("services:\n  app:\n    image: %s/app:v1\n", host)
+	pinned := fmt.Sprintf("services:\n  app:\n    image: %s/app@%s # v1\n", host, digest)
+
+	fs := memfs.New()
+	files := map[string]string{
+		"base/compose.yml":               compose,
+		"base/legacy/.frizbee.yml":       "images:\n  exclude_tags:\n    - v1\n",
+		"base/legacy/compose.yml":        compose,
+		"base/legacy/nested/compose.yml": compose,
+		"base/other/.frizbee.yml":        "images:\n  exclude_images:\n    - unrelated\n",
+		"base/other/compose.yml":         compose,
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// Without discovery, the replacer's configuration applies to every file
+	res, err := NewContainerImagesReplacer(config.DefaultConfig()).ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+	require.Len(t, res.Modified, 4)
+
+	// The configuration of the legacy directory excludes the tag for the files under it
+	res, err = NewContainerImagesReplacer(config.DefaultConfig()).
+		WithConfigDiscovery(".frizbee.yml").
+		ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"base/compose.yml":       pinned,
+		"base/other/compose.yml": pinned,
+	}, res.Modified)
+	require.Equal(t, []interfaces.SkippedReference{
+		{Path: "base/legacy/compose.yml", Line: 3, Reference: "image: " + host + "/app:v1", Reason: interfaces.SkipExcludedTag},
+		{Path: "base/legacy/nested/compose.yml", Line: 3, Reference: "image: " + host + "/app:v1", Reason: interfaces.SkipExcludedTag},
+	}, res.Skipped)
+
+	// The discovered configuration files refine the replacer's one rather than replacing it
+	cfg := config.DefaultConfig()
+	cfg.Images.ExcludeTags = []string{"v1"}
+	res, err = NewContainerImagesReplacer(cfg).
+		WithConfigDiscovery(".frizbee.yml").
+		ParsePathInFS(context.Background(), fs, "base")
+	require.NoError(t, err)
+	require.Empty(t, res.Modified)
+	require.Len(t, res.Skipped, 4)
+
+	// An invalid configuration file fails the run rather than being ignored
+	f, err := fs.Create("base/other/.frizbee.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("images: [\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = NewContainerImagesReplacer(config.DefaultConfig()).
+		WithConfigDiscovery(".frizbee.yml").
+		ParsePathInFS(context.Background(), fs, "base")
+	require.ErrorContains(t, err, "failed to read config file base/other/.frizbee.yml")
+}
+
+func TestReplacer_ListLocations(t *testing.T) {
+	t.Parallel()
+
+	checkout := interfaces.EntityRef{Name: "actions/checkout", Ref: "v4", Type: actions.ReferenceType}
+	setupGo := interfaces.EntityRef{Name: "actions/setup-go", Ref: "v5", Type: actions.ReferenceType}
+	lint := interfaces.EntityRef{Name: "golangci/golangci-lint-action", Ref: "v6", Type: actions.ReferenceType}
+
+	fs := memfs.New()
+	files := map[string]string{
+		"base/build.yml": `name: Build
+on: push
+jobs:
+  build:
+    steps:
+      - uses: actions/checkout@v4
+      # - uses: actions/cache@v4
+      - uses: actions/setup-go@v5
+  test:
+    steps:
+      - uses: actions/checkout@v4
+`,
+		"base/lint.yml": `name: Lint
+on: push
+jobs:
+  lint:
+    steps:
+      - uses: actions/checkout@v4
+      - uses: golangci/golangci-lint-action@v6
+`,
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	r := NewGitHubActionsReplacer(config.DefaultConfig())
+
+	t.Run("path", func(t *testing.T) {
+		t.Parallel()
+
+		res, err := r.ListPathInFS(fs, "base")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []interfaces.EntityRef{checkout, setupGo, lint}, res.Entities)
+		require.Equal(t, []EntityLocation{
+			{EntityRef: checkout, Path: "base/build.yml", Line: 6},
+			{EntityRef: setupGo, Path: "base/build.yml", Line: 8},
+			{EntityRef: checkout, Path: "base/build.yml", Line: 11},
+			{EntityRef: checkout, Path: "base/lint.yml", Line: 6},
+			{EntityRef: lint, Path: "base/lint.yml", Line: 7},
+		}, res.Locations)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		t.Parallel()
+
+		res, err := r.ListInFile(strings.NewReader(files["base/build.yml"]))
+		require.NoError(t, err)
+		require.ElementsMatch(t, []interfaces.EntityRef{checkout, setupGo}, res.Entities)
+		require.Equal(t, []EntityLocation{
+			{EntityRef: checkout, Line: 6},
+			{EntityRef: setupGo, Line: 8},
+			{EntityRef: checkout, Line: 11},
+		}, res.Locations)
+	})
+
+	t.Run("dockerfile", func(t *testing.T) {
+		t.Parallel()
+
+		dockerfile := `FROM golang:1.23 AS builder
+WORKDIR /src
+RUN go build -o /app .
+
+FROM alpine:3.20
+COPY --from=builder /app /app
+`
+		res, err := NewContainerImagesReplacer(config.DefaultConfig()).ListInFile(strings.NewReader(dockerfile))
+		require.NoError(t, err)
+		require.Equal(t, []EntityLocation{
+			{EntityRef: interfaces.EntityRef{Name: "golang", Ref: "1.23", Type: image.ReferenceType}, Line: 1},
+			{EntityRef: interfaces.EntityRef{Name: "alpine", Ref: "3.20", Type: image.ReferenceType}, Line: 5},
+		}, res.Locations)
+	})
+}
+
+func TestReplacer_ListContainerImagesInFile(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		before         string
+		expected       *ListResult
+		regex          string
+		wantErr        bool
+		useCustomRegex bool
+	}{
+		{
+			name: "Lust image reference",
+			before: `
+version: v1
+services:
+ - name: kube-apiserver
+   image: registry.k8s.io/kube-apiserver:v1.20.0
+ - name: kube-controller-manager
+   image: registry.k8s.io/kube-controller-manager:v1.15.0
+ - name: minder-app
+   image: minder:latest
+`,
+			expected: &ListResult{
+				Entities: []interfaces.EntityRef{
+					{
+						Name: "registry.k8s.io/kube-apiserver",
+						Ref:  "v1.20.0",
+						Type: image.ReferenceType,
+					},
+					{
+						Name: "registry.k8s.io/kube-controller-manager",
+						Ref:  "v1.15.0",
+						Type: image.ReferenceType,
+					},
+					{
+						Name: "minder",
+						Ref:  "latest",
+						Type: image.ReferenceType,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "No image reference modification",
+			before: `
+		version: v1
+		services:
+		- name: minder-app
+		  # image: minder:latest
+		`,
+			expected: &ListResult{
+				Entities: []interfaces.EntityRef{},
+			},
+			wantErr: false,
+		},
+		{
 			name: "Invalid image reference format",
 			before: `
 		version: v1
@@ -1462,3 +4320,895 @@ jobs:
 		})
 	}
 }
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantErr    error
+		parserType interfaces.Parser
+		regex      string
+	}{
+		{
+			name:    "no parser",
+			opts:    []Option{WithConfig(&config.Config{})},
+			wantErr: ErrNoParser,
+		},
+		{
+			name:       "actions parser",
+			opts:       []Option{WithActionsParser(), WithConfig(&config.Config{})},
+			parserType: actions.New(),
+			regex:      actions.New().GetRegex(),
+		},
+		{
+			name:       "image parser with options",
+			opts:       []Option{WithImageParser(), WithGitHubToken("token"), WithRegex(`^test-regex$`), WithCacheDisabled()},
+			parserType: image.New(),
+			regex:      `^test-regex$`,
+		},
+		{
+			name:       "last parser wins",
+			opts:       []Option{WithImageParser(), WithActionsParser()},
+			parserType: actions.New(),
+			regex:      actions.New().GetRegex(),
+		},
+		{
+			name:    "invalid regex",
+			opts:    []Option{WithActionsParser(), WithRegex(`uses:\s*(`)},
+			wantErr: ErrInvalidRegex,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r, err := New(tt.opts...)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.IsType(t, tt.parserType, r.parser)
+			require.Equal(t, tt.regex, r.parser.GetRegex())
+			require.Equal(t, DefaultMaxConcurrency(), r.maxConcurrency)
+			require.Contains(t, r.cfg.Images.ExcludeImages, "scratch")
+			require.IsType(t, &ghrest.Client{}, r.rest)
+		})
+	}
+
+	cfg := &config.Config{GHActions: config.GHActions{Filter: config.Filter{Exclude: []string{"actions/checkout"}}}}
+	r, err := New(WithActionsParser(), WithConfig(cfg))
+	require.NoError(t, err)
+	require.Equal(t, []string{"actions/checkout"}, r.cfg.GHActions.Exclude)
+}
+
+// Not parallel as the fake API is reached through http.DefaultTransport, which gock swaps out
+func TestReplacer_CircleCIOrbs(t *testing.T) {
+	versions := map[string]string{
+		"circleci/node@5":         "5.2.0",
+		"circleci/slack@volatile": "4.13.3",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		version := versions[req.Variables["orbVersionRef"]]
+		if version == "" {
+			_, _ = w.Write([]byte(`{"data":{"orbVersion":null}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"orbVersion":{"version":"` + version + `"}}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	content := `version: 2.1
+orbs:
+  node: circleci/node@5
+  slack: circleci/slack@volatile
+  aws-cli: circleci/aws-cli@3.1.4
+  missing: circleci/missing@1
+jobs:
+  build:
+    docker:
+      - image: cimg/node:20.1
+`
+	pinned := `version: 2.1
+orbs:
+  node: circleci/node@5.2.0 # 5
+  slack: circleci/slack@4.13.3 # volatile
+  aws-cli: circleci/aws-cli@3.1.4
+  missing: circleci/missing@1
+jobs:
+  build:
+    docker:
+      - image: cimg/node:20.1
+`
+
+	fs := memfs.New()
+	f, err := fs.Create(".circleci/config.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	r := NewCircleCIOrbsReplacer(config.DefaultConfig()).WithCircleCIHost(srv.URL)
+
+	res, err := r.ParsePathInFS(context.Background(), fs, ".circleci")
+	require.NoError(t, err)
+	require.Equal(t, pinned, res.Modified[".circleci/config.yml"])
+	require.Len(t, res.Errors, 1)
+	require.Equal(t, 6, res.Errors[0].Line)
+	require.ErrorIs(t, res.Errors[0].Err, circleci.ErrOrbVersionNotFound)
+
+	list, err := r.ListInFile(strings.NewReader(pinned))
+	require.NoError(t, err)
+	require.Len(t, list.Entities, 4)
+	unpinned := list.Unpinned()
+	require.Len(t, unpinned, 1)
+	require.Equal(t, "circleci/missing", unpinned[0].Name)
+
+	modified, unpinnedContent, err := r.UnpinFile(context.Background(), strings.NewReader(pinned))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, `version: 2.1
+orbs:
+  node: circleci/node@5
+  slack: circleci/slack@volatile
+  aws-cli: circleci/aws-cli@3.1.4
+  missing: circleci/missing@1
+jobs:
+  build:
+    docker:
+      - image: cimg/node:20.1
+`, unpinnedContent)
+}
+
+func TestReplacer_TerraformModules(t *testing.T) {
+	t.Parallel()
+
+	shas := map[string]string{
+		"/api/v3/repos/example-org/terraform-modules/git/refs/tags/v1.2.0": strings.Repeat("1", 40),
+		"/api/v3/repos/example-org/terraform-network/git/refs/tags/v2.0.1": strings.Repeat("2", 40),
+		"/api/v3/repos/example-org/terraform-iam/git/refs/tags/v0.9.0":     strings.Repeat("3", 40),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sha, ok := shas[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + sha + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile("terraform/testdata/main.tf")
+	require.NoError(t, err)
+	want, err := os.ReadFile("terraform/testdata/main.tf.pinned")
+	require.NoError(t, err)
+
+	fs := memfs.New()
+	for name, data := range map[string][]byte{
+		"infra/main.tf": content,
+		// Only Terraform and OpenTofu files are processed
+		"infra/values.yaml": []byte("source = \"github.com/example-org/terraform-modules?ref=v1.2.0\"\n"),
+	} {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	r := NewTerraformModulesReplacer(config.DefaultConfig()).WithGitHubClient(client)
+	res, err := r.ParsePathInFS(context.Background(), fs, "infra")
+	require.NoError(t, err)
+	require.Equal(t, []string{"infra/main.tf"}, res.Processed)
+	require.Equal(t, string(want), res.Modified["infra/main.tf"])
+	// The shallow clone is left untouched even though its tag resolves
+	require.Equal(t, []interfaces.SkippedReference{{
+		Path:      "infra/main.tf",
+		Line:      20,
+		Reference: `source = "git::ssh://git@github.com/example-org/terraform-iam.git?depth=1&ref=v0.9.0"`,
+		Reason:    interfaces.SkipShallowClone,
+	}}, res.Skipped)
+
+	list, err := r.ListInFile(strings.NewReader(string(want)))
+	require.NoError(t, err)
+	require.Len(t, list.Entities, 5)
+	unpinned := list.Unpinned()
+	require.Len(t, unpinned, 2)
+	require.ElementsMatch(t, []string{
+		"git::ssh://git@github.com/example-org/terraform-iam.git",
+		"git::https://gitlab.com/example-org/terraform-storage.git",
+	}, []string{unpinned[0].Name, unpinned[1].Name})
+
+	modified, unpinnedContent, err := r.UnpinFile(context.Background(), strings.NewReader(string(want)))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, string(content), unpinnedContent)
+}
+
+func TestReplacer_PreCommitHooks(t *testing.T) {
+	t.Parallel()
+
+	shas := map[string]string{
+		"/api/v3/repos/psf/black/git/refs/tags/23.1.0":                   strings.Repeat("1", 40),
+		"/api/v3/repos/pre-commit/pre-commit-hooks/git/refs/tags/v4.5.0": strings.Repeat("2", 40),
+		"/api/v3/repos/astral-sh/ruff-pre-commit/git/refs/tags/v0.1.6":   strings.Repeat("3", 40),
+		"/api/v3/repos/example-org/not-a-config/git/refs/tags/v1.0.0":    strings.Repeat("4", 40),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sha, ok := shas[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + sha + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile("precommit/testdata/.pre-commit-config.yaml")
+	require.NoError(t, err)
+	want, err := os.ReadFile("precommit/testdata/.pre-commit-config.yaml.pinned")
+	require.NoError(t, err)
+
+	fs := memfs.New()
+	for name, data := range map[string][]byte{
+		"repo/.pre-commit-config.yaml": content,
+		// Only pre-commit configuration files are processed
+		"repo/other.yaml": []byte("repos:\n  - repo: https://github.com/example-org/not-a-config\n    rev: v1.0.0\n"),
+	} {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	r := NewPreCommitHooksReplacer(config.DefaultConfig()).WithGitHubClient(client)
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"repo/.pre-commit-config.yaml"}, res.Processed)
+	require.Equal(t, string(want), res.Modified["repo/.pre-commit-config.yaml"])
+	// The revs are counted once, the already pinned, GitLab and branch ones as skipped
+	require.Equal(t, FileStats{Matched: 6, Modified: 3, Skipped: 3}, res.Stats["repo/.pre-commit-config.yaml"])
+	require.Len(t, res.Changes, 3)
+	require.Equal(t, interfaces.ReferenceChange{
+		Path:   "repo/.pre-commit-config.yaml",
+		Line:   5,
+		Before: "rev: 23.1.0",
+		After:  "rev: " + strings.Repeat("1", 40) + " # 23.1.0",
+		Type:   "hook",
+	}, res.Changes[0])
+
+	// Pinning again is a no-op
+	modified, _, err := r.ParseFile(context.Background(), strings.NewReader(string(want)))
+	require.NoError(t, err)
+	require.False(t, modified)
+
+	// The revs followed by a tag comment are unpinned
+	modified, unpinned, err := r.UnpinFile(context.Background(), strings.NewReader(string(want)))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Contains(t, unpinned, "    rev: 23.1.0\n")
+	require.Contains(t, unpinned, "    rev: \"v4.5.0\" # keep in sync with CI\n")
+	require.Contains(t, unpinned, "    rev: 'v0.1.6'\n")
+	require.Contains(t, unpinned, "    rev: v1.55.2\n")
+}
+
+func TestReplacer_PreCommitHooksErrors(t *testing.T) {
+	t.Parallel()
+
+	var rateLimited atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimited.Load() {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/.pre-commit-config.yaml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("repos:\n  - repo: https://github.com/psf/black\n    rev: 99.0.0\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The rev failing to resolve is reported like the references matched line by line
+	r := NewPreCommitHooksReplacer(config.DefaultConfig()).WithCacheDisabled().WithGitHubClient(client)
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Empty(t, res.Modified)
+	require.Len(t, res.Errors, 1)
+	require.Equal(t, "repo/.pre-commit-config.yaml", res.Errors[0].Path)
+	require.Equal(t, 3, res.Errors[0].Line)
+	require.Equal(t, "rev: 99.0.0", res.Errors[0].Reference)
+
+	_, err = r.WithFailOnUnresolved().ParsePathInFS(context.Background(), fs, "repo")
+	require.Error(t, err)
+
+	rateLimited.Store(true)
+	_, err = r.ParsePathInFS(context.Background(), fs, "repo")
+	require.ErrorIs(t, err, ghrest.ErrRateLimited)
+}
+
+func TestReplacer_LockFile(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	host := strings.TrimPrefix(srv.URL, "http://")
+	pushRandomImage(t, host+"/nginx:1.25")
+
+	const sum = "11bd71901bbe5b1630ceea73d27597364c9af683"
+	gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/actions/checkout/git/refs/tags/v4" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + sum + `", "type": "commit"}}`))
+	}))
+	client, err := ghrest.NewClient("").WithBaseURL(gh.URL)
+	require.NoError(t, err)
+
+	workflow := "steps:\n  - uses: actions/checkout@v4\n"
+	manifest := "spec:\n  containers:\n    - image: " + host + "/nginx:1.25\n"
+	pin := func(cache store.RefCacher) (string, string) {
+		t.Helper()
+		actions := NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubClient(client).
+			WithCache(cache).WithFailOnUnresolved()
+		_, pinnedWorkflow, err := actions.ParseFile(context.Background(), strings.NewReader(workflow))
+		require.NoError(t, err)
+		images := NewContainerImagesReplacer(config.DefaultConfig()).WithCache(cache).WithFailOnUnresolved()
+		_, pinnedManifest, err := images.ParseFile(context.Background(), strings.NewReader(manifest))
+		require.NoError(t, err)
+		return pinnedWorkflow, pinnedManifest
+	}
+
+	// The first run resolves the references online, recording them in the lockfile
+	path := filepath.Join(t.TempDir(), store.DefaultLockFileName)
+	lock, err := store.NewLockFile(path, nil, false, true)
+	require.NoError(t, err)
+	wantWorkflow, wantManifest := pin(lock)
+	require.Contains(t, wantWorkflow, sum)
+	require.Contains(t, wantManifest, "@sha256:")
+	require.NoError(t, lock.Save())
+
+	// The next one resolves them from the lockfile, without the network
+	srv.Close()
+	gh.Close()
+	lock, err = store.NewLockFile(path, nil, true, false)
+	require.NoError(t, err)
+	gotWorkflow, gotManifest := pin(lock)
+	require.Equal(t, wantWorkflow, gotWorkflow)
+	require.Equal(t, wantManifest, gotManifest)
+}
+
+func TestReplacer_GitHubHosts(t *testing.T) {
+	t.Parallel()
+
+	// Each host resolves its own actions, given the token of the host
+	newHost := func(action, token, sum string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v3/repos/"+action+"/git/refs/tags/v1" || r.Header.Get("Authorization") != "Bearer "+token {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + sum + `", "type": "commit"}}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	const (
+		publicSum     = "11bd71901bbe5b1630ceea73d27597364c9af683"
+		enterpriseSum = "b4ffde65f46336ab88eb53be808477a3936bae11"
+	)
+	public := newHost("actions/checkout", "public_token", publicSum)
+	enterprise := newHost("team/action", "enterprise_token", enterpriseSum)
+
+	client, err := ghrest.NewClient("public_token").WithBaseURL(public.URL)
+	require.NoError(t, err)
+	enterpriseClient, err := ghrest.NewClient("enterprise_token").WithBaseURL(enterprise.URL)
+	require.NoError(t, err)
+	r := NewGitHubActionsReplacer(config.DefaultConfig()).
+		WithGitHubClient(client.WithHostClient("ghe.example-corp.com", enterpriseClient)).
+		WithFailOnUnresolved()
+
+	_, pinned, err := r.ParseFile(context.Background(), strings.NewReader(`steps:
+  - uses: actions/checkout@v1
+  - uses: ghe.example-corp.com/team/action@v1
+`))
+	require.NoError(t, err)
+	require.Equal(t, `steps:
+  - uses: actions/checkout@`+publicSum+` # v1
+  - uses: ghe.example-corp.com/team/action@`+enterpriseSum+` # v1
+`, pinned)
+
+	r, err = NewGitHubActionsReplacer(config.DefaultConfig()).
+		WithGitHubHostTokens(map[string]string{"ghe.example-corp.com": "enterprise_token"})
+	require.NoError(t, err)
+	_, ok := r.rest.(interfaces.HostREST).ForHost("ghe.example-corp.com")
+	require.True(t, ok)
+}
+
+func TestReplacer_QuotedActions(t *testing.T) {
+	t.Parallel()
+
+	const sum = "11bd71901bbe5b1630ceea73d27597364c9af683"
+	gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/actions/checkout/git/refs/tags/v4" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + sum + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(gh.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(gh.URL)
+	require.NoError(t, err)
+	r := NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubClient(client).WithFailOnUnresolved()
+
+	workflow, err := os.ReadFile(filepath.Join("actions", "testdata", "quoted.yml"))
+	require.NoError(t, err)
+
+	// The quotes are kept around the pinned reference, the tag comment goes after the
+	// closing one and ahead of the comment already on the line
+	modified, pinned, err := r.ParseFile(context.Background(), strings.NewReader(string(workflow)))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, `name: CI
+on: push
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@`+sum+` # v4 # fetch the sources
+      - uses: "actions/checkout@`+sum+`" # v4
+      - name: Checkout with args
+        uses: 'actions/checkout@`+sum+`' # v4   # keep the history
+        with:
+          fetch-depth: 0
+      - uses: "actions/checkout@`+sum+`" # v4 # a comment with "quotes"
+      - uses: actions/checkout@`+sum+` # v4   
+`, pinned)
+
+	list, err := r.ListInFile(strings.NewReader(string(workflow)))
+	require.NoError(t, err)
+	require.Len(t, list.Entities, 1)
+	require.Equal(t, "actions/checkout", list.Entities[0].Name)
+	require.Equal(t, "v4", list.Entities[0].Ref)
+
+	// Unpinning restores the original references, quotes and comments included
+	modified, unpinned, err := r.UnpinFile(context.Background(), strings.NewReader(pinned))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, string(workflow), unpinned)
+}
+
+// proxyResolver resolves the references it knows about, falling back to the default
+// resolver for the others if set
+type proxyResolver struct {
+	*DefaultResolver
+	checksums map[string]string
+	digests   map[string]string
+}
+
+func (r *proxyResolver) ResolveActionChecksum(ctx context.Context, action, ref string) (string, error) {
+	if sum, ok := r.checksums[action+"@"+ref]; ok {
+		return sum, nil
+	}
+	if r.DefaultResolver != nil {
+		return r.DefaultResolver.ResolveActionChecksum(ctx, action, ref)
+	}
+	return "", fmt.Errorf("unknown action %s@%s", action, ref)
+}
+
+func (r *proxyResolver) ResolveImageDigest(_ context.Context, imageRef, platform string) (string, string, error) {
+	if digest, ok := r.digests[imageRef+"#"+platform]; ok {
+		return digest, "application/vnd.oci.image.index.v1+json", nil
+	}
+	return "", "", fmt.Errorf("unknown image %s", imageRef)
+}
+
+func TestReplacer_WithResolver(t *testing.T) {
+	t.Parallel()
+
+	const (
+		checkoutSum = "11bd71901bbe5b1630ceea73d27597364c9af683"
+		setupGoSum  = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+		alpine      = "sha256:beefdbd8a1da6d2915566fde36db9db0b524eb737fc57cd1367effd16dc0d06d"
+		alpineArm   = "sha256:b4a3a1a7ea8b5d5f43d3d1b8cb2fda3ec4d0a1a2bd4f0ab2e0ad3e5fd2f27e21"
+	)
+	resolver := &proxyResolver{
+		checksums: map[string]string{"actions/checkout@v4": checkoutSum, "actions/checkout@main": checkoutSum},
+		digests: map[string]string{
+			"index.docker.io/library/alpine:3.20#":            alpine,
+			"index.docker.io/library/alpine:3.20#linux/arm64": alpineArm,
+		},
+	}
+
+	t.Run("Actions", func(t *testing.T) {
+		t.Parallel()
+
+		// The GitHub API isn't reachable, everything goes through the resolver
+		client, err := ghrest.NewClient("").WithBaseURL("http://127.0.0.1:1")
+		require.NoError(t, err)
+		r := NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubClient(client).WithResolver(resolver)
+
+		modified, pinned, err := r.ParseFile(context.Background(), strings.NewReader(`steps:
+  - uses: actions/checkout@v4
+  - uses: docker://alpine:3.20
+`))
+		require.NoError(t, err)
+		require.True(t, modified)
+		require.Equal(t, `steps:
+  - uses: actions/checkout@`+checkoutSum+` # v4
+  - uses: docker://index.docker.io/library/alpine@`+alpine+` # 3.20
+`, pinned)
+
+		_, err = r.ParseString(context.Background(), "actions/setup-go@v5")
+		require.ErrorContains(t, err, "unknown action actions/setup-go@v5")
+	})
+
+	t.Run("BranchFilter", func(t *testing.T) {
+		t.Parallel()
+
+		// main is excluded by default, even though the resolver knows it
+		_, err := NewGitHubActionsReplacer(config.DefaultConfig()).WithResolver(resolver).
+			ParseString(context.Background(), "actions/checkout@main")
+		require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+
+		cfg := config.DefaultConfig()
+		cfg.GHActions.Filter.IncludeBranches = []string{"main"}
+		got, err := NewGitHubActionsReplacer(cfg).WithResolver(resolver).
+			ParseString(context.Background(), "actions/checkout@main")
+		require.NoError(t, err)
+		require.Equal(t, checkoutSum, got.Ref)
+	})
+
+	t.Run("Images", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := config.DefaultConfig()
+		cfg.Platform = "linux/arm64"
+		r := NewContainerImagesReplacer(cfg).WithResolver(resolver)
+
+		got, err := r.ParseString(context.Background(), "alpine:3.20")
+		require.NoError(t, err)
+		require.Equal(t, alpineArm, got.Ref)
+		require.Equal(t, "3.20", got.Tag)
+		require.Equal(t, "application/vnd.oci.image.index.v1+json", got.MediaType)
+	})
+
+	t.Run("FallBackToDefault", func(t *testing.T) {
+		t.Parallel()
+
+		gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v3/repos/actions/setup-go/git/refs/tags/v5" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + setupGoSum + `", "type": "commit"}}`))
+		}))
+		t.Cleanup(gh.Close)
+		client, err := ghrest.NewClient("").WithBaseURL(gh.URL)
+		require.NoError(t, err)
+		r := NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubClient(client)
+		r = r.WithResolver(&proxyResolver{DefaultResolver: r.DefaultResolver(), checksums: resolver.checksums})
+
+		got, err := r.ParseString(context.Background(), "actions/checkout@v4")
+		require.NoError(t, err)
+		require.Equal(t, checkoutSum, got.Ref)
+		got, err = r.ParseString(context.Background(), "actions/setup-go@v5")
+		require.NoError(t, err)
+		require.Equal(t, setupGoSum, got.Ref)
+	})
+}
+
+func TestReplacer_ReusableWorkflows(t *testing.T) {
+	t.Parallel()
+
+	const sum = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+	gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/org/repo/git/refs/tags/v1" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + sum + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(gh.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(gh.URL)
+	require.NoError(t, err)
+	r := NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubClient(client).WithFailOnUnresolved()
+
+	workflow, err := os.ReadFile(filepath.Join("actions", "testdata", "reusable.yml"))
+	require.NoError(t, err)
+
+	// Reusable workflows are resolved through the tags of their repository, like the actions
+	// living in a subdirectory of theirs, while the local ones are left alone
+	modified, pinned, err := r.ParseFile(context.Background(), strings.NewReader(string(workflow)))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, `name: Release
+on: push
+jobs:
+  ci:
+    uses: org/repo/.github/workflows/ci.yml@`+sum+` # v1
+  release:
+    needs: ci
+    uses: "org/repo/.github/workflows/release.yaml@`+sum+`" # v1 # publish the artifacts
+    with:
+      draft: true
+    secrets: inherit
+  local:
+    uses: ./.github/workflows/local.yml
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: org/repo@`+sum+` # v1
+`, pinned)
+
+	list, err := r.ListInFile(strings.NewReader(string(workflow)))
+	require.NoError(t, err)
+	names := make([]string, 0, len(list.Entities))
+	for _, e := range list.Entities {
+		names = append(names, e.Name)
+	}
+	require.ElementsMatch(t, []string{
+		"org/repo/.github/workflows/ci.yml",
+		"org/repo/.github/workflows/release.yaml",
+		"org/repo",
+	}, names)
+
+	modified, unpinned, err := r.UnpinFile(context.Background(), strings.NewReader(pinned))
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.Equal(t, string(workflow), unpinned)
+}
+
+func TestReplacer_WithOnlyFiles(t *testing.T) {
+	t.Parallel()
+
+	const pinned = "      - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4\n"
+	fs := memfs.New()
+	for path, content := range map[string]string{
+		"repo/workflows/ci.yml":          pinned,
+		"repo/workflows/release.yml":     pinned,
+		"repo/workflows/nested/lint.yml": pinned,
+		"repo/README.md":                 pinned,
+	} {
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// The files are relative to the processed directory, and still have to be processed
+	// by the parser
+	r := NewGitHubActionsReplacer(config.DefaultConfig()).
+		WithOnlyFiles([]string{"workflows/ci.yml", "workflows/nested/lint.yml", "README.md", "missing.yml"})
+	res, err := r.UnpinPathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"repo/workflows/ci.yml", "repo/workflows/nested/lint.yml"}, res.Processed)
+	require.Len(t, res.Modified, 2)
+
+	res, err = r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"repo/workflows/ci.yml", "repo/workflows/nested/lint.yml"}, res.Processed)
+
+	// No file is processed if none changed
+	res, err = NewGitHubActionsReplacer(config.DefaultConfig()).WithOnlyFiles(nil).UnpinPathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Empty(t, res.Processed)
+}
+
+func TestCompileRegex(t *testing.T) {
+	t.Parallel()
+
+	p := actions.New()
+	re, err := compileRegex(p)
+	require.NoError(t, err)
+	again, err := compileRegex(p)
+	require.NoError(t, err)
+	require.Same(t, re, again, "the regex should be compiled once rather than for every file")
+
+	p.SetRegex(`uses:\s*(`)
+	_, err = compileRegex(p)
+	require.ErrorIs(t, err, ErrInvalidRegex)
+
+	// Parsers which don't cache their compiled regex get it compiled on every call
+	re, err = compileRegex(uncompiledParser{actions.New()})
+	require.NoError(t, err)
+	require.Equal(t, actions.GitHubActionsRegex, re.String())
+}
+
+// uncompiledParser hides the CompiledRegex method of the parser it wraps, as a parser
+// only implementing interfaces.Parser would
+type uncompiledParser struct {
+	interfaces.Parser
+}
+
+func BenchmarkParsePath(b *testing.B) {
+	fs := memfs.New()
+	for i := 0; i < 200; i++ {
+		f, err := fs.Create(fmt.Sprintf("repo/.github/workflows/workflow-%d.yml", i))
+		require.NoError(b, err)
+		// References already pinned are skipped without reaching GitHub
+		_, err = f.Write([]byte(strings.Repeat(
+			"steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"+
+				"  - run: go test ./...\n", 20)))
+		require.NoError(b, err)
+		require.NoError(b, f.Close())
+	}
+
+	benchmarks := []struct {
+		name   string
+		parser interfaces.Parser
+	}{
+		{"CompiledOnce", actions.New()},
+		{"CompiledPerFile", uncompiledParser{actions.New()}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			r := newReplacer(bm.parser, config.DefaultConfig()).WithCacheDisabled()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := r.ParsePathInFS(context.Background(), fs, "repo"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReplacer_Drift(t *testing.T) {
+	t.Parallel()
+
+	const (
+		pinned  = "b4ffde65f46336ab88eb53be808477a3936bae11"
+		current = "11bd71901bbe5b1630ceea73d27597364c9af683"
+		setupGo = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/actions/checkout/git/refs/tags/v4":
+			// The tag was moved since the reference was pinned
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + current + `", "type": "commit"}}`))
+		case "/api/v3/repos/actions/setup-go/git/refs/tags/v5":
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + setupGo + `", "type": "commit"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	fs := memfs.New()
+	f, err := fs.Create("repo/workflow.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte(`steps:
+  - uses: actions/checkout@` + pinned + ` # v4
+  - uses: actions/setup-go@` + setupGo + ` # v5
+  - uses: actions/cache@` + pinned + `
+  - uses: actions/does-not-exist@` + pinned + ` # v1
+  - uses: actions/upload-artifact@v4
+`))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	r := NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubClient(client)
+	res, err := r.DriftPathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, []DriftedReference{{
+		Path:    "repo/workflow.yml",
+		Line:    2,
+		Type:    actions.ReferenceType,
+		Name:    "actions/checkout",
+		Tag:     "v4",
+		Pinned:  pinned,
+		Current: current,
+	}}, res.Drifted)
+	require.Len(t, res.Errors, 1)
+	require.Equal(t, 5, res.Errors[0].Line)
+	require.Equal(t, "uses: actions/does-not-exist@v1", res.Errors[0].Reference)
+}
+
+func TestCombinedReplacer(t *testing.T) {
+	t.Parallel()
+
+	const checkout = "11bd71901bbe5b1630ceea73d27597364c9af683"
+	ghSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/actions/checkout/git/refs/tags/v4" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object": {"sha": "` + checkout + `", "type": "commit"}}`))
+	}))
+	t.Cleanup(ghSrv.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(ghSrv.URL)
+	require.NoError(t, err)
+
+	host, digests := newTestRegistry(t, "golang:1.22", "alpine:3.19", "nginx:1.25")
+
+	files := map[string]string{
+		"repo/.github/workflows/ci.yml": "jobs:\n  build:\n    container:\n      image: " + host + "/golang:1.22\n" +
+			"    steps:\n      - uses: actions/checkout@v4\n",
+		// A Dockerfile can't reference actions, so it's left to the image replacer
+		"repo/Dockerfile":      "FROM " + host + "/alpine:3.19\nLABEL uses: actions/checkout@v4\n",
+		"repo/deploy/app.yaml": "image: " + host + "/nginx:1.25\n",
+		"repo/README.md":       "image: " + host + "/nginx:1.25\n",
+	}
+	fs := memfs.New()
+	for path, content := range files {
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	r := NewCombinedReplacer(
+		NewGitHubActionsReplacer(config.DefaultConfig()).WithGitHubClient(client),
+		NewContainerImagesReplacer(config.DefaultConfig()).WithFailOnUnresolved(),
+	)
+	res, err := r.ParsePathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"repo/.github/workflows/ci.yml", "repo/Dockerfile", "repo/deploy/app.yaml"}, res.Processed)
+	require.Equal(t, map[string]string{
+		"repo/.github/workflows/ci.yml": "jobs:\n  build:\n    container:\n      image: " + host + "/golang@" + digests["golang:1.22"] +
+			" # 1.22\n    steps:\n      - uses: actions/checkout@" + checkout + " # v4\n",
+		"repo/Dockerfile":      "FROM " + host + "/alpine:3.19@" + digests["alpine:3.19"] + "\nLABEL uses: actions/checkout@v4\n",
+		"repo/deploy/app.yaml": "image: " + host + "/nginx@" + digests["nginx:1.25"] + " # 1.25\n",
+	}, res.Modified)
+	require.Equal(t, FileStats{Matched: 2, Modified: 2}, res.Stats["repo/.github/workflows/ci.yml"])
+	require.Equal(t, FileStats{Matched: 4, Modified: 4}, res.Totals())
+
+	// The changes of both replacers are reported, in the order of the lines
+	var changed []string
+	for _, c := range res.Changes {
+		changed = append(changed, fmt.Sprintf("%s:%d:%s", c.Path, c.Line, c.Type))
+	}
+	require.Equal(t, []string{
+		"repo/.github/workflows/ci.yml:4:container",
+		"repo/.github/workflows/ci.yml:6:action",
+		"repo/Dockerfile:1:container",
+		"repo/deploy/app.yaml:1:container",
+	}, changed)
+
+	// Unpinning the result of both replacers gives the original files back
+	for path, content := range res.Modified {
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	res, err = r.UnpinPathInFS(context.Background(), fs, "repo")
+	require.NoError(t, err)
+	require.Equal(t, files["repo/.github/workflows/ci.yml"], res.Modified["repo/.github/workflows/ci.yml"])
+	require.Equal(t, files["repo/deploy/app.yaml"], res.Modified["repo/deploy/app.yaml"])
+
+	// A replacer traversing files of its own can't be combined
+	_, err = NewCombinedReplacer(NewTerraformModulesReplacer(config.DefaultConfig())).ParsePathInFS(context.Background(), fs, "repo")
+	require.ErrorIs(t, err, ErrNotCombinable)
+}
@@ -0,0 +1,61 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replacer
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/replacer/image"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+)
+
+// DefaultResolver resolves the checksums of the GitHub Actions through the GitHub API
+// and the digests of the container images through their registries. It's how the
+// references are resolved unless another resolver is set through WithResolver, which may
+// fall back to it for the references it doesn't know about.
+type DefaultResolver struct {
+	rest       interfaces.REST
+	cfg        config.GHActions
+	remoteOpts []remote.Option
+}
+
+// NewDefaultResolver creates a new DefaultResolver going through the given GitHub client
+// and the given remote options on top of the defaults to talk to the registries
+func NewDefaultResolver(restIf interfaces.REST, cfg config.GHActions, opts ...remote.Option) *DefaultResolver {
+	return &DefaultResolver{
+		rest:       restIf,
+		cfg:        cfg,
+		remoteOpts: opts,
+	}
+}
+
+// ResolveActionChecksum implements interfaces.Resolver
+func (r *DefaultResolver) ResolveActionChecksum(ctx context.Context, action, ref string) (string, error) {
+	return actions.GetChecksum(ctx, r.cfg, r.rest, action, ref)
+}
+
+// ResolveImageDigest implements interfaces.Resolver
+func (r *DefaultResolver) ResolveImageDigest(ctx context.Context, imageRef, platform string) (string, string, error) {
+	res, err := image.GetImageDigestFromRef(ctx, imageRef, platform, nil, r.remoteOpts...)
+	if err != nil {
+		return "", "", err
+	}
+	return res.Ref, res.MediaType, nil
+}
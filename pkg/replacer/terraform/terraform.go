@@ -0,0 +1,238 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package terraform provides utilities to work with Terraform and OpenTofu module sources.
+package terraform
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/stacklok/frizbee/internal/traverse"
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/replacer/actions"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/store"
+)
+
+const (
+	// ModuleSourceRegex is the regular expression pattern to match the source of
+	// modules fetched from a git repository at a given ref, e.g.
+	// source = "git::https://github.com/org/mod.git?ref=v1.2.0"
+	ModuleSourceRegex = `source\s*=\s*"[^"\s]*[?&]ref=[^"\s&]+[^"\s]*"`
+	// ReferenceType is the type of the reference
+	ReferenceType = "module"
+
+	prefixGit  = "git::"
+	githubHost = "github.com"
+)
+
+// refParam matches the ref parameter of the source query along with its value
+var refParam = regexp.MustCompile(`([?&]ref=)[^"\s&]+`)
+
+// depthParam matches the depth parameter of the source query, asking for a shallow clone
+var depthParam = regexp.MustCompile(`[?&]depth=`)
+
+// defaultRegex is ModuleSourceRegex compiled once for all the parsers
+var defaultRegex = regexp.MustCompile(ModuleSourceRegex)
+
+// Parser is a struct to replace the refs of module sources with commit checksums
+type Parser struct {
+	regex string
+	// compiled is the compiled regex, or nil along with regexErr if it's invalid
+	compiled *regexp.Regexp
+	regexErr error
+	cache    store.RefCacher
+	// logger logs the cache lookups at debug level, if set
+	logger *slog.Logger
+}
+
+// New creates a new Parser
+func New() *Parser {
+	return &Parser{
+		regex:    ModuleSourceRegex,
+		compiled: defaultRegex,
+		cache:    store.NewRefCacher(),
+	}
+}
+
+// SetCache sets the cache to store the resolved checksums
+func (p *Parser) SetCache(cache store.RefCacher) {
+	p.cache = cache
+}
+
+// SetLogger sets the logger the cache hits and misses are logged to at debug level
+func (p *Parser) SetLogger(logger *slog.Logger) {
+	p.logger = logger
+}
+
+// SetRegex sets the regular expression pattern to match module sources
+func (p *Parser) SetRegex(regex string) {
+	p.regex = regex
+	p.compiled, p.regexErr = regexp.Compile(regex)
+}
+
+// GetRegex returns the regular expression pattern to match module sources
+func (p *Parser) GetRegex() string {
+	return p.regex
+}
+
+// CompiledRegex returns the regular expression pattern to match module sources, compiled
+// once when set rather than for every file, or the error compiling it if it's invalid
+func (p *Parser) CompiledRegex() (*regexp.Regexp, error) {
+	return p.compiled, p.regexErr
+}
+
+// TraverseFiles calls fn with each Terraform and OpenTofu configuration file of the given directory
+func (*Parser) TraverseFiles(bfs billy.Filesystem, base string, fn func(path string) error, opts ...traverse.Option) error {
+	return traverse.TerraformFiles(bfs, base, fn, opts...)
+}
+
+// FormatReference rewrites the ref of the matched module source
+func (*Parser) FormatReference(matchedLine, ref string) string {
+	loc := refParam.FindStringSubmatchIndex(matchedLine)
+	if loc == nil {
+		return matchedLine
+	}
+	return matchedLine[:loc[3]] + ref + matchedLine[loc[1]:]
+}
+
+// Replace replaces the ref of the module source with the checksum of the commit it points at
+func (p *Parser) Replace(
+	ctx context.Context,
+	matchedLine string,
+	restIf interfaces.REST,
+	cfg config.Config,
+) (*interfaces.EntityRef, error) {
+	src, err := parseModuleSource(matchedLine)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only modules hosted on GitHub can be resolved
+	repo, ok := githubRepository(src.Name)
+	if !ok || !isIncluded(&cfg.Terraform, repo) || config.MatchAny(cfg.Terraform.Exclude, repo) {
+		return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+	}
+	if actions.IsChecksum(src.Ref) {
+		return nil, fmt.Errorf("module already referenced by checksum: %s %w", matchedLine, interfaces.ErrReferenceSkipped)
+	}
+	// Shallow clones can't check out a commit, pinning the source would break it
+	if depthParam.MatchString(matchedLine) {
+		return nil, &interfaces.SkippedError{Reference: matchedLine, Reason: interfaces.SkipShallowClone}
+	}
+
+	key := repo + "@" + src.Ref
+	ghCfg := config.GHActions{Filter: cfg.Terraform.Filter}
+	store.LogLookup(ctx, p.logger, p.cache, key, key)
+	sum, err := actions.GetChecksumCached(ctx, ghCfg, restIf, p.cache, key, repo, src.Ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksum for module '%s': %w", key, err)
+	}
+
+	src.Tag = src.Ref
+	src.Ref = sum
+	return src, nil
+}
+
+// Unpin reverts a module source pinned by its checksum back to the given tag
+func (*Parser) Unpin(matchedLine, tag string) (*interfaces.EntityRef, error) {
+	src, err := parseModuleSource(matchedLine)
+	if err != nil {
+		return nil, err
+	}
+	// Only sources pinned by a checksum with a known tag can be unpinned
+	if !actions.IsChecksum(src.Ref) || tag == "" {
+		return nil, fmt.Errorf("%w: %s", interfaces.ErrReferenceSkipped, matchedLine)
+	}
+	src.Tag = tag
+	return src, nil
+}
+
+// ConvertToEntityRef converts a module source to an EntityRef
+func (*Parser) ConvertToEntityRef(reference string) (*interfaces.EntityRef, error) {
+	return parseModuleSource(reference)
+}
+
+// parseModuleSource parses the module source assignment, or the bare source address, into
+// an EntityRef holding the address without its query as name and the ref of the query
+func parseModuleSource(reference string) (*interfaces.EntityRef, error) {
+	var prefix string
+	address := reference
+	if start := strings.Index(reference, `"`); start >= 0 {
+		end := strings.LastIndex(reference, `"`)
+		if end <= start {
+			return nil, fmt.Errorf("invalid module source: %s %w", reference, interfaces.ErrInvalidReference)
+		}
+		prefix = reference[:start+1]
+		address = reference[start+1 : end]
+	}
+
+	name, rawQuery, _ := strings.Cut(address, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil || name == "" || query.Get("ref") == "" {
+		return nil, fmt.Errorf("invalid module source: %s %w", reference, interfaces.ErrInvalidReference)
+	}
+
+	return &interfaces.EntityRef{
+		Name:   name,
+		Ref:    query.Get("ref"),
+		Type:   ReferenceType,
+		Prefix: prefix,
+	}, nil
+}
+
+// githubRepository returns the owner/repo of a module source hosted on GitHub, either
+// through the github.com/owner/repo shorthand or a git:: URL such as
+// git::https://github.com/owner/repo.git or git::git@github.com:owner/repo.git
+func githubRepository(address string) (string, bool) {
+	if !strings.HasPrefix(address, prefixGit) && !strings.HasPrefix(address, githubHost+"/") {
+		return "", false
+	}
+	address = strings.TrimPrefix(address, prefixGit)
+
+	// Drop the scheme and user, if any
+	if _, rest, ok := strings.Cut(address, "://"); ok {
+		address = rest
+	}
+	if at := strings.Index(address, "@"); at >= 0 && !strings.ContainsAny(address[:at], "/:") {
+		address = address[at+1:]
+	}
+
+	// The host is followed by a / or, in the scp-like syntax, by a :
+	sep := strings.IndexAny(address, "/:")
+	if sep < 0 || address[:sep] != githubHost {
+		return "", false
+	}
+	// Drop the subdirectory of the module within the repository, if any
+	path, _, _ := strings.Cut(address[sep+1:], "//")
+	frags := strings.Split(path, "/")
+	if len(frags) < 2 || frags[0] == "" || frags[1] == "" {
+		return "", false
+	}
+
+	return frags[0] + "/" + strings.TrimSuffix(frags[1], ".git"), true
+}
+
+// isIncluded returns true if the repository matches the include list, or if there's none
+func isIncluded(cfg *config.Terraform, repo string) bool {
+	return len(cfg.Include) == 0 || config.MatchAny(cfg.Include, repo)
+}
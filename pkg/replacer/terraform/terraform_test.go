@@ -0,0 +1,216 @@
+package terraform
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/config"
+	"github.com/stacklok/frizbee/pkg/utils/ghrest"
+)
+
+const testSHA = "0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+
+func TestReplace(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/example-org/terraform-network/git/refs/tags/v2.0.1",
+			"/api/v3/repos/example-org/terraform-modules/git/refs/tags/v1.2.0":
+			_, _ = w.Write([]byte(`{"object": {"sha": "` + testSHA + `", "type": "commit"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	client, err := ghrest.NewClient("").WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		line        string
+		cfg         config.Config
+		want        *interfaces.EntityRef
+		wantSkipped bool
+		wantErr     bool
+	}{
+		{
+			name: "git URL",
+			line: `source = "git::https://github.com/example-org/terraform-network.git?ref=v2.0.1"`,
+			want: &interfaces.EntityRef{
+				Name:   "git::https://github.com/example-org/terraform-network.git",
+				Ref:    testSHA,
+				Type:   ReferenceType,
+				Tag:    "v2.0.1",
+				Prefix: `source = "`,
+			},
+		},
+		{
+			name: "GitHub shorthand with a subdirectory",
+			line: `source  =  "github.com/example-org/terraform-modules//vpc?ref=v1.2.0"`,
+			want: &interfaces.EntityRef{
+				Name:   "github.com/example-org/terraform-modules//vpc",
+				Ref:    testSHA,
+				Type:   ReferenceType,
+				Tag:    "v1.2.0",
+				Prefix: `source  =  "`,
+			},
+		},
+		{
+			name:        "already pinned",
+			line:        `source = "git::https://github.com/example-org/terraform-network.git?ref=` + testSHA + `"`,
+			wantSkipped: true,
+		},
+		{
+			name:        "shallow clone",
+			line:        `source = "git::https://github.com/example-org/terraform-network.git?depth=1&ref=v2.0.1"`,
+			wantSkipped: true,
+		},
+		{
+			name:        "not hosted on GitHub",
+			line:        `source = "git::https://gitlab.com/example-org/terraform-network.git?ref=v2.0.1"`,
+			wantSkipped: true,
+		},
+		{
+			name:        "excluded",
+			line:        `source = "git::https://github.com/example-org/terraform-network.git?ref=v2.0.1"`,
+			cfg:         config.Config{Terraform: config.Terraform{Filter: config.Filter{Exclude: []string{"example-org/*"}}}},
+			wantSkipped: true,
+		},
+		{
+			name:        "excluded branch",
+			line:        `source = "git::https://github.com/example-org/terraform-network.git?ref=main"`,
+			cfg:         config.Config{Terraform: config.Terraform{Filter: config.Filter{ExcludeBranches: []string{"main"}}}},
+			wantSkipped: true,
+		},
+		{
+			name:    "unknown tag",
+			line:    `source = "git::https://github.com/example-org/terraform-network.git?ref=v9.9.9"`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := New().Replace(context.Background(), tt.line, client, tt.cfg)
+			switch {
+			case tt.wantSkipped:
+				require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+			case tt.wantErr:
+				require.Error(t, err)
+				require.NotErrorIs(t, err, interfaces.ErrReferenceSkipped)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGitHubRepository(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{address: "github.com/org/repo", want: "org/repo"},
+		{address: "github.com/org/repo//modules/vpc", want: "org/repo"},
+		{address: "git::https://github.com/org/repo.git", want: "org/repo"},
+		{address: "git::https://github.com/org/repo.git//modules/vpc", want: "org/repo"},
+		{address: "git::ssh://git@github.com/org/repo.git", want: "org/repo"},
+		{address: "git::git@github.com:org/repo.git", want: "org/repo"},
+		{address: "git::https://gitlab.com/org/repo.git"},
+		{address: "git::https://github.com.evil.example/org/repo.git"},
+		{address: "https://github.com/org/repo/archive/v1.zip"},
+		{address: "github.com/org"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.address, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := githubRepository(tt.address)
+			require.Equal(t, tt.want != "", ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFormatReference(t *testing.T) {
+	t.Parallel()
+
+	p := New()
+	require.Equal(t,
+		`source = "git::https://github.com/org/repo.git?depth=1&ref=`+testSHA+`"`,
+		p.FormatReference(`source = "git::https://github.com/org/repo.git?depth=1&ref=v1.0.0"`, testSHA))
+	require.Equal(t,
+		`source = "github.com/org/repo?ref=v1.0.0&depth=1"`,
+		p.FormatReference(`source = "github.com/org/repo?ref=`+testSHA+`&depth=1"`, "v1.0.0"))
+}
+
+func TestUnpin(t *testing.T) {
+	t.Parallel()
+
+	line := `source = "git::https://github.com/org/repo.git?ref=` + testSHA + `"`
+	got, err := New().Unpin(line, "v1.0.0")
+	require.NoError(t, err)
+	require.Equal(t, &interfaces.EntityRef{
+		Name:   "git::https://github.com/org/repo.git",
+		Ref:    testSHA,
+		Type:   ReferenceType,
+		Tag:    "v1.0.0",
+		Prefix: `source = "`,
+	}, got)
+
+	_, err = New().Unpin(line, "")
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+	_, err = New().Unpin(`source = "git::https://github.com/org/repo.git?ref=v1.0.0"`, "v1.0.0")
+	require.ErrorIs(t, err, interfaces.ErrReferenceSkipped)
+}
+
+func TestConvertToEntityRef(t *testing.T) {
+	t.Parallel()
+
+	got, err := New().ConvertToEntityRef("github.com/org/repo?ref=v1.0.0")
+	require.NoError(t, err)
+	require.Equal(t, &interfaces.EntityRef{Name: "github.com/org/repo", Ref: "v1.0.0", Type: ReferenceType}, got)
+
+	_, err = New().ConvertToEntityRef(`source = "github.com/org/repo"`)
+	require.ErrorIs(t, err, interfaces.ErrInvalidReference)
+}
+
+func TestModuleSourceRegex(t *testing.T) {
+	t.Parallel()
+
+	re := regexp.MustCompile(ModuleSourceRegex)
+	tests := []struct {
+		line string
+		want string
+	}{
+		{
+			line: `  source = "github.com/org/repo?ref=v1.0.0"`,
+			want: `source = "github.com/org/repo?ref=v1.0.0"`,
+		},
+		{
+			line: `  source  = "git::https://github.com/org/repo.git?depth=1&ref=v1.0.0" # comment`,
+			want: `source  = "git::https://github.com/org/repo.git?depth=1&ref=v1.0.0"`,
+		},
+		{line: `  source  = "hashicorp/consul/aws"`},
+		{line: `  source = "./modules/vpc"`},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, re.FindString(tt.line), tt.line)
+	}
+}
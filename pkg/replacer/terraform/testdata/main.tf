@@ -0,0 +1,37 @@
+terraform {
+  required_version = ">= 1.5.0"
+}
+
+# GitHub shorthand with a subdirectory
+module "vpc" {
+  source = "github.com/example-org/terraform-modules//vpc?ref=v1.2.0"
+
+  cidr_block = "10.0.0.0/16"
+}
+
+# Generic git URL over HTTPS
+module "network" {
+  source  = "git::https://github.com/example-org/terraform-network.git?ref=v2.0.1"
+  subnets = 3
+}
+
+# Generic git URL over SSH, with a shallow clone which can't check out a commit
+module "iam" {
+  source = "git::ssh://git@github.com/example-org/terraform-iam.git?depth=1&ref=v0.9.0"
+}
+
+# Already pinned
+module "dns" {
+  source = "git::https://github.com/example-org/terraform-dns.git?ref=0c52d547c9bc32b1aa3301fd7a9cb496313a4491"
+}
+
+# Not hosted on GitHub
+module "storage" {
+  source = "git::https://gitlab.com/example-org/terraform-storage.git?ref=v1.0.0"
+}
+
+# Registry module, versioned through the version argument
+module "consul" {
+  source  = "hashicorp/consul/aws"
+  version = "0.1.0"
+}
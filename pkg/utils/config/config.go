@@ -17,12 +17,15 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
+	"strings"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
@@ -53,44 +56,281 @@ func FromCommand(cmd *cobra.Command) (*Config, error) {
 	if cmd.Flags().Lookup("platform") != nil {
 		cfg.Platform = cmd.Flag("platform").Value.String()
 	}
+
+	// Only override the gitignore handling if the flag was explicitly passed.
+	if f := cmd.Flags().Lookup("respect-gitignore"); f != nil && f.Changed {
+		cfg.RespectGitignore = f.Value.String() == "true"
+	}
+
+	// Only override the symbolic links handling if the flag was explicitly passed.
+	if f := cmd.Flags().Lookup("follow-symlinks"); f != nil && f.Changed {
+		cfg.FollowSymlinks = f.Value.String() == "true"
+	}
+
+	// Only override the traversal depth if the flag was explicitly passed.
+	if f := cmd.Flags().Lookup("max-depth"); f != nil && f.Changed {
+		depth, err := cmd.Flags().GetInt("max-depth")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get max-depth flag: %w", err)
+		}
+		cfg.MaxDepth = depth
+	}
+
+	// Only override the registry rewriting if the flag was explicitly passed.
+	if f := cmd.Flags().Lookup("rewrite-registry"); f != nil && f.Changed {
+		cfg.Images.RewriteRegistry = f.Value.String() == "true"
+	}
+
+	// Only override the docker:// steps handling if the flag was explicitly passed.
+	if f := cmd.Flags().Lookup("no-docker"); f != nil && f.Changed {
+		cfg.GHActions.SkipDocker = f.Value.String() == "true"
+	}
+
+	// Only override the version resolution if the flag was explicitly passed.
+	if f := cmd.Flags().Lookup("resolve-version"); f != nil && f.Changed {
+		cfg.GHActions.ResolveVersion = f.Value.String() == "true"
+	}
+
+	// Catch a malformed platform before resolving any reference
+	if cfg.Platform != "" {
+		if _, err := ParsePlatform(cfg.Platform); err != nil {
+			return nil, err
+		}
+	}
 	return cfg, nil
 }
 
 // Config is the frizbee configuration.
 type Config struct {
-	Platform  string    `yaml:"platform" mapstructure:"platform"`
-	GHActions GHActions `yaml:"ghactions" mapstructure:"ghactions"`
-	Images    Images    `yaml:"images" mapstructure:"images"`
+	// Extends lists the configuration files, absolute or relative to this one, it's merged
+	// on top of, in order, e.g. the one of the root of a monorepo. See ParseConfigFileFromFS
+	// for the merge semantics. It's resolved while parsing, so it's always empty.
+	Extends  Extends `yaml:"extends" mapstructure:"extends"`
+	Platform string  `yaml:"platform" mapstructure:"platform"`
+	// IncludeExtensions are extensions of additional files to look for references in,
+	// e.g. .yaml.tmpl. YAML files, including the common templated ones, and Dockerfiles
+	// are always traversed. They don't apply to the files of a single kind, e.g. the
+	// Terraform configurations.
+	IncludeExtensions []string `yaml:"include_extensions" mapstructure:"include_extensions"`
+	// RespectGitignore skips the files and directories ignored by the .gitignore files of
+	// the processed directory, along with .git, node_modules and vendor directories.
+	RespectGitignore bool `yaml:"respect_gitignore" mapstructure:"respect_gitignore"`
+	// MaxDepth is how many directory levels below the processed directory are traversed,
+	// e.g. 1 only processes the files directly in it. 0 means no limit.
+	MaxDepth int `yaml:"max_depth" mapstructure:"max_depth"`
+	// FollowSymlinks walks into the directories symbolic links point to, e.g. shared
+	// workflows, which are processed under their real path and only once.
+	FollowSymlinks bool `yaml:"follow_symlinks" mapstructure:"follow_symlinks"`
+	// ExcludePaths are patterns of paths, relative to the processed directory, that are
+	// neither parsed nor listed, e.g. testdata/** or **/fixtures. A directory matching a
+	// pattern excludes everything under it.
+	ExcludePaths []string  `yaml:"exclude_paths" mapstructure:"exclude_paths"`
+	GHActions    GHActions `yaml:"ghactions" mapstructure:"ghactions"`
+	Images       Images    `yaml:"images" mapstructure:"images"`
+	CircleCI     CircleCI  `yaml:"circleci" mapstructure:"circleci"`
+	Terraform    Terraform `yaml:"terraform" mapstructure:"terraform"`
+	PreCommit    PreCommit `yaml:"pre_commit" mapstructure:"pre_commit"`
+}
+
+// Extends is a list of configuration files, which may be given as a single file
+type Extends []string
+
+// UnmarshalYAML accepts a single file as well as a list of files
+func (e *Extends) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*e = Extends{value.Value}
+		return nil
+	}
+	var files []string
+	if err := value.Decode(&files); err != nil {
+		return err
+	}
+	*e = files
+	return nil
+}
+
+// FileError is an error found in a configuration file extended by the parsed one
+type FileError struct {
+	Path string
+	Err  error
+}
+
+// Error implements the error interface
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// Unwrap returns the cause of the error
+func (e *FileError) Unwrap() error {
+	return e.Err
 }
 
 // GHActions is the GitHub Actions configuration.
 type GHActions struct {
 	Filter `yaml:",inline" mapstructure:",inline"`
+	// SkipDocker leaves the docker:// image steps untouched, e.g. when they point at
+	// a mutable development image, while the other actions are still pinned.
+	SkipDocker bool `yaml:"skip_docker" mapstructure:"skip_docker"`
+	// ResolveTagObject pins annotated tags to the checksum of the tag object rather than
+	// the one of the commit it points at, which is the default. Lightweight tags are
+	// pinned to their commit either way.
+	ResolveTagObject bool `yaml:"resolve_tag_object" mapstructure:"resolve_tag_object"`
+	// ResolveVersion records the concrete release a moving tag such as v4 or v4.1 points at,
+	// e.g. v4.1.1, in the comment of the pinned action along with the moving tag itself.
+	ResolveVersion bool `yaml:"resolve_version" mapstructure:"resolve_version"`
+}
+
+// CircleCI is the CircleCI orbs configuration.
+type CircleCI struct {
+	Filter `yaml:",inline" mapstructure:",inline"`
+}
+
+// Terraform is the Terraform and OpenTofu module sources configuration.
+// The patterns are matched against the owner/repo of the module source.
+type Terraform struct {
+	Filter `yaml:",inline" mapstructure:",inline"`
+}
+
+// PreCommit is the pre-commit hook repositories configuration.
+// The patterns are matched against the owner/repo of the hook repository.
+type PreCommit struct {
+	Filter `yaml:",inline" mapstructure:",inline"`
 }
 
 // Filter is a common configuration for filtering out patterns.
 type Filter struct {
-	// Exclude is a list of patterns to exclude.
-	Exclude         []string `yaml:"exclude" mapstructure:"exclude"`
+	// Include is a list of patterns to include. If set, only matching references are processed
+	// and the exclude patterns are applied on top.
+	Include []string `yaml:"include" mapstructure:"include"`
+	// Exclude is a list of patterns to exclude, e.g. actions/checkout or actions/*.
+	Exclude []string `yaml:"exclude" mapstructure:"exclude"`
+	// ExcludeBranches is a list of branches which aren't resolved. Unlike the patterns above,
+	// the branches are matched exactly, * standing for all of them.
 	ExcludeBranches []string `yaml:"exclude_branches" mapstructure:"exclude_branches"`
+	// IncludeBranches is a list of branches to resolve, matched like ExcludeBranches. If set, the
+	// other branches are skipped and ExcludeBranches is ignored, e.g. to only resolve main for
+	// trusted internal actions.
+	IncludeBranches []string `yaml:"include_branches" mapstructure:"include_branches"`
 }
 
 // Images is the image configuration.
 type Images struct {
 	ImageFilter `yaml:",inline" mapstructure:",inline"`
+	// ImageKeys are additional YAML keys referencing container images, e.g. sandbox_image
+	// or initImage. The image key and Dockerfile FROM instructions are always matched.
+	ImageKeys []string `yaml:"image_keys" mapstructure:"image_keys"`
+	// BuildArgKeys are the build args of Compose files holding base images, e.g. BASE_IMAGE,
+	// in both the mapping and the list form of build.args. None are matched by default as
+	// build args usually hold anything but images.
+	BuildArgKeys []string `yaml:"build_arg_keys" mapstructure:"build_arg_keys"`
+	// RegistryMirrors maps registry hosts, e.g. index.docker.io, to the mirror
+	// hosts used to resolve the digests of images hosted on them.
+	RegistryMirrors map[string]string `yaml:"registry_mirrors" mapstructure:"registry_mirrors"`
+	// RewriteRegistry replaces the registry host of pinned images with the mirror host.
+	RewriteRegistry bool `yaml:"rewrite_registry" mapstructure:"rewrite_registry"`
+	// PreserveShortNames keeps the repository of pinned images as written, e.g. golang,
+	// rather than expanding it to its full name, e.g. index.docker.io/library/golang.
+	PreserveShortNames bool `yaml:"preserve_short_names" mapstructure:"preserve_short_names"`
+	// DockerfileTagComment records the tag of pinned Dockerfile FROM instructions in a
+	// "# tag" comment, like the one trailing pinned YAML references. It's written on the
+	// line above the instruction as Dockerfiles don't support trailing comments.
+	DockerfileTagComment bool `yaml:"dockerfile_tag_comment" mapstructure:"dockerfile_tag_comment"`
+	// ResolveConstraints resolves the images whose tag is a version constraint, e.g.
+	// alpine:3.18.*, ^3.18 or ~3.18, to the newest tag satisfying it, e.g. 3.18.5, which
+	// is the tag the pinned image is then recorded with.
+	ResolveConstraints bool `yaml:"resolve_constraints" mapstructure:"resolve_constraints"`
+	// JSONManifests processes .json files as well, pinning the images referenced by the
+	// image keys of JSON documents, e.g. Kubernetes manifests or CloudFormation templates.
+	JSONManifests bool `yaml:"json_manifests" mapstructure:"json_manifests"`
+	// NixImages processes .nix files as well, filling in the imageDigest of the pullImage
+	// calls of Nix dockerTools which have a tag but no digest yet.
+	NixImages bool `yaml:"nix_images" mapstructure:"nix_images"`
 }
 
 // ImageFilter is the image filter configuration.
 type ImageFilter struct {
-	// ExcludeImages is a regex that must match in order for an image to be excluded and not pinned
+	// IncludeImages is a list of patterns that must match in order for an image to be pinned, if set.
+	// The exclude patterns are applied on top.
+	IncludeImages []string `yaml:"include_images" mapstructure:"include_images"`
+	// ExcludeImages is a list of patterns that must match in order for an image to be excluded and not pinned,
+	// e.g. ubuntu, ghcr.io/stacklok/* or */minder
 	ExcludeImages []string `yaml:"exclude_images" mapstructure:"exclude_images"`
 	ExcludeTags   []string `yaml:"exclude_tags" mapstructure:"exclude_tags"`
 }
 
+// MatchAny returns true if the input matches any of the given patterns. Patterns
+// use the path.Match syntax, so a pattern without wildcards must match exactly
+// and * doesn't match across a / separator. Malformed patterns never match.
+func MatchAny(patterns []string, input string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, input); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPath returns true if the slash-separated path matches the pattern. Patterns use
+// the path.Match syntax, extended with ** segments matching any number of directories,
+// e.g. testdata/** or **/fixtures. Malformed patterns never match.
+func MatchPath(pattern, p string) bool {
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(p, "/"))
+}
+
+// MatchAnyPath returns true if the slash-separated path, or any of its parent directories,
+// matches any of the given patterns, see MatchPath.
+func MatchAnyPath(patterns []string, p string) bool {
+	segments := strings.Split(p, "/")
+	for _, pattern := range patterns {
+		patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+		for i := 1; i <= len(segments); i++ {
+			if matchSegments(patternSegments, segments[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSegments matches the path segments against the pattern segments
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	matched, err := path.Match(pattern[0], segments[0])
+	return err == nil && matched && matchSegments(pattern[1:], segments[1:])
+}
+
 // ParseConfigFile parses a configuration file.
 func ParseConfigFile(configfile string) (*Config, error) {
-	bfs := osfs.New(".")
-	return ParseConfigFileFromFS(bfs, configfile)
+	bfs, path, err := RootFS(configfile)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfigFileFromFS(bfs, path)
+}
+
+// RootFS returns the file system of the OS rooted at the root directory of the configuration
+// file, along with its absolute path in it, for the absolute paths of the files it extends
+// to be resolved as such rather than under the current directory
+func RootFS(configfile string) (billy.Filesystem, string, error) {
+	abs, err := filepath.Abs(configfile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve config file path: %w", err)
+	}
+	vol := filepath.VolumeName(abs)
+	return osfs.New(vol + string(filepath.Separator)), abs[len(vol):], nil
 }
 
 // DefaultConfig returns the default configuration.
@@ -107,6 +347,16 @@ func DefaultConfig() *Config {
 				ExcludeTags:   []string{"latest"},
 			},
 		},
+		Terraform: Terraform{
+			Filter: Filter{
+				ExcludeBranches: []string{"main", "master"},
+			},
+		},
+		PreCommit: PreCommit{
+			Filter: Filter{
+				ExcludeBranches: []string{"main", "master"},
+			},
+		},
 	}
 }
 
@@ -128,11 +378,38 @@ func MergeUserConfig(userConfig *Config) *Config {
 	return userConfig
 }
 
+// ParseOption configures how a configuration file is parsed
+type ParseOption func(*parseOptions)
+
+type parseOptions struct {
+	strict bool
+}
+
+// WithStrict makes parsing fail on the keys which aren't known, e.g. misspelled ones
+// such as exclude_image instead of exclude_images, rather than ignoring them
+func WithStrict() ParseOption {
+	return func(o *parseOptions) {
+		o.strict = true
+	}
+}
+
 // ParseConfigFileFromFS parses a configuration file from a filesystem.
-func ParseConfigFileFromFS(fs billy.Filesystem, configfile string) (*Config, error) {
+//
+// A configuration file may extend others through its extends key, e.g. the root
+// configuration of a monorepo, in which case it's deep-merged on top of them, in
+// order: mappings are merged key by key, lists such as exclude, exclude_images or
+// exclude_tags are concatenated with the inherited entries first, and the other
+// values override the inherited ones. An empty value, e.g. "exclude_tags:", resets
+// the inherited one, while an empty list, e.g. "exclude_tags: []", keeps it.
+func ParseConfigFileFromFS(fs billy.Filesystem, configfile string, opts ...ParseOption) (*Config, error) {
+	var o parseOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	cfg := DefaultConfig()
 	cleancfgfile := filepath.Clean(configfile)
-	cfgF, err := fs.Open(cleancfgfile)
+	content, err := readConfigFile(fs, cleancfgfile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return cfg, nil
@@ -140,14 +417,177 @@ func ParseConfigFileFromFS(fs billy.Filesystem, configfile string) (*Config, err
 
 		return nil, fmt.Errorf("failed to open config file: %w", err)
 	}
-	defer cfgF.Close() // nolint:errcheck
 
-	dec := yaml.NewDecoder(cfgF)
-	if err := dec.Decode(cfg); err != nil {
-		if err != io.EOF {
-			return nil, fmt.Errorf("failed to decode config file: %w", err)
+	var own Config
+	if err := decodeConfig(content, &own, o.strict); err != nil {
+		return nil, fmt.Errorf("failed to decode config file: %w", err)
+	}
+	if len(own.Extends) > 0 {
+		merged, err := mergeConfigFiles(fs, cleancfgfile, content, o.strict, nil)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = yaml.Marshal(merged); err != nil {
+			return nil, fmt.Errorf("failed to merge config files: %w", err)
 		}
 	}
 
+	if err := decodeConfig(content, cfg, o.strict); err != nil {
+		return nil, fmt.Errorf("failed to decode config file: %w", err)
+	}
+
 	return cfg, nil
 }
+
+// MergeConfigFileFromFS parses a configuration file from a filesystem like
+// ParseConfigFileFromFS, merged on top of the base configuration rather than the default
+// one, as if it extended it, e.g. for a configuration file found in a subdirectory of a
+// monorepo to refine the root one.
+func MergeConfigFileFromFS(fs billy.Filesystem, configfile string, base *Config) (*Config, error) {
+	cleancfgfile := filepath.Clean(configfile)
+	content, err := readConfigFile(fs, cleancfgfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	doc, err := mergeConfigFiles(fs, cleancfgfile, content, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	baseDoc, err := configDocument(base)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = yaml.Marshal(mergeDocuments(baseDoc, doc)); err != nil {
+		return nil, fmt.Errorf("failed to merge config files: %w", err)
+	}
+
+	cfg := &Config{}
+	if err := decodeConfig(content, cfg, false); err != nil {
+		return nil, fmt.Errorf("failed to decode config file: %w", err)
+	}
+	return cfg, nil
+}
+
+// configDocument returns the YAML document of the configuration, leaving out the empty
+// lists and mappings, which keep the merged values like in a configuration file
+func configDocument(cfg *Config) (map[string]any, error) {
+	content, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	delete(doc, "extends")
+	pruneEmpty(doc)
+	return doc, nil
+}
+
+// pruneEmpty removes the empty lists and mappings from the YAML document, recursively
+func pruneEmpty(doc map[string]any) {
+	for k, v := range doc {
+		switch v := v.(type) {
+		case map[string]any:
+			pruneEmpty(v)
+			if len(v) == 0 {
+				delete(doc, k)
+			}
+		case []any:
+			if len(v) == 0 {
+				delete(doc, k)
+			}
+		}
+	}
+}
+
+// readConfigFile returns the content of the configuration file
+func readConfigFile(fs billy.Filesystem, configfile string) ([]byte, error) {
+	cfgF, err := fs.Open(configfile)
+	if err != nil {
+		return nil, err
+	}
+	defer cfgF.Close() // nolint:errcheck
+
+	return io.ReadAll(cfgF)
+}
+
+// decodeConfig decodes the YAML content into the configuration, an empty content
+// leaving it untouched
+func decodeConfig(content []byte, cfg *Config, strict bool) error {
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(strict)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// mergeConfigFiles returns the YAML document of the configuration file merged on top of
+// the ones it extends, recursively. The files extending each other are given by chain.
+func mergeConfigFiles(
+	fs billy.Filesystem,
+	configfile string,
+	content []byte,
+	strict bool,
+	chain []string,
+) (map[string]any, error) {
+	if slices.Contains(chain, configfile) {
+		return nil, fmt.Errorf("config files extend each other: %s -> %s", strings.Join(chain, " -> "), configfile)
+	}
+	chain = append(chain, configfile)
+
+	// Decode the file on its own first, for errors to point at its lines
+	var own Config
+	if err := decodeConfig(content, &own, strict); err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	delete(doc, "extends")
+
+	merged := map[string]any{}
+	for _, ext := range own.Extends {
+		extPath := ext
+		if !filepath.IsAbs(extPath) {
+			extPath = filepath.Join(filepath.Dir(configfile), ext)
+		}
+		extContent, err := readConfigFile(fs, extPath)
+		if err != nil {
+			return nil, &FileError{Path: extPath, Err: fmt.Errorf("failed to open extended config file: %w", err)}
+		}
+		base, err := mergeConfigFiles(fs, extPath, extContent, strict, chain)
+		if err != nil {
+			var fileErr *FileError
+			if errors.As(err, &fileErr) {
+				return nil, err
+			}
+			return nil, &FileError{Path: extPath, Err: err}
+		}
+		merged = mergeDocuments(merged, base)
+	}
+	return mergeDocuments(merged, doc), nil
+}
+
+// mergeDocuments deep-merges the override YAML document on top of the base one, see
+// ParseConfigFileFromFS for the semantics
+func mergeDocuments(base, override map[string]any) map[string]any {
+	for k, v := range override {
+		switch ov := v.(type) {
+		case map[string]any:
+			if bm, ok := base[k].(map[string]any); ok {
+				base[k] = mergeDocuments(bm, ov)
+				continue
+			}
+		case []any:
+			if bl, ok := base[k].([]any); ok {
+				base[k] = append(bl, ov...)
+				continue
+			}
+		}
+		base[k] = v
+	}
+	return base
+}
@@ -2,9 +2,12 @@ package config
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-git/go-billy/v5/memfs"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
 )
@@ -16,6 +19,12 @@ func TestFromCommand(t *testing.T) {
 		name         string
 		contextCfg   *Config
 		platformFlag string
+		rewriteFlag  string
+		ignoreFlag   string
+		depthFlag    string
+		symlinksFlag string
+		noDockerFlag string
+		versionFlag  string
 		expectedCfg  *Config
 		expectError  bool
 	}{
@@ -35,6 +44,57 @@ func TestFromCommand(t *testing.T) {
 			platformFlag: "windows/arm64",
 			expectedCfg:  &Config{Platform: "windows/arm64"},
 		},
+		{
+			name:         "WithVariantPlatformFlag",
+			contextCfg:   &Config{},
+			platformFlag: "linux/arm/v7",
+			expectedCfg:  &Config{Platform: "linux/arm/v7"},
+		},
+		{
+			name:         "WithInvalidPlatformFlag",
+			contextCfg:   &Config{},
+			platformFlag: "linux-amd64",
+			expectError:  true,
+		},
+		{
+			name:        "WithRewriteRegistryFlag",
+			contextCfg:  &Config{Images: Images{RegistryMirrors: map[string]string{"docker.io": "mirror.local"}}},
+			rewriteFlag: "true",
+			expectedCfg: &Config{Images: Images{
+				RegistryMirrors: map[string]string{"docker.io": "mirror.local"},
+				RewriteRegistry: true,
+			}},
+		},
+		{
+			name:        "WithRespectGitignoreFlag",
+			contextCfg:  &Config{RespectGitignore: true},
+			ignoreFlag:  "false",
+			expectedCfg: &Config{},
+		},
+		{
+			name:        "WithMaxDepthFlag",
+			contextCfg:  &Config{MaxDepth: 5},
+			depthFlag:   "2",
+			expectedCfg: &Config{MaxDepth: 2},
+		},
+		{
+			name:         "WithFollowSymlinksFlag",
+			contextCfg:   &Config{},
+			symlinksFlag: "true",
+			expectedCfg:  &Config{FollowSymlinks: true},
+		},
+		{
+			name:         "WithNoDockerFlag",
+			contextCfg:   &Config{},
+			noDockerFlag: "true",
+			expectedCfg:  &Config{GHActions: GHActions{SkipDocker: true}},
+		},
+		{
+			name:        "WithResolveVersionFlag",
+			contextCfg:  &Config{},
+			versionFlag: "true",
+			expectedCfg: &Config{GHActions: GHActions{ResolveVersion: true}},
+		},
 	}
 
 	for _, tt := range testCases {
@@ -56,6 +116,30 @@ func TestFromCommand(t *testing.T) {
 				cmd.Flags().String("platform", "", "platform")
 				require.NoError(t, cmd.Flags().Set("platform", tt.platformFlag))
 			}
+			if tt.rewriteFlag != "" {
+				cmd.Flags().Bool("rewrite-registry", false, "rewrite registry")
+				require.NoError(t, cmd.Flags().Set("rewrite-registry", tt.rewriteFlag))
+			}
+			if tt.ignoreFlag != "" {
+				cmd.Flags().Bool("respect-gitignore", false, "respect gitignore")
+				require.NoError(t, cmd.Flags().Set("respect-gitignore", tt.ignoreFlag))
+			}
+			if tt.depthFlag != "" {
+				cmd.Flags().Int("max-depth", 0, "max depth")
+				require.NoError(t, cmd.Flags().Set("max-depth", tt.depthFlag))
+			}
+			if tt.symlinksFlag != "" {
+				cmd.Flags().Bool("follow-symlinks", false, "follow symlinks")
+				require.NoError(t, cmd.Flags().Set("follow-symlinks", tt.symlinksFlag))
+			}
+			if tt.noDockerFlag != "" {
+				cmd.Flags().Bool("no-docker", false, "skip docker steps")
+				require.NoError(t, cmd.Flags().Set("no-docker", tt.noDockerFlag))
+			}
+			if tt.versionFlag != "" {
+				cmd.Flags().Bool("resolve-version", false, "resolve version")
+				require.NoError(t, cmd.Flags().Set("resolve-version", tt.versionFlag))
+			}
 
 			cfg, err := FromCommand(cmd)
 			if tt.expectError {
@@ -69,6 +153,48 @@ func TestFromCommand(t *testing.T) {
 	}
 }
 
+func TestParsePlatform(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		platform    string
+		expected    *v1.Platform
+		errContains string
+	}{
+		{platform: "linux/amd64", expected: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+		{platform: "linux/arm/v7", expected: &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{platform: "linux/arm64/v8", expected: &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{platform: "windows/amd64", expected: &v1.Platform{OS: "windows", Architecture: "amd64"}},
+		{platform: "", errContains: "expected os/arch[/variant]"},
+		{platform: "linux", errContains: "expected os/arch[/variant]"},
+		{platform: "linux/", errContains: "expected os/arch[/variant]"},
+		{platform: "linux//v7", errContains: "expected os/arch[/variant]"},
+		{platform: "linux/arm/v7/extra", errContains: "expected os/arch[/variant]"},
+		{platform: "linx/amd64", errContains: "unknown os linx, expected one of aix, android"},
+		{platform: "linux/x86_64", errContains: "unknown architecture x86_64 for linux, expected one of 386, amd64"},
+		{platform: "darwin/s390x", errContains: "unknown architecture s390x for darwin"},
+		{platform: "linux/arm/7", errContains: "invalid variant 7"},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.platform, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParsePlatform(tt.platform)
+			if tt.errContains != "" {
+				require.ErrorIs(t, err, ErrInvalidPlatform)
+				require.ErrorContains(t, err, tt.errContains)
+				require.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, got)
+		})
+	}
+}
+
 func TestParseConfigFile(t *testing.T) {
 	t.Parallel()
 
@@ -148,6 +274,98 @@ images:
 				},
 			},
 		},
+		{
+			name:     "RegistryMirrors",
+			fileName: "mirrors.yaml",
+			fsContent: map[string]string{
+				"mirrors.yaml": `
+images:
+  registry_mirrors:
+    index.docker.io: mirror.example.com
+    ghcr.io: ghcr-mirror.example.com:5000
+  rewrite_registry: true
+  preserve_short_names: true
+`,
+			},
+			expectedResult: &Config{
+				GHActions: GHActions{
+					Filter: Filter{
+						ExcludeBranches: []string{"main", "master"},
+					},
+				},
+				Images: Images{
+					ImageFilter: ImageFilter{
+						ExcludeImages: []string{"scratch"},
+						ExcludeTags:   []string{"latest"},
+					},
+					RegistryMirrors: map[string]string{
+						"index.docker.io": "mirror.example.com",
+						"ghcr.io":         "ghcr-mirror.example.com:5000",
+					},
+					RewriteRegistry:    true,
+					PreserveShortNames: true,
+				},
+			},
+		},
+		{
+			name:     "IncludeLists",
+			fileName: "include.yaml",
+			fsContent: map[string]string{
+				"include.yaml": `
+ghactions:
+  include:
+    - actions/*
+  exclude:
+    - actions/cache
+images:
+  include_images:
+    - ghcr.io/stacklok/*
+`,
+			},
+			expectedResult: &Config{
+				GHActions: GHActions{
+					Filter: Filter{
+						Include:         []string{"actions/*"},
+						Exclude:         []string{"actions/cache"},
+						ExcludeBranches: []string{"main", "master"},
+					},
+				},
+				Images: Images{
+					ImageFilter: ImageFilter{
+						IncludeImages: []string{"ghcr.io/stacklok/*"},
+						ExcludeImages: []string{"scratch"},
+						ExcludeTags:   []string{"latest"},
+					},
+				},
+			},
+		},
+		{
+			name:     "ImageOptions",
+			fileName: "comment.yaml",
+			fsContent: map[string]string{
+				"comment.yaml": `
+images:
+  dockerfile_tag_comment: true
+  image_keys:
+    - sandbox_image
+`,
+			},
+			expectedResult: &Config{
+				GHActions: GHActions{
+					Filter: Filter{
+						ExcludeBranches: []string{"main", "master"},
+					},
+				},
+				Images: Images{
+					ImageFilter: ImageFilter{
+						ExcludeImages: []string{"scratch"},
+						ExcludeTags:   []string{"latest"},
+					},
+					ImageKeys:            []string{"sandbox_image"},
+					DockerfileTagComment: true,
+				},
+			},
+		},
 		{
 			name:           "EmptyFile",
 			fileName:       "empty.yaml",
@@ -187,7 +405,268 @@ images:
 				if cfg.GHActions.ExcludeBranches != nil {
 					require.Equal(t, tt.expectedResult.GHActions.ExcludeBranches, cfg.GHActions.ExcludeBranches)
 				}
+				require.Equal(t, tt.expectedResult.GHActions.Include, cfg.GHActions.Include)
+				require.Equal(t, tt.expectedResult.Images.IncludeImages, cfg.Images.IncludeImages)
+				require.Equal(t, tt.expectedResult.Images.RegistryMirrors, cfg.Images.RegistryMirrors)
+				require.Equal(t, tt.expectedResult.Images.RewriteRegistry, cfg.Images.RewriteRegistry)
+				require.Equal(t, tt.expectedResult.Images.PreserveShortNames, cfg.Images.PreserveShortNames)
+				require.Equal(t, tt.expectedResult.Images.DockerfileTagComment, cfg.Images.DockerfileTagComment)
+				require.Equal(t, tt.expectedResult.Images.ImageKeys, cfg.Images.ImageKeys)
 			}
 		})
 	}
 }
+
+func TestMatchAny(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		patterns []string
+		input    string
+		expected bool
+	}{
+		{name: "NoPatterns", input: "actions/checkout"},
+		{name: "ExactMatch", patterns: []string{"actions/checkout"}, input: "actions/checkout", expected: true},
+		{name: "ExactMismatch", patterns: []string{"actions/checkout"}, input: "actions/cache"},
+		{name: "OwnerWildcard", patterns: []string{"actions/*"}, input: "actions/cache", expected: true},
+		{name: "NameWildcard", patterns: []string{"*/trivy-action"}, input: "aquasecurity/trivy-action", expected: true},
+		{name: "WildcardDoesNotCrossSeparator", patterns: []string{"actions/*"}, input: "actions/cache/save"},
+		{name: "MalformedPattern", patterns: []string{"[actions"}, input: "[actions"},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.expected, MatchAny(tt.patterns, tt.input))
+		})
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		pattern  string
+		input    string
+		expected bool
+	}{
+		{name: "ExactMatch", pattern: "testdata/ci.yml", input: "testdata/ci.yml", expected: true},
+		{name: "Wildcard", pattern: "testdata/*.yml", input: "testdata/ci.yml", expected: true},
+		{name: "WildcardDoesNotCrossSeparator", pattern: "testdata/*", input: "testdata/nested/ci.yml"},
+		{name: "TrailingDoubleStar", pattern: "testdata/**", input: "testdata/nested/ci.yml", expected: true},
+		{name: "LeadingDoubleStar", pattern: "**/fixtures/*.yaml", input: "a/b/fixtures/app.yaml", expected: true},
+		{name: "LeadingDoubleStarAtRoot", pattern: "**/fixtures/*.yaml", input: "fixtures/app.yaml", expected: true},
+		{name: "MiddleDoubleStar", pattern: "charts/**/values.yaml", input: "charts/app/values.yaml", expected: true},
+		{name: "Mismatch", pattern: "testdata/**", input: "deploy/testdata.yml"},
+		{name: "MalformedPattern", pattern: "[testdata/**", input: "[testdata/ci.yml"},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.expected, MatchPath(tt.pattern, tt.input))
+		})
+	}
+}
+
+func TestMatchAnyPath(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		patterns []string
+		input    string
+		expected bool
+	}{
+		{name: "NoPatterns", input: "testdata/ci.yml"},
+		{name: "File", patterns: []string{"deploy/*.yaml"}, input: "deploy/app.yaml", expected: true},
+		{name: "ParentDirectory", patterns: []string{"testdata"}, input: "testdata/nested/ci.yml", expected: true},
+		{name: "NestedDirectory", patterns: []string{"**/testdata"}, input: "pkg/testdata/ci.yml", expected: true},
+		{name: "NotAParent", patterns: []string{"testdata"}, input: "pkg/testdata/ci.yml"},
+		{name: "AnyPattern", patterns: []string{"docs/**", "testdata/**"}, input: "testdata/ci.yml", expected: true},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.expected, MatchAnyPath(tt.patterns, tt.input))
+		})
+	}
+}
+
+func TestParseConfigFileExtends(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{
+		// The organization wide configuration at the root of the monorepo
+		".frizbee.yml": `platform: linux/amd64
+ghactions:
+  exclude:
+    - my-org/*
+images:
+  exclude_images:
+    - busybox
+  exclude_tags:
+    - latest
+    - dev
+  registry_mirrors:
+    index.docker.io: mirror.example.com
+`,
+		"team/.frizbee.yml": `extends: ../.frizbee.yml
+platform: linux/arm64
+ghactions:
+  exclude:
+    - actions/cache
+images:
+  exclude_tags:
+    - nightly
+`,
+		"team/svc/.frizbee.yml": `extends: ../.frizbee.yml
+ghactions:
+  exclude_branches:
+images:
+  exclude_images:
+    - alpine
+  exclude_tags: []
+  registry_mirrors:
+    ghcr.io: ghcr-mirror.example.com
+`,
+		"shared/go.yml": `images:
+  exclude_images:
+    - golang
+`,
+		"shared/node.yml": `images:
+  exclude_images:
+    - node
+  dockerfile_tag_comment: true
+`,
+		"app/.frizbee.yml": `extends:
+  - ../shared/go.yml
+  - ../shared/node.yml
+images:
+  dockerfile_tag_comment: false
+`,
+		"cycle/a.yml":          "extends: b.yml\n",
+		"cycle/b.yml":          "extends: a.yml\n",
+		"missing/.frizbee.yml": "extends: ../nowhere.yml\n",
+		"typo/.frizbee.yml":    "extends: ../typo.yml\n",
+		"typo.yml":             "images:\n  exclude_image:\n    - busybox\n",
+	}
+	fs := memfs.New()
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// Two levels of inheritance, lists are concatenated and scalars overridden
+	cfg, err := ParseConfigFileFromFS(fs, "team/svc/.frizbee.yml")
+	require.NoError(t, err)
+	require.Empty(t, cfg.Extends)
+	require.Equal(t, "linux/arm64", cfg.Platform)
+	require.Equal(t, []string{"my-org/*", "actions/cache"}, cfg.GHActions.Exclude)
+	require.Empty(t, cfg.GHActions.ExcludeBranches, "an empty value resets the inherited one")
+	require.Equal(t, []string{"busybox", "alpine"}, cfg.Images.ExcludeImages)
+	require.Equal(t, []string{"latest", "dev", "nightly"}, cfg.Images.ExcludeTags, "an empty list keeps the inherited one")
+	require.Equal(t, map[string]string{
+		"index.docker.io": "mirror.example.com",
+		"ghcr.io":         "ghcr-mirror.example.com",
+	}, cfg.Images.RegistryMirrors)
+	// The defaults still apply to what no file sets
+	require.Equal(t, []string{"main", "master"}, cfg.Terraform.ExcludeBranches)
+
+	// Several files are merged in order
+	cfg, err = ParseConfigFileFromFS(fs, "app/.frizbee.yml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"golang", "node"}, cfg.Images.ExcludeImages)
+	require.False(t, cfg.Images.DockerfileTagComment)
+
+	_, err = ParseConfigFileFromFS(fs, "cycle/a.yml")
+	require.ErrorContains(t, err, "config files extend each other: cycle/a.yml -> cycle/b.yml -> cycle/a.yml")
+
+	_, err = ParseConfigFileFromFS(fs, "missing/.frizbee.yml")
+	var fileErr *FileError
+	require.ErrorAs(t, err, &fileErr)
+	require.Equal(t, "nowhere.yml", fileErr.Path)
+
+	// The unknown keys of extended files are only rejected when parsing strictly
+	_, err = ParseConfigFileFromFS(fs, "typo/.frizbee.yml")
+	require.NoError(t, err)
+	_, err = ParseConfigFileFromFS(fs, "typo/.frizbee.yml", WithStrict())
+	require.ErrorAs(t, err, &fileErr)
+	require.Equal(t, "typo.yml", fileErr.Path)
+}
+
+func TestParseConfigFileExtendsAbsolute(t *testing.T) {
+	t.Parallel()
+
+	// The organization wide configuration lives outside of the repository
+	orgDir := t.TempDir()
+	org := filepath.Join(orgDir, "frizbee.yml")
+	require.NoError(t, os.WriteFile(org, []byte("images:\n  exclude_images:\n    - busybox\n"), 0600))
+
+	repoDir := t.TempDir()
+	repo := filepath.Join(repoDir, ".frizbee.yml")
+	require.NoError(t, os.WriteFile(repo, []byte("extends: "+org+"\nimages:\n  exclude_images:\n    - alpine\n"), 0600))
+
+	cfg, err := ParseConfigFile(repo)
+	require.NoError(t, err)
+	require.Equal(t, []string{"busybox", "alpine"}, cfg.Images.ExcludeImages)
+}
+
+func TestMergeConfigFileFromFS(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	files := map[string]string{
+		"svc/.frizbee.yml": `extends: ../shared.yml
+platform: linux/arm64
+ghactions:
+  exclude:
+    - actions/cache
+images:
+  exclude_tags:
+`,
+		"shared.yml": "images:\n  exclude_images:\n    - node\n",
+	}
+	for name, content := range files {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	base := DefaultConfig()
+	base.Platform = "linux/amd64"
+	base.MaxDepth = 3
+	base.GHActions.Exclude = []string{"my-org/*"}
+	base.Images.ExcludeTags = []string{"latest"}
+	base.Images.RegistryMirrors = map[string]string{"index.docker.io": "mirror.example.com"}
+
+	// The file is merged on top of the base configuration, then of the files it extends
+	cfg, err := MergeConfigFileFromFS(fs, "svc/.frizbee.yml", base)
+	require.NoError(t, err)
+	require.Equal(t, "linux/arm64", cfg.Platform)
+	require.Equal(t, 3, cfg.MaxDepth)
+	require.Equal(t, []string{"my-org/*", "actions/cache"}, cfg.GHActions.Exclude)
+	require.Equal(t, []string{"main", "master"}, cfg.GHActions.ExcludeBranches)
+	require.Equal(t, []string{"scratch", "node"}, cfg.Images.ExcludeImages)
+	require.Empty(t, cfg.Images.ExcludeTags, "an empty value resets the base one")
+	require.Equal(t, map[string]string{"index.docker.io": "mirror.example.com"}, cfg.Images.RegistryMirrors)
+	require.Nil(t, cfg.IncludeExtensions, "the empty lists of the base configuration are left out")
+	require.Equal(t, "linux/amd64", base.Platform, "the base configuration is left untouched")
+
+	_, err = MergeConfigFileFromFS(fs, "missing/.frizbee.yml", base)
+	require.ErrorContains(t, err, "failed to open config file")
+}
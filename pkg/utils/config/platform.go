@@ -0,0 +1,98 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ErrInvalidPlatform is returned when a platform isn't a known os/arch[/variant] combination
+var ErrInvalidPlatform = errors.New("invalid platform")
+
+// knownPlatforms returns the os/arch combinations container images are built for, i.e.
+// the ones listed by the OCI image index specification
+func knownPlatforms() map[string][]string {
+	return map[string][]string{
+		"aix":       {"ppc64"},
+		"android":   {"386", "amd64", "arm", "arm64"},
+		"darwin":    {"amd64", "arm64"},
+		"dragonfly": {"amd64"},
+		"freebsd":   {"386", "amd64", "arm", "arm64", "riscv64"},
+		"illumos":   {"amd64"},
+		"ios":       {"arm64"},
+		"js":        {"wasm"},
+		"linux": {
+			"386", "amd64", "arm", "arm64", "loong64", "mips", "mipsle", "mips64", "mips64le",
+			"ppc64", "ppc64le", "riscv64", "s390x",
+		},
+		"netbsd":  {"386", "amd64", "arm", "arm64"},
+		"openbsd": {"386", "amd64", "arm", "arm64"},
+		"plan9":   {"386", "amd64", "arm"},
+		"solaris": {"amd64"},
+		"wasip1":  {"wasm"},
+		"windows": {"386", "amd64", "arm", "arm64"},
+	}
+}
+
+// variantRegex matches the CPU variants of architectures, e.g. v7 for arm or v8.2 for arm64
+var variantRegex = regexp.MustCompile(`^v\d+(\.\d+)?$`)
+
+// ParsePlatform parses and validates a platform in the os/arch[/variant] format,
+// e.g. linux/amd64 or linux/arm/v7
+func ParsePlatform(platform string) (*v1.Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return nil, fmt.Errorf("%w %q, expected os/arch[/variant], e.g. linux/amd64 or linux/arm/v7",
+			ErrInvalidPlatform, platform)
+	}
+
+	p := &v1.Platform{OS: parts[0], Architecture: parts[1]}
+	archs, ok := knownPlatforms()[p.OS]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, unknown os %s, expected one of %s",
+			ErrInvalidPlatform, platform, p.OS, strings.Join(knownOSes(), ", "))
+	}
+	if !slices.Contains(archs, p.Architecture) {
+		return nil, fmt.Errorf("%w %q, unknown architecture %s for %s, expected one of %s",
+			ErrInvalidPlatform, platform, p.Architecture, p.OS, strings.Join(archs, ", "))
+	}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+		if !variantRegex.MatchString(p.Variant) {
+			return nil, fmt.Errorf("%w %q, invalid variant %s, expected e.g. v7 or v8",
+				ErrInvalidPlatform, platform, p.Variant)
+		}
+	}
+
+	return p, nil
+}
+
+// knownOSes returns the sorted operating systems of the known platforms
+func knownOSes() []string {
+	platforms := knownPlatforms()
+	oses := make([]string, 0, len(platforms))
+	for os := range platforms {
+		oses = append(oses, os)
+	}
+	slices.Sort(oses)
+	return oses
+}
@@ -0,0 +1,175 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	_ "embed" // embeds the JSON schema
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// schema is the JSON schema of the configuration file
+//
+//go:embed schema.json
+var schema []byte
+
+// Schema returns the JSON schema of the configuration file, e.g. for editors to
+// complete and check it
+func Schema() []byte {
+	return schema
+}
+
+// lineRegex matches the line number the YAML decoder prefixes its errors with
+var lineRegex = regexp.MustCompile(`line (\d+): (.*)`)
+
+// Problem is a problem found in a configuration file
+type Problem struct {
+	// File is the path of the extended configuration file the problem was found in,
+	// empty if it was found in the validated one
+	File string
+	// Line is the 1-based line number, 0 if the problem isn't tied to a line
+	Line int
+	// Source is the content of the line, for context
+	Source  string
+	Message string
+}
+
+// ValidationError lists the problems found in a configuration file
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "found %d problem(s) in the config file:", len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n  ")
+		if p.File != "" {
+			b.WriteString(p.File + ": ")
+		}
+		if p.Line == 0 {
+			b.WriteString(p.Message)
+			continue
+		}
+		fmt.Fprintf(&b, "line %d: %s\n    %s", p.Line, p.Message, strings.TrimSpace(p.Source))
+	}
+	return b.String()
+}
+
+// Validate returns an error if any of the configuration values is invalid
+func (c *Config) Validate() error {
+	if c.Platform != "" {
+		if _, err := ParsePlatform(c.Platform); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateConfigFileFromFS parses the configuration file strictly, i.e. failing on unknown
+// keys, and validates its values. The problems found are returned as a ValidationError,
+// along with the line they were found at. Unlike ParseConfigFileFromFS, a missing file is
+// an error.
+func ValidateConfigFileFromFS(fs billy.Filesystem, configfile string) error {
+	content, err := readConfigFile(fs, filepath.Clean(configfile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("config file %s not found", configfile)
+		}
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	lines := strings.Split(string(content), "\n")
+
+	cfg, err := ParseConfigFileFromFS(fs, configfile, WithStrict())
+	var fileErr *FileError
+	if errors.As(err, &fileErr) {
+		// The problems of an extended file point at its own lines
+		var extLines []string
+		if extContent, err := readConfigFile(fs, fileErr.Path); err == nil {
+			extLines = strings.Split(string(extContent), "\n")
+		}
+		problems := decodeProblems(fileErr.Err, extLines)
+		for i := range problems {
+			problems[i].File = fileErr.Path
+		}
+		return &ValidationError{Problems: problems}
+	}
+	if err != nil {
+		return &ValidationError{Problems: decodeProblems(err, lines)}
+	}
+	if err := cfg.Validate(); err != nil {
+		line := keyLine(content, "platform")
+		return &ValidationError{Problems: []Problem{newProblem(line, err.Error(), lines)}}
+	}
+	return nil
+}
+
+// decodeProblems splits the error of the YAML decoder into one problem per line it
+// reports, e.g. every unknown key
+func decodeProblems(err error, lines []string) []Problem {
+	var messages []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+
+	problems := make([]Problem, 0, len(messages))
+	for _, msg := range messages {
+		m := lineRegex.FindStringSubmatch(msg)
+		if m == nil {
+			problems = append(problems, Problem{Message: msg})
+			continue
+		}
+		line, _ := strconv.Atoi(m[1])
+		problems = append(problems, newProblem(line, m[2], lines))
+	}
+	return problems
+}
+
+// newProblem returns the problem found at the given line, along with its content
+func newProblem(line int, message string, lines []string) Problem {
+	p := Problem{Line: line, Message: message}
+	if line > 0 && line <= len(lines) {
+		p.Source = lines[line-1]
+	}
+	return p
+}
+
+// keyLine returns the line of the given top-level key of the YAML document, or 0
+func keyLine(content []byte, key string) int {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil || len(doc.Content) == 0 {
+		return 0
+	}
+	mapping := doc.Content[0]
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i].Line
+		}
+	}
+	return 0
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfigFileFromFS(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		content          string
+		expectedProblems []Problem
+		expectError      bool
+	}{
+		{
+			name: "Valid",
+			content: `platform: linux/arm64
+images:
+  exclude_images:
+    - busybox
+`,
+		},
+		{
+			name: "MisspelledKey",
+			content: `images:
+  exclude_image:
+    - busybox
+  exclude_tags:
+    - latest
+`,
+			expectedProblems: []Problem{{
+				Line:    2,
+				Source:  "  exclude_image:",
+				Message: "field exclude_image not found in type config.Images",
+			}},
+		},
+		{
+			name: "SeveralUnknownKeys",
+			content: `ghaction:
+  exclude:
+    - actions/checkout
+images:
+  rewrite_registries: true
+`,
+			expectedProblems: []Problem{
+				{Line: 1, Source: "ghaction:", Message: "field ghaction not found in type config.Config"},
+				{Line: 5, Source: "  rewrite_registries: true", Message: "field rewrite_registries not found in type config.Images"},
+			},
+		},
+		{
+			name: "InvalidPlatform",
+			content: `images:
+  exclude_tags: []
+platform: linux/amd65
+`,
+			expectedProblems: []Problem{{
+				Line:   3,
+				Source: "platform: linux/amd65",
+				Message: `invalid platform "linux/amd65", unknown architecture amd65 for linux, expected one of ` +
+					"386, amd64, arm, arm64, loong64, mips, mipsle, mips64, mips64le, ppc64, ppc64le, riscv64, s390x",
+			}},
+		},
+		{
+			name:        "NotFound",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := memfs.New()
+			if tt.content != "" {
+				f, err := fs.Create(".frizbee.yml")
+				require.NoError(t, err)
+				_, err = f.Write([]byte(tt.content))
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+			}
+
+			err := ValidateConfigFileFromFS(fs, ".frizbee.yml")
+			switch {
+			case tt.expectError:
+				require.Error(t, err)
+			case tt.expectedProblems == nil:
+				require.NoError(t, err)
+			default:
+				var validationErr *ValidationError
+				require.ErrorAs(t, err, &validationErr)
+				require.Equal(t, tt.expectedProblems, validationErr.Problems)
+			}
+		})
+	}
+}
+
+func TestValidateConfigFileFromFSExtends(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	for name, content := range map[string]string{
+		".frizbee.yml":     "images:\n  exclude_tags:\n    - latest\n  exclude_image:\n    - busybox\n",
+		"svc/.frizbee.yml": "extends: ../.frizbee.yml\nimages:\n  exclude_tags:\n    - dev\n",
+	} {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// The problem is reported at the line of the extended file
+	err := ValidateConfigFileFromFS(fs, "svc/.frizbee.yml")
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Equal(t, []Problem{{
+		File:    ".frizbee.yml",
+		Line:    4,
+		Source:  "  exclude_image:",
+		Message: "field exclude_image not found in type config.Images",
+	}}, validationErr.Problems)
+}
+
+func TestParseConfigFileStrict(t *testing.T) {
+	t.Parallel()
+
+	fs := memfs.New()
+	f, err := fs.Create(".frizbee.yml")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("images:\n  exclude_image:\n    - busybox\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The misspelled key is ignored unless parsing strictly
+	cfg, err := ParseConfigFileFromFS(fs, ".frizbee.yml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"scratch"}, cfg.Images.ExcludeImages)
+
+	_, err = ParseConfigFileFromFS(fs, ".frizbee.yml", WithStrict())
+	require.ErrorContains(t, err, "field exclude_image not found")
+}
+
+// yamlKeys returns the YAML keys of the struct type, including the ones of inlined structs
+func yamlKeys(typ reflect.Type) map[string]reflect.Type {
+	keys := map[string]reflect.Type{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if opts == "inline" {
+			for k, v := range yamlKeys(field.Type) {
+				keys[k] = v
+			}
+			continue
+		}
+		keys[name] = field.Type
+	}
+	return keys
+}
+
+// schemaObject returns the object schema, following its reference if any
+func schemaObject(t *testing.T, root, obj map[string]any) map[string]any {
+	t.Helper()
+	ref, ok := obj["$ref"].(string)
+	if !ok {
+		return obj
+	}
+	def, ok := root["$defs"].(map[string]any)[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+	require.True(t, ok, "unknown reference %s", ref)
+	return schemaObject(t, root, def)
+}
+
+// requireSchemaMatches checks the properties of the object schema are the YAML keys of the struct type
+func requireSchemaMatches(t *testing.T, root, obj map[string]any, typ reflect.Type) {
+	t.Helper()
+	obj = schemaObject(t, root, obj)
+	require.Equal(t, false, obj["additionalProperties"], "unknown %s keys should be rejected", typ.Name())
+	props, ok := obj["properties"].(map[string]any)
+	require.True(t, ok)
+
+	keys := yamlKeys(typ)
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	propNames := make([]string, 0, len(props))
+	for k := range props {
+		propNames = append(propNames, k)
+	}
+	require.ElementsMatch(t, names, propNames, "the schema of %s should list its keys", typ.Name())
+
+	for k, fieldType := range keys {
+		if fieldType.Kind() == reflect.Struct {
+			requireSchemaMatches(t, root, props[k].(map[string]any), fieldType)
+			continue
+		}
+		prop := schemaObject(t, root, props[k].(map[string]any))
+		require.Contains(t, schemaTypes(prop), jsonTypes[fieldType.Kind()],
+			"the schema of %s.%s should accept a %s", typ.Name(), k, fieldType)
+	}
+}
+
+// jsonTypes maps the kinds of the configuration fields to the JSON types they're decoded from
+var jsonTypes = map[reflect.Kind]string{
+	reflect.Bool:   "boolean",
+	reflect.Int:    "integer",
+	reflect.String: "string",
+	reflect.Slice:  "array",
+	reflect.Map:    "object",
+}
+
+// schemaTypes returns the JSON types the property schema accepts, including the ones of
+// its oneOf alternatives
+func schemaTypes(prop map[string]any) []string {
+	var types []string
+	switch typ := prop["type"].(type) {
+	case string:
+		types = append(types, typ)
+	case []any:
+		for _, t := range typ {
+			types = append(types, t.(string))
+		}
+	}
+	alternatives, _ := prop["oneOf"].([]any)
+	for _, alt := range alternatives {
+		types = append(types, schemaTypes(alt.(map[string]any))...)
+	}
+	return types
+}
+
+func TestSchema(t *testing.T) {
+	t.Parallel()
+
+	var root map[string]any
+	require.NoError(t, json.Unmarshal(Schema(), &root))
+	requireSchemaMatches(t, root, root, reflect.TypeOf(Config{}))
+
+	// The branches are matched exactly rather than as patterns
+	defs := root["$defs"].(map[string]any)
+	for _, name := range []string{"exclude_branches", "include_branches"} {
+		require.Equal(t, "#/$defs/branches", defs[name].(map[string]any)["$ref"], name)
+	}
+}
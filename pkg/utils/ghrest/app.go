@@ -0,0 +1,203 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghrest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v66/github"
+)
+
+const (
+	// appJWTLifetime is how long the JWTs authenticating as the app are valid for,
+	// GitHub accepts at most 10 minutes
+	appJWTLifetime = 9 * time.Minute
+	// appClockDrift is how far back the JWTs are issued, to allow for the clock of
+	// GitHub running behind
+	appClockDrift = time.Minute
+	// tokenRefreshMargin is how long before its expiry an installation token is renewed
+	tokenRefreshMargin = time.Minute
+)
+
+// ErrInvalidPrivateKey is returned when the private key of a GitHub App can't be parsed
+var ErrInvalidPrivateKey = errors.New("invalid GitHub App private key")
+
+// NewAppClient creates a new instance of GhRest authenticated as the given installation
+// of a GitHub App, i.e. with an installation token renewed as needed and the rate limits
+// of the app. The private key is the PEM encoded key generated for the app.
+func NewAppClient(appID, installationID int64, privateKeyPEM []byte) (*Client, error) {
+	key, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	ghcli := github.NewClient(nil)
+	app := &appTransport{
+		base:           http.DefaultTransport,
+		baseURL:        ghcli.BaseURL,
+		appID:          appID,
+		installationID: installationID,
+		key:            key,
+	}
+	return &Client{
+		client: github.NewClient(&http.Client{Transport: app}),
+		app:    app,
+	}, nil
+}
+
+// appTransport authenticates requests as an installation of a GitHub App, exchanging
+// a JWT signed with the private key of the app for an installation token
+type appTransport struct {
+	base           http.RoundTripper
+	baseURL        *url.URL
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// withBaseURL returns a copy of the transport requesting installation tokens from the
+// API at the given base URL
+func (t *appTransport) withBaseURL(baseURL *url.URL) *appTransport {
+	return &appTransport{
+		base:           t.base,
+		baseURL:        baseURL,
+		appID:          t.appID,
+		installationID: t.installationID,
+		key:            t.key,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.installationToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// A RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
+
+// installationToken returns the current installation token, requesting a new one if
+// it's missing or about to expire
+func (t *appTransport) installationToken(req *http.Request) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.token != "" && now.Add(tokenRefreshMargin).Before(t.expiresAt) {
+		return t.token, nil
+	}
+
+	jwt, err := t.signJWT(now)
+	if err != nil {
+		return "", err
+	}
+	tokenURL := t.baseURL.JoinPath("app", "installations", strconv.FormatInt(t.installationID, 10), "access_tokens")
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	tokenReq.Header.Set("Accept", "application/vnd.github+json")
+	tokenReq.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := t.base.RoundTrip(tokenReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to request an installation token: %w", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to request an installation token for installation %d: %s: %s",
+			t.installationID, resp.Status, body)
+	}
+
+	var res struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("failed to decode the installation token: %w", err)
+	}
+	t.token, t.expiresAt = res.Token, res.ExpiresAt
+	return t.token, nil
+}
+
+// signJWT returns a JWT authenticating as the app, signed with its private key
+func (t *appTransport) signJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-appClockDrift).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": strconv.FormatInt(t.appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the GitHub App JWT: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parsePrivateKey parses the PEM encoded RSA private key of a GitHub App, GitHub
+// generates PKCS#1 keys but PKCS#8 ones are accepted as well
+func parsePrivateKey(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data found", ErrInvalidPrivateKey)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPrivateKey, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: not an RSA key", ErrInvalidPrivateKey)
+	}
+	return rsaKey, nil
+}
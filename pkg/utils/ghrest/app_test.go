@@ -0,0 +1,128 @@
+package ghrest
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAppClient(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	var exchanges atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/app/installations/42/access_tokens":
+			exchanges.Add(1)
+			require.Equal(t, http.MethodPost, r.Method)
+			jwt, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			require.True(t, ok)
+			verifyJWT(t, &key.PublicKey, jwt, "7")
+
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"token": "ghs_installation", "expires_at": "` +
+				time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`))
+		case "/api/v3/repos/o/r/git/refs/tags/v1":
+			require.Equal(t, "Bearer ghs_installation", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte(`{"object": {"sha": "abc", "type": "commit"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := NewAppClient(7, 42, keyPEM)
+	require.NoError(t, err)
+	// Don't go through http.DefaultTransport, which gock swaps in other tests
+	client.app.base = srv.Client().Transport
+	client, err = client.WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		req, err := client.NewRequest(http.MethodGet, "repos/o/r/git/refs/tags/v1", nil)
+		require.NoError(t, err)
+		resp, err := client.Do(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	}
+	require.Equal(t, int32(1), exchanges.Load(), "the installation token is reused until it expires")
+}
+
+func TestNewAppClientTokenError(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"message": "A JSON web token could not be decoded"}`, http.StatusUnauthorized)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := NewAppClient(7, 42, keyPEM)
+	require.NoError(t, err)
+	// Don't go through http.DefaultTransport, which gock swaps in other tests
+	client.app.base = srv.Client().Transport
+	client, err = client.WithBaseURL(srv.URL)
+	require.NoError(t, err)
+
+	req, err := client.NewRequest(http.MethodGet, "repos/o/r/git/refs/tags/v1", nil)
+	require.NoError(t, err)
+	_, err = client.Do(context.Background(), req)
+	require.ErrorContains(t, err, "failed to request an installation token for installation 42: 401")
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	t.Parallel()
+
+	_, err := parsePrivateKey([]byte("not a key"))
+	require.ErrorIs(t, err, ErrInvalidPrivateKey)
+
+	_, err = parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("garbage")}))
+	require.ErrorIs(t, err, ErrInvalidPrivateKey)
+}
+
+// verifyJWT checks the signature and the issuer of the JWT
+func verifyJWT(t *testing.T, pub *rsa.PublicKey, jwt, issuer string) {
+	t.Helper()
+
+	parts := strings.Split(jwt, ".")
+	require.Len(t, parts, 3)
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig))
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	require.Equal(t, issuer, claims.Iss)
+	require.LessOrEqual(t, claims.Exp-claims.Iat, int64(10*time.Minute/time.Second))
+}
@@ -19,18 +19,62 @@ package ghrest
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/go-github/v66/github"
+
+	"github.com/stacklok/frizbee/pkg/interfaces"
+	"github.com/stacklok/frizbee/pkg/utils/retry"
 )
 
+// ErrRateLimited is returned, wrapped in a RateLimitError, when the GitHub API rate limit is exhausted
+var ErrRateLimited = errors.New("GitHub API rate limit exceeded")
+
+// RateLimitError is returned when the GitHub API rate limit is exhausted
+type RateLimitError struct {
+	// Reset is the time the rate limit resets at
+	Reset time.Time
+	// Err is the error returned by the GitHub client
+	Err error
+}
+
+// Error implements the error interface
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s, it resets at %s", ErrRateLimited, e.Reset.Local().Format(time.RFC1123))
+}
+
+// Is returns true for ErrRateLimited
+func (*RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Unwrap returns the error returned by the GitHub client
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
 // Client is the struct that contains the GitHub REST API client
 // this struct implements the REST API
 type Client struct {
 	client *github.Client
+	retry  retry.Policy
+	// app is set when authenticating as a GitHub App installation
+	app *appTransport
+	// hosts are the clients of the other GitHub hosts, e.g. GitHub Enterprise Servers,
+	// by the host prefixing the references to resolve through them
+	hosts map[string]*Client
 }
 
+// PublicHost is the host of the public GitHub, talked to by default
+const PublicHost = "github.com"
+
 // NewClient creates a new instance of GhRest
 func NewClient(token string) *Client {
 	ghcli := github.NewClient(nil)
@@ -43,6 +87,113 @@ func NewClient(token string) *Client {
 	}
 }
 
+// WithBaseURL returns a copy of the client talking to the given API base URL,
+// e.g. https://github.example-corp.com/api/v3 for a GitHub Enterprise Server.
+// The /api/v3 path is appended to the URL of an enterprise host if missing.
+func (c *Client) WithBaseURL(baseURL string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API URL %s: %w", baseURL, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid GitHub API URL %s: must be an absolute URL", baseURL)
+	}
+	if u.Host == c.client.BaseURL.Host && strings.Trim(u.Path, "/") == strings.Trim(c.client.BaseURL.Path, "/") {
+		// Already talking to this API, e.g. https://api.github.com
+		return c, nil
+	}
+
+	// The uploads API of an enterprise host lives next to the REST API, i.e. /api/uploads
+	uploadURL := strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/api/v3")
+	ghcli, err := c.client.WithEnterpriseURLs(baseURL, uploadURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API URL %s: %w", baseURL, err)
+	}
+	app := c.app
+	if app != nil {
+		// Request the installation tokens from the same API, the transport of the
+		// original client keeps requesting them from its own
+		app = app.withBaseURL(ghcli.BaseURL)
+		ghcli, err = github.NewClient(&http.Client{Transport: app}).WithEnterpriseURLs(baseURL, uploadURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitHub API URL %s: %w", baseURL, err)
+		}
+	}
+	return &Client{
+		client: ghcli,
+		retry:  c.retry,
+		app:    app,
+		hosts:  c.hosts,
+	}, nil
+}
+
+// WithHostTokens returns a copy of the client authenticating with the token of each host.
+// The token of PublicHost is used by the client itself, the other hosts are GitHub
+// Enterprise Servers whose API at https://<host>/api/v3 is used for the references they
+// prefix, e.g. ghe.example-corp.com/team/action, see ForHost.
+func (c *Client) WithHostTokens(tokens map[string]string) (*Client, error) {
+	client := c
+	for host, token := range tokens {
+		if host == PublicHost {
+			client = &Client{
+				client: client.client.WithAuthToken(token),
+				retry:  client.retry,
+				hosts:  client.hosts,
+			}
+			continue
+		}
+		hostClient, err := NewClient(token).WithBaseURL("https://" + host + "/api/v3")
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitHub host %s: %w", host, err)
+		}
+		client = client.WithHostClient(host, hostClient.WithRetry(c.retry))
+	}
+	return client, nil
+}
+
+// WithHostClient returns a copy of the client resolving the references prefixed by
+// the given host through the given client, see ForHost
+func (c *Client) WithHostClient(host string, hostClient *Client) *Client {
+	hosts := make(map[string]*Client, len(c.hosts)+1)
+	for h, hc := range c.hosts {
+		hosts[h] = hc
+	}
+	hosts[host] = hostClient
+	return &Client{
+		client: c.client,
+		retry:  c.retry,
+		app:    c.app,
+		hosts:  hosts,
+	}
+}
+
+// ForHost returns the client of the given host, if any. It implements interfaces.HostREST.
+func (c *Client) ForHost(host string) (interfaces.REST, bool) {
+	client, ok := c.hosts[host]
+	if !ok {
+		return nil, false
+	}
+	return client, true
+}
+
+// WithRetry returns a copy of the client retrying requests failing with server
+// errors or hitting the rate limits according to the given policy
+func (c *Client) WithRetry(policy retry.Policy) *Client {
+	var hosts map[string]*Client
+	if c.hosts != nil {
+		hosts = make(map[string]*Client, len(c.hosts))
+		for host, client := range c.hosts {
+			hosts[host] = client.WithRetry(policy)
+		}
+	}
+	return &Client{
+		client: c.client,
+		retry:  policy,
+		app:    c.app,
+		hosts:  hosts,
+	}
+}
+
 // NewRequest creates an API request. A relative URL can be provided in urlStr,
 // which will be resolved to the BaseURL of the Client. Relative URLS should
 // always be specified without a preceding slash. If specified, the value
@@ -53,6 +204,22 @@ func (c *Client) NewRequest(method, requestUrl string, body any) (*http.Request,
 
 // Do sends an API request and returns the API response.
 func (c *Client) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	err := c.retry.Do(ctx, func() error {
+		var err error
+		resp, err = c.do(ctx, req)
+		if reset, ok := rateLimitReset(resp, err); ok {
+			err = &RateLimitError{Reset: reset, Err: err}
+		}
+		if retryAfter, ok := shouldRetry(ctx, resp, err, time.Now()); ok {
+			return retry.Transient(err, retryAfter)
+		}
+		return err
+	})
+	return resp, unwrapTransient(err)
+}
+
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
 	var buf bytes.Buffer
 
 	// The GitHub client closes the response body, so we need to capture it
@@ -68,3 +235,65 @@ func (c *Client) Do(ctx context.Context, req *http.Request) (*http.Response, err
 
 	return resp.Response, err
 }
+
+// shouldRetry returns true along with the delay requested by GitHub, if any, when
+// the request failed with a transient error, i.e. a network or server error, or
+// hit the rate limits. Other client errors are not retried.
+func shouldRetry(ctx context.Context, resp *http.Response, err error, now time.Time) (time.Duration, bool) {
+	if err == nil || ctx.Err() != nil {
+		return 0, false
+	}
+	if resp == nil {
+		// Network errors
+		return 0, true
+	}
+
+	retryAfter := retry.ParseRetryAfter(resp.Header.Get("Retry-After"), now)
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError, resp.StatusCode == http.StatusTooManyRequests:
+		return retryAfter, true
+	case resp.StatusCode == http.StatusForbidden:
+		// Secondary rate limits come with a Retry-After header, primary ones with the reset time
+		if retryAfter > 0 {
+			return retryAfter, true
+		}
+		if reset, ok := rateLimitReset(resp, err); ok {
+			return max(reset.Sub(now), 0), true
+		}
+	}
+	return 0, false
+}
+
+// rateLimitReset returns the time the rate limit resets at if the request failed
+// because the rate limit is exhausted
+func rateLimitReset(resp *http.Response, err error) (time.Time, bool) {
+	if err == nil || resp == nil {
+		return time.Time{}, false
+	}
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+
+	reset, convErr := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" && convErr == nil {
+		return time.Unix(reset, 0), true
+	}
+
+	// The GitHub client doesn't send requests it knows would exceed the rate
+	// limit, it returns an error without the response headers instead
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return rateLimitErr.Rate.Reset.Time, true
+	}
+	return time.Time{}, false
+}
+
+// unwrapTransient returns the error wrapped as transient, for callers to see the
+// same errors regardless of the retry policy
+func unwrapTransient(err error) error {
+	var transient *retry.Error
+	if errors.As(err, &transient) && transient == err {
+		return transient.Err
+	}
+	return err
+}
@@ -5,11 +5,17 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/h2non/gock.v1"
+
+	"github.com/stacklok/frizbee/pkg/utils/retry"
 )
 
 // nolint:gocyclo
@@ -109,3 +115,338 @@ func TestClientFunctions(t *testing.T) {
 		defer resp.Body.Close() // nolint:errcheck
 	}
 }
+
+func TestWithBaseURL(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		baseURL        string
+		expectedURL    string
+		expectedUpload string
+		expectError    bool
+	}{
+		{
+			name:           "EnterpriseHost",
+			baseURL:        "https://github.example-corp.com",
+			expectedURL:    "https://github.example-corp.com/api/v3/repos/owner/repo/git/refs/tags/v1",
+			expectedUpload: "https://github.example-corp.com/api/uploads/",
+		},
+		{
+			name:           "EnterpriseAPIURL",
+			baseURL:        "https://github.example-corp.com/api/v3/",
+			expectedURL:    "https://github.example-corp.com/api/v3/repos/owner/repo/git/refs/tags/v1",
+			expectedUpload: "https://github.example-corp.com/api/uploads/",
+		},
+		{
+			name:           "EnterpriseAPIURLWithoutTrailingSlash",
+			baseURL:        "https://github.example-corp.com/api/v3",
+			expectedURL:    "https://github.example-corp.com/api/v3/repos/owner/repo/git/refs/tags/v1",
+			expectedUpload: "https://github.example-corp.com/api/uploads/",
+		},
+		{
+			name:           "PublicAPI",
+			baseURL:        "https://api.github.com",
+			expectedURL:    "https://api.github.com/repos/owner/repo/git/refs/tags/v1",
+			expectedUpload: "https://uploads.github.com/",
+		},
+		{
+			name:        "RelativeURL",
+			baseURL:     "github.example-corp.com",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, err := NewClient("test_token").WithBaseURL(tt.baseURL)
+			if tt.expectError {
+				require.Error(t, err)
+				require.Nil(t, client)
+				return
+			}
+			require.NoError(t, err)
+
+			req, err := client.NewRequest(http.MethodGet, "repos/owner/repo/git/refs/tags/v1", nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedURL, req.URL.String())
+			require.Equal(t, tt.expectedUpload, client.client.UploadURL.String())
+		})
+	}
+}
+
+func TestWithHostTokens(t *testing.T) {
+	t.Parallel()
+
+	client, err := NewClient("").WithHostTokens(map[string]string{
+		PublicHost:                "public_token",
+		"github.example-corp.com": "enterprise_token",
+	})
+	require.NoError(t, err)
+
+	// The github.com token authenticates the client itself
+	req, err := client.NewRequest(http.MethodGet, "repos/owner/repo/git/refs/tags/v1", nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://api.github.com/repos/owner/repo/git/refs/tags/v1", req.URL.String())
+
+	// The other hosts get their own client, retrying like the original one
+	client = client.WithRetry(retry.Policy{MaxAttempts: 3})
+	hostClient, ok := client.ForHost("github.example-corp.com")
+	require.True(t, ok)
+	req, err = hostClient.NewRequest(http.MethodGet, "repos/owner/repo/git/refs/tags/v1", nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://github.example-corp.com/api/v3/repos/owner/repo/git/refs/tags/v1", req.URL.String())
+	require.Equal(t, 3, hostClient.(*Client).retry.MaxAttempts)
+
+	_, ok = client.ForHost("github.other-corp.com")
+	require.False(t, ok)
+	_, ok = client.ForHost(PublicHost)
+	require.False(t, ok)
+
+	_, err = NewClient("").WithHostTokens(map[string]string{"bad host": "token"})
+	require.Error(t, err)
+}
+
+// TestDoRetry doesn't run in parallel to the other tests, given that gock intercepts
+// the requests sent through the default HTTP transport while mocking responses.
+// nolint:paralleltest
+func TestDoRetry(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+
+	testCases := []struct {
+		name           string
+		failures       int
+		failStatus     int
+		failHeaders    map[string]string
+		policy         retry.Policy
+		expectedCalls  int32
+		expectedStatus int
+	}{
+		{
+			name:           "ServerErrorRetried",
+			failures:       2,
+			failStatus:     http.StatusServiceUnavailable,
+			policy:         policy,
+			expectedCalls:  3,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "ServerErrorAttemptsExhausted",
+			failures:       5,
+			failStatus:     http.StatusBadGateway,
+			policy:         policy,
+			expectedCalls:  3,
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "NotFoundNotRetried",
+			failures:       1,
+			failStatus:     http.StatusNotFound,
+			policy:         policy,
+			expectedCalls:  1,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "ForbiddenNotRetried",
+			failures:       1,
+			failStatus:     http.StatusForbidden,
+			policy:         policy,
+			expectedCalls:  1,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "SecondaryRateLimitRetried",
+			failures:       1,
+			failStatus:     http.StatusForbidden,
+			failHeaders:    map[string]string{"Retry-After": "1"},
+			policy:         policy,
+			expectedCalls:  2,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "TooManyRequestsRetried",
+			failures:       1,
+			failStatus:     http.StatusTooManyRequests,
+			policy:         policy,
+			expectedCalls:  2,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "NoPolicy",
+			failures:       1,
+			failStatus:     http.StatusServiceUnavailable,
+			expectedCalls:  1,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if calls.Add(1) <= int32(tt.failures) {
+					for k, v := range tt.failHeaders {
+						w.Header().Set(k, v)
+					}
+					w.WriteHeader(tt.failStatus)
+					return
+				}
+				_, _ = w.Write([]byte(`{"message": "hello world"}`))
+			}))
+			t.Cleanup(srv.Close)
+
+			client, err := NewClient("").WithBaseURL(srv.URL)
+			require.NoError(t, err)
+			client = client.WithRetry(tt.policy)
+
+			req, err := client.NewRequest(http.MethodGet, "test", nil)
+			require.NoError(t, err)
+			resp, err := client.Do(context.Background(), req)
+			require.NotNil(t, resp)
+			defer resp.Body.Close() // nolint:errcheck
+			if tt.expectedStatus == http.StatusOK {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				var transient *retry.Error
+				require.False(t, errors.As(err, &transient), "transient errors should be unwrapped")
+			}
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			require.Equal(t, tt.expectedCalls, calls.Load())
+		})
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	errFailed := errors.New("failed")
+
+	testCases := []struct {
+		name          string
+		status        int
+		headers       map[string]string
+		err           error
+		expectedRetry bool
+		expectedAfter time.Duration
+	}{
+		{name: "Success", status: http.StatusOK},
+		{name: "ServerError", status: http.StatusInternalServerError, err: errFailed, expectedRetry: true},
+		{
+			name:          "ServerErrorWithRetryAfter",
+			status:        http.StatusServiceUnavailable,
+			headers:       map[string]string{"Retry-After": "7"},
+			err:           errFailed,
+			expectedRetry: true,
+			expectedAfter: 7 * time.Second,
+		},
+		{name: "NotFound", status: http.StatusNotFound, err: errFailed},
+		{name: "Unauthorized", status: http.StatusUnauthorized, err: errFailed},
+		{name: "Forbidden", status: http.StatusForbidden, err: errFailed},
+		{
+			name:   "PrimaryRateLimit",
+			status: http.StatusForbidden,
+			headers: map[string]string{
+				"X-RateLimit-Remaining": "0",
+				"X-RateLimit-Reset":     strconv.FormatInt(now.Add(42*time.Second).Unix(), 10),
+			},
+			err:           errFailed,
+			expectedRetry: true,
+			expectedAfter: 42 * time.Second,
+		},
+		{
+			name:   "ForbiddenWithRemainingRateLimit",
+			status: http.StatusForbidden,
+			headers: map[string]string{
+				"X-RateLimit-Remaining": "10",
+				"X-RateLimit-Reset":     strconv.FormatInt(now.Unix(), 10),
+			},
+			err: errFailed,
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			for k, v := range tt.headers {
+				resp.Header.Set(k, v)
+			}
+			after, ok := shouldRetry(context.Background(), resp, tt.err, now)
+			require.Equal(t, tt.expectedRetry, ok)
+			require.Equal(t, tt.expectedAfter, after)
+		})
+	}
+
+	_, ok := shouldRetry(context.Background(), nil, errFailed, now)
+	require.True(t, ok, "network errors should be retried")
+}
+
+// TestDoRateLimited doesn't run in parallel to the other tests, given that gock intercepts
+// the requests sent through the default HTTP transport while mocking responses.
+// nolint:paralleltest
+func TestDoRateLimited(t *testing.T) {
+	reset := time.Now().Add(30 * time.Minute).Truncate(time.Second)
+
+	testCases := []struct {
+		name   string
+		status int
+	}{
+		{name: "Forbidden", status: http.StatusForbidden},
+		{name: "TooManyRequests", status: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				calls.Add(1)
+				w.Header().Set("X-RateLimit-Limit", "60")
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+			}))
+			t.Cleanup(srv.Close)
+
+			// Don't wait for the rate limit to reset
+			client, err := NewClient("").WithBaseURL(srv.URL)
+			require.NoError(t, err)
+			client = client.WithRetry(retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second})
+
+			// The second request is rejected by the GitHub client without reaching the server
+			for i := 0; i < 2; i++ {
+				req, err := client.NewRequest(http.MethodGet, "test", nil)
+				require.NoError(t, err)
+				resp, err := client.Do(context.Background(), req)
+				require.ErrorIs(t, err, ErrRateLimited)
+				var rateLimitErr *RateLimitError
+				require.ErrorAs(t, err, &rateLimitErr)
+				require.True(t, reset.Equal(rateLimitErr.Reset), "expected reset %s, got %s", reset, rateLimitErr.Reset)
+				require.Contains(t, err.Error(), "rate limit exceeded")
+				if resp != nil {
+					_ = resp.Body.Close()
+				}
+			}
+			if tt.status == http.StatusForbidden {
+				require.Equal(t, int32(1), calls.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retry provides a policy to retry operations failing with transient errors.
+package retry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Policy configures how operations failing with transient errors are retried.
+// The zero value doesn't retry.
+type Policy struct {
+	// MaxAttempts is the total number of attempts, a value of 1 or less disables retries
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled on every subsequent one
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts. Operations asking to be retried
+	// after a longer delay, e.g. through a Retry-After header, are not retried.
+	MaxDelay time.Duration
+}
+
+// DefaultPolicy returns the default retry policy
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: 4,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+	}
+}
+
+// Error marks an error as transient, i.e. the operation may succeed when retried
+type Error struct {
+	Err error
+	// RetryAfter is the minimum delay before retrying, if requested by the remote
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Transient marks the error as transient, optionally with the delay requested by the remote
+func Transient(err error, retryAfter time.Duration) error {
+	return &Error{Err: err, RetryAfter: retryAfter}
+}
+
+// Backoff returns the delay before the given retry, starting at 1
+func (p Policy) Backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Do calls fn until it succeeds, fails with an error not marked as transient,
+// the attempts are exhausted or the context is done. The last error is returned.
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var transient *Error
+		if err == nil || !errors.As(err, &transient) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		delay := p.Backoff(attempt)
+		if transient.RetryAfter > delay {
+			if p.MaxDelay > 0 && transient.RetryAfter > p.MaxDelay {
+				// Don't keep the caller waiting for longer than configured
+				return err
+			}
+			delay = transient.RetryAfter
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// ParseRetryAfter returns the delay requested by the Retry-After header, given
+// either in seconds or as an HTTP date. It returns zero if the header is invalid.
+func ParseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	p := Policy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	require.Equal(t, time.Second, p.Backoff(1))
+	require.Equal(t, 2*time.Second, p.Backoff(2))
+	require.Equal(t, 4*time.Second, p.Backoff(3))
+	require.Equal(t, 5*time.Second, p.Backoff(4), "the delay should be capped")
+	require.Equal(t, 5*time.Second, p.Backoff(100))
+}
+
+func TestDo(t *testing.T) {
+	t.Parallel()
+
+	errTransient := Transient(errors.New("service unavailable"), 0)
+	errPermanent := errors.New("not found")
+	policy := Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	testCases := []struct {
+		name          string
+		policy        Policy
+		errs          []error
+		expectedCalls int
+		expectedErr   error
+	}{
+		{
+			name:          "Success",
+			policy:        policy,
+			errs:          []error{nil},
+			expectedCalls: 1,
+		},
+		{
+			name:          "TransientThenSuccess",
+			policy:        policy,
+			errs:          []error{errTransient, errTransient, nil},
+			expectedCalls: 3,
+		},
+		{
+			name:          "AttemptsExhausted",
+			policy:        policy,
+			errs:          []error{errTransient, errTransient, errTransient, nil},
+			expectedCalls: 3,
+			expectedErr:   errTransient,
+		},
+		{
+			name:          "PermanentError",
+			policy:        policy,
+			errs:          []error{errPermanent, nil},
+			expectedCalls: 1,
+			expectedErr:   errPermanent,
+		},
+		{
+			name:          "ZeroPolicyDoesNotRetry",
+			errs:          []error{errTransient, nil},
+			expectedCalls: 1,
+			expectedErr:   errTransient,
+		},
+		{
+			name:          "RetryAfterExceedsMaxDelay",
+			policy:        policy,
+			errs:          []error{Transient(errPermanent, time.Hour), nil},
+			expectedCalls: 1,
+			expectedErr:   errPermanent,
+		},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := 0
+			err := tt.policy.Do(context.Background(), func() error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			require.Equal(t, tt.expectedCalls, calls)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestDoCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := Policy{MaxAttempts: 5, BaseDelay: time.Hour}
+
+	calls := 0
+	err := policy.Do(ctx, func() error {
+		calls++
+		cancel()
+		return Transient(errors.New("service unavailable"), 0)
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, calls)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, time.Duration(0), ParseRetryAfter("", now))
+	require.Equal(t, 30*time.Second, ParseRetryAfter("30", now))
+	require.Equal(t, 90*time.Second, ParseRetryAfter("Tue, 01 Oct 2024 12:01:30 GMT", now))
+	require.Equal(t, time.Duration(0), ParseRetryAfter("Tue, 01 Oct 2024 11:00:00 GMT", now))
+	require.Equal(t, time.Duration(0), ParseRetryAfter("soon", now))
+}
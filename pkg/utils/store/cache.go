@@ -17,6 +17,10 @@
 package store
 
 import (
+	"context"
+	"log/slog"
+	"strings"
+
 	"github.com/puzpuzpuz/xsync"
 )
 
@@ -26,21 +30,107 @@ type RefCacher interface {
 	Load(key string) (string, bool)
 }
 
+// NegativeCacher is implemented by the RefCachers also remembering the references
+// which don't exist, so they aren't looked up again while the negative entry lasts.
+// Storing a value for the key replaces its negative entry.
+type NegativeCacher interface {
+	StoreMissing(key string)
+	IsMissing(key string) bool
+}
+
+// valueSeparator separates the checksum or digest of a cached value from the metadata
+// stored along with it
+const valueSeparator = " "
+
+// JoinValue returns the value caching a checksum or digest along with metadata about it,
+// e.g. the media type of an image manifest, in a single entry. The metadata may be empty.
+func JoinValue(checksum, metadata string) string {
+	if metadata == "" {
+		return checksum
+	}
+	return checksum + valueSeparator + metadata
+}
+
+// SplitValue returns the checksum or digest of a cached value and the metadata stored
+// along with it by JoinValue, if any.
+func SplitValue(value string) (string, string) {
+	checksum, metadata, _ := strings.Cut(value, valueSeparator)
+	return checksum, metadata
+}
+
+// MetadataCacher is implemented by the RefCachers telling the metadata cached about the
+// references, e.g. the release a moving tag points at, apart from their resolutions, so
+// it isn't recorded along with them in a lockfile.
+type MetadataCacher interface {
+	StoreMetadata(key, value string)
+	LoadMetadata(key string) (string, bool)
+}
+
+// UsageTracker is implemented by the RefCachers keeping track of the checksums and
+// digests met already pinned, e.g. a LockFile keeping the entries resolved to them.
+type UsageTracker interface {
+	MarkUsed(value string)
+}
+
+// StoreMetadata stores metadata about a reference in the given cache, apart from the
+// resolutions if it's a MetadataCacher.
+func StoreMetadata(cache RefCacher, key, value string) {
+	if meta, ok := cache.(MetadataCacher); ok {
+		meta.StoreMetadata(key, value)
+		return
+	}
+	cache.Store(key, value)
+}
+
+// LoadMetadata loads metadata about a reference stored with StoreMetadata.
+func LoadMetadata(cache RefCacher, key string) (string, bool) {
+	if meta, ok := cache.(MetadataCacher); ok {
+		return meta.LoadMetadata(key)
+	}
+	return cache.Load(key)
+}
+
+// MarkUsed tells the given cache the checksum or digest was met already pinned, if it's
+// a UsageTracker.
+func MarkUsed(cache RefCacher, value string) {
+	if tracker, ok := cache.(UsageTracker); ok {
+		tracker.MarkUsed(value)
+	}
+}
+
+// LogLookup logs at debug level whether the reference is served from the cache, i.e.
+// whether its key is cached, before it's resolved. Nothing is logged if the cache or the
+// logger is nil, or the logger doesn't log debug messages.
+func LogLookup(ctx context.Context, logger *slog.Logger, cache RefCacher, key, reference string) {
+	if cache == nil || logger == nil || !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	if _, ok := cache.Load(key); ok {
+		logger.DebugContext(ctx, "cache hit", "reference", reference)
+	} else {
+		logger.DebugContext(ctx, "cache miss", "reference", reference)
+	}
+}
+
 type refCacher struct {
-	cache *xsync.MapOf[string, string]
+	cache   *xsync.MapOf[string, string]
+	missing *xsync.MapOf[string, struct{}]
 }
 
 // NewRefCacher returns a new RefCacher. The default implementation is
-// thread-safe.
+// thread-safe. It implements NegativeCacher, its negative entries last as
+// long as the cacher.
 func NewRefCacher() RefCacher {
 	return &refCacher{
-		cache: xsync.NewMapOf[string](),
+		cache:   xsync.NewMapOf[string](),
+		missing: xsync.NewMapOf[struct{}](),
 	}
 }
 
 // Store stores a key-value pair.
 func (r *refCacher) Store(key, value string) {
 	r.cache.Store(key, value)
+	r.missing.Delete(key)
 }
 
 // Load loads a value for a given key.
@@ -48,20 +138,35 @@ func (r *refCacher) Load(key string) (string, bool) {
 	return r.cache.Load(key)
 }
 
+// StoreMissing remembers that the given key doesn't exist.
+func (r *refCacher) StoreMissing(key string) {
+	r.missing.Store(key, struct{}{})
+}
+
+// IsMissing returns true if the given key is known not to exist.
+func (r *refCacher) IsMissing(key string) bool {
+	_, ok := r.missing.Load(key)
+	return ok
+}
+
 type unsafeCacher struct {
-	cache map[string]string
+	cache   map[string]string
+	missing map[string]bool
 }
 
 // NewUnsafeCacher returns a new RefCacher that's not thread-safe.
+// It implements NegativeCacher like the default implementation.
 func NewUnsafeCacher() RefCacher {
 	return &unsafeCacher{
-		cache: map[string]string{},
+		cache:   map[string]string{},
+		missing: map[string]bool{},
 	}
 }
 
 // Store stores a key-value pair.
 func (r *unsafeCacher) Store(key, value string) {
 	r.cache[key] = value
+	delete(r.missing, key)
 }
 
 // Load loads a value for a given key.
@@ -69,3 +174,13 @@ func (r *unsafeCacher) Load(key string) (string, bool) {
 	v, ok := r.cache[key]
 	return v, ok
 }
+
+// StoreMissing remembers that the given key doesn't exist.
+func (r *unsafeCacher) StoreMissing(key string) {
+	r.missing[key] = true
+}
+
+// IsMissing returns true if the given key is known not to exist.
+func (r *unsafeCacher) IsMissing(key string) bool {
+	return r.missing[key]
+}
@@ -1,7 +1,10 @@
 package store
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -117,3 +120,58 @@ func TestConcurrency(t *testing.T) {
 		<-done
 	}
 }
+
+// TestNegativeCacher tests the negative entries of both refCacher and unsafeCacher.
+func TestNegativeCacher(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		cacher RefCacher
+	}{
+		{name: "RefCacher", cacher: NewRefCacher()},
+		{name: "UnsafeCacher", cacher: NewUnsafeCacher()},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			neg, ok := tt.cacher.(NegativeCacher)
+			require.True(t, ok)
+			require.False(t, neg.IsMissing("key1"))
+
+			neg.StoreMissing("key1")
+			require.True(t, neg.IsMissing("key1"))
+			_, found := tt.cacher.Load("key1")
+			require.False(t, found, "negative entries must not be loaded")
+
+			tt.cacher.Store("key1", "value1")
+			require.False(t, neg.IsMissing("key1"), "storing a value must replace the negative entry")
+		})
+	}
+}
+
+func TestLogLookup(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	cache := NewRefCacher()
+	cache.Store("cached", "value")
+	ctx := context.Background()
+
+	LogLookup(ctx, logger, cache, "cached", "ref1")
+	LogLookup(ctx, logger, cache, "missing", "ref2")
+	require.Contains(t, buf.String(), `msg="cache hit" reference=ref1`)
+	require.Contains(t, buf.String(), `msg="cache miss" reference=ref2`)
+
+	// Nothing is logged without a cache, a logger or below the debug level
+	buf.Reset()
+	LogLookup(ctx, logger, nil, "cached", "ref1")
+	LogLookup(ctx, nil, cache, "cached", "ref1")
+	LogLookup(ctx, slog.New(slog.NewTextHandler(&buf, nil)), cache, "cached", "ref1")
+	require.Empty(t, buf.String())
+}
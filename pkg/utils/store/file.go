@@ -0,0 +1,193 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultNegativeTTL is how long a FileCacher remembers references which don't exist
+// by default. It's shorter than the usual TTL, as a missing tag is likely to be created.
+const DefaultNegativeTTL = time.Hour
+
+// FileCacher is a thread-safe RefCacher persisted to a JSON file, so references
+// resolved by a previous run can be reused until they expire. It implements
+// NegativeCacher, its negative entries expire after their own TTL.
+type FileCacher struct {
+	path        string
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+	mu          sync.Mutex
+	entries     map[string]fileCacheEntry
+	dirty       bool
+}
+
+type fileCacheEntry struct {
+	Value    string    `json:"value"`
+	StoredAt time.Time `json:"stored_at"`
+	// Missing is set for the negative entries, i.e. the references which don't exist
+	Missing bool `json:"missing,omitempty"`
+}
+
+// NewFileCacher returns a new FileCacher loaded from the file at the given path,
+// if it exists. Entries older than the TTL are ignored, a TTL of zero or less
+// means they never expire. A file that can't be decoded is discarded.
+func NewFileCacher(path string, ttl time.Duration) (*FileCacher, error) {
+	return newFileCacher(path, ttl, time.Now)
+}
+
+func newFileCacher(path string, ttl time.Duration, now func() time.Time) (*FileCacher, error) {
+	c := &FileCacher{
+		path:        path,
+		ttl:         ttl,
+		negativeTTL: DefaultNegativeTTL,
+		now:         now,
+		entries:     map[string]fileCacheEntry{},
+	}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read cache file %s: %w", path, err)
+	}
+
+	var entries map[string]fileCacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		// The cache is rebuilt on the next save
+		c.dirty = true
+		return c, nil
+	}
+	for key, entry := range entries {
+		if c.expired(entry) {
+			c.dirty = true
+			continue
+		}
+		c.entries[key] = entry
+	}
+	return c, nil
+}
+
+// Store stores a key-value pair.
+func (c *FileCacher) Store(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = fileCacheEntry{Value: value, StoredAt: c.now()}
+	c.dirty = true
+}
+
+// Load loads a value for a given key, unless it expired.
+func (c *FileCacher) Load(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.Missing || c.expired(entry) {
+		return "", false
+	}
+	return entry.Value, true
+}
+
+// SetNegativeTTL sets how long the references which don't exist are remembered for,
+// DefaultNegativeTTL unless set. A TTL of zero or less disables the negative entries.
+func (c *FileCacher) SetNegativeTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.negativeTTL = ttl
+}
+
+// StoreMissing remembers that the given key doesn't exist, unless the negative
+// entries are disabled. It doesn't replace a value stored for the key.
+func (c *FileCacher) StoreMissing(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.negativeTTL <= 0 {
+		return
+	}
+	if entry, ok := c.entries[key]; ok && !entry.Missing && !c.expired(entry) {
+		return
+	}
+	c.entries[key] = fileCacheEntry{StoredAt: c.now(), Missing: true}
+	c.dirty = true
+}
+
+// IsMissing returns true if the given key is known not to exist, unless the
+// negative entry expired.
+func (c *FileCacher) IsMissing(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return ok && entry.Missing && !c.expired(entry)
+}
+
+// Save writes the cache to its file if it changed since it was loaded,
+// creating the parent directory if needed
+func (c *FileCacher) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cache: %w", err)
+	}
+
+	if err := writeFileAtomic(c.path, data); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	c.dirty = false
+	return nil
+}
+
+func (c *FileCacher) expired(entry fileCacheEntry) bool {
+	if entry.Missing {
+		return c.negativeTTL <= 0 || c.now().Sub(entry.StoredAt) > c.negativeTTL
+	}
+	return c.ttl > 0 && c.now().Sub(entry.StoredAt) > c.ttl
+}
+
+// writeFileAtomic writes the data to a temporary file next to the given path first, then
+// renames it, so concurrent runs never read a partial file. The parent directory is created
+// if needed.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // nolint:errcheck
+	if _, err := f.Write(data); err != nil {
+		f.Close() // nolint:errcheck,gosec
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCacherRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "nested", "refs.json")
+
+	c, err := NewFileCacher(path, time.Hour)
+	require.NoError(t, err)
+	_, ok := c.Load("actions/checkout@v4")
+	require.False(t, ok)
+
+	c.Store("actions/checkout@v4", "11bd71901bbe5b1630ceea73d27597364c9af683")
+	c.Store("alpine:3.18", "sha256:deadbeef")
+	require.NoError(t, c.Save())
+
+	loaded, err := NewFileCacher(path, time.Hour)
+	require.NoError(t, err)
+	val, ok := loaded.Load("actions/checkout@v4")
+	require.True(t, ok)
+	require.Equal(t, "11bd71901bbe5b1630ceea73d27597364c9af683", val)
+	val, ok = loaded.Load("alpine:3.18")
+	require.True(t, ok)
+	require.Equal(t, "sha256:deadbeef", val)
+
+	// Saving an unchanged cache doesn't touch the file
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, loaded.Save())
+	require.NoFileExists(t, path)
+}
+
+func TestFileCacherTTL(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "refs.json")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	c, err := newFileCacher(path, time.Hour, clock)
+	require.NoError(t, err)
+	c.Store("old", "1")
+	now = now.Add(30 * time.Minute)
+	c.Store("new", "2")
+	require.NoError(t, c.Save())
+
+	now = now.Add(45 * time.Minute)
+	_, ok := c.Load("old")
+	require.False(t, ok, "expired entries must not be loaded")
+	val, ok := c.Load("new")
+	require.True(t, ok)
+	require.Equal(t, "2", val)
+
+	loaded, err := newFileCacher(path, time.Hour, clock)
+	require.NoError(t, err)
+	_, ok = loaded.Load("old")
+	require.False(t, ok)
+	_, ok = loaded.Load("new")
+	require.True(t, ok)
+
+	// Entries never expire without a TTL
+	forever, err := newFileCacher(path, 0, clock)
+	require.NoError(t, err)
+	_, ok = forever.Load("old")
+	require.True(t, ok)
+}
+
+func TestFileCacherCorrupt(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "refs.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+
+	c, err := NewFileCacher(path, time.Hour)
+	require.NoError(t, err)
+	_, ok := c.Load("anything")
+	require.False(t, ok)
+
+	// The corrupt file is replaced on save
+	require.NoError(t, c.Save())
+	loaded, err := NewFileCacher(path, time.Hour)
+	require.NoError(t, err)
+	require.Empty(t, loaded.entries)
+	require.False(t, loaded.dirty)
+}
+
+func TestFileCacherNegative(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "refs.json")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	c, err := newFileCacher(path, 24*time.Hour, clock)
+	require.NoError(t, err)
+	c.Store("actions/checkout@v4", "11bd71901bbe5b1630ceea73d27597364c9af683")
+	c.StoreMissing("actions/checkout@v4")
+	require.False(t, c.IsMissing("actions/checkout@v4"), "a negative entry must not replace a value")
+
+	c.StoreMissing("actions/checkout@v99")
+	require.True(t, c.IsMissing("actions/checkout@v99"))
+	_, ok := c.Load("actions/checkout@v99")
+	require.False(t, ok, "negative entries must not be loaded")
+	require.NoError(t, c.Save())
+
+	// Negative entries survive a round trip
+	loaded, err := newFileCacher(path, 24*time.Hour, clock)
+	require.NoError(t, err)
+	require.True(t, loaded.IsMissing("actions/checkout@v99"))
+
+	// They expire after the negative TTL, unlike the values
+	now = now.Add(DefaultNegativeTTL + time.Minute)
+	require.False(t, loaded.IsMissing("actions/checkout@v99"))
+	_, ok = loaded.Load("actions/checkout@v4")
+	require.True(t, ok)
+
+	// The tag was created in the meantime
+	loaded.Store("actions/checkout@v99", "0c52d547c9bc32b1aa3301fd7a9cb496313a4491")
+	val, ok := loaded.Load("actions/checkout@v99")
+	require.True(t, ok)
+	require.Equal(t, "0c52d547c9bc32b1aa3301fd7a9cb496313a4491", val)
+
+	// Negative entries are disabled without a negative TTL
+	disabled, err := newFileCacher(filepath.Join(t.TempDir(), "refs.json"), time.Hour, clock)
+	require.NoError(t, err)
+	disabled.SetNegativeTTL(0)
+	disabled.StoreMissing("actions/checkout@v99")
+	require.False(t, disabled.IsMissing("actions/checkout@v99"))
+	require.False(t, disabled.dirty)
+}
@@ -0,0 +1,226 @@
+//
+// Copyright 2024 Stacklok, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultLockFileName is the name of the lockfile written by default, relative to the
+	// current directory rather than to the pinned files
+	DefaultLockFileName = "frizbee.lock"
+	// lockFileVersion is the version of the lockfile format
+	lockFileVersion = 1
+)
+
+// ErrLockFileVersion is returned when a lockfile was written in an unsupported format
+var ErrLockFileVersion = errors.New("unsupported lockfile version")
+
+// lockFileContent is the JSON content of a lockfile, mapping each resolved reference to
+// its checksum or digest
+type lockFileContent struct {
+	Version    int               `json:"version"`
+	References map[string]string `json:"references"`
+}
+
+// LockFile is a thread-safe RefCacher recording the resolved references in a JSON file
+// meant to be committed next to the pinned files, like a FileCacher without a TTL. It
+// wraps another RefCacher, which resolutions are read from and written to. If used, the
+// references recorded in the lockfile are served from it rather than resolved again. If
+// recording, every reference resolved, even from the wrapped cache, is added to it, while
+// the entries of the other references are kept unless pruning, see WithPrune, as several
+// runs, e.g. over the actions then the images, may share a lockfile. Negative entries and
+// metadata are left to the wrapped cache, if it implements NegativeCacher and
+// MetadataCacher.
+type LockFile struct {
+	path    string
+	next    RefCacher
+	use     bool
+	record  bool
+	prune   bool
+	mu      sync.Mutex
+	entries map[string]string
+	// used holds the references resolved and the checksums or digests met since loaded
+	used  map[string]bool
+	dirty bool
+}
+
+// NewLockFile returns a new LockFile loaded from the file at the given path, if it
+// exists, wrapping the given cache, which may be nil. Use makes the references recorded
+// in the file served from it, record makes the resolved ones added to it on Save.
+// Unlike a cache, a file that can't be decoded is an error.
+func NewLockFile(path string, next RefCacher, use, record bool) (*LockFile, error) {
+	if next == nil {
+		next = NewRefCacher()
+	}
+	l := &LockFile{
+		path:    path,
+		next:    next,
+		use:     use,
+		record:  record,
+		entries: map[string]string{},
+		used:    map[string]bool{},
+	}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read lockfile %s: %w", path, err)
+	}
+
+	var content lockFileContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("failed to decode lockfile %s: %w", path, err)
+	}
+	if content.Version != lockFileVersion {
+		return nil, fmt.Errorf("%w %d in %s", ErrLockFileVersion, content.Version, path)
+	}
+	for key, value := range content.References {
+		l.entries[key] = value
+	}
+	return l, nil
+}
+
+// Store stores a key-value pair in the wrapped cache, recording it if asked to.
+func (l *LockFile) Store(key, value string) {
+	l.next.Store(key, value)
+	l.recordEntry(key, value)
+}
+
+// Load loads a value for a given key from the lockfile if it's used, or else from
+// the wrapped cache, recording it if asked to.
+func (l *LockFile) Load(key string) (string, bool) {
+	if l.use {
+		l.mu.Lock()
+		value, ok := l.entries[key]
+		l.mu.Unlock()
+		if ok {
+			l.recordEntry(key, value)
+			return value, true
+		}
+	}
+	value, ok := l.next.Load(key)
+	if ok {
+		l.recordEntry(key, value)
+	}
+	return value, ok
+}
+
+// StoreMissing remembers that the given key doesn't exist in the wrapped cache, if
+// it's a NegativeCacher. Missing references are never recorded in the lockfile.
+func (l *LockFile) StoreMissing(key string) {
+	if neg, ok := l.next.(NegativeCacher); ok {
+		neg.StoreMissing(key)
+	}
+}
+
+// IsMissing returns true if the wrapped cache knows the given key doesn't exist.
+func (l *LockFile) IsMissing(key string) bool {
+	neg, ok := l.next.(NegativeCacher)
+	return ok && neg.IsMissing(key)
+}
+
+// StoreMetadata stores metadata about a reference in the wrapped cache, without
+// recording it.
+func (l *LockFile) StoreMetadata(key, value string) {
+	StoreMetadata(l.next, key, value)
+}
+
+// LoadMetadata loads metadata about a reference from the wrapped cache.
+func (l *LockFile) LoadMetadata(key string) (string, bool) {
+	return LoadMetadata(l.next, key)
+}
+
+// MarkUsed keeps the entries resolved to the given checksum or digest, met pinned, when
+// pruning on Save.
+func (l *LockFile) MarkUsed(checksum string) {
+	if !l.record {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.used[checksum] = true
+}
+
+// WithPrune makes Save drop the entries neither resolved nor met pinned since the lockfile
+// was loaded, e.g. of the references removed from the files. It's meant for the runs over
+// all the files and kinds of references the lockfile covers, as the entries of the others
+// are dropped as well.
+func (l *LockFile) WithPrune() *LockFile {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune = true
+	return l
+}
+
+// Save writes the lockfile if it's recording and changed since it was loaded, dropping
+// the entries neither resolved nor met pinned since if pruning. The references are sorted
+// so the file diffs cleanly.
+func (l *LockFile) Save() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.record {
+		return nil
+	}
+	if l.prune {
+		for key, value := range l.entries {
+			if checksum, _ := SplitValue(value); !l.used[key] && !l.used[checksum] {
+				delete(l.entries, key)
+				l.dirty = true
+			}
+		}
+	}
+	if !l.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(lockFileContent{Version: lockFileVersion, References: l.entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lockfile: %w", err)
+	}
+	if err := writeFileAtomic(l.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write lockfile: %w", err)
+	}
+
+	l.dirty = false
+	return nil
+}
+
+func (l *LockFile) recordEntry(key, value string) {
+	if !l.record {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	checksum, _ := SplitValue(value)
+	l.used[key] = true
+	l.used[checksum] = true
+	// A reference already pinned resolves to itself, which isn't worth recording
+	if l.entries[key] == value || strings.HasSuffix(key, "@"+checksum) {
+		return
+	}
+	l.entries[key] = value
+	l.dirty = true
+}
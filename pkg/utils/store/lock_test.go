@@ -0,0 +1,167 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFileRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), DefaultLockFileName)
+
+	cache := NewRefCacher()
+	cache.Store("alpine:3.18", "sha256:deadbeef")
+	l, err := NewLockFile(path, cache, false, true)
+	require.NoError(t, err)
+	l.Store("actions/checkout@v4", "11bd71901bbe5b1630ceea73d27597364c9af683")
+	// References served from the wrapped cache are recorded as well
+	val, ok := l.Load("alpine:3.18")
+	require.True(t, ok)
+	require.Equal(t, "sha256:deadbeef", val)
+	require.NoError(t, l.Save())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `{
+  "version": 1,
+  "references": {
+    "actions/checkout@v4": "11bd71901bbe5b1630ceea73d27597364c9af683",
+    "alpine:3.18": "sha256:deadbeef"
+  }
+}
+`, string(content))
+
+	// The lockfile serves the references once used, without the wrapped cache
+	used, err := NewLockFile(path, nil, true, false)
+	require.NoError(t, err)
+	val, ok = used.Load("actions/checkout@v4")
+	require.True(t, ok)
+	require.Equal(t, "11bd71901bbe5b1630ceea73d27597364c9af683", val)
+	_, ok = used.Load("actions/cache@v4")
+	require.False(t, ok)
+
+	// A lockfile which isn't used is only written to
+	unused, err := NewLockFile(path, nil, false, true)
+	require.NoError(t, err)
+	_, ok = unused.Load("actions/checkout@v4")
+	require.False(t, ok)
+
+	// Saving an unchanged lockfile doesn't touch the file
+	unused.Store("actions/checkout@v4", "11bd71901bbe5b1630ceea73d27597364c9af683")
+	unused.Store("alpine:3.18", "sha256:deadbeef")
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, unused.Save())
+	require.NoFileExists(t, path)
+}
+
+func TestLockFilePrune(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), DefaultLockFileName)
+	require.NoError(t, os.WriteFile(path, []byte(`{
+  "version": 1,
+  "references": {
+    "actions/cache@v3": "0c45773b623bea8c8e75f6c82b208c3cf94ea4f9",
+    "actions/checkout@v4": "11bd71901bbe5b1630ceea73d27597364c9af683",
+    "alpine:3.18": "sha256:deadbeef application/vnd.oci.image.index.v1+json"
+  }
+}
+`), 0600))
+
+	l, err := NewLockFile(path, nil, true, true)
+	require.NoError(t, err)
+	l = l.WithPrune()
+	// Served from the lockfile
+	_, ok := l.Load("actions/checkout@v4")
+	require.True(t, ok)
+	// Met already pinned
+	l.Store("actions/setup-go@0aaccfd150d50ccaeb58ebe88d36e91e39d0cdc2", "0aaccfd150d50ccaeb58ebe88d36e91e39d0cdc2")
+	MarkUsed(l, "sha256:deadbeef")
+	// Metadata is left to the wrapped cache
+	StoreMetadata(l, "actions/checkout@v4#version", "v4.1.1")
+	version, ok := LoadMetadata(l, "actions/checkout@v4#version")
+	require.True(t, ok)
+	require.Equal(t, "v4.1.1", version)
+	require.NoError(t, l.Save())
+
+	// actions/cache@v3 isn't used anymore
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `{
+  "version": 1,
+  "references": {
+    "actions/checkout@v4": "11bd71901bbe5b1630ceea73d27597364c9af683",
+    "alpine:3.18": "sha256:deadbeef application/vnd.oci.image.index.v1+json"
+  }
+}
+`, string(content))
+}
+
+func TestLockFileShared(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), DefaultLockFileName)
+
+	// The actions then the images are pinned with the same lockfile
+	actions, err := NewLockFile(path, nil, false, true)
+	require.NoError(t, err)
+	actions.Store("actions/checkout@v4", "11bd71901bbe5b1630ceea73d27597364c9af683")
+	require.NoError(t, actions.Save())
+
+	images, err := NewLockFile(path, nil, false, true)
+	require.NoError(t, err)
+	images.Store("alpine:3.18", "sha256:deadbeef")
+	require.NoError(t, images.Save())
+
+	// The entries of the first run are kept by the second one
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `{
+  "version": 1,
+  "references": {
+    "actions/checkout@v4": "11bd71901bbe5b1630ceea73d27597364c9af683",
+    "alpine:3.18": "sha256:deadbeef"
+  }
+}
+`, string(content))
+}
+
+func TestLockFileInvalid(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{name: "Garbage", content: "not json"},
+		{name: "UnknownVersion", content: `{"version": 2, "references": {}}`},
+	}
+
+	for _, tt := range testCases {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), DefaultLockFileName)
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0600))
+			_, err := NewLockFile(path, nil, true, false)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLockFileNegativeEntries(t *testing.T) {
+	t.Parallel()
+
+	l, err := NewLockFile(filepath.Join(t.TempDir(), DefaultLockFileName), nil, true, true)
+	require.NoError(t, err)
+	l.StoreMissing("actions/checkout@nope")
+	require.True(t, l.IsMissing("actions/checkout@nope"))
+	require.False(t, l.IsMissing("actions/checkout@v4"))
+	require.False(t, l.dirty, "missing references must not be recorded")
+}